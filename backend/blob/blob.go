@@ -0,0 +1,177 @@
+// Package blob stores the files behind conversions — uploaded inputs and
+// converted outputs — under slash-separated keys, on local disk or in an
+// S3-compatible bucket, so several backend instances can share them.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/oneforall/backend/config"
+)
+
+// ErrNotExist is returned for keys that hold no blob
+var ErrNotExist = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are empty, absolute or try to
+// leave the store with ".."
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Info describes a stored blob
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is implemented by each blob storage driver
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any blob
+	// already there. Readers never see a partially written blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get streams the blob stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange streams length bytes of a blob starting at offset, or the
+	// rest of it if length is negative
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat describes the blob stored under key
+	Stat(ctx context.Context, key string) (Info, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// List describes every blob whose key starts with prefix, in key order
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// New creates the blob store selected by BLOB_STORAGE
+func New(cfg *config.Config) (Store, error) {
+	switch cfg.BlobStorage {
+	case "", "local":
+		return NewLocal(cfg.UploadDirectory), nil
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob storage %q", cfg.BlobStorage)
+	}
+}
+
+// CheckKey reports ErrInvalidKey unless key is a clean relative
+// slash-separated path
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// File is an open blob that can be read sequentially, seeked and read at
+// arbitrary offsets, as http.ServeContent and content sniffing need
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// fileOpener is implemented by drivers that can hand out a File directly
+// instead of going through ranged reads
+type fileOpener interface {
+	open(key string) (File, Info, error)
+}
+
+// Open opens the blob stored under key for random access
+func Open(ctx context.Context, store Store, key string) (File, Info, error) {
+	if opener, ok := store.(fileOpener); ok {
+		return opener.open(key)
+	}
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return &rangeReader{ctx: ctx, store: store, key: key, size: info.Size}, info, nil
+}
+
+// rangeReader reads a blob through GetRange, so seeking past data does not
+// download it. Sequential reads share one open stream.
+type rangeReader struct {
+	ctx    context.Context
+	store  Store
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.store.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("blob: negative seek offset")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), r.size-off)
+	body, err := r.store.GetRange(r.ctx, r.key, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:length])
+	if err == nil && int(length) < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
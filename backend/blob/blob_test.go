@@ -0,0 +1,128 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	for _, key := range []string{"inputs/abc", "outputs/id/photo.jpg", "a"} {
+		if err := CheckKey(key); err != nil {
+			t.Errorf("CheckKey(%q) = %v; want nil", key, err)
+		}
+	}
+	for _, key := range []string{"", "/abs", "a/../b", "..", "../up", "a//b", "a/", `a\b`, "./a"} {
+		if err := CheckKey(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CheckKey(%q) = %v; want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+// testStore runs the behaviour every driver shares against store, which
+// must hold nothing under prefix
+func testStore(t *testing.T, store Store, prefix string) {
+	ctx := context.Background()
+	key := prefix + "dir/hello.txt"
+	const data = "hello, blob store"
+
+	if err := store.Put(ctx, key, strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { store.Delete(ctx, key) })
+
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(data)) || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v; want key %s, size %d and a modification time", info, key, len(data))
+	}
+
+	r, err := store.Get(ctx, key)
+	if got := readAll(t, r, err); got != data {
+		t.Errorf("Get = %q; want %q", got, data)
+	}
+	ranges := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 5, "hello"},
+		{7, 4, "blob"},
+		{12, -1, "store"},
+		{3, 0, ""},
+	}
+	for _, r := range ranges {
+		body, err := store.GetRange(ctx, key, r.offset, r.length)
+		if got := readAll(t, body, err); got != r.want {
+			t.Errorf("GetRange(%d, %d) = %q; want %q", r.offset, r.length, got, r.want)
+		}
+	}
+
+	f, _, err := Open(ctx, store, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 7); err != nil || string(buf[:n]) != "blob" {
+		t.Errorf("ReadAt(7) = %q, %v; want \"blob\"", buf[:n], err)
+	}
+	if _, err := f.Seek(12, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if rest, err := io.ReadAll(f); err != nil || string(rest) != "store" {
+		t.Errorf("read after Seek = %q, %v; want \"store\"", rest, err)
+	}
+	f.Close()
+
+	other := prefix + "other.txt"
+	if err := store.Put(ctx, other, strings.NewReader(""), 0); err != nil {
+		t.Fatalf("Put empty blob: %v", err)
+	}
+	t.Cleanup(func() { store.Delete(ctx, other) })
+	infos, err := store.List(ctx, prefix)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Key != key || infos[1].Key != other || infos[1].Size != 0 {
+		t.Errorf("List = %+v; want %s then the empty %s", infos, key, other)
+	}
+	if infos, err := store.List(ctx, prefix+"dir/"); err != nil || len(infos) != 1 {
+		t.Errorf("List(dir/) = %+v, %v; want only %s", infos, err, key)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob = %v; want nil", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Delete = %v; want ErrNotExist", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Get after Delete = %v; want ErrNotExist", err)
+	}
+	if _, _, err := Open(ctx, store, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open after Delete = %v; want ErrNotExist", err)
+	}
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put outside the store = %v; want ErrInvalidKey", err)
+	}
+}
+
+// readAll reads and closes a blob stream, failing the test on error
+func readAll(t *testing.T, r io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("open blob: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read blob: %v", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,70 @@
+package blob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testStore(t, NewLocal(t.TempDir()), "")
+}
+
+func TestLocalPutShortRead(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewLocal(root)
+
+	// A body shorter than its stated size leaves nothing behind
+	if err := store.Put(ctx, "inputs/short", strings.NewReader("abc"), 10); err == nil {
+		t.Fatal("Put of a short body succeeded")
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Put left %d files behind", len(entries))
+	}
+}
+
+func TestLocalListSkipsPartialWrites(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewLocal(root)
+
+	if err := store.Put(ctx, "inputs/done", strings.NewReader("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "inputs", tempPrefix+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := store.List(ctx, "inputs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Key != "inputs/done" {
+		t.Errorf("List = %+v; want only inputs/done", infos)
+	}
+}
+
+func TestLocalDeleteRemovesEmptyDirectories(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewLocal(root)
+
+	if err := store.Put(ctx, "outputs/id/photo.jpg", strings.NewReader("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "outputs/id/photo.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "outputs")); !os.IsNotExist(err) {
+		t.Errorf("empty directories left behind: %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("root removed: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Blobs shared by several conversions, such as identical uploads stored by
+// content, are reference counted: each conversion holding one counts a
+// reference, and the blob is deleted with the last. Every reference change
+// goes through Acquire, Release or Discard, which serialise changes to the
+// same key within this process, so a blob is never deleted between another
+// caller finding it and counting its reference.
+
+// RefCounter records how many conversions hold each blob; storage.Store
+// implements it
+type RefCounter interface {
+	// AddBlobRef counts one more reference and returns the new count
+	AddBlobRef(ctx context.Context, key string) (int, error)
+	// ReleaseBlobRef drops one reference and returns how many remain
+	ReleaseBlobRef(ctx context.Context, key string) (int, error)
+}
+
+// refLocks holds the per-key locks of reference changes in this process
+var refLocks keyLocks
+
+// Acquire adds a reference to the blob under key, first storing it with put
+// if it is not there yet. put may be nil for blobs that must already exist,
+// in which case a missing blob is reported as ErrNotExist.
+func Acquire(ctx context.Context, store Store, refs RefCounter, key string, put func() error) error {
+	unlock := refLocks.lock(key)
+	defer unlock()
+
+	stored := false
+	_, err := store.Stat(ctx, key)
+	if errors.Is(err, ErrNotExist) && put != nil {
+		err = put()
+		stored = err == nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := refs.AddBlobRef(ctx, key); err != nil {
+		if stored {
+			store.Delete(ctx, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Release drops a reference to the blob under key and deletes the blob once
+// nothing refers to it. Failures are only logged: at worst a blob is left
+// behind.
+func Release(ctx context.Context, store Store, refs RefCounter, key string) {
+	if key == "" {
+		return
+	}
+	unlock := refLocks.lock(key)
+	defer unlock()
+	release(ctx, store, refs, key)
+}
+
+// Discard deletes a blob that was stored but never referenced, unless
+// something has come to refer to it meanwhile
+func Discard(ctx context.Context, store Store, refs RefCounter, key string) {
+	unlock := refLocks.lock(key)
+	defer unlock()
+
+	// Counting a reference and dropping it again deletes the blob only if
+	// that was the last one
+	if _, err := refs.AddBlobRef(ctx, key); err != nil {
+		log.Printf("⚠ Failed to discard blob %s: %v", key, err)
+		return
+	}
+	release(ctx, store, refs, key)
+}
+
+// release drops a reference with the key's lock held
+func release(ctx context.Context, store Store, refs RefCounter, key string) {
+	left, err := refs.ReleaseBlobRef(ctx, key)
+	if err != nil {
+		log.Printf("⚠ Failed to release blob %s: %v", key, err)
+		return
+	}
+	if left > 0 {
+		return
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		log.Printf("⚠ Failed to delete blob %s: %v", key, err)
+	}
+}
+
+// keyLocks serialises work on the same key
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks key and returns the function unlocking it
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+	}
+	l := k.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
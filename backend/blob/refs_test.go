@@ -0,0 +1,137 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memRefs counts references in memory
+type memRefs struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *memRefs) AddBlobRef(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = map[string]int{}
+	}
+	m.counts[key]++
+	return m.counts[key], nil
+}
+
+func (m *memRefs) ReleaseBlobRef(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[key] > 0 {
+		m.counts[key]--
+	}
+	n := m.counts[key]
+	if n == 0 {
+		delete(m.counts, key)
+	}
+	return n, nil
+}
+
+func putString(ctx context.Context, store Store, key, data string) func() error {
+	return func() error {
+		return store.Put(ctx, key, strings.NewReader(data), int64(len(data)))
+	}
+}
+
+func TestRefs(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir())
+	refs := &memRefs{}
+	const key = "inputs/shared"
+
+	puts := 0
+	put := func() error {
+		puts++
+		return putString(ctx, store, key, "data")()
+	}
+	for i := 0; i < 2; i++ {
+		if err := Acquire(ctx, store, refs, key, put); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+	}
+	if puts != 1 {
+		t.Errorf("blob stored %d times; want once", puts)
+	}
+
+	Release(ctx, store, refs, key)
+	if _, err := store.Stat(ctx, key); err != nil {
+		t.Fatalf("blob deleted while still referenced: %v", err)
+	}
+	Release(ctx, store, refs, key)
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after the last release = %v; want ErrNotExist", err)
+	}
+
+	if err := Acquire(ctx, store, refs, "outputs/missing", nil); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Acquire of a missing blob without put = %v; want ErrNotExist", err)
+	}
+}
+
+func TestRefsDiscard(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir())
+	refs := &memRefs{}
+
+	// An unreferenced blob is deleted
+	if err := putString(ctx, store, "outputs/a", "a")(); err != nil {
+		t.Fatal(err)
+	}
+	Discard(ctx, store, refs, "outputs/a")
+	if _, err := store.Stat(ctx, "outputs/a"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Discard = %v; want ErrNotExist", err)
+	}
+
+	// A blob someone has come to refer to meanwhile is kept
+	if err := Acquire(ctx, store, refs, "outputs/b", putString(ctx, store, "outputs/b", "b")); err != nil {
+		t.Fatal(err)
+	}
+	Discard(ctx, store, refs, "outputs/b")
+	if _, err := store.Stat(ctx, "outputs/b"); err != nil {
+		t.Errorf("Discard deleted a referenced blob: %v", err)
+	}
+	if refs.counts["outputs/b"] != 1 {
+		t.Errorf("count after Discard = %d; want 1", refs.counts["outputs/b"])
+	}
+}
+
+// TestRefsConcurrent races callers acquiring and releasing one key: a
+// caller whose Acquire succeeded must always find the blob in place.
+func TestRefsConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir())
+	refs := &memRefs{}
+	const key = "inputs/raced"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := Acquire(ctx, store, refs, key, putString(ctx, store, key, "data")); err != nil {
+					t.Errorf("Acquire: %v", err)
+					return
+				}
+				if _, err := store.Stat(ctx, key); err != nil {
+					t.Errorf("blob missing while referenced: %v", err)
+				}
+				Release(ctx, store, refs, key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after every release = %v; want ErrNotExist", err)
+	}
+}
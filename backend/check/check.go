@@ -0,0 +1,358 @@
+// Package check reports whether a file meets the requirements of an exam
+// document: its format, size and, for images, pixel size, shape and DPI.
+// Files are only read, never converted or stored.
+package check
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/utils"
+)
+
+// aspectTolerance is how far, relatively, an image's shape may be from a
+// required aspect ratio and still pass
+const aspectTolerance = 0.01
+
+// File is a file to check
+type File struct {
+	Name string
+	Data io.ReaderAt
+	Size int64
+}
+
+// Checker checks files against document requirements and suggests tools
+// that fix them
+type Checker struct {
+	registry *convert.Registry
+}
+
+// New creates a checker. Fixes suggest tools from registry.
+func New(registry *convert.Registry) *Checker {
+	return &Checker{registry: registry}
+}
+
+// Check reports on file against doc. tools are the tool IDs users may
+// request; fixes only suggest those.
+func (c *Checker) Check(doc models.Document, file File, tools []string) models.DocumentCheck {
+	report := models.DocumentCheck{
+		DocumentID: doc.ID,
+		FileName:   file.Name,
+		FileSize:   file.Size,
+		Rules:      []models.RuleCheck{},
+	}
+	s := suggester{registry: c.registry, tools: tools, doc: doc}
+
+	detected, err := utils.DetectFileType(file.Data, file.Size)
+	format := ""
+	if err == nil {
+		format = convert.FormatOf(detected.Extension)
+		report.Format, report.MIME = format, detected.MIME
+		s.format = format
+	}
+	report.Rules = append(report.Rules, formatRule(doc, format, s))
+	if format != "" && file.Name != "" {
+		report.Rules = append(report.Rules, extensionRule(file.Name, detected))
+	}
+	report.Rules = append(report.Rules, sizeRule(doc, file.Size, s))
+
+	switch {
+	case format == "pdf":
+		report.Pages = CountPDFPages(file.Data, file.Size)
+	case convert.IsImageFormat(format) && doc.HasImageRequirements():
+		info, err := convert.ReadImageInfo(file.Data, file.Size, format)
+		if err != nil {
+			report.Rules = append(report.Rules, models.RuleCheck{
+				Rule:     "readable",
+				Expected: "a readable image",
+				Actual:   err.Error(),
+				Fix:      "Export the image again from an image editor; the file may be damaged",
+			})
+			break
+		}
+		report.Width, report.Height, report.DPI = info.Width, info.Height, info.DPI
+		report.Rules = append(report.Rules, imageRules(doc, info, s)...)
+	case convert.IsImageFormat(format):
+		if info, err := convert.ReadImageInfo(file.Data, file.Size, format); err == nil {
+			report.Width, report.Height, report.DPI = info.Width, info.Height, info.DPI
+		}
+	}
+
+	report.Compliant = true
+	for _, rule := range report.Rules {
+		if !rule.Passed {
+			report.Compliant = false
+		}
+	}
+	return report
+}
+
+// formatRule checks the detected format against the document's formats
+func formatRule(doc models.Document, format string, s suggester) models.RuleCheck {
+	rule := models.RuleCheck{Rule: "format", Expected: doc.Format, Actual: "unrecognised"}
+	if format == "" {
+		rule.Fix = "Save the file as " + formatsText(doc.Format) + "; its content is not a recognised file type"
+		return rule
+	}
+	rule.Actual = strings.ToUpper(format)
+	if s.allowed(format) {
+		rule.Passed = true
+		return rule
+	}
+	if tool := s.tool(func(convert.Registration) bool { return true }); tool != "" {
+		reg, _ := s.registry.Lookup(tool)
+		rule.Fix = "Convert the file to " + strings.ToUpper(reg.OutputFor(format)) + " with the " + tool + " tool"
+	} else {
+		rule.Fix = "Convert the file to " + formatsText(doc.Format)
+	}
+	return rule
+}
+
+// formatsText names the formats of a document, e.g. "PDF" or "one of JPG,
+// PNG"
+func formatsText(format string) string {
+	if strings.Contains(format, ",") {
+		return "one of " + format
+	}
+	return format
+}
+
+// extensionRule checks that the file name's extension matches its content,
+// as uploads are refused otherwise
+func extensionRule(name string, detected utils.FileType) models.RuleCheck {
+	ext := strings.ToLower(utils.GetFileExtension(name))
+	rule := models.RuleCheck{Rule: "extension", Expected: detected.Extension, Actual: ext}
+	if convert.FormatOf(name) == convert.FormatOf(detected.Extension) {
+		rule.Passed = true
+		return rule
+	}
+	if ext == "" {
+		rule.Actual = "none"
+	}
+	rule.Fix = fmt.Sprintf("Rename the file to end in %s; it contains %s", detected.Extension, detected.MIME)
+	return rule
+}
+
+// sizeRule checks the file size against the document's limit
+func sizeRule(doc models.Document, size int64, s suggester) models.RuleCheck {
+	rule := models.RuleCheck{
+		Rule:     "max_size",
+		Passed:   size <= doc.MaxSize,
+		Expected: "at most " + utils.FormatFileSize(doc.MaxSize),
+		Actual:   utils.FormatFileSize(size),
+	}
+	if rule.Passed {
+		return rule
+	}
+	rule.Fix = "Reduce the file to " + utils.FormatFileSize(doc.MaxSize) + " or less"
+	if tool := s.tool(compresses); tool != "" {
+		rule.Fix += " with the " + tool + " tool"
+	}
+	return rule
+}
+
+// imageRules check an image's pixel size, shape and DPI
+func imageRules(doc models.Document, info convert.ImageInfo, s suggester) []models.RuleCheck {
+	var rules []models.RuleCheck
+	actual := fmt.Sprintf("%dx%d pixels", info.Width, info.Height)
+	fixWith := ""
+	// A tool that also compresses fixes the size too
+	tool := s.tool(func(reg convert.Registration) bool { return appliesRequirements(reg) && compresses(reg) })
+	if tool == "" {
+		tool = s.tool(appliesRequirements)
+	}
+	if tool != "" {
+		fixWith = ", or convert it with the " + tool + " tool, which applies this document's requirements"
+	}
+
+	if w, h := doc.PixelSize(); w > 0 {
+		rule := models.RuleCheck{
+			Rule:     "dimensions",
+			Passed:   info.Width == w && info.Height == h,
+			Expected: fmt.Sprintf("%dx%d pixels (%gx%gcm at %d DPI)", w, h, doc.WidthCM, doc.HeightCM, doc.DPI),
+			Actual:   actual,
+		}
+		if !rule.Passed {
+			rule.Fix = fmt.Sprintf("Crop and resize the image to exactly %dx%d pixels%s", w, h, fixWith)
+		}
+		rules = append(rules, rule)
+	}
+
+	for _, side := range []struct {
+		name     string
+		size     int
+		min, max int
+	}{
+		{"width", info.Width, doc.MinWidth, doc.MaxWidth},
+		{"height", info.Height, doc.MinHeight, doc.MaxHeight},
+	} {
+		if side.min == 0 && side.max == 0 {
+			continue
+		}
+		rule := models.RuleCheck{
+			Rule:     side.name,
+			Passed:   side.size >= side.min && (side.max == 0 || side.size <= side.max),
+			Expected: pixelRange(side.min, side.max),
+			Actual:   fmt.Sprintf("%d pixels", side.size),
+		}
+		if !rule.Passed {
+			verb := "Enlarge"
+			if side.max > 0 && side.size > side.max {
+				verb = "Shrink"
+			}
+			rule.Fix = fmt.Sprintf("%s the image to a %s of %s%s", verb, side.name, pixelRange(side.min, side.max), fixWith)
+		}
+		rules = append(rules, rule)
+	}
+
+	if doc.AspectRatio != "" {
+		if want, err := utils.ParseAspectRatio(doc.AspectRatio); err == nil {
+			got := float64(info.Width) / float64(info.Height)
+			rule := models.RuleCheck{
+				Rule:     "aspect_ratio",
+				Passed:   math.Abs(got-want)/want <= aspectTolerance,
+				Expected: fmt.Sprintf("%s (width %.3g times the height)", doc.AspectRatio, want),
+				Actual:   fmt.Sprintf("%s (width %.3g times the height)", actual, got),
+			}
+			if !rule.Passed {
+				how := "Crop"
+				if doc.Fit == models.FitPad {
+					how = "Pad"
+				}
+				rule.Fix = fmt.Sprintf("%s the image to a %s shape%s", how, doc.AspectRatio, fixWith)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	if doc.DPI > 0 {
+		rule := models.RuleCheck{
+			Rule:     "dpi",
+			Passed:   info.DPI == doc.DPI,
+			Expected: fmt.Sprintf("%d DPI", doc.DPI),
+			Actual:   fmt.Sprintf("%d DPI", info.DPI),
+		}
+		if info.DPI == 0 {
+			rule.Actual = "not recorded"
+		}
+		if !rule.Passed {
+			rule.Fix = fmt.Sprintf("Save the image with its resolution set to %d DPI%s", doc.DPI, fixWith)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// pixelRange describes a range of pixel sizes, where zero is unbounded
+func pixelRange(min, max int) string {
+	switch {
+	case max == 0:
+		return fmt.Sprintf("at least %d pixels", min)
+	case min == 0:
+		return fmt.Sprintf("at most %d pixels", max)
+	case min == max:
+		return fmt.Sprintf("%d pixels", min)
+	default:
+		return fmt.Sprintf("%d to %d pixels", min, max)
+	}
+}
+
+// suggester finds tools that turn a file of one format into a file a
+// document accepts
+type suggester struct {
+	registry *convert.Registry
+	tools    []string
+	doc      models.Document
+	format   string
+}
+
+// allowed reports whether the document accepts files in format
+func (s suggester) allowed(format string) bool {
+	extensions, _ := utils.FormatExtensions(s.doc.Format)
+	for _, ext := range extensions {
+		if convert.FormatOf(ext) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// tool returns the first tool that takes the file's format, produces a
+// format the document accepts and matches want, or "" if there is none
+func (s suggester) tool(want func(convert.Registration) bool) string {
+	if s.format == "" || s.registry == nil {
+		return ""
+	}
+	for _, id := range s.tools {
+		reg, ok := s.registry.Lookup(id)
+		if ok && reg.Accepts(s.format) && s.allowed(reg.OutputFor(s.format)) && want(reg) {
+			return id
+		}
+	}
+	return ""
+}
+
+// compresses reports whether a tool compresses to the document's size limit
+// by default
+func compresses(reg convert.Registration) bool {
+	return reg.Defaults[convert.OptionMaxSize] == convert.MaxSizeDocument
+}
+
+// appliesRequirements reports whether a tool takes options from the
+// document's requirements
+func appliesRequirements(reg convert.Registration) bool {
+	_, ok := reg.Converter.(convert.DocumentOptioner)
+	return ok
+}
+
+// Plan is how a file is brought to meet a document's requirements
+type Plan struct {
+	// Report checks the file as it is
+	Report models.DocumentCheck
+	// ToolID is the tool that fixes the file, or empty if it already meets
+	// the requirements
+	ToolID string
+}
+
+// Plan picks the available tool that fixes the rules file fails, preferring
+// one that also compresses to the document's size limit. The extension
+// rule is left out, as the caller names the file. It errors with the fixes
+// of the failed rules when no tool can fix them.
+func (c *Checker) Plan(doc models.Document, file File, tools []string) (Plan, error) {
+	report := c.Check(doc, file, tools)
+	plan := Plan{Report: report}
+	failed := map[string]bool{}
+	var fixes []string
+	for _, rule := range report.Rules {
+		if !rule.Passed && rule.Rule != "extension" {
+			failed[rule.Rule] = true
+			fixes = append(fixes, rule.Rule+": "+rule.Fix)
+		}
+	}
+	if len(failed) == 0 {
+		return plan, nil
+	}
+
+	s := suggester{registry: c.registry, tools: tools, doc: doc, format: report.Format}
+	fixesFile := func(reg convert.Registration) bool {
+		if failed["readable"] {
+			return false
+		}
+		if failed["max_size"] && !compresses(reg) {
+			return false
+		}
+		return !doc.HasImageRequirements() || appliesRequirements(reg)
+	}
+	plan.ToolID = s.tool(func(reg convert.Registration) bool { return fixesFile(reg) && compresses(reg) })
+	if plan.ToolID == "" {
+		plan.ToolID = s.tool(fixesFile)
+	}
+	if plan.ToolID == "" {
+		return plan, fmt.Errorf("no available tool can fix this file: %s", strings.Join(fixes, "; "))
+	}
+	return plan, nil
+}
@@ -0,0 +1,176 @@
+package check
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/models"
+)
+
+// pngFile encodes a w x h PNG, recording dpi in a pHYs chunk if it is set
+func pngFile(t *testing.T, w, h, dpi int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if dpi == 0 {
+		return data
+	}
+	ppm := uint32(float64(dpi)/0.0254 + 0.5)
+	chunk := binary.BigEndian.AppendUint32(nil, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	const ihdrEnd = 33
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func newChecker(t *testing.T) *Checker {
+	t.Helper()
+	registry, err := convert.Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(registry)
+}
+
+// ruleOf returns the named rule of a report
+func ruleOf(report models.DocumentCheck, name string) (models.RuleCheck, bool) {
+	for _, rule := range report.Rules {
+		if rule.Rule == name {
+			return rule, true
+		}
+	}
+	return models.RuleCheck{}, false
+}
+
+func TestCheckRules(t *testing.T) {
+	c := newChecker(t)
+	pdf := []byte(plainPDF(2))
+	photo := models.Document{ID: "photo", Format: "JPG, PNG", MaxSize: 1 << 20}
+	tests := []struct {
+		name   string
+		doc    models.Document
+		file   string
+		data   []byte
+		tools  []string
+		rule   string
+		passed bool
+		// fix is part of the fix text of a failing rule
+		fix string
+	}{
+		{"format", models.Document{Format: "PDF", MaxSize: 1 << 20}, "a.pdf", pdf, nil, "format", true, ""},
+		{"wrong format", models.Document{Format: "PDF", MaxSize: 1 << 20}, "a.png", pngFile(t, 4, 4, 0), nil, "format", false, "Convert the file to PDF"},
+		{"wrong format with a tool", photo, "a.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), []string{"webp-to-png"}, "format", false, "webp-to-png"},
+		{"unrecognised format", photo, "a.png", []byte("plain text"), nil, "format", false, "not a recognised file type"},
+
+		{"extension", photo, "a.png", pngFile(t, 4, 4, 0), nil, "extension", true, ""},
+		{"wrong extension", photo, "a.jpg", pngFile(t, 4, 4, 0), nil, "extension", false, "end in .png"},
+		{"no extension", photo, "photo", pngFile(t, 4, 4, 0), nil, "extension", false, "end in .png"},
+
+		{"size", models.Document{Format: "PDF", MaxSize: int64(len(pdf))}, "a.pdf", pdf, nil, "max_size", true, ""},
+		{"too large", models.Document{Format: "PDF", MaxSize: 10}, "a.pdf", pdf, nil, "max_size", false, "Reduce the file"},
+		{"too large with a tool", models.Document{Format: "JPG", MaxSize: 10}, "a.png", pngFile(t, 4, 4, 0), []string{"compress-image"}, "max_size", false, "compress-image"},
+
+		{"dimensions", models.Document{Format: "PNG", MaxSize: 1 << 20, WidthCM: 2.54, HeightCM: 5.08, DPI: 100}, "a.png", pngFile(t, 100, 200, 100), nil, "dimensions", true, ""},
+		{"wrong dimensions", models.Document{Format: "PNG", MaxSize: 1 << 20, WidthCM: 2.54, HeightCM: 5.08, DPI: 100}, "a.png", pngFile(t, 100, 100, 100), nil, "dimensions", false, "exactly 100x200 pixels"},
+
+		{"width", models.Document{Format: "PNG", MaxSize: 1 << 20, MinWidth: 50, MaxWidth: 100}, "a.png", pngFile(t, 80, 10, 0), nil, "width", true, ""},
+		{"too narrow", models.Document{Format: "PNG", MaxSize: 1 << 20, MinWidth: 50, MaxWidth: 100}, "a.png", pngFile(t, 40, 10, 0), nil, "width", false, "Enlarge the image to a width of 50 to 100 pixels"},
+		{"too tall", models.Document{Format: "PNG", MaxSize: 1 << 20, MaxHeight: 100}, "a.png", pngFile(t, 10, 140, 0), nil, "height", false, "Shrink the image to a height of at most 100 pixels"},
+
+		{"aspect ratio", models.Document{Format: "PNG", MaxSize: 1 << 20, AspectRatio: "3:4"}, "a.png", pngFile(t, 300, 400, 0), nil, "aspect_ratio", true, ""},
+		{"aspect ratio within tolerance", models.Document{Format: "PNG", MaxSize: 1 << 20, AspectRatio: "3:4"}, "a.png", pngFile(t, 302, 400, 0), nil, "aspect_ratio", true, ""},
+		{"wrong aspect ratio", models.Document{Format: "PNG", MaxSize: 1 << 20, AspectRatio: "3:4"}, "a.png", pngFile(t, 400, 400, 0), nil, "aspect_ratio", false, "Crop the image to a 3:4 shape"},
+		{"wrong aspect ratio padded", models.Document{Format: "PNG", MaxSize: 1 << 20, AspectRatio: "3:4", Fit: models.FitPad}, "a.png", pngFile(t, 400, 400, 0), nil, "aspect_ratio", false, "Pad the image"},
+		{"wrong aspect ratio with a tool", models.Document{Format: "JPG", MaxSize: 1 << 20, AspectRatio: "3:4"}, "a.png", pngFile(t, 400, 400, 0), []string{"png-to-jpg"}, "aspect_ratio", false, "png-to-jpg tool, which applies"},
+
+		{"dpi", models.Document{Format: "PNG", MaxSize: 1 << 20, DPI: 300}, "a.png", pngFile(t, 10, 10, 300), nil, "dpi", true, ""},
+		{"wrong dpi", models.Document{Format: "PNG", MaxSize: 1 << 20, DPI: 300}, "a.png", pngFile(t, 10, 10, 72), nil, "dpi", false, "set to 300 DPI"},
+		{"no dpi", models.Document{Format: "PNG", MaxSize: 1 << 20, DPI: 300}, "a.png", pngFile(t, 10, 10, 0), nil, "dpi", false, "set to 300 DPI"},
+
+		{"unreadable image", models.Document{Format: "PNG", MaxSize: 1 << 20, DPI: 300}, "a.png", []byte("\x89PNG\r\n\x1a\nbroken"), nil, "readable", false, "damaged"},
+	}
+	for _, tt := range tests {
+		report := c.Check(tt.doc, File{Name: tt.file, Data: bytes.NewReader(tt.data), Size: int64(len(tt.data))}, tt.tools)
+		rule, ok := ruleOf(report, tt.rule)
+		if !ok {
+			t.Errorf("%s: no %s rule in %+v", tt.name, tt.rule, report.Rules)
+			continue
+		}
+		if rule.Passed != tt.passed {
+			t.Errorf("%s: %s passed = %v; want %v (%+v)", tt.name, tt.rule, rule.Passed, tt.passed, rule)
+		}
+		if tt.passed && rule.Fix != "" {
+			t.Errorf("%s: passing rule has a fix %q", tt.name, rule.Fix)
+		}
+		if !tt.passed && !strings.Contains(rule.Fix, tt.fix) {
+			t.Errorf("%s: fix %q; want it to mention %q", tt.name, rule.Fix, tt.fix)
+		}
+		if !tt.passed && report.Compliant {
+			t.Errorf("%s: report compliant despite a failing rule", tt.name)
+		}
+	}
+}
+
+func TestCheckReport(t *testing.T) {
+	c := newChecker(t)
+	doc := models.Document{ID: "photo", Format: "JPG, PNG", MaxSize: 1 << 20, AspectRatio: "1:1", DPI: 200}
+	data := pngFile(t, 64, 64, 200)
+	report := c.Check(doc, File{Name: "me.png", Data: bytes.NewReader(data), Size: int64(len(data))}, nil)
+	if !report.Compliant || report.Format != "png" || report.MIME != "image/png" ||
+		report.Width != 64 || report.Height != 64 || report.DPI != 200 || report.DocumentID != "photo" {
+		t.Errorf("report = %+v; want a compliant 64x64 PNG at 200 DPI", report)
+	}
+	var names []string
+	for _, rule := range report.Rules {
+		names = append(names, rule.Rule)
+	}
+	if got := strings.Join(names, ","); got != "format,extension,max_size,aspect_ratio,dpi" {
+		t.Errorf("rules %s; want format,extension,max_size,aspect_ratio,dpi", got)
+	}
+
+	pdf := []byte(plainPDF(3))
+	report = c.Check(models.Document{Format: "PDF", MaxSize: 1 << 20}, File{Name: "a.pdf", Data: bytes.NewReader(pdf), Size: int64(len(pdf))}, nil)
+	if !report.Compliant || report.Pages != 3 {
+		t.Errorf("PDF report = %+v; want compliant with 3 pages", report)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	c := newChecker(t)
+	tools := []string{"png-to-jpg", "compress-image"}
+	large := pngFile(t, 300, 300, 0)
+	tests := []struct {
+		name    string
+		doc     models.Document
+		data    []byte
+		tools   []string
+		want    string
+		wantErr bool
+	}{
+		{"compliant", models.Document{Format: "PNG", MaxSize: 1 << 20}, large, tools, "", false},
+		{"format only", models.Document{Format: "JPG", MaxSize: 1 << 20}, large, tools, "compress-image", false},
+		{"format without a compressing tool", models.Document{Format: "JPG", MaxSize: 1 << 20}, large, []string{"png-to-jpg"}, "png-to-jpg", false},
+		{"size needs compression", models.Document{Format: "JPG", MaxSize: 100}, large, []string{"png-to-jpg"}, "", true},
+		{"size", models.Document{Format: "JPG, PNG", MaxSize: 100}, large, tools, "compress-image", false},
+		{"no tools", models.Document{Format: "JPG", MaxSize: 1 << 20}, large, nil, "", true},
+		{"unreadable", models.Document{Format: "JPG", MaxSize: 1 << 20, DPI: 100}, []byte("\x89PNG\r\n\x1a\nbroken"), tools, "", true},
+	}
+	for _, tt := range tests {
+		plan, err := c.Plan(tt.doc, File{Name: "upload", Data: bytes.NewReader(tt.data), Size: int64(len(tt.data))}, tt.tools)
+		if (err != nil) != tt.wantErr || plan.ToolID != tt.want {
+			t.Errorf("%s: Plan = %q, %v; want %q, error %v", tt.name, plan.ToolID, err, tt.want, tt.wantErr)
+		}
+	}
+}
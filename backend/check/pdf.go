@@ -0,0 +1,118 @@
+package check
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// maxPDFScan bounds how much of a PDF is searched for page objects
+	maxPDFScan = 64 << 20
+	// maxPDFStream bounds how much of one compressed stream is inflated
+	maxPDFStream = 16 << 20
+	// maxPDFInflated bounds how much is inflated from all of a PDF's object
+	// streams together
+	maxPDFInflated = 64 << 20
+	// maxPDFDict bounds how far before a stream its dictionary is looked for
+	maxPDFDict = 4 << 10
+)
+
+var (
+	// pdfPage matches the type entry of a page object, but not of the page
+	// tree nodes (/Type /Pages)
+	pdfPage = regexp.MustCompile(`/Type\s*/Page[^s]`)
+	// pdfCount matches the page count of a page tree node
+	pdfCount = regexp.MustCompile(`/Count\s+(\d+)`)
+	// pdfStream matches the start of a stream's data
+	pdfStream = regexp.MustCompile(`stream\r?\n`)
+	// pdfObjStm matches the type entry of an object stream, which holds
+	// compressed objects such as pages
+	pdfObjStm = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+)
+
+// CountPDFPages counts the pages of a PDF from its page objects, looking
+// inside compressed object streams too, or else from the largest page tree
+// count. It returns zero if neither can be found, as in encrypted files.
+// Only object streams are inflated, up to maxPDFInflated bytes in all;
+// image and content streams are skipped. The count is a heuristic: pages
+// replaced by incremental updates are counted twice.
+func CountPDFPages(r io.ReaderAt, size int64) int {
+	if size > maxPDFScan {
+		size = maxPDFScan
+	}
+	data := make([]byte, size)
+	n, err := r.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return 0
+	}
+	data = data[:n]
+
+	pages := len(pdfPage.FindAllIndex(data, -1))
+	count := maxPDFCount(data)
+	budget := int64(maxPDFInflated)
+	for _, loc := range pdfStream.FindAllIndex(data, -1) {
+		if budget <= 0 {
+			break
+		}
+		if bytes.HasSuffix(data[:loc[0]], []byte("end")) || !isObjectStream(data[:loc[0]]) {
+			continue
+		}
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		inflated := inflate(data[start:start+end], budget)
+		budget -= int64(len(inflated))
+		pages += len(pdfPage.FindAllIndex(inflated, -1))
+		if c := maxPDFCount(inflated); c > count {
+			count = c
+		}
+	}
+	if pages > 0 {
+		return pages
+	}
+	return count
+}
+
+// maxPDFCount returns the largest page tree count in data
+func maxPDFCount(data []byte) int {
+	count := 0
+	for _, m := range pdfCount.FindAllSubmatch(data, -1) {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+// isObjectStream reports whether the stream whose data follows before is
+// an object stream, from the dictionary since the start of its object
+func isObjectStream(before []byte) bool {
+	if len(before) > maxPDFDict {
+		before = before[len(before)-maxPDFDict:]
+	}
+	if i := bytes.LastIndex(before, []byte("obj")); i >= 0 {
+		before = before[i:]
+	}
+	return pdfObjStm.Match(before)
+}
+
+// inflate decompresses at most limit bytes, and no more than maxPDFStream,
+// of a Flate-encoded stream, or returns nil if it is not one. Streams cut
+// short return what could be inflated.
+func inflate(stream []byte, limit int64) []byte {
+	if limit > maxPDFStream {
+		limit = maxPDFStream
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	out, _ := io.ReadAll(io.LimitReader(zr, limit))
+	return out
+}
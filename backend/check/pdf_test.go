@@ -0,0 +1,127 @@
+package check
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// deflate zlib-compresses data, as a FlateDecode stream holds it. Trailing
+// whitespace makes even short data compressible, so it is not kept as a
+// stored block whose plain text would be counted without inflating it.
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Write(bytes.Repeat([]byte(" "), 256))
+	zw.Close()
+	return buf.Bytes()
+}
+
+// pdfStreamObject is an indirect object holding a stream with dict and data
+func pdfStreamObject(num int, dict string, data []byte) string {
+	return fmt.Sprintf("%d 0 obj\n<< %s /Length %d >>\nstream\n%s\nendstream\nendobj\n", num, dict, len(data), data)
+}
+
+// plainPDF is a PDF whose n pages are plain objects with a classic xref
+// table (the offsets are not checked, so they are left at zero)
+func plainPDF(n int) string {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	fmt.Fprintf(&b, "2 0 obj\n<< /Type /Pages /Kids [] /Count %d >>\nendobj\n", n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>\nendobj\n", 3+i)
+	}
+	b.WriteString("xref\n0 1\n0000000000 65535 f \ntrailer\n<< /Root 1 0 R >>\nstartxref\n0\n%%EOF\n")
+	return b.String()
+}
+
+// compressedPDF is a PDF 1.5 file whose page tree and pages are all in one
+// object stream, with a compressed cross-reference stream, so no page
+// object appears uncompressed. A content stream mentioning /Type /Page
+// must not be counted.
+func compressedPDF(n int) string {
+	var objects strings.Builder
+	objects.WriteString("<< /Type /Pages /Kids [] /Count " + fmt.Sprint(n) + " >>\n")
+	for i := 0; i < n; i++ {
+		objects.WriteString("<</Type/Page/Parent 2 0 R>>\n")
+	}
+	var b strings.Builder
+	b.WriteString("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	b.WriteString(pdfStreamObject(10, "/Type /ObjStm /N 3 /First 0 /Filter /FlateDecode", deflate([]byte(objects.String()))))
+	b.WriteString(pdfStreamObject(11, "/Filter /FlateDecode", deflate([]byte("BT (/Type /Page here) Tj ET"))))
+	b.WriteString(pdfStreamObject(12, "/Type /XRef /Size 13 /W [1 2 1] /Root 1 0 R /Filter /FlateDecode", deflate(make([]byte, 13*4))))
+	b.WriteString("startxref\n0\n%%EOF\n")
+	return b.String()
+}
+
+func countPages(pdf string) int {
+	return CountPDFPages(strings.NewReader(pdf), int64(len(pdf)))
+}
+
+func TestCountPDFPages(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  string
+		want int
+	}{
+		{"one page", plainPDF(1), 1},
+		{"three pages", plainPDF(3), 3},
+		{"compressed objects and xref", compressedPDF(4), 4},
+		{"page tree count only", "%PDF-1.4\n1 0 obj << /Type /Pages /Count 7 >> endobj\n2 0 obj << /Type /Pages /Count 3 >> endobj\n%%EOF", 7},
+		{"no pages found", "%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%EOF", 0},
+		{"not a pdf", "hello", 0},
+		{"empty", "", 0},
+		// Encrypted files hold their object streams in ciphertext, which
+		// does not inflate
+		{"undecodable object stream", "%PDF-1.5\n" + pdfStreamObject(1, "/Type /ObjStm /N 1 /First 0 /Filter /FlateDecode", []byte("\x8a\x11ciphertext")), 0},
+		{"stream without its end", "%PDF-1.5\n1 0 obj << /Type /Page >> endobj\n2 0 obj\n<< /Type /ObjStm >>\nstream\nxx", 1},
+		{"truncated object stream", "%PDF-1.5\n" + pdfStreamObject(1, "/Type /ObjStm /Filter /FlateDecode", deflate([]byte("<</Type/Page>>\n<</Type/Page>>"))[:12]), 0},
+	}
+	for _, tt := range tests {
+		if got := countPages(tt.pdf); got != tt.want {
+			t.Errorf("%s: %d pages; want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// A truncated copy still counts what it holds
+	pdf := plainPDF(5)
+	if got := countPages(pdf[:strings.Index(pdf, "4 0 obj")]); got != 1 {
+		t.Errorf("truncated: %d pages; want 1", got)
+	}
+}
+
+// TestCountPDFPagesInflateBudget checks that a PDF whose object streams
+// inflate to more than maxPDFInflated in all is not fully inflated: pages
+// in streams past the budget are not seen, and the page tree count is used
+// instead
+func TestCountPDFPagesInflateBudget(t *testing.T) {
+	// Each padding stream inflates to the per-stream cap
+	padding := deflate(make([]byte, maxPDFStream))
+	var b strings.Builder
+	b.WriteString("%PDF-1.5\n1 0 obj\n<< /Type /Pages /Count 9 >>\nendobj\n")
+	for i := 0; i < maxPDFInflated/maxPDFStream; i++ {
+		b.WriteString(pdfStreamObject(2+i, "/Type /ObjStm /Filter /FlateDecode", padding))
+	}
+	b.WriteString(pdfStreamObject(20, "/Type /ObjStm /Filter /FlateDecode", deflate([]byte("<</Type/Page>>\n<</Type/Page>>\n"))))
+	if got := countPages(b.String()); got != 9 {
+		t.Errorf("%d pages; want the page tree count 9, with the last stream past the budget", got)
+	}
+
+	// Within the budget the same stream is read
+	small := "%PDF-1.5\n1 0 obj\n<< /Type /Pages /Count 9 >>\nendobj\n" +
+		pdfStreamObject(2, "/Type /ObjStm /Filter /FlateDecode", padding) +
+		pdfStreamObject(20, "/Type /ObjStm /Filter /FlateDecode", deflate([]byte("<</Type/Page>>\n<</Type/Page>>\n")))
+	if got := countPages(small); got != 2 {
+		t.Errorf("%d pages; want the 2 in the object stream", got)
+	}
+
+	// One stream is inflated no further than maxPDFStream
+	long := append(make([]byte, maxPDFStream), "<</Type/Page>>\n"...)
+	pdf := "%PDF-1.5\n" + pdfStreamObject(1, "/Type /ObjStm /Filter /FlateDecode", deflate(long))
+	if got := countPages(pdf); got != 0 {
+		t.Errorf("%d pages; want the page past the stream cap unseen", got)
+	}
+}
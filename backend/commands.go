@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/storage"
+)
+
+// runCommand executes a one-shot subcommand instead of starting the server
+func runCommand(cfg *config.Config, name string, args []string) error {
+	switch name {
+	case "import":
+		return runImport(cfg, args)
+	case "migrate":
+		return runMigrate(cfg, args)
+	default:
+		return fmt.Errorf("unknown command %q (available: import, migrate)", name)
+	}
+}
+
+// runImport copies the JSON data files into the configured database backend
+func runImport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dataDir := flags.String("data", cfg.DataDirectory, "directory containing exams.json, tools.json and conversions.json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, err := storage.New(cfg)
+	if err != nil {
+		return err
+	}
+	importer, ok := store.(storage.Importer)
+	if !ok {
+		return fmt.Errorf("database type %q does not support importing; set DATABASE_TYPE to sqlite or postgresql", cfg.DatabaseType)
+	}
+	if err := store.Initialize(ctx); err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer store.Close()
+
+	stats, err := storage.ImportJSON(ctx, importer, *dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d exams (%d revisions), %d tools, %d conversions and %d blob references from %s\n",
+		stats.Exams, stats.Revisions, stats.Tools, stats.Conversions, stats.BlobRefs, *dataDir)
+	return nil
+}
+
+// runMigrate applies, rolls back or lists schema migrations:
+//
+//	migrate up          apply all pending migrations
+//	migrate down [n]    roll back the last n migrations (default 1)
+//	migrate status      list migrations and whether they are applied
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [n]|status")
+	}
+
+	ctx := context.Background()
+	store, err := storage.New(cfg)
+	if err != nil {
+		return err
+	}
+	db, ok := store.(storage.Migratable)
+	if !ok {
+		return fmt.Errorf("database type %q has no schema to migrate", cfg.DatabaseType)
+	}
+	defer store.Close()
+
+	migrator, err := db.Migrator(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations to roll back")
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q (expected up, down or status)", args[0])
+	}
+	return nil
+}
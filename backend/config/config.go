@@ -2,29 +2,75 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port              string
-	Environment       string
-	MaxFileSize       int64
-	AllowedFileTypes  []string
-	StoragePath       string
-	UploadDirectory   string
-	DataDirectory     string
+	Port               string
+	Environment        string
+	MaxFileSize        int64
+	AllowedFileTypes   []string
+	StoragePath        string
+	UploadDirectory    string
+	DataDirectory      string
+	DatabaseType       string
+	DatabaseURL        string
+	AutoMigrate        bool
+	ReloadInterval     time.Duration
+	AdminToken         string
+	UploadExpiry       time.Duration
+	BlobStorage        string
+	S3Endpoint         string
+	S3Region           string
+	S3Bucket           string
+	S3AccessKeyID      string
+	S3SecretAccessKey  string
+	S3PathStyle        bool
+	URLSigningSecret   string
+	SignedURLExpiry    time.Duration
+	ClamdAddress       string
+	ClamdTimeout       time.Duration
+	WorkerCount        int
+	QueueSize          int
+	WorkerPollInterval time.Duration
+	JobTimeout         time.Duration
+	ShutdownTimeout    time.Duration
 }
 
 // NewConfig creates a new configuration from environment variables
 func NewConfig() *Config {
 	return &Config{
-		Port:            getEnv("PORT", "8080"),
-		Environment:     getEnv("ENVIRONMENT", "development"),
-		MaxFileSize:     1073741824, // 1GB in bytes
-		AllowedFileTypes: []string{".pdf", ".jpg", ".jpeg", ".png", ".docx", ".doc", ".xlsx", ".pptx"},
-		StoragePath:     getEnv("STORAGE_PATH", "./data"),
-		UploadDirectory: getEnv("UPLOAD_DIR", "./uploads"),
-		DataDirectory:   getEnv("DATA_DIR", "./data"),
+		Port:               getEnv("PORT", "8080"),
+		Environment:        getEnv("ENVIRONMENT", "development"),
+		MaxFileSize:        getEnvInt64("MAX_FILE_SIZE", 1073741824), // 1GB in bytes
+		AllowedFileTypes:   []string{".pdf", ".jpg", ".jpeg", ".png", ".docx", ".doc", ".xlsx", ".pptx"},
+		StoragePath:        getEnv("STORAGE_PATH", "./data"),
+		UploadDirectory:    getEnv("UPLOAD_DIR", "./uploads"),
+		DataDirectory:      getEnv("DATA_DIR", "./data"),
+		DatabaseType:       getEnv("DATABASE_TYPE", "json"),
+		DatabaseURL:        getEnv("DATABASE_URL", ""),
+		AutoMigrate:        getEnv("DATABASE_AUTO_MIGRATE", "true") != "false",
+		ReloadInterval:     getEnvDuration("RELOAD_INTERVAL", 5*time.Second),
+		AdminToken:         getEnv("ADMIN_TOKEN", ""),
+		UploadExpiry:       getEnvDuration("UPLOAD_EXPIRY", 24*time.Hour),
+		BlobStorage:        getEnv("BLOB_STORAGE", "local"),
+		S3Endpoint:         getEnv("S3_ENDPOINT", ""),
+		S3Region:           getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:           getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:      getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:  getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3PathStyle:        getEnv("S3_PATH_STYLE", "true") != "false",
+		URLSigningSecret:   getEnv("URL_SIGNING_SECRET", ""),
+		SignedURLExpiry:    getEnvDuration("SIGNED_URL_EXPIRY", 15*time.Minute),
+		ClamdAddress:       getEnv("CLAMD_ADDRESS", ""),
+		ClamdTimeout:       getEnvDuration("CLAMD_TIMEOUT", 2*time.Minute),
+		WorkerCount:        int(getEnvInt64("WORKER_COUNT", 4)),
+		QueueSize:          int(getEnvInt64("QUEUE_SIZE", 100)),
+		WorkerPollInterval: getEnvDuration("WORKER_POLL_INTERVAL", 30*time.Second),
+		JobTimeout:         getEnvDuration("JOB_TIMEOUT", 10*time.Minute),
+		ShutdownTimeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
 	}
 }
 
@@ -35,3 +81,25 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvDuration parses a duration such as "5s" from an environment variable,
+// falling back to the default if it is unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvInt64 parses an integer from an environment variable, falling back
+// to the default if it is unset or invalid
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
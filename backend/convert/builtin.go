@@ -0,0 +1,26 @@
+package convert
+
+// builtin lists the converters this build provides, by tool ID
+var builtin = map[string]Registration{
+	"png-to-jpg":  {Inputs: []string{"png"}, Output: "jpg", Converter: Image{}},
+	"jpg-to-png":  {Inputs: []string{"jpg"}, Output: "png", Converter: Image{}},
+	"webp-to-png": {Inputs: []string{"webp"}, Output: "png", Converter: Image{}},
+	"compress-image": {
+		Inputs:    []string{"jpg", "png", "webp", "gif", "pdf"},
+		Output:    "jpg",
+		Outputs:   map[string]string{"pdf": "pdf"},
+		Converter: Image{},
+		Defaults:  map[string]string{OptionMaxSize: MaxSizeDocument},
+	},
+}
+
+// Builtin creates a registry holding every converter this build provides
+func Builtin() (*Registry, error) {
+	r := NewRegistry()
+	for id, reg := range builtin {
+		if err := r.Register(id, reg); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
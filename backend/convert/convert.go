@@ -0,0 +1,227 @@
+// Package convert holds the converters behind the tools in tools.json. Each
+// tool ID is registered with the converter that implements it and the file
+// formats it takes and produces; tools without one are not offered.
+package convert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oneforall/backend/models"
+)
+
+// Job is one file to convert
+type Job struct {
+	// Input is the file to convert, in InputFormat
+	Input       io.Reader
+	InputFormat string
+	// Output receives the converted file, in OutputFormat
+	Output       io.Writer
+	OutputFormat string
+	// Options are the tool options given with the conversion request
+	Options map[string]string
+}
+
+// Result describes a finished conversion
+type Result struct {
+	// Settings are the settings the converter chose, e.g. {"quality": "72"},
+	// recorded on the conversion
+	Settings map[string]string
+}
+
+// Converter is implemented by each conversion engine
+type Converter interface {
+	// Convert reads job.Input and writes the converted file to job.Output.
+	// It must give up when ctx is cancelled.
+	Convert(ctx context.Context, job Job) (Result, error)
+}
+
+// OptionValidator is implemented by converters that can check a request's
+// options before it is queued
+type OptionValidator interface {
+	ValidateOptions(options map[string]string) error
+}
+
+// DocumentOptioner is implemented by converters that take options from the
+// requirements of the conversion's document, such as its pixel dimensions
+type DocumentOptioner interface {
+	DocumentOptions(doc models.Document) map[string]string
+}
+
+// ConverterFunc adapts a function to Converter
+type ConverterFunc func(ctx context.Context, job Job) (Result, error)
+
+// Convert calls f
+func (f ConverterFunc) Convert(ctx context.Context, job Job) (Result, error) {
+	return f(ctx, job)
+}
+
+// MaxSizeDocument is the max_size option value that stands for the size
+// limit of the conversion's document
+const MaxSizeDocument = "document"
+
+// Registration declares what a tool converts and what converts it. Formats
+// are lower-case file extensions without the dot, e.g. "png" or "jpg".
+type Registration struct {
+	Inputs []string
+	Output string
+	// Outputs overrides Output for some input formats
+	Outputs   map[string]string
+	Converter Converter
+	// Defaults are options used when a request does not give them
+	Defaults map[string]string
+}
+
+// Accepts reports whether the tool takes input in format
+func (r Registration) Accepts(format string) bool {
+	for _, in := range r.Inputs {
+		if in == format {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputFor returns the format the tool produces from input in format
+func (r Registration) OutputFor(format string) string {
+	if out, ok := r.Outputs[format]; ok {
+		return out
+	}
+	return r.Output
+}
+
+// CheckInput returns an error naming the formats the tool takes if it does
+// not take input in format
+func (r Registration) CheckInput(toolID, format string) error {
+	if r.Accepts(format) {
+		return nil
+	}
+	if format == "" {
+		format = "extensionless"
+	}
+	return fmt.Errorf("tool %s does not accept %s files; it takes %s", toolID, format, strings.ToUpper(strings.Join(r.Inputs, ", ")))
+}
+
+// ValidateOptions checks options with the converter, if it can
+func (r Registration) ValidateOptions(options map[string]string) error {
+	if v, ok := r.Converter.(OptionValidator); ok {
+		return v.ValidateOptions(options)
+	}
+	return nil
+}
+
+// RequestOptions returns the options to store for a conversion requested
+// with options for doc: the tool's defaults, then the options the converter
+// takes from doc's requirements, overridden by options, with a max_size of
+// "document" replaced by doc's size limit. Storing the requirements
+// themselves keeps the outputs of documents with different rules apart.
+func (r Registration) RequestOptions(options map[string]string, doc models.Document) (map[string]string, error) {
+	merged := make(map[string]string, len(r.Defaults)+len(options))
+	for k, v := range r.Defaults {
+		merged[k] = v
+	}
+	if d, ok := r.Converter.(DocumentOptioner); ok {
+		for k, v := range d.DocumentOptions(doc) {
+			merged[k] = v
+		}
+	}
+	for k, v := range options {
+		merged[k] = v
+	}
+	if merged[OptionMaxSize] == MaxSizeDocument {
+		if doc.MaxSize <= 0 {
+			return nil, fmt.Errorf("document %s has no size limit", doc.ID)
+		}
+		merged[OptionMaxSize] = strconv.FormatInt(doc.MaxSize, 10)
+	}
+	if err := r.ValidateOptions(merged); err != nil {
+		return nil, err
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// Registry maps tool IDs to their converters
+type Registry struct {
+	tools map[string]Registration
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]Registration{}}
+}
+
+// Register adds the converter for toolID
+func (r *Registry) Register(toolID string, reg Registration) error {
+	if _, ok := r.tools[toolID]; ok {
+		return fmt.Errorf("converter for tool %q already registered", toolID)
+	}
+	if reg.Converter == nil || len(reg.Inputs) == 0 || reg.Output == "" {
+		return fmt.Errorf("converter for tool %q needs a converter, input formats and an output format", toolID)
+	}
+	r.tools[toolID] = reg
+	return nil
+}
+
+// Lookup returns the registration for toolID
+func (r *Registry) Lookup(toolID string) (Registration, bool) {
+	reg, ok := r.tools[toolID]
+	return reg, ok
+}
+
+// Supports reports whether toolID has a converter
+func (r *Registry) Supports(toolID string) bool {
+	_, ok := r.tools[toolID]
+	return ok
+}
+
+// ToolIDs lists the registered tools in order
+func (r *Registry) ToolIDs() []string {
+	ids := make([]string, 0, len(r.tools))
+	for id := range r.tools {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Check compares the registry with the tool catalogue. It returns the
+// enabled tools that have no converter, and the registered tools that the
+// catalogue does not list.
+func (r *Registry) Check(categories []models.ToolCategory) (missing, unlisted []string) {
+	listed := map[string]bool{}
+	for _, cat := range categories {
+		for _, tool := range cat.Tools {
+			listed[tool.ID] = true
+			if !cat.Disabled && !tool.Disabled && !r.Supports(tool.ID) {
+				missing = append(missing, tool.ID)
+			}
+		}
+	}
+	for _, id := range r.ToolIDs() {
+		if !listed[id] {
+			unlisted = append(unlisted, id)
+		}
+	}
+	return missing, unlisted
+}
+
+// FormatOf is the format of a file named name, from its extension
+func FormatOf(name string) string {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	switch format {
+	case "jpeg", "jpe", "jfif":
+		return "jpg"
+	case "tif":
+		return "tiff"
+	default:
+		return format
+	}
+}
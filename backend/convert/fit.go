@@ -0,0 +1,255 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strconv"
+
+	"github.com/oneforall/backend/convert/jpegenc"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// minFitQuality is the lowest JPEG quality tried to meet a size limit
+	// before the image is scaled down instead; below it text and faces
+	// smear visibly
+	minFitQuality = 40
+	// minFixedQuality is the lowest JPEG quality tried when the image has
+	// an exact pixel size to keep, leaving quality as the only way down
+	minFixedQuality = 10
+	// minFitDimension is the smallest width or height an image is scaled
+	// down to to meet a size limit, unless its options set a larger minimum
+	minFitDimension = 64
+)
+
+// fitted is an encoded image that meets a size limit, and how it was made
+type fitted struct {
+	data     []byte
+	settings map[string]string
+}
+
+// fitImage encodes img in format no larger than maxSize bytes, keeping as
+// much quality and resolution as it can. For JPEG it first gives up colour
+// resolution, trying chroma subsampling from 4:4:4 through 4:2:2 to 4:2:0 at
+// opts.quality, then looks for the highest quality down to minFitQuality
+// that fits at 4:2:0, and only scales the image down when even that is too
+// large. PNG is lossless, so only scaling helps. Scaling stops at the
+// minimum width and height in opts, and an image with an exact size in opts
+// is not scaled at all. The DPI in opts is recorded in the output and counts
+// towards its size.
+func fitImage(ctx context.Context, img image.Image, format string, maxSize int64, opts imageOptions) (fitted, error) {
+	var encodeFormat func(image.Image, int, jpegenc.Subsampling) ([]byte, error)
+	switch format {
+	case "jpg":
+		img = flatten(img, opts.background)
+		encodeFormat = encodeJPEG
+	case "png":
+		encodeFormat = func(img image.Image, _ int, _ jpegenc.Subsampling) ([]byte, error) { return encodePNG(img) }
+	default:
+		return fitted{}, fmt.Errorf("cannot encode %s images", format)
+	}
+	encode := func(img image.Image, quality int, chroma jpegenc.Subsampling) ([]byte, error) {
+		data, err := encodeFormat(img, quality, chroma)
+		if err != nil {
+			return nil, err
+		}
+		return withDPI(data, format, opts.dpi)
+	}
+
+	fixed := opts.width > 0
+	minQuality := minFitQuality
+	if fixed {
+		minQuality = minFixedQuality
+	}
+	if format != "jpg" {
+		minQuality = opts.quality
+	}
+	minWidth, minHeight := minFitDimension, minFitDimension
+	if opts.minWidth > minWidth {
+		minWidth = opts.minWidth
+	}
+	if opts.minHeight > minHeight {
+		minHeight = opts.minHeight
+	}
+
+	scaled := img
+	scale, tooLarge := 1.0, 0.0
+	for {
+		if err := ctx.Err(); err != nil {
+			return fitted{}, err
+		}
+		chromas := chromaSteps(scaled)
+		if format != "jpg" {
+			chromas = chromas[len(chromas)-1:]
+		}
+		best, err := bestEncoding(ctx, maxSize, minQuality, opts.quality, chromas,
+			func(quality int, chroma jpegenc.Subsampling) ([]byte, error) { return encode(scaled, quality, chroma) })
+		if err != nil {
+			return fitted{}, err
+		}
+		data := best.data
+		if int64(len(data)) <= maxSize {
+			if format == "png" && tooLarge > 0 {
+				// Without a quality to raise, win back resolution lost to
+				// the coarse scaling steps
+				scaled, data, scale = largestFit(ctx, img, maxSize, scaled, data, scale, tooLarge,
+					func(img image.Image) ([]byte, error) { return encode(img, 0, 0) })
+			}
+			b := scaled.Bounds()
+			settings := map[string]string{
+				"width":  strconv.Itoa(b.Dx()),
+				"height": strconv.Itoa(b.Dy()),
+				"scale":  strconv.FormatFloat(scale, 'f', 3, 64),
+			}
+			if format == "jpg" {
+				settings["quality"] = strconv.Itoa(best.quality)
+				if len(chromas) > 1 {
+					settings["chroma_subsampling"] = best.chroma.String()
+				}
+			}
+			return fitted{data: data, settings: settings}, nil
+		}
+
+		// File size grows roughly with the pixel count, so scale both sides
+		// by the square root of how far off the smallest attempt was, and a
+		// little more so the next attempt is likely to fit
+		next := scale * shrinkFactor(maxSize, int64(len(data)))
+		b := img.Bounds()
+		w, h := int(float64(b.Dx())*next), int(float64(b.Dy())*next)
+		if fixed || w < minWidth || h < minHeight {
+			return fitted{}, fmt.Errorf("cannot fit image in %d bytes: %d bytes at quality %d and %dx%d",
+				maxSize, len(data), best.quality, scaled.Bounds().Dx(), scaled.Bounds().Dy())
+		}
+		tooLarge, scale = scale, next
+		scaled = resize(img, w, h)
+	}
+}
+
+// shrinkFactor is how much to scale an image's sides by when its smallest
+// encoding was size bytes and must come down to maxSize: the square root of
+// the ratio, a little less so the next attempt is likely to fit, and
+// between a half and 0.95
+func shrinkFactor(maxSize, size int64) float64 {
+	factor := math.Sqrt(float64(maxSize)/float64(size)) * 0.95
+	return math.Max(0.5, math.Min(factor, 0.95))
+}
+
+// largestFit bisects between a scale that fits in maxSize and a larger one
+// that does not, a few times, and returns the largest fitting scale found
+func largestFit(ctx context.Context, img image.Image, maxSize int64, scaled image.Image, data []byte, fits, tooLarge float64,
+	encode func(image.Image) ([]byte, error)) (image.Image, []byte, float64) {
+	b := img.Bounds()
+	for i := 0; i < 4 && ctx.Err() == nil; i++ {
+		mid := (fits + tooLarge) / 2
+		candidate := resize(img, int(float64(b.Dx())*mid), int(float64(b.Dy())*mid))
+		encoded, err := encode(candidate)
+		if err != nil {
+			break
+		}
+		if int64(len(encoded)) <= maxSize {
+			scaled, data, fits = candidate, encoded, mid
+		} else {
+			tooLarge = mid
+		}
+	}
+	return scaled, data, fits
+}
+
+// encoding is an encoded image and the settings it was encoded with
+type encoding struct {
+	data    []byte
+	quality int
+	chroma  jpegenc.Subsampling
+}
+
+// chromaSteps are the chroma subsamplings to try for img, from the finest
+// to the coarsest. Grey images have no chroma to subsample.
+func chromaSteps(img image.Image) []jpegenc.Subsampling {
+	if _, ok := img.(*image.Gray); ok {
+		return []jpegenc.Subsampling{jpegenc.Subsample420}
+	}
+	return []jpegenc.Subsampling{jpegenc.Subsample444, jpegenc.Subsample422, jpegenc.Subsample420}
+}
+
+// bestEncoding looks for the encoding that keeps the most detail within
+// maxSize. It tries each of chromas in turn at maxQuality, then the highest
+// quality from minQuality to maxQuality that fits with the last of them, by
+// binary search. If none fits it returns the encoding at the lowest quality
+// tried, which is too large. With a single chroma and minQuality equal to
+// maxQuality it encodes once, for formats without those settings.
+func bestEncoding(ctx context.Context, maxSize int64, minQuality, maxQuality int, chromas []jpegenc.Subsampling,
+	encode func(quality int, chroma jpegenc.Subsampling) ([]byte, error)) (encoding, error) {
+	var best encoding
+	for _, chroma := range chromas {
+		if err := ctx.Err(); err != nil {
+			return encoding{}, err
+		}
+		data, err := encode(maxQuality, chroma)
+		if err != nil {
+			return encoding{}, err
+		}
+		best = encoding{data: data, quality: maxQuality, chroma: chroma}
+		if int64(len(data)) <= maxSize {
+			return best, nil
+		}
+	}
+	if maxQuality <= minQuality {
+		return best, nil
+	}
+
+	chroma := best.chroma
+	lo, hi := minQuality, maxQuality-1
+	var fits *encoding
+	smallest := best
+	for lo <= hi {
+		if err := ctx.Err(); err != nil {
+			return encoding{}, err
+		}
+		q := (lo + hi) / 2
+		data, err := encode(q, chroma)
+		if err != nil {
+			return encoding{}, err
+		}
+		if int64(len(data)) <= maxSize {
+			fits = &encoding{data: data, quality: q, chroma: chroma}
+			lo = q + 1
+		} else {
+			smallest = encoding{data: data, quality: q, chroma: chroma}
+			hi = q - 1
+		}
+	}
+	if fits != nil {
+		return *fits, nil
+	}
+	return smallest, nil
+}
+
+// encodeJPEG encodes img as a JPEG at quality with chroma subsampling
+func encodeJPEG(img image.Image, quality int, chroma jpegenc.Subsampling) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpegenc.Encode(&buf, img, &jpegenc.Options{Quality: quality, Subsampling: chroma})
+	return buf.Bytes(), err
+}
+
+// encodePNG encodes img as an 8-bit PNG with the best compression
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	err := enc.Encode(&buf, eightBit(img))
+	return buf.Bytes(), err
+}
+
+// resize scales img to w x h with Catmull-Rom resampling. Grey images stay
+// grey, so they are still encoded with one component.
+func resize(img image.Image, w, h int) image.Image {
+	var dst xdraw.Image = image.NewRGBA(image.Rect(0, 0, w, h))
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(image.Rect(0, 0, w, h))
+	}
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
@@ -0,0 +1,173 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/oneforall/backend/convert/jpegenc"
+)
+
+// noisyImage is a w x h image of smooth colour with seeded noise, which
+// compresses about as badly as a photo
+func noisyImage(w, h int) *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := uint8(rng.Intn(48))
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x*200/w) + n, G: uint8(y*200/h) + n, B: 100 + n, A: 255})
+		}
+	}
+	return img
+}
+
+// fitSize runs fitImage and checks the result is within maxSize and decodes
+// to the size its settings report
+func fitSize(t *testing.T, img image.Image, format string, maxSize int64, options map[string]string) fitted {
+	t.Helper()
+	opts, err := parseImageOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fit, err := fitImage(context.Background(), img, format, maxSize, opts)
+	if err != nil {
+		t.Fatalf("fitImage(%s, %d): %v", format, maxSize, err)
+	}
+	if int64(len(fit.data)) > maxSize {
+		t.Errorf("fitImage(%s, %d) wrote %d bytes", format, maxSize, len(fit.data))
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(fit.data))
+	if err != nil {
+		t.Fatalf("fitImage(%s, %d) output does not decode: %v", format, maxSize, err)
+	}
+	if strconv.Itoa(cfg.Width) != fit.settings["width"] || strconv.Itoa(cfg.Height) != fit.settings["height"] {
+		t.Errorf("output is %dx%d; settings say %v", cfg.Width, cfg.Height, fit.settings)
+	}
+	return fit
+}
+
+func TestFitImageJPEGQuality(t *testing.T) {
+	img := noisyImage(200, 150)
+	high, _ := encodeJPEG(img, defaultQuality, jpegenc.Subsample420)
+	low, _ := encodeJPEG(img, minFitQuality, jpegenc.Subsample420)
+
+	// Fits at full quality, with the finest chroma subsampling that fits
+	for _, chroma := range []jpegenc.Subsampling{jpegenc.Subsample444, jpegenc.Subsample422, jpegenc.Subsample420} {
+		full, _ := encodeJPEG(img, defaultQuality, chroma)
+		fit := fitSize(t, img, "jpg", int64(len(full)), nil)
+		if fit.settings["quality"] != strconv.Itoa(defaultQuality) || fit.settings["scale"] != "1.000" ||
+			fit.settings["chroma_subsampling"] != chroma.String() {
+			t.Errorf("settings %v; want quality %d at %s and scale 1", fit.settings, defaultQuality, chroma)
+		}
+	}
+
+	// Fits once the quality is lowered, without scaling
+	limit := int64(len(low)+len(high)) / 2
+	fit := fitSize(t, img, "jpg", limit, nil)
+	q, _ := strconv.Atoi(fit.settings["quality"])
+	if q < minFitQuality || q >= defaultQuality || fit.settings["scale"] != "1.000" || fit.settings["chroma_subsampling"] != "4:2:0" {
+		t.Errorf("settings %v; want a lower quality at 4:2:0 and scale 1", fit.settings)
+	}
+	if above, _ := encodeJPEG(img, q+1, jpegenc.Subsample420); int64(len(above)) <= limit {
+		t.Errorf("quality %d chosen, but %d also fits", q, q+1)
+	}
+
+	// The quality option caps the search
+	fit = fitSize(t, img, "jpg", int64(len(high)), map[string]string{OptionQuality: "60"})
+	if fit.settings["quality"] != "60" {
+		t.Errorf("settings %v; want the requested quality 60", fit.settings)
+	}
+
+	// Grey images have no chroma to subsample
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, image.Point{}, draw.Src)
+	fit = fitSize(t, gray, "jpg", int64(len(high)), nil)
+	if _, ok := fit.settings["chroma_subsampling"]; ok {
+		t.Errorf("settings %v; want no chroma subsampling for a grey image", fit.settings)
+	}
+}
+
+func TestFitImageScalesDown(t *testing.T) {
+	img := noisyImage(400, 300)
+	low, _ := encodeJPEG(img, minFitQuality, jpegenc.Subsample420)
+	full, _ := encodePNG(img)
+
+	for _, tt := range []struct {
+		format string
+		limit  int64
+	}{
+		{"jpg", int64(len(low)) / 3},
+		{"png", int64(len(full)) / 3},
+	} {
+		fit := fitSize(t, img, tt.format, tt.limit, nil)
+		scale, _ := strconv.ParseFloat(fit.settings["scale"], 64)
+		if scale >= 1 || scale < 0.3 {
+			t.Errorf("%s: scale %v; want a moderate reduction", tt.format, scale)
+		}
+		w, _ := strconv.Atoi(fit.settings["width"])
+		h, _ := strconv.Atoi(fit.settings["height"])
+		// Scaling keeps the shape
+		if diff := w*300 - h*400; diff > 400 || diff < -400 {
+			t.Errorf("%s: scaled to %dx%d; want 4:3", tt.format, w, h)
+		}
+		// The smaller image wins back quality
+		if q, _ := strconv.Atoi(fit.settings["quality"]); tt.format == "jpg" && q < minFitQuality {
+			t.Errorf("jpg: scaled at quality %d; want at least %d", q, minFitQuality)
+		}
+	}
+}
+
+func TestFitImageLimits(t *testing.T) {
+	img := noisyImage(400, 300)
+	low, _ := encodeJPEG(img, minFitQuality, jpegenc.Subsample420)
+	ctx := context.Background()
+
+	// An exact size is kept, trading quality below minFitQuality instead
+	opts, _ := parseImageOptions(map[string]string{OptionWidth: "400", OptionHeight: "300"})
+	fit, err := fitImage(ctx, img, "jpg", int64(len(low))*3/4, opts)
+	if err != nil {
+		t.Fatalf("exact size: %v", err)
+	}
+	if q, _ := strconv.Atoi(fit.settings["quality"]); q >= minFitQuality || q < minFixedQuality || fit.settings["width"] != "400" {
+		t.Errorf("exact size: settings %v; want 400 wide below quality %d", fit.settings, minFitQuality)
+	}
+	if _, err := fitImage(ctx, img, "jpg", 500, opts); err == nil {
+		t.Error("exact size: fitted in 500 bytes")
+	}
+
+	// Scaling stops at minFitDimension, or the minimum width asked for
+	opts, _ = parseImageOptions(nil)
+	if _, err := fitImage(ctx, img, "jpg", 300, opts); err == nil {
+		t.Error("fitted in 300 bytes")
+	}
+	opts, _ = parseImageOptions(map[string]string{OptionMinWidth: "350"})
+	if _, err := fitImage(ctx, img, "jpg", int64(len(low))/3, opts); err == nil {
+		t.Error("scaled below the minimum width")
+	}
+
+	// The recorded DPI counts towards the size: the JFIF segment would
+	// push an exact fit at full quality over the limit
+	high, _ := encodeJPEG(img, defaultQuality, jpegenc.Subsample420)
+	fit = fitSize(t, img, "jpg", int64(len(high)), map[string]string{OptionDPI: "300"})
+	if fit.settings["quality"] == strconv.Itoa(defaultQuality) {
+		t.Errorf("settings %v; want a lower quality to make room for the DPI", fit.settings)
+	}
+	if info, err := ReadImageInfo(bytes.NewReader(fit.data), int64(len(fit.data)), "jpg"); err != nil || info.DPI != 300 {
+		t.Errorf("fitted output reads back as %+v, %v; want 300 dpi", info, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := fitImage(cancelled, img, "jpg", int64(len(low))/3, opts); err == nil {
+		t.Error("fitImage ran after its context was cancelled")
+	}
+	if _, err := fitImage(ctx, img, "gif", 1000, opts); err == nil {
+		t.Error("fitted a GIF")
+	}
+}
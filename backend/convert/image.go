@@ -0,0 +1,351 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/utils"
+	"golang.org/x/image/webp"
+)
+
+// Image options
+const (
+	// OptionQuality is the JPEG quality, 1-100
+	OptionQuality = "quality"
+	// OptionBackground is the colour transparent pixels are flattened onto
+	// when the output has no alpha channel, as #rgb or #rrggbb
+	OptionBackground = "background"
+	// OptionMaxSize is a size limit in bytes for the output. Chroma
+	// resolution, quality, and if need be resolution, are lowered until the
+	// output fits; quality then becomes the highest quality tried.
+	OptionMaxSize = "max_size"
+	// OptionWidth and OptionHeight give an exact output size in pixels,
+	// together. The image is cropped or padded to their ratio first.
+	OptionWidth  = "width"
+	OptionHeight = "height"
+	// OptionMinWidth, OptionMaxWidth, OptionMinHeight and OptionMaxHeight
+	// bound the output size in pixels; the image is scaled to fit them
+	OptionMinWidth  = "min_width"
+	OptionMaxWidth  = "max_width"
+	OptionMinHeight = "min_height"
+	OptionMaxHeight = "max_height"
+	// OptionAspectRatio is a width:height ratio such as "3:4" the image is
+	// cropped or padded to
+	OptionAspectRatio = "aspect_ratio"
+	// OptionFit is how the image is brought to a ratio: "crop" (the
+	// default) trims the edges, "pad" adds background around it
+	OptionFit = "fit"
+	// OptionDPI is recorded in the output file's metadata
+	OptionDPI = "dpi"
+)
+
+const (
+	defaultQuality = 90
+	// maxImagePixels bounds the images decoded, so a small file declaring
+	// huge dimensions cannot exhaust memory
+	maxImagePixels = 50_000_000
+	// maxImageDimension bounds the pixel sizes options may ask for
+	maxImageDimension = 20_000
+)
+
+// imageDecoders decode each input format. GIFs are decoded to their first
+// frame.
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	"png":  png.Decode,
+	"jpg":  jpeg.Decode,
+	"gif":  gif.Decode,
+	"webp": webp.Decode,
+}
+
+// imageConfigDecoders read each input format's dimensions
+var imageConfigDecoders = map[string]func(io.Reader) (image.Config, error){
+	"png":  png.DecodeConfig,
+	"jpg":  jpeg.DecodeConfig,
+	"gif":  gif.DecodeConfig,
+	"webp": webp.DecodeConfig,
+}
+
+// Image converts between image formats in pure Go. It decodes PNG, JPEG,
+// GIF and WEBP, and encodes PNG and JPEG. It also compresses PDFs to a size
+// limit by re-encoding their images.
+type Image struct{}
+
+// Convert decodes job.Input, brings it to the required shape and pixel
+// size, and encodes it in job.OutputFormat with the DPI recorded, within
+// max_size if it is given. A PDF input is compressed to max_size, which it
+// requires, and written as a PDF.
+func (Image) Convert(ctx context.Context, job Job) (Result, error) {
+	opts, err := parseImageOptions(job.Options)
+	if err != nil {
+		return Result{}, err
+	}
+	if job.InputFormat == "pdf" {
+		return convertPDF(ctx, job, opts)
+	}
+	img, err := decodeImage(job.Input, job.InputFormat)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	img, settings, err := shapeImage(img, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var data []byte
+	if opts.maxSize == 0 {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, job.OutputFormat, opts); err != nil {
+			return Result{}, err
+		}
+		if data, err = withDPI(buf.Bytes(), job.OutputFormat, opts.dpi); err != nil {
+			return Result{}, err
+		}
+	} else {
+		fit, err := fitImage(ctx, img, job.OutputFormat, opts.maxSize, opts)
+		if err != nil {
+			return Result{}, err
+		}
+		data = fit.data
+		if settings == nil {
+			settings = fit.settings
+		}
+		for k, v := range fit.settings {
+			settings[k] = v
+		}
+	}
+	if opts.dpi > 0 {
+		if settings == nil {
+			settings = map[string]string{}
+		}
+		settings["dpi"] = strconv.Itoa(opts.dpi)
+	}
+	if _, err := job.Output.Write(data); err != nil {
+		return Result{}, err
+	}
+	return Result{Settings: settings}, nil
+}
+
+// DocumentOptions turns a document's image requirements into options, so
+// conversions for the document meet them
+func (Image) DocumentOptions(doc models.Document) map[string]string {
+	options := map[string]string{}
+	setInt := func(key string, v int) {
+		if v > 0 {
+			options[key] = strconv.Itoa(v)
+		}
+	}
+	w, h := doc.PixelSize()
+	setInt(OptionWidth, w)
+	setInt(OptionHeight, h)
+	setInt(OptionMinWidth, doc.MinWidth)
+	setInt(OptionMaxWidth, doc.MaxWidth)
+	setInt(OptionMinHeight, doc.MinHeight)
+	setInt(OptionMaxHeight, doc.MaxHeight)
+	setInt(OptionDPI, doc.DPI)
+	if doc.AspectRatio != "" {
+		options[OptionAspectRatio] = doc.AspectRatio
+	}
+	if doc.Fit != "" && (w > 0 || doc.AspectRatio != "") {
+		options[OptionFit] = doc.Fit
+	}
+	return options
+}
+
+// ValidateOptions checks the options before a conversion is queued
+func (Image) ValidateOptions(options map[string]string) error {
+	_, err := parseImageOptions(options)
+	return err
+}
+
+// imageOptions are the parsed image options
+type imageOptions struct {
+	quality    int
+	background color.Color
+	maxSize    int64
+
+	// width and height are an exact size, or zero
+	width, height int
+	// Bounds on the size; zero is unbounded
+	minWidth, maxWidth, minHeight, maxHeight int
+	// aspect is width divided by height, or zero
+	aspect float64
+	fit    string
+	dpi    int
+}
+
+// parseImageOptions reads the image options, using defaults for those not
+// given. Options that do not apply to images are ignored.
+func parseImageOptions(options map[string]string) (imageOptions, error) {
+	opts := imageOptions{quality: defaultQuality, background: color.White}
+	if v, ok := options[OptionQuality]; ok {
+		q, err := strconv.Atoi(v)
+		if err != nil || q < 1 || q > 100 {
+			return opts, fmt.Errorf("invalid %s %q: want a number from 1 to 100", OptionQuality, v)
+		}
+		opts.quality = q
+	}
+	if v, ok := options[OptionBackground]; ok {
+		c, err := parseHexColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: want a colour like #ffffff", OptionBackground, v)
+		}
+		opts.background = c
+	}
+	if v, ok := options[OptionMaxSize]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid %s %q: want a size in bytes", OptionMaxSize, v)
+		}
+		opts.maxSize = n
+	}
+
+	for _, o := range []struct {
+		key string
+		dst *int
+		max int
+	}{
+		{OptionWidth, &opts.width, maxImageDimension},
+		{OptionHeight, &opts.height, maxImageDimension},
+		{OptionMinWidth, &opts.minWidth, maxImageDimension},
+		{OptionMaxWidth, &opts.maxWidth, maxImageDimension},
+		{OptionMinHeight, &opts.minHeight, maxImageDimension},
+		{OptionMaxHeight, &opts.maxHeight, maxImageDimension},
+		{OptionDPI, &opts.dpi, utils.MaxDPI},
+	} {
+		v, ok := options[o.key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > o.max {
+			return opts, fmt.Errorf("invalid %s %q: want a number from 1 to %d", o.key, v, o.max)
+		}
+		*o.dst = n
+	}
+	if (opts.width > 0) != (opts.height > 0) {
+		return opts, fmt.Errorf("%s and %s must be given together", OptionWidth, OptionHeight)
+	}
+	if int64(opts.width)*int64(opts.height) > maxImagePixels {
+		return opts, fmt.Errorf("%dx%d is larger than the %d megapixel limit", opts.width, opts.height, maxImagePixels/1_000_000)
+	}
+	if opts.maxWidth > 0 && opts.minWidth > opts.maxWidth {
+		return opts, fmt.Errorf("%s is larger than %s", OptionMinWidth, OptionMaxWidth)
+	}
+	if opts.maxHeight > 0 && opts.minHeight > opts.maxHeight {
+		return opts, fmt.Errorf("%s is larger than %s", OptionMinHeight, OptionMaxHeight)
+	}
+	if v, ok := options[OptionAspectRatio]; ok {
+		ratio, err := utils.ParseAspectRatio(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: want width:height, like 3:4", OptionAspectRatio, v)
+		}
+		opts.aspect = ratio
+	}
+	opts.fit = models.FitCrop
+	if v, ok := options[OptionFit]; ok {
+		if v != models.FitCrop && v != models.FitPad {
+			return opts, fmt.Errorf("invalid %s %q: want %s or %s", OptionFit, v, models.FitCrop, models.FitPad)
+		}
+		opts.fit = v
+	}
+	return opts, nil
+}
+
+// parseHexColor parses #rgb or #rrggbb, with or without the #
+func parseHexColor(s string) (color.Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return nil, fmt.Errorf("bad length")
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// decodeImage decodes an image in format, refusing images larger than
+// maxImagePixels before their pixels are decoded
+func decodeImage(r io.Reader, format string) (image.Image, error) {
+	decode, ok := imageDecoders[format]
+	if !ok {
+		return nil, fmt.Errorf("cannot decode %s images", format)
+	}
+
+	// Keep the bytes read for the header so the image can be decoded from
+	// the start without seeking
+	var header bytes.Buffer
+	cfg, err := imageConfigDecoders[format](io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s image: %w", format, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%s image has no pixels", format)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image is %dx%d, larger than the %d megapixel limit",
+			cfg.Width, cfg.Height, maxImagePixels/1_000_000)
+	}
+
+	img, err := decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %w", format, err)
+	}
+	return img, nil
+}
+
+// encodeImage writes img in format
+func encodeImage(w io.Writer, img image.Image, format string, opts imageOptions) error {
+	switch format {
+	case "jpg":
+		return jpeg.Encode(w, flatten(img, opts.background), &jpeg.Options{Quality: opts.quality})
+	case "png":
+		return png.Encode(w, eightBit(img))
+	default:
+		return fmt.Errorf("cannot encode %s images", format)
+	}
+}
+
+// flatten draws img over a background colour, for formats without
+// transparency. Opaque images are returned as they are.
+func flatten(img image.Image, background color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+// eightBit returns img in a type the PNG encoder writes with 8 bits per
+// channel. It would write others, such as the YCbCr images JPEG and WEBP
+// decode to, with 16 bits, doubling the file size for no gain.
+func eightBit(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.RGBA, *image.NRGBA, *image.Gray, *image.Paletted:
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
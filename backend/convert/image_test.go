@@ -0,0 +1,223 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// testImage is an opaque gradient, so every pixel differs from its
+// neighbours and a wrong offset or channel order shows
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// encodeTestImage encodes img as an input in format. WEBP, which cannot be
+// encoded in pure Go, comes from testdata instead of img.
+func encodeTestImage(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	case "gif":
+		// Without dithering, whose noise JPEG would blur away
+		err = gif.Encode(&buf, img, &gif.Options{NumColors: 256, Drawer: draw.Src})
+	case "webp":
+		data, err := os.ReadFile("testdata/gopher.webp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	default:
+		t.Fatalf("no encoder for %s", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// convertImage runs the image converter and decodes its output, checking
+// the output is in format
+func convertImage(t *testing.T, input []byte, from, to string, options map[string]string) (image.Image, Result) {
+	t.Helper()
+	var out bytes.Buffer
+	result, err := Image{}.Convert(context.Background(), Job{
+		Input:        bytes.NewReader(input),
+		InputFormat:  from,
+		Output:       &out,
+		OutputFormat: to,
+		Options:      options,
+	})
+	if err != nil {
+		t.Fatalf("%s to %s: %v", from, to, err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("%s to %s: output does not decode: %v", from, to, err)
+	}
+	if want := map[string]string{"jpg": "jpeg", "png": "png"}[to]; format != want {
+		t.Fatalf("%s to %s: output is %s", from, to, format)
+	}
+	return img, result
+}
+
+// nrgba converts img to non-premultiplied RGBA
+func nrgba(img image.Image) *image.NRGBA {
+	dst := image.NewNRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// meanDifference is the mean absolute difference between the colour
+// channels of two same-sized images, ignoring the colour of pixels that
+// are fully transparent in both
+func meanDifference(a, b *image.NRGBA) float64 {
+	var sum, n float64
+	for i := 0; i < len(a.Pix); i += 4 {
+		if a.Pix[i+3] == 0 && b.Pix[i+3] == 0 {
+			n += 4
+			continue
+		}
+		for c := 0; c < 4; c++ {
+			d := float64(a.Pix[i+c]) - float64(b.Pix[i+c])
+			if d < 0 {
+				d = -d
+			}
+			sum += d
+			n++
+		}
+	}
+	return sum / n
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	src := testImage(48, 32)
+	for _, from := range []string{"png", "jpg", "gif", "webp"} {
+		input := encodeTestImage(t, src, from)
+		// The expected pixels are the input's as decoded, so the loss of
+		// the input encoding is not counted against the conversion
+		decoded, err := imageDecoders[from](bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("decoding %s fixture: %v", from, err)
+		}
+		for _, to := range []string{"png", "jpg"} {
+			got, _ := convertImage(t, input, from, to, nil)
+			if got.Bounds().Size() != decoded.Bounds().Size() {
+				t.Errorf("%s to %s: size %v; want %v", from, to, got.Bounds().Size(), decoded.Bounds().Size())
+				continue
+			}
+			want := decoded
+			limit := 0.0
+			if to == "jpg" {
+				want = flatten(decoded, color.White)
+				limit = 6
+			}
+			if d := meanDifference(nrgba(got), nrgba(want)); d > limit {
+				t.Errorf("%s to %s: pixels differ by %.2f on average; want at most %.0f", from, to, d, limit)
+			}
+		}
+	}
+}
+
+func TestImageTransparencyOnJPEG(t *testing.T) {
+	// Left half transparent, right half opaque red
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(src, image.Rect(8, 0, 16, 16), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	input := encodeTestImage(t, src, "png")
+
+	near := func(c color.Color, want color.NRGBA) bool {
+		got := color.NRGBAModel.Convert(c).(color.NRGBA)
+		close := func(a, b uint8) bool { return a-b < 8 || b-a < 8 }
+		return close(got.R, want.R) && close(got.G, want.G) && close(got.B, want.B) && got.A == 255
+	}
+	white := color.NRGBA{255, 255, 255, 255}
+	red := color.NRGBA{255, 0, 0, 255}
+
+	got, _ := convertImage(t, input, "png", "jpg", nil)
+	if c := got.At(2, 8); !near(c, white) {
+		t.Errorf("transparent pixel became %v; want white", c)
+	}
+	if c := got.At(13, 8); !near(c, red) {
+		t.Errorf("opaque pixel became %v; want red", c)
+	}
+
+	got, _ = convertImage(t, input, "png", "jpg", map[string]string{OptionBackground: "#00f"})
+	if c := got.At(2, 8); !near(c, color.NRGBA{0, 0, 255, 255}) {
+		t.Errorf("transparent pixel on a blue background became %v; want blue", c)
+	}
+
+	// A GIF's transparent index is flattened the same way
+	pal := image.NewPaletted(src.Bounds(), color.Palette{color.Transparent, red})
+	draw.Draw(pal, pal.Bounds(), src, image.Point{}, draw.Src)
+	got, _ = convertImage(t, encodeTestImage(t, pal, "gif"), "gif", "jpg", nil)
+	if c := got.At(2, 8); !near(c, white) {
+		t.Errorf("transparent GIF pixel became %v; want white", c)
+	}
+
+	// PNG output keeps the transparency
+	got, _ = convertImage(t, input, "png", "png", nil)
+	if _, _, _, a := got.At(2, 8).RGBA(); a != 0 {
+		t.Errorf("PNG output alpha = %d; want transparent", a)
+	}
+}
+
+func TestImageConvertErrors(t *testing.T) {
+	png := encodeTestImage(t, testImage(4, 4), "png")
+	tests := []struct {
+		name     string
+		input    []byte
+		from, to string
+		options  map[string]string
+	}{
+		{"not an image", []byte("not a png"), "png", "jpg", nil},
+		{"truncated", png[:len(png)/2], "png", "jpg", nil},
+		{"wrong input format", png, "jpg", "png", nil},
+		{"unknown input format", png, "bmp", "png", nil},
+		{"unknown output format", png, "png", "webp", nil},
+		{"bad quality", png, "png", "jpg", map[string]string{OptionQuality: "0"}},
+		{"bad background", png, "png", "jpg", map[string]string{OptionBackground: "white"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		_, err := Image{}.Convert(context.Background(), Job{
+			Input: bytes.NewReader(tt.input), InputFormat: tt.from,
+			Output: &out, OutputFormat: tt.to, Options: tt.options,
+		})
+		if err == nil {
+			t.Errorf("%s: Convert succeeded", tt.name)
+		}
+	}
+}
+
+// TestDecodeImagePixelLimit checks that images declaring more pixels than
+// maxImagePixels are refused from their header alone
+func TestDecodeImagePixelLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Rewrite the IHDR dimensions; the decoder never gets to the CRC
+	copy(data[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10}) // 10000x10000
+	if _, err := decodeImage(bytes.NewReader(data), "png"); err == nil {
+		t.Error("decoded an image over the pixel limit")
+	}
+}
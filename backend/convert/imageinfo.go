@@ -0,0 +1,108 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ImageInfo describes an image file without decoding its pixels
+type ImageInfo struct {
+	Width, Height int
+	// DPI is the resolution recorded in the file, or zero if it records none
+	DPI int
+}
+
+// IsImageFormat reports whether images in format can be read and converted
+func IsImageFormat(format string) bool {
+	_, ok := imageDecoders[format]
+	return ok
+}
+
+// ReadImageInfo reads the dimensions and recorded DPI of the size bytes of
+// an image in format readable from r. The DPI is read from the JFIF segment
+// of a JPEG and the pHYs chunk of a PNG; EXIF resolution is not read.
+func ReadImageInfo(r io.ReaderAt, size int64, format string) (ImageInfo, error) {
+	decode, ok := imageConfigDecoders[format]
+	if !ok {
+		return ImageInfo{}, fmt.Errorf("cannot read %s images", format)
+	}
+	cfg, err := decode(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to read %s image: %w", format, err)
+	}
+	info := ImageInfo{Width: cfg.Width, Height: cfg.Height}
+	switch format {
+	case "jpg":
+		info.DPI = jpegDPI(r, size)
+	case "png":
+		info.DPI = pngDPI(r, size)
+	}
+	return info, nil
+}
+
+// jpegDPI reads the density of the JFIF segment among the segments before
+// the image data, or zero if there is none or it gives no unit
+func jpegDPI(r io.ReaderAt, size int64) int {
+	off := int64(2) // after the start of image marker
+	for off+4 <= size {
+		var hdr [4]byte
+		if _, err := r.ReadAt(hdr[:], off); err != nil || hdr[0] != 0xff {
+			return 0
+		}
+		marker, length := hdr[1], int64(binary.BigEndian.Uint16(hdr[2:]))
+		if marker == 0xda || length < 2 {
+			// Start of scan: no more metadata segments
+			return 0
+		}
+		if marker == 0xe0 && length >= 14 {
+			seg := make([]byte, 12)
+			if _, err := r.ReadAt(seg, off+4); err != nil {
+				return 0
+			}
+			if bytes.Equal(seg[:5], []byte("JFIF\x00")) {
+				density := float64(binary.BigEndian.Uint16(seg[8:10]))
+				switch seg[7] {
+				case 1: // dots per inch
+					return int(density)
+				case 2: // dots per centimetre
+					return int(math.Round(density * 2.54))
+				}
+				return 0
+			}
+		}
+		off += 2 + length
+	}
+	return 0
+}
+
+// pngDPI reads the pHYs chunk among the chunks before the image data, or
+// zero if there is none or it gives no unit
+func pngDPI(r io.ReaderAt, size int64) int {
+	off := int64(8) // after the signature
+	for off+8 <= size {
+		var hdr [8]byte
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return 0
+		}
+		length, typ := int64(binary.BigEndian.Uint32(hdr[:4])), string(hdr[4:])
+		switch typ {
+		case "IDAT", "IEND":
+			return 0
+		case "pHYs":
+			data := make([]byte, 9)
+			if length != 9 {
+				return 0
+			}
+			if _, err := r.ReadAt(data, off+8); err != nil || data[8] != 1 {
+				return 0
+			}
+			ppm := float64(binary.BigEndian.Uint32(data[:4]))
+			return int(math.Round(ppm * 0.0254))
+		}
+		off += 8 + length + 4
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestWithDPIReadBack(t *testing.T) {
+	img := testImage(30, 20)
+	for _, format := range []string{"jpg", "png"} {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, format, imageOptions{quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		plain := buf.Bytes()
+
+		info, err := ReadImageInfo(bytes.NewReader(plain), int64(len(plain)), format)
+		if err != nil || info != (ImageInfo{Width: 30, Height: 20}) {
+			t.Errorf("%s without dpi: %+v, %v; want 30x20 and no DPI", format, info, err)
+		}
+
+		for _, dpi := range []int{72, 200, 300, 600} {
+			data, err := withDPI(plain, format, dpi)
+			if err != nil {
+				t.Fatalf("%s at %d dpi: %v", format, dpi, err)
+			}
+			info, err := ReadImageInfo(bytes.NewReader(data), int64(len(data)), format)
+			if err != nil || info != (ImageInfo{Width: 30, Height: 20, DPI: dpi}) {
+				t.Errorf("%s at %d dpi: read back %+v, %v", format, dpi, info, err)
+			}
+			// The image itself is untouched
+			decoded, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil || decoded.Bounds().Size() != image.Pt(30, 20) {
+				t.Errorf("%s at %d dpi does not decode: %v", format, dpi, err)
+			}
+		}
+
+		if data, err := withDPI(plain, format, 0); err != nil || !bytes.Equal(data, plain) {
+			t.Errorf("%s at 0 dpi changed the image", format)
+		}
+	}
+
+	if _, err := withDPI([]byte("GIF89a"), "gif", 300); err == nil {
+		t.Error("recorded dpi in a GIF")
+	}
+	if _, err := withDPI([]byte("not a jpeg"), "jpg", 300); err == nil {
+		t.Error("recorded dpi in a non-JPEG")
+	}
+	if _, err := withDPI([]byte("not a png"), "png", 300); err == nil {
+		t.Error("recorded dpi in a non-PNG")
+	}
+}
+
+// TestImageDPIOption checks that the converter records the dpi option
+// and reports it, with and without a size limit
+func TestImageDPIOption(t *testing.T) {
+	input := encodeTestImage(t, testImage(40, 40), "png")
+	for _, to := range []string{"jpg", "png"} {
+		for _, options := range []map[string]string{
+			{OptionDPI: "300"},
+			{OptionDPI: "300", OptionMaxSize: "100000"},
+		} {
+			var out bytes.Buffer
+			result, err := Image{}.Convert(context.Background(), Job{
+				Input: bytes.NewReader(input), InputFormat: "png",
+				Output: &out, OutputFormat: to, Options: options,
+			})
+			if err != nil {
+				t.Fatalf("%s %v: %v", to, options, err)
+			}
+			info, err := ReadImageInfo(bytes.NewReader(out.Bytes()), int64(out.Len()), to)
+			if err != nil || info.DPI != 300 {
+				t.Errorf("%s %v: read back %+v, %v; want 300 dpi", to, options, info, err)
+			}
+			if result.Settings["dpi"] != "300" {
+				t.Errorf("%s %v: settings %v; want dpi 300", to, options, result.Settings)
+			}
+		}
+	}
+}
+
+// pngChunk encodes one PNG chunk
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestReadImageInfoDPIVariants(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 3)))
+	plainPNG := buf.Bytes()
+	const ihdrEnd = 33
+	withChunk := func(chunk []byte) []byte {
+		return append(append(append([]byte{}, plainPNG[:ihdrEnd]...), chunk...), plainPNG[ihdrEnd:]...)
+	}
+	phys := func(ppm uint32, unit byte) []byte {
+		data := binary.BigEndian.AppendUint32(nil, ppm)
+		data = binary.BigEndian.AppendUint32(data, ppm)
+		return pngChunk("pHYs", append(data, unit))
+	}
+
+	var jbuf bytes.Buffer
+	encodeImage(&jbuf, image.NewGray(image.Rect(0, 0, 4, 3)), "jpg", imageOptions{quality: 90})
+	plainJPEG := jbuf.Bytes()
+	jfif := func(unit byte, density uint16) []byte {
+		seg := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 2, unit,
+			byte(density >> 8), byte(density), byte(density >> 8), byte(density), 0, 0}
+		return append(append(append([]byte{}, plainJPEG[:2]...), seg...), plainJPEG[2:]...)
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		want   int
+	}{
+		{"png pixels per metre", "png", withChunk(phys(11811, 1)), 300},
+		{"png unknown unit", "png", withChunk(phys(11811, 0)), 0},
+		{"png after another chunk", "png", withChunk(append(pngChunk("tEXt", []byte("k\x00v")), phys(3780, 1)...)), 96},
+		{"jpeg dots per inch", "jpg", jfif(1, 150), 150},
+		{"jpeg dots per centimetre", "jpg", jfif(2, 118), 300},
+		{"jpeg aspect ratio only", "jpg", jfif(0, 1), 0},
+	}
+	for _, tt := range tests {
+		info, err := ReadImageInfo(bytes.NewReader(tt.data), int64(len(tt.data)), tt.format)
+		if err != nil || info.DPI != tt.want || info.Width != 4 || info.Height != 3 {
+			t.Errorf("%s: %+v, %v; want 4x3 at %d dpi", tt.name, info, err, tt.want)
+		}
+	}
+
+	if _, err := ReadImageInfo(bytes.NewReader([]byte("junk")), 4, "png"); err == nil {
+		t.Error("read info from junk")
+	}
+	if _, err := ReadImageInfo(bytes.NewReader(plainPNG), int64(len(plainPNG)), "bmp"); err == nil {
+		t.Error("read info of an unsupported format")
+	}
+}
@@ -0,0 +1,578 @@
+// The tables, Huffman coding and marker writing in this file are adapted
+// from Go's image/jpeg writer:
+//
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jpegenc writes baseline JPEG images with a choice of chroma
+// subsampling. image/jpeg always subsamples colour 4:2:0; this encoder also
+// writes 4:2:2 and 4:4:4, which keep more colour detail at a larger size.
+// The quantisation and Huffman tables, the quality scaling and the marker
+// and entropy coding follow image/jpeg's writer, so at 4:2:0 the output is
+// much the same size as image/jpeg's.
+package jpegenc
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Subsampling is how much of the colour resolution is kept
+type Subsampling int
+
+const (
+	// Subsample420 halves the colour resolution both ways, as image/jpeg
+	// does
+	Subsample420 Subsampling = iota
+	// Subsample422 halves the colour resolution horizontally
+	Subsample422
+	// Subsample444 keeps the colour at full resolution
+	Subsample444
+)
+
+// String names s in the usual J:a:b notation, e.g. "4:2:0"
+func (s Subsampling) String() string {
+	switch s {
+	case Subsample420:
+		return "4:2:0"
+	case Subsample422:
+		return "4:2:2"
+	case Subsample444:
+		return "4:4:4"
+	default:
+		return "unknown"
+	}
+}
+
+// factors are the horizontal and vertical sampling factors of the luma
+// component, relative to the chroma components
+func (s Subsampling) factors() (h, v int) {
+	switch s {
+	case Subsample422:
+		return 2, 1
+	case Subsample444:
+		return 1, 1
+	default:
+		return 2, 2
+	}
+}
+
+// DefaultQuality is the quality used when no options are given
+const DefaultQuality = 75
+
+// Options are the encoding parameters. Quality ranges from 1 to 100,
+// higher is better; the zero Subsampling is 4:2:0.
+type Options struct {
+	Quality     int
+	Subsampling Subsampling
+}
+
+const blockSize = 64
+
+// block is an 8x8 block of samples or coefficients in natural order
+type block [blockSize]float32
+
+// unzig maps the zig-zag order of coefficients to their natural order
+var unzig = [blockSize]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// unscaledQuant are the quantisation tables of section K.1 of the spec, in
+// zig-zag order, for luma and chroma
+var unscaledQuant = [2][blockSize]byte{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec is a Huffman table: count[i] codes of i+1 bits, for the
+// values in order
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+// huffmanSpecs are the tables of section K.3 of the spec: luma DC, luma
+// AC, chroma DC and chroma AC
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanLUTs map each value of huffmanSpecs to its code: the code length
+// in the top 8 bits and the code in the low 24
+var huffmanLUTs [4][]uint32
+
+// aanScale are the scale factors the AAN DCT leaves on its outputs:
+// 1 for the DC term and cos(k*pi/16)*sqrt(2) for the others
+var aanScale = [8]float32{
+	1, 1.387039845, 1.306562965, 1.175875602,
+	1, 0.785694958, 0.541196100, 0.275899379,
+}
+
+func init() {
+	for i, s := range huffmanSpecs {
+		maxValue := 0
+		for _, v := range s.value {
+			if int(v) > maxValue {
+				maxValue = int(v)
+			}
+		}
+		lut := make([]uint32, maxValue+1)
+		code, k := uint32(0), 0
+		for n := range s.count {
+			for j := byte(0); j < s.count[n]; j++ {
+				lut[s.value[k]] = uint32(n+1)<<24 | code
+				code++
+				k++
+			}
+			code <<= 1
+		}
+		huffmanLUTs[i] = lut
+	}
+}
+
+// encoder writes one image
+type encoder struct {
+	w   *bufio.Writer
+	err error
+	// bits and nBits are bits waiting to be written
+	bits, nBits uint32
+	// quant are the scaled quantisation tables in zig-zag order, and
+	// divisors the same tables in natural order with the DCT's output
+	// scaling folded in
+	quant    [2][blockSize]byte
+	divisors [2]block
+}
+
+// Encode writes m to w as a baseline JPEG. A nil o uses DefaultQuality and
+// 4:2:0. Grey images are written without chroma.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return errors.New("jpegenc: image has no pixels")
+	}
+	if b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return errors.New("jpegenc: image is too large to encode")
+	}
+	opts := Options{Quality: DefaultQuality}
+	if o != nil {
+		opts = *o
+	}
+	quality := min(max(opts.Quality, 1), 100)
+
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.initQuant(quality)
+	gray, isGray := m.(*image.Gray)
+	components := 3
+	if isGray {
+		components = 1
+	}
+	h, v := opts.Subsampling.factors()
+
+	e.write([]byte{0xff, 0xd8})
+	e.writeDQT(components)
+	e.writeSOF0(b.Size(), components, h, v)
+	e.writeDHT(components)
+	if isGray {
+		e.writeGrayScan(gray)
+	} else {
+		e.writeColourScan(m, h, v)
+	}
+	e.write([]byte{0xff, 0xd9})
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.err
+}
+
+// initQuant scales the quantisation tables to quality as image/jpeg does
+func (e *encoder) initQuant(quality int) {
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range e.quant {
+		for zig := range e.quant[i] {
+			x := (int(unscaledQuant[i][zig])*scale + 50) / 100
+			x = min(max(x, 1), 255)
+			e.quant[i][zig] = uint8(x)
+			k := unzig[zig]
+			e.divisors[i][k] = float32(x) * aanScale[k/8] * aanScale[k%8] * 8
+		}
+	}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) writeByte(b byte) {
+	if e.err != nil {
+		return
+	}
+	e.err = e.w.WriteByte(b)
+}
+
+// writeMarker writes a marker and the length of a segment with n bytes
+// of content
+func (e *encoder) writeMarker(marker byte, n int) {
+	n += 2
+	e.write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+}
+
+// writeDQT writes the quantisation tables, leaving out chroma's for grey
+// images
+func (e *encoder) writeDQT(components int) {
+	tables := min(components, 2)
+	e.writeMarker(0xdb, tables*(1+blockSize))
+	for i := 0; i < tables; i++ {
+		e.writeByte(byte(i))
+		e.write(e.quant[i][:])
+	}
+}
+
+// writeSOF0 writes the frame header. Luma is sampled h by v times as
+// densely as chroma.
+func (e *encoder) writeSOF0(size image.Point, components, h, v int) {
+	e.writeMarker(0xc0, 6+3*components)
+	e.write([]byte{8, byte(size.Y >> 8), byte(size.Y), byte(size.X >> 8), byte(size.X), byte(components)})
+	if components == 1 {
+		e.write([]byte{1, 0x11, 0})
+		return
+	}
+	e.write([]byte{1, byte(h<<4 | v), 0, 2, 0x11, 1, 3, 0x11, 1})
+}
+
+// writeDHT writes the Huffman tables, leaving out chroma's for grey images
+func (e *encoder) writeDHT(components int) {
+	specs := huffmanSpecs[:]
+	if components == 1 {
+		specs = specs[:2]
+	}
+	n := 0
+	for _, s := range specs {
+		n += 1 + 16 + len(s.value)
+	}
+	e.writeMarker(0xc4, n)
+	for i, s := range specs {
+		e.writeByte("\x00\x10\x01\x11"[i])
+		e.write(s.count[:])
+		e.write(s.value)
+	}
+}
+
+// emit writes the low nBits bits of bits, stuffing a zero byte after each
+// 0xff. bits < 1<<nBits and nBits <= 16.
+func (e *encoder) emit(bits, nBits uint32) {
+	nBits += e.nBits
+	bits <<= 32 - nBits
+	bits |= e.bits
+	for nBits >= 8 {
+		b := byte(bits >> 24)
+		e.writeByte(b)
+		if b == 0xff {
+			e.writeByte(0)
+		}
+		bits <<= 8
+		nBits -= 8
+	}
+	e.bits, e.nBits = bits, nBits
+}
+
+// emitHuff writes value's code from Huffman table t
+func (e *encoder) emitHuff(t int, value int32) {
+	x := huffmanLUTs[t][value]
+	e.emit(x&(1<<24-1), x>>24)
+}
+
+// emitHuffRLE writes a run of runLength zeros followed by value
+func (e *encoder) emitHuffRLE(t int, runLength, value int32) {
+	a, b := value, value
+	if a < 0 {
+		a, b = -value, value-1
+	}
+	nBits := uint32(0)
+	for a > 0 {
+		nBits++
+		a >>= 1
+	}
+	e.emitHuff(t, runLength<<4|int32(nBits))
+	if nBits > 0 {
+		e.emit(uint32(b)&(1<<nBits-1), nBits)
+	}
+}
+
+// writeBlock transforms, quantises and writes a block of level-shifted
+// samples with the tables of component class c (0 luma, 1 chroma), and
+// returns its quantised DC term
+func (e *encoder) writeBlock(b *block, c int, prevDC int32) int32 {
+	fdct(b)
+	dc := quantize(b[0], e.divisors[c][0])
+	e.emitHuffRLE(2*c, 0, dc-prevDC)
+	t, runLength := 2*c+1, int32(0)
+	for zig := 1; zig < blockSize; zig++ {
+		k := unzig[zig]
+		ac := quantize(b[k], e.divisors[c][k])
+		if ac == 0 {
+			runLength++
+			continue
+		}
+		for runLength > 15 {
+			e.emitHuff(t, 0xf0)
+			runLength -= 16
+		}
+		e.emitHuffRLE(t, runLength, ac)
+		runLength = 0
+	}
+	if runLength > 0 {
+		e.emitHuff(t, 0x00)
+	}
+	return dc
+}
+
+// quantize divides a coefficient by its divisor, rounding to the nearest
+// integer
+func quantize(x, divisor float32) int32 {
+	x /= divisor
+	if x < 0 {
+		return -int32(-x + 0.5)
+	}
+	return int32(x + 0.5)
+}
+
+// writeGrayScan writes the scan of a grey image, one 8x8 block per MCU
+func (e *encoder) writeGrayScan(m *image.Gray) {
+	e.write([]byte{0xff, 0xda, 0, 8, 1, 1, 0x00, 0, 0x3f, 0})
+	bounds := m.Bounds()
+	var b block
+	var prevDC int32
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 8 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 8 {
+			for j := 0; j < 8; j++ {
+				sy := min(y+j, bounds.Max.Y-1)
+				for i := 0; i < 8; i++ {
+					sx := min(x+i, bounds.Max.X-1)
+					b[8*j+i] = float32(m.Pix[m.PixOffset(sx, sy)]) - 128
+				}
+			}
+			prevDC = e.writeBlock(&b, 0, prevDC)
+		}
+	}
+	e.emit(0x7f, 7)
+}
+
+// writeColourScan writes the scan of a colour image in MCUs of h by v luma
+// blocks and one block of each chroma component, averaged down from the
+// MCU's full-resolution chroma
+func (e *encoder) writeColourScan(m image.Image, h, v int) {
+	e.write([]byte{0xff, 0xda, 0, 12, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 0x3f, 0})
+	bounds := m.Bounds()
+	width, height := 8*h, 8*v
+	ys := make([]float32, width*height)
+	cbs := make([]float32, width*height)
+	crs := make([]float32, width*height)
+	var b block
+	var prevY, prevCb, prevCr int32
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += height {
+		for x := bounds.Min.X; x < bounds.Max.X; x += width {
+			for j := 0; j < height; j++ {
+				sy := min(y+j, bounds.Max.Y-1)
+				for i := 0; i < width; i++ {
+					sx := min(x+i, bounds.Max.X-1)
+					yy, cb, cr := ycbcrAt(m, sx, sy)
+					ys[width*j+i] = float32(yy) - 128
+					cbs[width*j+i] = float32(cb) - 128
+					crs[width*j+i] = float32(cr) - 128
+				}
+			}
+			for by := 0; by < v; by++ {
+				for bx := 0; bx < h; bx++ {
+					for j := 0; j < 8; j++ {
+						copy(b[8*j:8*j+8], ys[width*(8*by+j)+8*bx:])
+					}
+					prevY = e.writeBlock(&b, 0, prevY)
+				}
+			}
+			downsample(&b, cbs, h, v)
+			prevCb = e.writeBlock(&b, 1, prevCb)
+			downsample(&b, crs, h, v)
+			prevCr = e.writeBlock(&b, 1, prevCr)
+		}
+	}
+	e.emit(0x7f, 7)
+}
+
+// downsample averages each h by v cell of the 8h by 8v samples in src into
+// one sample of dst
+func downsample(dst *block, src []float32, h, v int) {
+	width := 8 * h
+	n := float32(h * v)
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			var sum float32
+			for dy := 0; dy < v; dy++ {
+				row := src[width*(v*j+dy)+h*i:]
+				for dx := 0; dx < h; dx++ {
+					sum += row[dx]
+				}
+			}
+			dst[8*j+i] = sum / n
+		}
+	}
+}
+
+// ycbcrAt returns the colour of m at (x, y) in YCbCr, reading the common
+// image types directly
+func ycbcrAt(m image.Image, x, y int) (uint8, uint8, uint8) {
+	switch m := m.(type) {
+	case *image.RGBA:
+		p := m.Pix[m.PixOffset(x, y):]
+		return color.RGBToYCbCr(p[0], p[1], p[2])
+	case *image.YCbCr:
+		c := m.COffset(x, y)
+		return m.Y[m.YOffset(x, y)], m.Cb[c], m.Cr[c]
+	}
+	r, g, b, _ := m.At(x, y).RGBA()
+	return color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+}
+
+// fdct is the forward DCT of Arai, Agui and Nakajima, applied to rows then
+// columns. Each output is left scaled by 8*aanScale[u]*aanScale[v], which
+// the quantisation divisors take out.
+func fdct(b *block) {
+	for i := 0; i < 8; i++ {
+		fdct1(b, 8*i, 1)
+	}
+	for i := 0; i < 8; i++ {
+		fdct1(b, i, 8)
+	}
+}
+
+// fdct1 transforms the 8 samples of b from off, step apart
+func fdct1(b *block, off, step int) {
+	d := func(k int) *float32 { return &b[off+k*step] }
+	tmp0 := *d(0) + *d(7)
+	tmp7 := *d(0) - *d(7)
+	tmp1 := *d(1) + *d(6)
+	tmp6 := *d(1) - *d(6)
+	tmp2 := *d(2) + *d(5)
+	tmp5 := *d(2) - *d(5)
+	tmp3 := *d(3) + *d(4)
+	tmp4 := *d(3) - *d(4)
+
+	// Even part
+	tmp10 := tmp0 + tmp3
+	tmp13 := tmp0 - tmp3
+	tmp11 := tmp1 + tmp2
+	tmp12 := tmp1 - tmp2
+	*d(0) = tmp10 + tmp11
+	*d(4) = tmp10 - tmp11
+	z1 := (tmp12 + tmp13) * 0.707106781
+	*d(2) = tmp13 + z1
+	*d(6) = tmp13 - z1
+
+	// Odd part
+	tmp10 = tmp4 + tmp5
+	tmp11 = tmp5 + tmp6
+	tmp12 = tmp6 + tmp7
+	z5 := (tmp10 - tmp12) * 0.382683433
+	z2 := 0.541196100*tmp10 + z5
+	z4 := 1.306562965*tmp12 + z5
+	z3 := tmp11 * 0.707106781
+	z11 := tmp7 + z3
+	z13 := tmp7 - z3
+	*d(5) = z13 + z2
+	*d(3) = z13 - z2
+	*d(1) = z11 + z4
+	*d(7) = z11 - z4
+}
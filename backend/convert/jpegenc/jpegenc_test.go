@@ -0,0 +1,142 @@
+package jpegenc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// gradient is a w x h colour image with smooth gradients, a sharp edge and
+// fine colour stripes
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{uint8(255 * x / w), uint8(255 * y / h), 128, 255}
+			if x > w/2 {
+				c.B = 30
+			}
+			if y > h/2 && x%4 < 2 {
+				c.R, c.B = c.B, c.R
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// meanDiff is the mean absolute difference per channel between a and b
+func meanDiff(a, b image.Image) float64 {
+	var sum, n float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += float64(d)
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+func encode(t *testing.T, img image.Image, o *Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeSubsampling(t *testing.T) {
+	img := gradient(101, 67)
+	sizes := map[Subsampling]int{}
+	for s, ratio := range map[Subsampling]image.YCbCrSubsampleRatio{
+		Subsample420: image.YCbCrSubsampleRatio420,
+		Subsample422: image.YCbCrSubsampleRatio422,
+		Subsample444: image.YCbCrSubsampleRatio444,
+	} {
+		data := encode(t, img, &Options{Quality: 90, Subsampling: s})
+		sizes[s] = len(data)
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		ycc, ok := decoded.(*image.YCbCr)
+		if !ok || ycc.SubsampleRatio != ratio {
+			t.Errorf("%s: decoded %T with ratio %v; want %v", s, decoded, ycc.SubsampleRatio, ratio)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("%s: bounds %v; want %v", s, decoded.Bounds(), img.Bounds())
+		}
+		if d := meanDiff(img, decoded); d > 4 {
+			t.Errorf("%s: mean difference %.2f; want at most 4", s, d)
+		}
+	}
+	if !(sizes[Subsample444] > sizes[Subsample422] && sizes[Subsample422] > sizes[Subsample420]) {
+		t.Errorf("sizes %v; want 4:4:4 > 4:2:2 > 4:2:0", sizes)
+	}
+}
+
+func TestEncodeQuality(t *testing.T) {
+	img := gradient(64, 64)
+	low := encode(t, img, &Options{Quality: 20})
+	high := encode(t, img, &Options{Quality: 95})
+	if len(low) >= len(high) {
+		t.Errorf("quality 20 is %d bytes, quality 95 %d; want smaller", len(low), len(high))
+	}
+
+	// At 4:2:0 the output is about the size image/jpeg writes
+	var std bytes.Buffer
+	if err := jpeg.Encode(&std, img, &jpeg.Options{Quality: 75}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(encode(t, img, nil)); got < std.Len()*9/10 || got > std.Len()*11/10 {
+		t.Errorf("default encoding is %d bytes; image/jpeg writes %d", got, std.Len())
+	}
+}
+
+func TestEncodeGray(t *testing.T) {
+	img := image.NewGray(image.Rect(3, 5, 20, 14))
+	for y := 5; y < 14; y++ {
+		for x := 3; x < 20; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x * y)})
+		}
+	}
+	data := encode(t, img, &Options{Quality: 95, Subsampling: Subsample444})
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := decoded.(*image.Gray)
+	if !ok || gray.Bounds().Size() != img.Bounds().Size() {
+		t.Fatalf("decoded %T %v; want a %v grey image", decoded, decoded.Bounds(), img.Bounds().Size())
+	}
+	var sum float64
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 17; x++ {
+			d := int(gray.GrayAt(x, y).Y) - int(img.GrayAt(x+3, y+5).Y)
+			if d < 0 {
+				d = -d
+			}
+			sum += float64(d)
+		}
+	}
+	if mean := sum / (9 * 17); mean > 4 {
+		t.Errorf("mean difference %.2f; want at most 4", mean)
+	}
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, 0, 4)), nil); err == nil {
+		t.Error("Encode of an empty image succeeded")
+	}
+}
@@ -0,0 +1,325 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/oneforall/backend/convert/jpegenc"
+	"github.com/oneforall/backend/convert/pdf"
+)
+
+// pdfImage is an image in a PDF that can be re-encoded as a JPEG
+type pdfImage struct {
+	num    int
+	stream *pdf.Stream
+	img    image.Image
+}
+
+// convertPDF compresses the PDF in job.Input to the max_size option
+func convertPDF(ctx context.Context, job Job, opts imageOptions) (Result, error) {
+	if opts.maxSize == 0 {
+		return Result{}, fmt.Errorf("PDFs can only be compressed to a size limit; give %s", OptionMaxSize)
+	}
+	data, err := io.ReadAll(job.Input)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	fit, err := compressPDF(ctx, data, opts.maxSize, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := job.Output.Write(fit.data); err != nil {
+		return Result{}, err
+	}
+	return Result{Settings: fit.settings}, nil
+}
+
+// compressPDF rewrites the PDF in data no larger than maxSize bytes by
+// re-encoding its images as JPEGs. A PDF that already fits is kept as it
+// is. Otherwise the images are searched for settings as fitImage searches
+// for one image: chroma subsampling from 4:4:4 to 4:2:0 at opts.quality,
+// then lower quality down to minFitQuality, then smaller scales, applied to
+// every image alike. Images are not scaled below minFitDimension, and an
+// image keeps its original encoding when that is smaller. Text, fonts and
+// other content are copied unchanged.
+func compressPDF(ctx context.Context, data []byte, maxSize int64, opts imageOptions) (fitted, error) {
+	f, err := pdf.Read(data)
+	if errors.Is(err, pdf.ErrEncrypted) {
+		return fitted{}, fmt.Errorf("encrypted PDFs cannot be compressed")
+	}
+	if err != nil {
+		return fitted{}, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	if int64(len(data)) <= maxSize {
+		return fitted{data: data, settings: map[string]string{"images": "0"}}, nil
+	}
+	images := pdfImages(f)
+	if len(images) == 0 {
+		return fitted{}, fmt.Errorf("cannot fit PDF in %d bytes: it is %d bytes and has no images that can be re-encoded",
+			maxSize, len(data))
+	}
+
+	// The chroma steps are only tried when there are colour images
+	chromas := chromaSteps(images[0].img)
+	for _, im := range images[1:] {
+		if steps := chromaSteps(im.img); len(steps) > len(chromas) {
+			chromas = steps
+		}
+	}
+
+	scale := 1.0
+	for {
+		if err := ctx.Err(); err != nil {
+			return fitted{}, err
+		}
+		scaled := make([]image.Image, len(images))
+		for i, im := range images {
+			scaled[i] = scaleImage(im.img, scale)
+		}
+		var replaced, imageBytes int
+		best, err := bestEncoding(ctx, maxSize, minFitQuality, opts.quality, chromas,
+			func(quality int, chroma jpegenc.Subsampling) ([]byte, error) {
+				var data []byte
+				var err error
+				data, replaced, imageBytes, err = rewritePDF(ctx, f, images, scaled, quality, chroma)
+				return data, err
+			})
+		if err != nil {
+			return fitted{}, err
+		}
+		if int64(len(best.data)) <= maxSize {
+			settings := map[string]string{
+				"images":  strconv.Itoa(replaced),
+				"quality": strconv.Itoa(best.quality),
+				"scale":   strconv.FormatFloat(scale, 'f', 3, 64),
+			}
+			if len(chromas) > 1 {
+				settings["chroma_subsampling"] = best.chroma.String()
+			}
+			return fitted{data: best.data, settings: settings}, nil
+		}
+
+		// Only the images shrink with scale; the rest of the file is a
+		// fixed cost
+		other := int64(len(best.data) - imageBytes)
+		if other >= maxSize {
+			return fitted{}, fmt.Errorf("cannot fit PDF in %d bytes: %d bytes are text, fonts and other content that cannot be compressed",
+				maxSize, other)
+		}
+		next := scale * shrinkFactor(maxSize-other, int64(imageBytes))
+		if !scalesDown(images, scale, next) {
+			return fitted{}, fmt.Errorf("cannot fit PDF in %d bytes: %d bytes with its images at quality %d and %.0f%% scale",
+				maxSize, len(best.data), best.quality, scale*100)
+		}
+		scale = next
+	}
+}
+
+// rewritePDF writes f with each of images replaced by a JPEG of the
+// matching scaled image, unless its current encoding is smaller. It returns
+// the file, how many images were replaced and the bytes of image data in
+// the file.
+func rewritePDF(ctx context.Context, f *pdf.File, images []pdfImage, scaled []image.Image,
+	quality int, chroma jpegenc.Subsampling) ([]byte, int, int, error) {
+	objects := make(map[int]pdf.Object, len(f.Objects))
+	for num, obj := range f.Objects {
+		objects[num] = obj
+	}
+	replaced, imageBytes := 0, 0
+	for i, im := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+		data, err := encodeJPEG(scaled[i], quality, chroma)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if len(data) >= len(im.stream.Data) {
+			imageBytes += len(im.stream.Data)
+			continue
+		}
+		dict := pdf.Dict{}
+		for k, v := range im.stream.Dict {
+			dict[k] = v
+		}
+		delete(dict, "DecodeParms")
+		b := scaled[i].Bounds()
+		dict["Filter"] = pdf.Name("DCTDecode")
+		dict["Width"] = pdf.Integer(b.Dx())
+		dict["Height"] = pdf.Integer(b.Dy())
+		dict["BitsPerComponent"] = pdf.Integer(8)
+		objects[im.num] = &pdf.Stream{Dict: dict, Data: data}
+		replaced++
+		imageBytes += len(data)
+	}
+
+	out := &pdf.File{Version: f.Version, Objects: objects, Trailer: f.Trailer, Compressed: f.Compressed}
+	var buf bytes.Buffer
+	if err := out.Write(&buf); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), replaced, imageBytes, nil
+}
+
+// pdfImages finds the images of f that can be re-encoded as JPEGs: 8-bit
+// grey or RGB images, stored as JPEGs or deflated or raw samples. Masks,
+// images used as masks and images with other colour spaces or decode
+// arrays are left alone, as JPEG would change their exact values.
+func pdfImages(f *pdf.File) []pdfImage {
+	masks := map[int]bool{}
+	var nums []int
+	for num, obj := range f.Objects {
+		s, ok := obj.(*pdf.Stream)
+		if !ok || f.Resolve(s.Dict["Subtype"]) != pdf.Name("Image") {
+			continue
+		}
+		nums = append(nums, num)
+		for _, key := range []pdf.Name{"SMask", "Mask"} {
+			if ref, ok := s.Dict[key].(pdf.Ref); ok {
+				masks[ref.Num] = true
+			}
+		}
+	}
+	sort.Ints(nums)
+
+	var images []pdfImage
+	for _, num := range nums {
+		s := f.Objects[num].(*pdf.Stream)
+		if masks[num] {
+			continue
+		}
+		if img, err := decodePDFImage(f, s); err == nil {
+			images = append(images, pdfImage{num: num, stream: s, img: img})
+		}
+	}
+	return images
+}
+
+// decodePDFImage decodes an image XObject that pdfImages can re-encode
+func decodePDFImage(f *pdf.File, s *pdf.Stream) (image.Image, error) {
+	d := s.Dict
+	if f.Resolve(d["ImageMask"]) == pdf.Bool(true) || d["Decode"] != nil {
+		return nil, errors.New("image is a mask or has a decode array")
+	}
+	if _, ok := f.Resolve(d["Mask"]).(pdf.Array); ok {
+		return nil, errors.New("image has a colour key mask")
+	}
+	components := pdfColourComponents(f, d["ColorSpace"])
+	if components != 1 && components != 3 {
+		return nil, errors.New("unsupported colour space")
+	}
+	width, _ := pdf.Int(f.Resolve(d["Width"]))
+	height, _ := pdf.Int(f.Resolve(d["Height"]))
+	if width <= 0 || height <= 0 || width*height > maxImagePixels {
+		return nil, errors.New("image has no pixels or too many")
+	}
+
+	filters, err := f.Filters(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) == 1 && (filters[0] == "DCTDecode" || filters[0] == "DCT") {
+		img, err := decodeImage(bytes.NewReader(s.Data), "jpg")
+		if err != nil {
+			return nil, err
+		}
+		switch img.(type) {
+		case *image.Gray:
+			if components != 1 {
+				return nil, errors.New("grey JPEG in a colour space with 3 components")
+			}
+		case *image.YCbCr, *image.RGBA:
+			if components != 3 {
+				return nil, errors.New("colour JPEG in a colour space with 1 component")
+			}
+		default:
+			return nil, errors.New("unsupported JPEG colour model")
+		}
+		return img, nil
+	}
+
+	if bits, _ := pdf.Int(f.Resolve(d["BitsPerComponent"])); bits != 8 {
+		return nil, errors.New("image does not have 8 bits per component")
+	}
+	size := int(width * height * int64(components))
+	samples, err := f.Decode(s, int64(size))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < size {
+		return nil, errors.New("image data is truncated")
+	}
+	w, h := int(width), int(height)
+	if components == 1 {
+		return &image.Gray{Pix: samples[:size], Stride: w, Rect: image.Rect(0, 0, w, h)}, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, j := 0, 0; i < size; i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = samples[i], samples[i+1], samples[i+2], 0xff
+	}
+	return img, nil
+}
+
+// pdfColourComponents is the number of components of a colour space that
+// JPEG can store directly: 1 for grey, 3 for RGB, or 0
+func pdfColourComponents(f *pdf.File, cs pdf.Object) int {
+	cs = f.Resolve(cs)
+	name, ok := cs.(pdf.Name)
+	if arr, isArray := cs.(pdf.Array); isArray && len(arr) > 0 {
+		name, ok = f.Resolve(arr[0]).(pdf.Name)
+		if ok && name == "ICCBased" && len(arr) == 2 {
+			if s, isStream := f.Resolve(arr[1]).(*pdf.Stream); isStream {
+				n, _ := pdf.Int(f.Resolve(s.Dict["N"]))
+				if n == 1 || n == 3 {
+					return int(n)
+				}
+			}
+			return 0
+		}
+	}
+	if !ok {
+		return 0
+	}
+	switch name {
+	case "DeviceGray", "CalGray", "G":
+		return 1
+	case "DeviceRGB", "CalRGB", "RGB":
+		return 3
+	}
+	return 0
+}
+
+// scaleImage scales img by scale, but not so far that either side falls
+// below minFitDimension, keeping its shape; small images keep their size
+func scaleImage(img image.Image, scale float64) image.Image {
+	b := img.Bounds()
+	scale = imageScale(b, scale)
+	if scale >= 1 {
+		return img
+	}
+	return resize(img, max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1))
+}
+
+// imageScale is the scale an image of bounds b is given for a requested
+// scale: no smaller than keeps its shorter side at minFitDimension, and no
+// larger than 1
+func imageScale(b image.Rectangle, scale float64) float64 {
+	floor := float64(minFitDimension) / float64(min(b.Dx(), b.Dy()))
+	return min(max(scale, floor), 1)
+}
+
+// scalesDown reports whether going from scale to next shrinks any image
+func scalesDown(images []pdfImage, scale, next float64) bool {
+	for _, im := range images {
+		if imageScale(im.img.Bounds(), next) < imageScale(im.img.Bounds(), scale) {
+			return true
+		}
+	}
+	return false
+}
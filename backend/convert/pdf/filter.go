@@ -0,0 +1,142 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedFilter is returned when a stream is encoded with a filter
+// this package cannot undo
+var ErrUnsupportedFilter = errors.New("pdf: unsupported stream filter")
+
+// decodeStream undoes the filters of s, resolving indirect filter
+// parameters with resolve, and refuses to inflate more than limit bytes
+func decodeStream(s *Stream, limit int64, resolve func(Object) Object) ([]byte, error) {
+	filters, ok := names(resolve(s.Dict["Filter"]))
+	if !ok {
+		return nil, fmt.Errorf("pdf: malformed stream filter %v", s.Dict["Filter"])
+	}
+	params := resolve(s.Dict["DecodeParms"])
+	data := s.Data
+	for i, filter := range filters {
+		var parms Dict
+		switch p := params.(type) {
+		case Dict:
+			parms = p
+		case Array:
+			if i < len(p) {
+				parms, _ = resolve(p[i]).(Dict)
+			}
+		}
+		switch filter {
+		case "FlateDecode", "Fl":
+			var err error
+			if data, err = inflate(data, limit); err != nil {
+				return nil, err
+			}
+			if data, err = unpredict(data, parms, resolve); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w %s", ErrUnsupportedFilter, filter)
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, up to limit bytes
+func inflate(data []byte, limit int64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: cannot inflate stream: %w", err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("pdf: stream inflates to more than %d bytes", limit)
+	}
+	// Truncated streams are common; keep what could be read
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("pdf: cannot inflate stream: %w", err)
+	}
+	return out, nil
+}
+
+// unpredict undoes the PNG predictors a FlateDecode stream's parameters
+// give. TIFF predictors are not supported.
+func unpredict(data []byte, parms Dict, resolve func(Object) Object) ([]byte, error) {
+	param := func(key Name, def int64) int64 {
+		if v, ok := Int(resolve(parms[key])); ok {
+			return v
+		}
+		return def
+	}
+	predictor := param("Predictor", 1)
+	switch {
+	case predictor == 1:
+		return data, nil
+	case predictor < 10:
+		return nil, fmt.Errorf("%w: predictor %d", ErrUnsupportedFilter, predictor)
+	}
+	colors, bits, columns := param("Colors", 1), param("BitsPerComponent", 8), param("Columns", 1)
+	if colors < 1 || colors > 32 || bits < 1 || bits > 16 || columns < 1 || columns > 1<<20 {
+		return nil, errors.New("pdf: malformed predictor parameters")
+	}
+	bpp := int(max((colors*bits+7)/8, 1))
+	rowLen := int((colors*bits*columns + 7) / 8)
+
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		filter, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		cur := make([]byte, rowLen)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 0:
+				cur[i] = row[i]
+			case 1:
+				cur[i] = row[i] + left
+			case 2:
+				cur[i] = row[i] + up
+			case 3:
+				cur[i] = row[i] + byte((int(left)+int(up))/2)
+			case 4:
+				cur[i] = row[i] + paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("pdf: unknown PNG filter %d", filter)
+			}
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+// paeth is the PNG Paeth predictor
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
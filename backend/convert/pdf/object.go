@@ -0,0 +1,150 @@
+// Package pdf reads and rewrites PDF files in pure Go. Read parses every
+// object of a file, including those packed into object streams, keeping the
+// latest definition of each; Write writes the objects reachable from the
+// trailer to a new file, numbered afresh with new cross-reference data.
+// That is enough to replace some of a document's streams, such as its
+// images, and leave the rest as it was. Encrypted files are not read.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Object is a PDF object: nil for null, Bool, Integer, Real, String, Name,
+// Array, Dict, Ref, or *Stream for the stream objects of a file
+type Object interface{}
+
+// Bool is a boolean object
+type Bool bool
+
+// Integer is an integer number
+type Integer int64
+
+// Real is a number with a fractional part
+type Real float64
+
+// String is a string object's bytes, from either literal or hex syntax
+type String []byte
+
+// Name is a name object, without its leading slash
+type Name string
+
+// Array is an array object
+type Array []Object
+
+// Dict is a dictionary object
+type Dict map[Name]Object
+
+// Ref is a reference to an indirect object
+type Ref struct {
+	Num, Gen int
+}
+
+// Stream is a stream object: its dictionary and its data, still encoded
+// with the dictionary's filters
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+// Int returns o as an integer, if it is one
+func Int(o Object) (int64, bool) {
+	switch v := o.(type) {
+	case Integer:
+		return int64(v), true
+	case Real:
+		if v == Real(int64(v)) {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// names returns o as a list of names: a name on its own, or an array of
+// them. It is how filters and the like are given.
+func names(o Object) ([]Name, bool) {
+	switch v := o.(type) {
+	case nil:
+		return nil, true
+	case Name:
+		return []Name{v}, true
+	case Array:
+		out := make([]Name, 0, len(v))
+		for _, e := range v {
+			n, ok := e.(Name)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, n)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// appendObject writes o in PDF syntax. Streams are written by the caller,
+// as only indirect objects may be streams.
+func appendObject(buf *bytes.Buffer, o Object) error {
+	switch v := o.(type) {
+	case nil:
+		buf.WriteString("null")
+	case Bool:
+		buf.WriteString(strconv.FormatBool(bool(v)))
+	case Integer:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Real:
+		buf.WriteString(strconv.FormatFloat(float64(v), 'f', -1, 64))
+	case String:
+		fmt.Fprintf(buf, "<%x>", []byte(v))
+	case Name:
+		appendName(buf, v)
+	case Array:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			if err := appendObject(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		buf.WriteString("<<")
+		for _, k := range keys {
+			appendName(buf, Name(k))
+			buf.WriteByte(' ')
+			if err := appendObject(buf, v[Name(k)]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(">>")
+	case Ref:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	default:
+		return fmt.Errorf("pdf: cannot write %T inside another object", o)
+	}
+	return nil
+}
+
+// appendName writes a name, escaping the bytes that would end it or that
+// are not printable as #xx
+func appendName(buf *bytes.Buffer, n Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < 0x21 || c > 0x7e || c == '#' || isDelimiter(c) {
+			fmt.Fprintf(buf, "#%02x", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+}
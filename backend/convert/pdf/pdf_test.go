@@ -0,0 +1,259 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// build writes a PDF of the given object bodies, numbered from 1, with a
+// cross-reference table and a trailer naming object 1 as the catalog
+func build(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	var offsets []int
+	for i, body := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func read(t *testing.T, data []byte) *File {
+	t.Helper()
+	f, err := Read(data)
+	if err != nil {
+		t.Fatalf("Read: %v\n%s", err, data)
+	}
+	return f
+}
+
+func rewrite(t *testing.T, f *File) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// page returns the first page of f, following the page tree
+func page(t *testing.T, f *File) Dict {
+	t.Helper()
+	catalog, _ := f.Resolve(f.Trailer["Root"]).(Dict)
+	pages, _ := f.Resolve(catalog["Pages"]).(Dict)
+	kids, _ := f.Resolve(pages["Kids"]).(Array)
+	if len(kids) == 0 {
+		t.Fatalf("no pages in %v", catalog)
+	}
+	p, _ := f.Resolve(kids[0]).(Dict)
+	return p
+}
+
+func TestReadObjects(t *testing.T) {
+	content := "BT /F1 12 Tf (Hello) Tj ET"
+	f := read(t, build(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		`<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595.5 842] /Contents 4 0 R
+		   /Title (A \(nested\) string\\ with \101 escapes) /Hex <48 65 6C6C 6f7>
+		   /Odd#20Name true /Missing null /Gone 9 0 R >>`,
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	))
+
+	p := page(t, f)
+	if !reflect.DeepEqual(p["MediaBox"], Array{Integer(0), Integer(0), Real(595.5), Integer(842)}) {
+		t.Errorf("MediaBox = %#v", p["MediaBox"])
+	}
+	if got := string(p["Title"].(String)); got != `A (nested) string\ with A escapes` {
+		t.Errorf("Title = %q", got)
+	}
+	if got := string(p["Hex"].(String)); got != "Hellop" {
+		t.Errorf("Hex = %q", got)
+	}
+	if p["Odd Name"] != Bool(true) {
+		t.Errorf("escaped name not read: %v", p)
+	}
+	if _, ok := p["Missing"]; ok {
+		t.Error("null entry kept")
+	}
+	if s, ok := f.Resolve(p["Contents"]).(*Stream); !ok || string(s.Data) != content {
+		t.Errorf("Contents = %#v", f.Resolve(p["Contents"]))
+	}
+	if f.Resolve(p["Gone"]) != nil {
+		t.Error("reference to a missing object did not resolve to null")
+	}
+}
+
+func TestReadStreamLength(t *testing.T) {
+	data := "binary\nendstrea\x00m data"
+	for name, dict := range map[string]string{
+		"direct":   fmt.Sprintf("<< /Length %d >>", len(data)),
+		"indirect": "<< /Length 3 0 R >>",
+		"wrong":    "<< /Length 4 >>",
+	} {
+		f := read(t, build("<< /Type /Catalog /Stream 2 0 R >>", dict+"\nstream\r\n"+data+"\r\nendstream", fmt.Sprint(len(data))))
+		s, ok := f.Objects[2].(*Stream)
+		if !ok || string(s.Data) != data {
+			t.Errorf("%s length: stream %#v", name, f.Objects[2])
+		}
+	}
+}
+
+func TestReadIncrementalUpdate(t *testing.T) {
+	data := build(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Rotate 0 >>",
+	)
+	// An update redefines the page and adds an info dictionary
+	data = append(data, "3 0 obj\n<< /Type /Page /Parent 2 0 R /Rotate 90 >>\nendobj\n4 0 obj\n<< /Title (Updated) >>\nendobj\n"+
+		"trailer\n<< /Size 5 /Root 1 0 R /Info 4 0 R /Prev 9 >>\n%%EOF\n"...)
+	f := read(t, data)
+	if p := page(t, f); p["Rotate"] != Integer(90) {
+		t.Errorf("page = %v; want the updated one", p)
+	}
+	if _, ok := f.Trailer["Info"]; !ok || f.Trailer["Prev"] != nil {
+		t.Errorf("trailer = %v; want Root and Info only", f.Trailer)
+	}
+}
+
+func TestReadObjectStreams(t *testing.T) {
+	index := "1 0 2 40 "
+	body := "<< /Type /Catalog /Pages 2 0 R >>      \n<< /Type /Pages /Kids [] /Count 0 >>"
+	packed := zlibBytes(t, []byte(index+body))
+	data := build(
+		"<< /Type /Catalog /Pages 2 0 R /Stale true >>",
+		"null",
+		fmt.Sprintf("<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", len(index), len(packed), packed),
+	)
+	f := read(t, data)
+	if !f.Compressed {
+		t.Error("file with an object stream not marked compressed")
+	}
+	catalog := f.Resolve(f.Trailer["Root"]).(Dict)
+	if catalog["Stale"] != nil {
+		t.Errorf("catalog = %v; want the packed definition, which is later", catalog)
+	}
+	if pages, ok := f.Resolve(catalog["Pages"]).(Dict); !ok || pages["Count"] != Integer(0) {
+		t.Errorf("pages = %#v", f.Resolve(catalog["Pages"]))
+	}
+}
+
+func TestReadRefuses(t *testing.T) {
+	if _, err := Read([]byte("GIF89a")); err == nil {
+		t.Error("read a GIF")
+	}
+	encrypted := append(build("<< /Type /Catalog >>"), "trailer\n<< /Root 1 0 R /Encrypt << /Filter /Standard >> >>\n"...)
+	if _, err := Read(encrypted); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("encrypted file: %v; want ErrEncrypted", err)
+	}
+	if _, err := Read(build("(not a catalog)")); err == nil {
+		t.Error("read a file without a catalog")
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	content := []byte("q 10 0 0 10 0 0 cm /Im0 Do Q")
+	f := read(t, build(
+		"<< /Type /Catalog /Pages 7 0 R >>",
+		"<< /Unreachable true >>",
+		"<< /Type /Page /Parent 7 0 R /Contents 5 0 R /Resources << /XObject << /Im0 6 0 R >> >> /Annots [9 0 R] >>",
+		"(unused)",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x80\nendstream",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Label (Pages \\) #1) /Scale 0.25 >>",
+	))
+
+	for _, compressed := range []bool{false, true} {
+		f.Compressed = compressed
+		out := rewrite(t, f)
+		if compressed != bytes.Contains(out, []byte("/ObjStm")) {
+			t.Errorf("compressed %v: object streams used %v", compressed, !compressed)
+		}
+		g := read(t, out)
+		if g.Compressed != compressed {
+			t.Errorf("compressed %v: read back as %v", compressed, g.Compressed)
+		}
+		// Only the reachable objects are kept: catalog, pages, page,
+		// contents and image
+		if len(g.Objects) != 5+btoi(compressed)*2 {
+			t.Errorf("compressed %v: %d objects", compressed, len(g.Objects))
+		}
+		p := page(t, g)
+		if s, ok := g.Resolve(p["Contents"]).(*Stream); !ok || !bytes.Equal(s.Data, content) {
+			t.Errorf("compressed %v: contents %#v", compressed, g.Resolve(p["Contents"]))
+		}
+		xobjects := p["Resources"].(Dict)["XObject"].(Dict)
+		if im, ok := g.Resolve(xobjects["Im0"]).(*Stream); !ok || string(im.Data) != "\x80" || im.Dict["Width"] != Integer(1) {
+			t.Errorf("compressed %v: image %#v", compressed, g.Resolve(xobjects["Im0"]))
+		}
+		if annots := p["Annots"].(Array); len(annots) != 1 || annots[0] != nil {
+			t.Errorf("compressed %v: annots %v; want the missing object as null", compressed, annots)
+		}
+		parent := g.Resolve(p["Parent"]).(Dict)
+		if string(parent["Label"].(String)) != "Pages ) #1" || parent["Scale"] != Real(0.25) {
+			t.Errorf("compressed %v: pages %v", compressed, parent)
+		}
+
+		// Writing again gives the same file
+		if again := rewrite(t, g); !bytes.Equal(again, out) {
+			t.Errorf("compressed %v: rewriting changed the file", compressed)
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestDecodePredictors(t *testing.T) {
+	// Two rows of two RGB pixels, with the Sub and Up PNG filters
+	rows := []byte{
+		1, 10, 20, 30, 5, 5, 5,
+		2, 1, 1, 1, 1, 1, 1,
+	}
+	f := &File{Objects: map[int]Object{}}
+	s := &Stream{
+		Dict: Dict{"Filter": Name("FlateDecode"), "DecodeParms": Dict{"Predictor": Integer(15), "Colors": Integer(3), "Columns": Integer(2)}},
+		Data: zlibBytes(t, rows),
+	}
+	got, err := f.Decode(s, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{10, 20, 30, 15, 25, 35, 11, 21, 31, 16, 26, 36}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decoded %v; want %v", got, want)
+	}
+
+	if _, err := f.Decode(s, 5); err == nil || !strings.Contains(err.Error(), "more than 5 bytes") {
+		t.Errorf("decode past the limit: %v", err)
+	}
+	s.Dict["Filter"] = Name("DCTDecode")
+	if _, err := f.Decode(s, 100); !errors.Is(err, ErrUnsupportedFilter) {
+		t.Errorf("DCTDecode: %v; want ErrUnsupportedFilter", err)
+	}
+}
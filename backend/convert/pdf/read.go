@@ -0,0 +1,572 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	// maxDepth bounds how deeply arrays and dictionaries may nest
+	maxDepth = 100
+	// maxObjectStream bounds how much one object stream may inflate to
+	maxObjectStream = 64 << 20
+	// maxRefChain bounds how many references Resolve follows in a row
+	maxRefChain = 32
+)
+
+// ErrEncrypted is returned by Read for encrypted files, whose strings and
+// streams cannot be read without their key
+var ErrEncrypted = errors.New("pdf: file is encrypted")
+
+// File is a parsed PDF
+type File struct {
+	// Version is the version in the file's header, e.g. "1.7"
+	Version string
+	// Objects are the file's indirect objects by object number, each at
+	// its latest definition
+	Objects map[int]Object
+	// Trailer holds the trailer entries Write keeps: Root, Info and ID
+	Trailer Dict
+	// Compressed is set when the file packs objects into object streams;
+	// Write then does too
+	Compressed bool
+}
+
+// definition is where an object was last defined: its offset in the file,
+// or that of the object stream it was packed into
+type definition struct {
+	pos int
+	obj Object
+}
+
+var (
+	// pdfHeader matches the version comment a PDF starts with
+	pdfHeader = regexp.MustCompile(`%PDF-(\d\.\d)`)
+	// objectStart matches the start of an indirect object or of a trailer
+	objectStart = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b|\btrailer\b`)
+)
+
+// Read parses a PDF. Rather than trusting the cross-reference data, which
+// is often damaged, it scans the file for objects in order, so each
+// object's last definition wins as it does with incremental updates, and
+// then unpacks the object streams.
+func Read(data []byte) (*File, error) {
+	header := pdfHeader.FindSubmatch(data[:min(len(data), 1024)])
+	if header == nil {
+		return nil, errors.New("pdf: not a PDF file")
+	}
+	f := &File{Version: string(header[1]), Objects: map[int]Object{}}
+
+	defs := map[int]definition{}
+	var trailers []definition
+	p := &parser{data: data}
+	for pos := 0; pos < len(data); {
+		loc := objectStart.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[0], pos+loc[1]
+		p.pos = end
+		if loc[2] < 0 {
+			// A trailer dictionary
+			if d, err := p.object(); err == nil {
+				if d, ok := d.(Dict); ok {
+					trailers = append(trailers, definition{pos: start, obj: d})
+				}
+			}
+			pos = max(p.pos, end)
+			continue
+		}
+		num, err := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		obj, perr := p.indirect()
+		if err != nil || perr != nil {
+			pos = end
+			continue
+		}
+		defs[num] = definition{pos: start, obj: obj}
+		if s, ok := obj.(*Stream); ok && s.Dict["Type"] == Name("XRef") {
+			trailers = append(trailers, definition{pos: start, obj: s.Dict})
+		}
+		pos = p.pos
+	}
+
+	if err := f.unpackObjectStreams(defs); err != nil {
+		return nil, err
+	}
+	for num, def := range defs {
+		f.Objects[num] = def.obj
+	}
+	return f, f.setTrailer(trailers)
+}
+
+// unpackObjectStreams adds the objects packed into the object streams among
+// defs, unless they are defined again later in the file
+func (f *File) unpackObjectStreams(defs map[int]definition) error {
+	type objStm struct {
+		pos int
+		s   *Stream
+	}
+	var streams []objStm
+	for _, def := range defs {
+		if s, ok := def.obj.(*Stream); ok && s.Dict["Type"] == Name("ObjStm") {
+			streams = append(streams, objStm{def.pos, s})
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].pos < streams[j].pos })
+	resolve := func(o Object) Object {
+		if r, ok := o.(Ref); ok {
+			return defs[r.Num].obj
+		}
+		return o
+	}
+
+	for _, stm := range streams {
+		f.Compressed = true
+		data, err := decodeStream(stm.s, maxObjectStream, resolve)
+		if err != nil {
+			return fmt.Errorf("pdf: cannot read object stream: %w", err)
+		}
+		n, _ := Int(resolve(stm.s.Dict["N"]))
+		first, _ := Int(resolve(stm.s.Dict["First"]))
+		if n < 0 || first < 0 || first > int64(len(data)) {
+			return errors.New("pdf: malformed object stream")
+		}
+		p := &parser{data: data[:first]}
+		for i := int64(0); i < n; i++ {
+			num, err1 := p.object()
+			off, err2 := p.object()
+			numInt, ok1 := num.(Integer)
+			offInt, ok2 := off.(Integer)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 || offInt < 0 || first+int64(offInt) > int64(len(data)) {
+				return errors.New("pdf: malformed object stream index")
+			}
+			if def, ok := defs[int(numInt)]; ok && def.pos > stm.pos {
+				continue
+			}
+			op := &parser{data: data, pos: int(first + int64(offInt))}
+			obj, err := op.object()
+			if err != nil {
+				return fmt.Errorf("pdf: object %d in object stream: %w", numInt, err)
+			}
+			defs[int(numInt)] = definition{pos: stm.pos, obj: obj}
+		}
+	}
+	return nil
+}
+
+// setTrailer merges the trailers found, later ones overriding earlier
+// ones, and keeps the entries a rewritten file needs. A file without a
+// usable trailer falls back on its catalog object.
+func (f *File) setTrailer(trailers []definition) error {
+	sort.SliceStable(trailers, func(i, j int) bool { return trailers[i].pos < trailers[j].pos })
+	merged := Dict{}
+	for _, t := range trailers {
+		for k, v := range t.obj.(Dict) {
+			merged[k] = v
+		}
+	}
+	if merged["Encrypt"] != nil {
+		return ErrEncrypted
+	}
+	f.Trailer = Dict{}
+	for _, key := range []Name{"Root", "Info", "ID"} {
+		if v, ok := merged[key]; ok {
+			f.Trailer[key] = v
+		}
+	}
+	if root, ok := f.Trailer["Root"].(Ref); ok {
+		if _, ok := f.Resolve(root).(Dict); ok {
+			return nil
+		}
+	}
+
+	nums := make([]int, 0, len(f.Objects))
+	for num := range f.Objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if d, ok := f.Objects[num].(Dict); ok && d["Type"] == Name("Catalog") {
+			f.Trailer["Root"] = Ref{Num: num}
+			return nil
+		}
+	}
+	return errors.New("pdf: no document catalog found")
+}
+
+// Resolve follows references from o to the object they lead to. Missing
+// objects resolve to null.
+func (f *File) Resolve(o Object) Object {
+	for i := 0; i < maxRefChain; i++ {
+		r, ok := o.(Ref)
+		if !ok {
+			return o
+		}
+		o = f.Objects[r.Num]
+	}
+	return nil
+}
+
+// Decode returns the data of s with its filters undone, refusing to
+// inflate more than limit bytes. Only FlateDecode, with or without PNG
+// predictors, is supported.
+func (f *File) Decode(s *Stream, limit int64) ([]byte, error) {
+	return decodeStream(s, limit, f.Resolve)
+}
+
+// Filters lists the filters s is encoded with, in the order they are
+// undone when decoding
+func (f *File) Filters(s *Stream) ([]Name, error) {
+	filters, ok := names(f.Resolve(s.Dict["Filter"]))
+	if !ok {
+		return nil, fmt.Errorf("pdf: malformed stream filter %v", s.Dict["Filter"])
+	}
+	return filters, nil
+}
+
+// parser reads objects from data at pos
+type parser struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white space and comments
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// token reads a run of regular characters, such as a keyword or number
+func (p *parser) token() string {
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// keyword reports whether the next token is kw, and skips it if it is
+func (p *parser) keyword(kw string) bool {
+	p.skipSpace()
+	save := p.pos
+	if p.token() == kw {
+		return true
+	}
+	p.pos = save
+	return false
+}
+
+// indirect reads the body of an indirect object, after its "obj" keyword:
+// an object, the data that follows if it is a stream's dictionary, and the
+// closing "endobj" if it is there
+func (p *parser) indirect() (Object, error) {
+	obj, err := p.object()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := obj.(Dict); ok && p.keyword("stream") {
+		data, err := p.streamData(d)
+		if err != nil {
+			return nil, err
+		}
+		obj = &Stream{Dict: d, Data: data}
+	}
+	p.keyword("endobj")
+	return obj, nil
+}
+
+// streamData reads a stream's data, after its "stream" keyword, and skips
+// the "endstream" that ends it. A Length that does not lead to endstream,
+// or that refers to another object, is ignored in favour of searching for
+// endstream.
+func (p *parser) streamData(d Dict) ([]byte, error) {
+	if bytes.HasPrefix(p.data[p.pos:], []byte("\r\n")) {
+		p.pos += 2
+	} else if p.pos < len(p.data) && (p.data[p.pos] == '\n' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+	start := p.pos
+	if n, ok := d["Length"].(Integer); ok && n >= 0 && int64(start)+int64(n) <= int64(len(p.data)) {
+		p.pos = start + int(n)
+		if p.keyword("endstream") {
+			return p.data[start : start+int(n)], nil
+		}
+	}
+	i := bytes.Index(p.data[start:], []byte("endstream"))
+	if i < 0 {
+		return nil, errors.New("stream has no end")
+	}
+	end := start + i
+	p.pos = end + len("endstream")
+	if end > start && p.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && p.data[end-1] == '\r' {
+		end--
+	}
+	return p.data[start:end], nil
+}
+
+// object reads one direct object
+func (p *parser) object() (Object, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errors.New("unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		return p.name(), nil
+	case c == '(':
+		return p.literal()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.dict()
+	case c == '<':
+		return p.hex()
+	case c == '[':
+		return p.array()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+	start := p.pos
+	switch tok := p.token(); tok {
+	case "true":
+		return Bool(true), nil
+	case "false":
+		return Bool(false), nil
+	case "null":
+		return nil, nil
+	default:
+		if tok == "" {
+			tok = string(p.data[p.pos])
+		}
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok, start)
+	}
+}
+
+// name reads a name after its slash, undoing #xx escapes
+func (p *parser) name() Name {
+	raw := p.token()
+	if !bytes.ContainsRune([]byte(raw), '#') {
+		return Name(raw)
+	}
+	var out []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if v, err := strconv.ParseUint(raw[i+1:i+3], 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, raw[i])
+	}
+	return Name(out)
+}
+
+// number reads an integer or real, or a reference if the integer is
+// followed by a generation number and R
+func (p *parser) number() (Object, error) {
+	tok := p.token()
+	if i, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		if i >= 0 {
+			save := p.pos
+			p.skipSpace()
+			gen := p.token()
+			if g, err := strconv.Atoi(gen); err == nil && g >= 0 && gen[0] != '+' && gen[0] != '-' && p.keyword("R") {
+				return Ref{Num: int(i), Gen: g}, nil
+			}
+			p.pos = save
+		}
+		return Integer(i), nil
+	}
+	f, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		// Some writers emit numbers like "--5" or "0.0.1"; read them as 0
+		return Integer(0), nil
+	}
+	return Real(f), nil
+}
+
+// literal reads a (string), with nested parentheses and escapes
+func (p *parser) literal() (Object, error) {
+	p.pos++
+	var out []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(out), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				break
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A backslash before a line break continues the line
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return nil, errors.New("unterminated string")
+}
+
+// hex reads a <hex string>; a missing last digit counts as 0
+func (p *parser) hex() (Object, error) {
+	p.pos++
+	var out []byte
+	var digits []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if len(digits) == 1 {
+				digits = append(digits, '0')
+			}
+			if len(digits) == 2 {
+				v, _ := strconv.ParseUint(string(digits), 16, 8)
+				out = append(out, byte(v))
+			}
+			return String(out), nil
+		}
+		if isSpace(c) {
+			continue
+		}
+		digits = append(digits, c)
+		if len(digits) == 2 {
+			v, err := strconv.ParseUint(string(digits), 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex string at offset %d", p.pos)
+			}
+			out = append(out, byte(v))
+			digits = digits[:0]
+		}
+	}
+	return nil, errors.New("unterminated hex string")
+}
+
+// array reads an [array]
+func (p *parser) array() (Object, error) {
+	if p.depth++; p.depth > maxDepth {
+		return nil, errors.New("objects nested too deeply")
+	}
+	defer func() { p.depth-- }()
+	p.pos++
+	arr := Array{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, errors.New("unterminated array")
+		}
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return arr, nil
+		}
+		o, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, o)
+	}
+}
+
+// dict reads a <<dictionary>>. Entries whose value is null are left out,
+// as the spec treats them as absent.
+func (p *parser) dict() (Object, error) {
+	if p.depth++; p.depth > maxDepth {
+		return nil, errors.New("objects nested too deeply")
+	}
+	defer func() { p.depth-- }()
+	p.pos += 2
+	d := Dict{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, errors.New("unterminated dictionary")
+		}
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return d, nil
+		}
+		if p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("dictionary key is not a name at offset %d", p.pos)
+		}
+		p.pos++
+		key := p.name()
+		v, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			d[key] = v
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// objectsPerStream is how many objects Write packs into each object stream
+const objectsPerStream = 100
+
+// Write writes the objects reachable from the trailer as a new PDF,
+// renumbered from 1 in the order they are reached, with generation 0.
+// References to missing objects become null. When f.Compressed is set,
+// objects other than streams are packed into object streams and the
+// cross-reference data is written as a stream.
+func (f *File) Write(w io.Writer) error {
+	if _, ok := f.Trailer["Root"].(Ref); !ok {
+		return errors.New("pdf: trailer has no document catalog")
+	}
+	order, numbers := f.reachable()
+	renumber := func(o Object) Object { return renumbered(o, numbers) }
+
+	version := f.Version
+	if f.Compressed && version < "1.5" {
+		version = "1.5"
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-" + version + "\n%\xe2\xe3\xcf\xd3\n")
+
+	// offsets[n] is where object n starts; for objects in an object
+	// stream, packed[n] is the stream and the index within it
+	offsets := make([]int, len(order)+1)
+	type location struct{ stream, index int }
+	packed := map[int]location{}
+	var loose []int
+	for i, num := range order {
+		n := i + 1
+		if s, ok := f.Objects[num].(*Stream); ok {
+			offsets[n] = buf.Len()
+			if err := writeStream(&buf, n, renumber(s.Dict).(Dict), s.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Compressed {
+			offsets[n] = buf.Len()
+			fmt.Fprintf(&buf, "%d 0 obj\n", n)
+			if err := appendObject(&buf, renumber(f.Objects[num])); err != nil {
+				return err
+			}
+			buf.WriteString("\nendobj\n")
+			continue
+		}
+		loose = append(loose, n)
+	}
+
+	trailer := renumber(f.Trailer).(Dict)
+	if !f.Compressed {
+		xref := buf.Len()
+		fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+		for _, off := range offsets[1:] {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+		}
+		trailer["Size"] = Integer(len(offsets))
+		buf.WriteString("trailer\n")
+		if err := appendObject(&buf, trailer); err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	// Pack the remaining objects, then describe every object in a
+	// cross-reference stream numbered last
+	next := len(order) + 1
+	for start := 0; start < len(loose); start += objectsPerStream {
+		group := loose[start:min(start+objectsPerStream, len(loose))]
+		var index, body bytes.Buffer
+		for i, n := range group {
+			fmt.Fprintf(&index, "%d %d ", n, body.Len())
+			if err := appendObject(&body, renumber(f.Objects[order[n-1]])); err != nil {
+				return err
+			}
+			body.WriteByte('\n')
+			packed[n] = location{next, i}
+		}
+		data, err := deflate(append(index.Bytes(), body.Bytes()...))
+		if err != nil {
+			return err
+		}
+		offsets = append(offsets, buf.Len())
+		dict := Dict{"Type": Name("ObjStm"), "N": Integer(len(group)), "First": Integer(index.Len()), "Filter": Name("FlateDecode")}
+		if err := writeStream(&buf, next, dict, data); err != nil {
+			return err
+		}
+		next++
+	}
+
+	size := next + 1
+	offsets = append(offsets, buf.Len())
+	var rows bytes.Buffer
+	row := make([]byte, 7)
+	for n := 0; n < size; n++ {
+		row[0], row[5], row[6] = 1, 0, 0
+		binary.BigEndian.PutUint32(row[1:5], uint32(offsets[n]))
+		if n == 0 {
+			row[0], row[5], row[6] = 0, 0xff, 0xff
+		} else if loc, ok := packed[n]; ok {
+			row[0] = 2
+			binary.BigEndian.PutUint32(row[1:5], uint32(loc.stream))
+			binary.BigEndian.PutUint16(row[5:7], uint16(loc.index))
+		}
+		rows.Write(row)
+	}
+	data, err := deflate(rows.Bytes())
+	if err != nil {
+		return err
+	}
+	xref := buf.Len()
+	trailer["Type"] = Name("XRef")
+	trailer["Size"] = Integer(size)
+	trailer["W"] = Array{Integer(1), Integer(4), Integer(2)}
+	trailer["Filter"] = Name("FlateDecode")
+	if err := writeStream(&buf, next, trailer, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xref)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// reachable lists the numbers of the objects reachable from the trailer,
+// depth first, and the new number of each
+func (f *File) reachable() ([]int, map[int]int) {
+	var order []int
+	numbers := map[int]int{}
+	var visit func(o Object)
+	visit = func(o Object) {
+		switch v := o.(type) {
+		case Ref:
+			obj, ok := f.Objects[v.Num]
+			if _, seen := numbers[v.Num]; seen || !ok {
+				return
+			}
+			order = append(order, v.Num)
+			numbers[v.Num] = len(order)
+			visit(obj)
+		case Array:
+			for _, e := range v {
+				visit(e)
+			}
+		case Dict:
+			// In key order, so the numbering does not vary between runs
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, string(k))
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				visit(v[Name(k)])
+			}
+		case *Stream:
+			visit(v.Dict)
+		}
+	}
+	// Visit the catalog first, so it becomes object 1
+	visit(f.Trailer["Root"])
+	visit(f.Trailer)
+	return order, numbers
+}
+
+// renumbered copies o with its references renumbered by numbers, and
+// references to objects not in numbers replaced by null
+func renumbered(o Object, numbers map[int]int) Object {
+	switch v := o.(type) {
+	case Ref:
+		if n, ok := numbers[v.Num]; ok {
+			return Ref{Num: n}
+		}
+		return nil
+	case Array:
+		out := make(Array, len(v))
+		for i, e := range v {
+			out[i] = renumbered(e, numbers)
+		}
+		return out
+	case Dict:
+		out := make(Dict, len(v))
+		for k, e := range v {
+			if e = renumbered(e, numbers); e != nil {
+				out[k] = e
+			}
+		}
+		return out
+	}
+	return o
+}
+
+// writeStream writes a stream object, setting its Length
+func writeStream(buf *bytes.Buffer, num int, dict Dict, data []byte) error {
+	d := make(Dict, len(dict)+1)
+	for k, v := range dict {
+		d[k] = v
+	}
+	d["Length"] = Integer(len(data))
+	buf.WriteString(strconv.Itoa(num) + " 0 obj\n")
+	if err := appendObject(buf, d); err != nil {
+		return err
+	}
+	buf.WriteString("\nstream\n")
+	buf.Write(data)
+	buf.WriteString("\nendstream\nendobj\n")
+	return nil
+}
+
+// deflate compresses data with zlib
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
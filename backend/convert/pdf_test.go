@@ -0,0 +1,212 @@
+package convert
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/oneforall/backend/convert/pdf"
+)
+
+// testPDF writes a one-page PDF showing a deflated RGB image with a soft
+// mask, a JPEG and a deflated grey image in an ICC colour space
+func testPDF(t *testing.T) []byte {
+	t.Helper()
+	photo := noisyImage(400, 300)
+	rgb := make([]byte, 0, 400*300*3)
+	for i := 0; i < len(photo.Pix); i += 4 {
+		rgb = append(rgb, photo.Pix[i:i+3]...)
+	}
+	rng := rand.New(rand.NewSource(2))
+	grey := make([]byte, 200*120)
+	for i := range grey {
+		grey[i] = uint8(i%200) + uint8(rng.Intn(40))
+	}
+	alpha := bytes.Repeat([]byte{0xff}, 400*300)
+	var scan bytes.Buffer
+	if err := jpeg.Encode(&scan, noisyImage(300, 200), &jpeg.Options{Quality: 98}); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "q 400 0 0 300 0 0 cm /Im0 Do Q q 300 0 0 200 0 300 cm /Im1 Do Q q 200 0 0 120 0 500 cm /Im2 Do Q"
+	return buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R /Resources << /XObject << /Im0 5 0 R /Im1 6 0 R /Im2 7 0 R >> >> >>",
+		pdfStream("", []byte(content)),
+		pdfStream("/Subtype /Image /Width 400 /Height 300 /ColorSpace /DeviceRGB /BitsPerComponent 8 /SMask 8 0 R /Filter /FlateDecode", deflated(t, rgb)),
+		pdfStream("/Subtype /Image /Width 300 /Height 200 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode", scan.Bytes()),
+		pdfStream("/Subtype /Image /Width 200 /Height 120 /ColorSpace [/ICCBased 9 0 R] /BitsPerComponent 8 /Filter /FlateDecode", deflated(t, grey)),
+		pdfStream("/Subtype /Image /Width 400 /Height 300 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode", deflated(t, alpha)),
+		pdfStream("/N 1", []byte("profile")),
+	)
+}
+
+// buildPDF writes a PDF of the given object bodies, numbered from 1, with
+// object 1 as the catalog
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	var offsets []int
+	for i, body := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func pdfStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func deflated(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pageImages returns the image streams of the first page of the PDF in data
+func pageImages(t *testing.T, data []byte) map[string]*pdf.Stream {
+	t.Helper()
+	f, err := pdf.Read(data)
+	if err != nil {
+		t.Fatalf("output does not read back: %v", err)
+	}
+	catalog := f.Resolve(f.Trailer["Root"]).(pdf.Dict)
+	pages := f.Resolve(catalog["Pages"]).(pdf.Dict)
+	page := f.Resolve(pages["Kids"].(pdf.Array)[0]).(pdf.Dict)
+	xobjects := f.Resolve(f.Resolve(page["Resources"]).(pdf.Dict)["XObject"]).(pdf.Dict)
+	images := map[string]*pdf.Stream{}
+	for name, ref := range xobjects {
+		images[string(name)] = f.Resolve(ref).(*pdf.Stream)
+		if mask, ok := images[string(name)].Dict["SMask"]; ok {
+			images[string(name)+" mask"] = f.Resolve(mask).(*pdf.Stream)
+		}
+	}
+	return images
+}
+
+func compressTestPDF(t *testing.T, data []byte, maxSize int64) fitted {
+	t.Helper()
+	opts, err := parseImageOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fit, err := compressPDF(context.Background(), data, maxSize, opts)
+	if err != nil {
+		t.Fatalf("compressPDF(%d): %v", maxSize, err)
+	}
+	if int64(len(fit.data)) > maxSize {
+		t.Errorf("compressPDF(%d) wrote %d bytes", maxSize, len(fit.data))
+	}
+	return fit
+}
+
+func TestCompressPDF(t *testing.T) {
+	data := testPDF(t)
+	before := pageImages(t, data)
+
+	for _, maxSize := range []int64{int64(len(data)) / 2, int64(len(data)) / 10, 20_000} {
+		fit := compressTestPDF(t, data, maxSize)
+		if fit.settings["images"] != "3" || fit.settings["chroma_subsampling"] == "" || fit.settings["quality"] == "" {
+			t.Errorf("limit %d: settings %v; want all 3 images replaced", maxSize, fit.settings)
+		}
+		images := pageImages(t, fit.data)
+		for _, name := range []string{"Im0", "Im1", "Im2"} {
+			im := images[name]
+			if im.Dict["Filter"] != pdf.Name("DCTDecode") || im.Dict["DecodeParms"] != nil {
+				t.Errorf("limit %d: %s dict %v; want a plain JPEG", maxSize, name, im.Dict)
+				continue
+			}
+			img, err := jpeg.Decode(bytes.NewReader(im.Data))
+			if err != nil {
+				t.Errorf("limit %d: %s does not decode: %v", maxSize, name, err)
+				continue
+			}
+			b := img.Bounds()
+			if im.Dict["Width"] != pdf.Integer(b.Dx()) || im.Dict["Height"] != pdf.Integer(b.Dy()) {
+				t.Errorf("limit %d: %s is %v; dict says %v x %v", maxSize, name, b, im.Dict["Width"], im.Dict["Height"])
+			}
+			if _, grey := img.(*image.Gray); grey != (name == "Im2") {
+				t.Errorf("limit %d: %s decodes to %T", maxSize, name, img)
+			}
+		}
+		// The soft mask is left as it was
+		if mask := images["Im0 mask"]; mask == nil || !bytes.Equal(mask.Data, before["Im0 mask"].Data) {
+			t.Errorf("limit %d: soft mask changed", maxSize)
+		}
+	}
+
+	// A tight limit scales the images down
+	fit := compressTestPDF(t, data, 20_000)
+	if fit.settings["scale"] == "1.000" {
+		t.Errorf("settings %v; want the images scaled down", fit.settings)
+	}
+	// A loose one keeps full quality and chooses the finest chroma
+	// subsampling that fits
+	fit = compressTestPDF(t, data, int64(len(data))/2)
+	if fit.settings["quality"] != "90" || fit.settings["scale"] != "1.000" {
+		t.Errorf("settings %v; want full quality and scale", fit.settings)
+	}
+}
+
+func TestCompressPDFUnchanged(t *testing.T) {
+	data := testPDF(t)
+	fit := compressTestPDF(t, data, int64(len(data)))
+	if !bytes.Equal(fit.data, data) || fit.settings["images"] != "0" {
+		t.Errorf("PDF within the limit was changed: settings %v", fit.settings)
+	}
+}
+
+func TestCompressPDFErrors(t *testing.T) {
+	text := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		pdfStream("", bytes.Repeat([]byte("BT (text) Tj ET "), 200)),
+	)
+	encrypted := append(buildPDF("<< /Type /Catalog >>"), "trailer\n<< /Root 1 0 R /Encrypt << /Filter /Standard >> >>\n"...)
+	tests := []struct {
+		name    string
+		data    []byte
+		maxSize int64
+		error   string
+	}{
+		{"not a PDF", []byte("GIF89a"), 10, "failed to read PDF"},
+		{"encrypted", encrypted, 10, "encrypted"},
+		{"no images", text, 100, "no images"},
+		{"too small", testPDF(t), 300, "cannot fit PDF in 300 bytes"},
+	}
+	opts, _ := parseImageOptions(nil)
+	for _, tt := range tests {
+		_, err := compressPDF(context.Background(), tt.data, tt.maxSize, opts)
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: %v; want an error mentioning %q", tt.name, err, tt.error)
+		}
+	}
+
+	var out bytes.Buffer
+	_, err := Image{}.Convert(context.Background(), Job{Input: bytes.NewReader(text), InputFormat: "pdf", Output: &out, OutputFormat: "pdf"})
+	if err == nil || !strings.Contains(err.Error(), OptionMaxSize) {
+		t.Errorf("PDF without max_size: %v; want an error naming %s", err, OptionMaxSize)
+	}
+}
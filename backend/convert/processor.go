@@ -0,0 +1,120 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/worker"
+)
+
+// Processor runs conversions for the worker pool: it reads the input from
+// blob storage, converts it with the tool's converter and stores the output
+// under outputs/<conversion ID>/
+type Processor struct {
+	registry *Registry
+	blobs    blob.Store
+	spoolDir string
+}
+
+// NewProcessor creates a processor. Outputs are written to a temporary file
+// in spoolDir before they are stored, so their size is known.
+func NewProcessor(registry *Registry, blobs blob.Store, spoolDir string) *Processor {
+	return &Processor{registry: registry, blobs: blobs, spoolDir: spoolDir}
+}
+
+// Process converts conv and stores its output. A kit conversion without a
+// tool is for a file that already meets its document's requirements, and
+// its input is stored unchanged as the output.
+func (p *Processor) Process(ctx context.Context, conv models.ConversionRequest) (worker.Output, error) {
+	if conv.ToolID == "" && conv.KitID != "" {
+		return p.copyInput(ctx, conv)
+	}
+	if conv.ToolID == "" {
+		return worker.Output{}, fmt.Errorf("no tool selected for this conversion")
+	}
+	reg, ok := p.registry.Lookup(conv.ToolID)
+	if !ok {
+		return worker.Output{}, fmt.Errorf("no converter available for tool %q", conv.ToolID)
+	}
+	format := FormatOf(conv.FileName)
+	if err := reg.CheckInput(conv.ToolID, format); err != nil {
+		return worker.Output{}, err
+	}
+
+	in, _, err := blob.Open(ctx, p.blobs, conv.InputPath)
+	if err != nil {
+		return worker.Output{}, fmt.Errorf("failed to open input: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(p.spoolDir, 0755); err != nil {
+		return worker.Output{}, err
+	}
+	out, err := os.CreateTemp(p.spoolDir, "output-*")
+	if err != nil {
+		return worker.Output{}, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	result, err := reg.Converter.Convert(ctx, Job{
+		Input:        in,
+		InputFormat:  format,
+		Output:       out,
+		OutputFormat: reg.OutputFor(format),
+		Options:      conv.Options,
+	})
+	if err != nil {
+		return worker.Output{}, err
+	}
+
+	size, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return worker.Output{}, err
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return worker.Output{}, err
+	}
+	key := OutputKey(conv, reg.OutputFor(format))
+	if err := p.blobs.Put(ctx, key, out, size); err != nil {
+		return worker.Output{}, fmt.Errorf("failed to store output: %w", err)
+	}
+	return worker.Output{Key: key, Size: size, Settings: result.Settings}, nil
+}
+
+// copyInput stores the input of conv as its output
+func (p *Processor) copyInput(ctx context.Context, conv models.ConversionRequest) (worker.Output, error) {
+	in, info, err := blob.Open(ctx, p.blobs, conv.InputPath)
+	if err != nil {
+		return worker.Output{}, fmt.Errorf("failed to open input: %w", err)
+	}
+	defer in.Close()
+
+	key := OutputKey(conv, FormatOf(conv.FileName))
+	if err := p.blobs.Put(ctx, key, in, info.Size); err != nil {
+		return worker.Output{}, fmt.Errorf("failed to store output: %w", err)
+	}
+	return worker.Output{Key: key, Size: info.Size}, nil
+}
+
+// OutputKey is the blob key for the output of conv in format
+func OutputKey(conv models.ConversionRequest, format string) string {
+	return "outputs/" + conv.ID + "/" + OutputName(conv.FileName, format)
+}
+
+// OutputName is the name an output in format is downloaded under: the
+// uploaded file's name with the output's extension
+func OutputName(fileName, format string) string {
+	base := path.Base(fileName)
+	name := strings.TrimSuffix(base, path.Ext(base))
+	if name == "" || name == "." || name == "/" {
+		name = "output"
+	}
+	return name + "." + format
+}
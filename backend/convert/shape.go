@@ -0,0 +1,211 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+
+	"github.com/oneforall/backend/models"
+)
+
+// shapeImage brings img to the shape and pixel size the options ask for:
+// an exact size, or else an aspect ratio and pixel bounds. It returns the
+// image and the settings it applied, or nil settings if none were asked for.
+func shapeImage(img image.Image, opts imageOptions) (image.Image, map[string]string, error) {
+	aspect := opts.aspect
+	if opts.width > 0 {
+		aspect = float64(opts.width) / float64(opts.height)
+	}
+	bounded := opts.minWidth > 0 || opts.maxWidth > 0 || opts.minHeight > 0 || opts.maxHeight > 0
+	if aspect == 0 && !bounded && opts.width == 0 {
+		return img, nil, nil
+	}
+
+	settings := map[string]string{}
+	if aspect > 0 {
+		var reshaped bool
+		img, reshaped = toAspect(img, aspect, opts)
+		if reshaped {
+			settings["fit"] = opts.fit
+		}
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if opts.width > 0 {
+		w, h = opts.width, opts.height
+	} else if bounded {
+		var err error
+		if w, h, err = scaleToBounds(w, h, opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	if w != b.Dx() || h != b.Dy() {
+		img = resize(img, w, h)
+	}
+	settings["width"] = strconv.Itoa(w)
+	settings["height"] = strconv.Itoa(h)
+	return img, settings, nil
+}
+
+// toAspect crops or pads img, centred, to width/height ratio aspect. It
+// reports whether the image had to change; a difference of under a pixel is
+// left alone.
+func toAspect(img image.Image, aspect float64, opts imageOptions) (image.Image, bool) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// The width and height the image would have at aspect, keeping the
+	// other side
+	atHeight := int(math.Round(float64(h) * aspect))
+	atWidth := int(math.Round(float64(w) / aspect))
+	if atHeight == w || atWidth == h {
+		return img, false
+	}
+
+	if opts.fit == models.FitPad {
+		// Grow the short side
+		cw, ch := w, h
+		if atHeight > w {
+			cw = atHeight
+		} else {
+			ch = atWidth
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, cw, ch))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(opts.background), image.Point{}, draw.Src)
+		at := image.Pt((cw-w)/2, (ch-h)/2)
+		draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(b.Size())}, img, b.Min, draw.Over)
+		return dst, true
+	}
+
+	// Trim the long side
+	crop := b
+	if atHeight < w {
+		crop.Min.X += (w - atHeight) / 2
+		crop.Max.X = crop.Min.X + atHeight
+	} else {
+		crop.Min.Y += (h - atWidth) / 2
+		crop.Max.Y = crop.Min.Y + atWidth
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, crop.Min, draw.Src)
+	return dst, true
+}
+
+// scaleToBounds returns the size w x h is scaled to, keeping its shape, to
+// fall within the pixel bounds. It errors when the shape cannot meet them.
+func scaleToBounds(w, h int, opts imageOptions) (int, int, error) {
+	// Scale factors the bounds allow, from up at least to down at most
+	up, down := 0.0, math.Inf(1)
+	for _, bound := range []struct {
+		size     int
+		min, max int
+	}{{w, opts.minWidth, opts.maxWidth}, {h, opts.minHeight, opts.maxHeight}} {
+		if bound.min > 0 {
+			up = math.Max(up, float64(bound.min)/float64(bound.size))
+		}
+		if bound.max > 0 {
+			down = math.Min(down, float64(bound.max)/float64(bound.size))
+		}
+	}
+	if up > down*1.01 {
+		return 0, 0, fmt.Errorf("a %dx%d image cannot be scaled to width %s and height %s",
+			w, h, boundsText(opts.minWidth, opts.maxWidth), boundsText(opts.minHeight, opts.maxHeight))
+	}
+	scale := math.Max(up, math.Min(1, down))
+
+	// Rounding may leave a side a pixel outside its bounds
+	sw := clampSize(int(math.Round(float64(w)*scale)), opts.minWidth, opts.maxWidth)
+	sh := clampSize(int(math.Round(float64(h)*scale)), opts.minHeight, opts.maxHeight)
+	if int64(sw)*int64(sh) > maxImagePixels {
+		return 0, 0, fmt.Errorf("scaling to %dx%d would exceed the %d megapixel limit", sw, sh, maxImagePixels/1_000_000)
+	}
+	return sw, sh, nil
+}
+
+// clampSize keeps n within min and max, where zero is unbounded
+func clampSize(n, min, max int) int {
+	if min > 0 && n < min {
+		n = min
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	return n
+}
+
+// boundsText describes a pixel range for errors, e.g. "200-400px"
+func boundsText(min, max int) string {
+	switch {
+	case max == 0:
+		return fmt.Sprintf("at least %dpx", min)
+	case min == 0:
+		return fmt.Sprintf("at most %dpx", max)
+	default:
+		return fmt.Sprintf("%d-%dpx", min, max)
+	}
+}
+
+// withDPI records dpi in an encoded image, in a JFIF segment for JPEG and a
+// pHYs chunk for PNG. A zero dpi leaves the image as it is.
+func withDPI(data []byte, format string, dpi int) ([]byte, error) {
+	if dpi <= 0 {
+		return data, nil
+	}
+	switch format {
+	case "jpg":
+		return jpegWithDPI(data, dpi)
+	case "png":
+		return pngWithDPI(data, dpi)
+	default:
+		return nil, fmt.Errorf("cannot record dpi in %s images", format)
+	}
+}
+
+// jpegWithDPI inserts a JFIF APP0 segment giving dpi after the start of
+// image marker. Neither image/jpeg nor jpegenc writes an APP0 segment of
+// its own.
+func jpegWithDPI(data []byte, dpi int) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG image")
+	}
+	app0 := []byte{
+		0xff, 0xe0, 0, 16, // marker and length
+		'J', 'F', 'I', 'F', 0,
+		1, 2, // version 1.02
+		1, // density in dots per inch
+		byte(dpi >> 8), byte(dpi), byte(dpi >> 8), byte(dpi),
+		0, 0, // no thumbnail
+	}
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...), nil
+}
+
+// pngWithDPI inserts a pHYs chunk giving dpi, in pixels per metre, after
+// the IHDR chunk that image/png writes first
+func pngWithDPI(data []byte, dpi int) ([]byte, error) {
+	// Signature, then IHDR: length, type, 13 bytes of data and a CRC
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.Equal(data[12:16], []byte("IHDR")) {
+		return nil, fmt.Errorf("not a PNG image")
+	}
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := make([]byte, 0, 4+4+9+4)
+	chunk = binary.BigEndian.AppendUint32(chunk, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1) // unit: metre
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...), nil
+}
@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/oneforall/backend/models"
+)
+
+// markedImage is an opaque w x h image, grey but for a red top-left pixel
+// and a blue bottom-right one, so crops and pads can be located
+func markedImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(w-1, h-1, color.NRGBA{B: 255, A: 255})
+	return img
+}
+
+func sameColor(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func TestToAspect(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	black := color.RGBA{A: 255}
+	tests := []struct {
+		name     string
+		w, h     int
+		aspect   float64
+		fit      string
+		want     image.Point
+		reshaped bool
+		// at is where the source's top-left pixel ends up, if it is kept
+		at *image.Point
+	}{
+		{"already there", 300, 400, 0.75, models.FitCrop, image.Pt(300, 400), false, &image.Point{}},
+		{"under a pixel off", 100, 301, 1.0 / 3, models.FitCrop, image.Pt(100, 301), false, &image.Point{}},
+		{"crop width", 400, 400, 0.75, models.FitCrop, image.Pt(300, 400), true, nil},
+		{"crop height", 300, 500, 0.75, models.FitCrop, image.Pt(300, 400), true, nil},
+		{"pad height", 400, 400, 0.5, models.FitPad, image.Pt(400, 800), true, &image.Point{Y: 200}},
+		{"pad width", 300, 300, 1.5, models.FitPad, image.Pt(450, 300), true, &image.Point{X: 75}},
+		{"pad to portrait", 400, 300, 0.75, models.FitPad, image.Pt(400, 533), true, &image.Point{Y: 116}},
+	}
+	for _, tt := range tests {
+		src := markedImage(tt.w, tt.h)
+		got, reshaped := toAspect(src, tt.aspect, imageOptions{fit: tt.fit, background: black})
+		if size := got.Bounds().Size(); size != tt.want || reshaped != tt.reshaped {
+			t.Errorf("%s: %v, reshaped %v; want %v, %v", tt.name, size, reshaped, tt.want, tt.reshaped)
+			continue
+		}
+		if tt.at != nil && !sameColor(got.At(tt.at.X, tt.at.Y), red) {
+			t.Errorf("%s: top-left pixel not at %v", tt.name, *tt.at)
+		}
+		if tt.fit == models.FitPad && tt.reshaped && (!sameColor(got.At(0, 0), black) || !sameColor(got.At(got.Bounds().Dx()-1, got.Bounds().Dy()-1), black)) {
+			t.Errorf("%s: padding is not the background colour", tt.name)
+		}
+	}
+
+	// Cropping is centred: the corners go, the centre stays
+	src := markedImage(400, 300)
+	src.SetNRGBA(200, 150, color.NRGBA{G: 255, A: 255})
+	got, _ := toAspect(src, 1, imageOptions{fit: models.FitCrop})
+	if got.Bounds().Size() != image.Pt(300, 300) {
+		t.Fatalf("crop to square = %v", got.Bounds().Size())
+	}
+	if sameColor(got.At(0, 0), red) {
+		t.Error("crop kept the left edge")
+	}
+	if !sameColor(got.At(150, 150), color.NRGBA{G: 255, A: 255}) {
+		t.Error("crop is not centred")
+	}
+}
+
+func TestScaleToBounds(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		opts         imageOptions
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{"within bounds", 300, 400, imageOptions{minWidth: 200, maxWidth: 500}, 300, 400, false},
+		{"down to max width", 1000, 500, imageOptions{maxWidth: 500}, 500, 250, false},
+		{"down to the tighter max", 1000, 1000, imageOptions{maxWidth: 800, maxHeight: 400}, 400, 400, false},
+		{"up to min height", 100, 50, imageOptions{minHeight: 200}, 400, 200, false},
+		{"up within max", 100, 100, imageOptions{minWidth: 200, maxHeight: 300}, 200, 200, false},
+		{"rounding clamped", 999, 333, imageOptions{maxWidth: 500, minHeight: 167}, 500, 167, false},
+		{"cannot meet both", 1000, 100, imageOptions{maxWidth: 200, minHeight: 200}, 0, 0, true},
+		{"over the pixel limit", 100, 100, imageOptions{minWidth: 10_000, minHeight: 10_000}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, h, err := scaleToBounds(tt.w, tt.h, tt.opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: scaled to %dx%d; want an error", tt.name, w, h)
+			}
+			continue
+		}
+		if err != nil || w != tt.wantW || h != tt.wantH {
+			t.Errorf("%s: %dx%d, %v; want %dx%d", tt.name, w, h, err, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestShapeImage(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		options      map[string]string
+		wantW, wantH int
+		settings     map[string]string
+	}{
+		{"no shape options", 640, 480, nil, 640, 480, nil},
+		{"exact size", 640, 480, map[string]string{OptionWidth: "300", OptionHeight: "400"}, 300, 400,
+			map[string]string{"width": "300", "height": "400", "fit": "crop"}},
+		{"exact size padded", 640, 480, map[string]string{OptionWidth: "300", OptionHeight: "400", OptionFit: "pad"}, 300, 400,
+			map[string]string{"width": "300", "height": "400", "fit": "pad"}},
+		{"aspect then bounds", 1000, 500, map[string]string{OptionAspectRatio: "1:1", OptionMaxWidth: "200"}, 200, 200,
+			map[string]string{"width": "200", "height": "200", "fit": "crop"}},
+		{"bounds only", 1000, 500, map[string]string{OptionMaxHeight: "100"}, 200, 100,
+			map[string]string{"width": "200", "height": "100"}},
+		{"already in shape", 300, 400, map[string]string{OptionAspectRatio: "3:4"}, 300, 400,
+			map[string]string{"width": "300", "height": "400"}},
+	}
+	for _, tt := range tests {
+		opts, err := parseImageOptions(tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		img, settings, err := shapeImage(markedImage(tt.w, tt.h), opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if size := img.Bounds().Size(); size != image.Pt(tt.wantW, tt.wantH) {
+			t.Errorf("%s: %v; want %dx%d", tt.name, size, tt.wantW, tt.wantH)
+		}
+		if len(settings) != len(tt.settings) {
+			t.Errorf("%s: settings %v; want %v", tt.name, settings, tt.settings)
+			continue
+		}
+		for k, v := range tt.settings {
+			if settings[k] != v {
+				t.Errorf("%s: settings %v; want %v", tt.name, settings, tt.settings)
+				break
+			}
+		}
+	}
+
+	opts, _ := parseImageOptions(map[string]string{OptionMaxWidth: "100", OptionMinHeight: "500"})
+	if _, _, err := shapeImage(markedImage(1000, 100), opts); err == nil {
+		t.Error("shaped an image to bounds its shape cannot meet")
+	}
+}
@@ -1,5 +1,7 @@
 -- 1forall Database Schema for Supabase/PostgreSQL
 -- This file contains the schema to migrate from JSON to PostgreSQL
+-- The Go backends apply the numbered migrations in migrate/ instead of this
+-- file; keep the two in sync when changing tables.
 
 -- Enable UUID extension
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
@@ -11,10 +13,23 @@ CREATE TABLE exams (
   title VARCHAR(255) NOT NULL,
   icon VARCHAR(10),
   description TEXT,
+  revision INTEGER NOT NULL DEFAULT 1,
+  sort_order INTEGER NOT NULL DEFAULT 0,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Exam Revisions Table (every version of an exam's documents; kept after
+-- the exam is deleted, so no foreign key)
+CREATE TABLE exam_revisions (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  exam_id VARCHAR(50) NOT NULL,
+  revision INTEGER NOT NULL,
+  documents JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (exam_id, revision)
+);
+
 -- Documents Table
 CREATE TABLE documents (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -25,8 +40,19 @@ CREATE TABLE documents (
   format VARCHAR(100),
   max_size BIGINT,
   required BOOLEAN DEFAULT false,
+  min_width INTEGER,
+  max_width INTEGER,
+  min_height INTEGER,
+  max_height INTEGER,
+  aspect_ratio VARCHAR(20),
+  dpi INTEGER,
+  width_cm DOUBLE PRECISION,
+  height_cm DOUBLE PRECISION,
+  fit VARCHAR(20),
+  sort_order INTEGER NOT NULL DEFAULT 0,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (exam_id, document_id)
 );
 
 -- Conversion Requests Table
@@ -34,30 +60,53 @@ CREATE TABLE conversion_requests (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   conversion_id VARCHAR(50) UNIQUE NOT NULL,
   user_id VARCHAR(100) NOT NULL,
-  exam_id VARCHAR(50) NOT NULL REFERENCES exams(exam_id) ON DELETE SET NULL,
+  exam_id VARCHAR(50) REFERENCES exams(exam_id) ON DELETE SET NULL,
   document_id VARCHAR(50),
+  exam_revision INTEGER,
   file_name VARCHAR(255) NOT NULL,
   file_size BIGINT NOT NULL,
   input_path VARCHAR(500),
   output_path VARCHAR(500),
-  status VARCHAR(50) DEFAULT 'pending', -- pending, processing, completed, failed
+  status VARCHAR(50) DEFAULT 'pending', -- pending, processing, completed, failed, quarantined
   error_msg TEXT,
+  content_type VARCHAR(255),
+  input_hash VARCHAR(64),
+  tool_id VARCHAR(50),
+  options TEXT, -- canonical JSON object
+  scan_verdict VARCHAR(20), -- clean, infected
+  scan_signature VARCHAR(255),
+  output_size BIGINT,
+  output_settings TEXT, -- canonical JSON object
+  kit_id VARCHAR(50),
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   
   -- Indexes for better query performance
-  CONSTRAINT status_check CHECK (status IN ('pending', 'processing', 'completed', 'failed'))
+  CONSTRAINT status_check CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'quarantined'))
+);
+
+-- Tool Categories Table
+CREATE TABLE tool_categories (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  category_id VARCHAR(50) UNIQUE NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  icon VARCHAR(10),
+  disabled BOOLEAN NOT NULL DEFAULT false,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Tools Table
 CREATE TABLE tools (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   tool_id VARCHAR(50) UNIQUE NOT NULL,
-  category VARCHAR(50) NOT NULL,
-  category_icon VARCHAR(10),
+  category VARCHAR(50) NOT NULL REFERENCES tool_categories(category_id) ON DELETE CASCADE,
   name VARCHAR(255) NOT NULL,
   description TEXT,
   logo VARCHAR(10),
+  disabled BOOLEAN NOT NULL DEFAULT false,
+  sort_order INTEGER NOT NULL DEFAULT 0,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -81,11 +130,19 @@ CREATE TABLE user_conversions (
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Blob References Table (conversions sharing each deduplicated blob)
+CREATE TABLE blob_refs (
+  blob_key VARCHAR(500) PRIMARY KEY,
+  refs INTEGER NOT NULL CHECK (refs > 0)
+);
+
 -- Create Indexes for better query performance
 CREATE INDEX idx_conversion_requests_user_id ON conversion_requests(user_id);
 CREATE INDEX idx_conversion_requests_exam_id ON conversion_requests(exam_id);
 CREATE INDEX idx_conversion_requests_status ON conversion_requests(status);
 CREATE INDEX idx_conversion_requests_created_at ON conversion_requests(created_at DESC);
+CREATE INDEX idx_conversion_requests_input_hash ON conversion_requests(input_hash, tool_id);
+CREATE INDEX idx_conversion_requests_kit_id ON conversion_requests(kit_id);
 CREATE INDEX idx_documents_exam_id ON documents(exam_id);
 CREATE INDEX idx_tools_category ON tools(category);
 CREATE INDEX idx_user_conversions_user_id ON user_conversions(user_id);
@@ -133,6 +190,9 @@ CREATE TRIGGER update_documents_updated_at BEFORE UPDATE ON documents
 CREATE TRIGGER update_conversion_requests_updated_at BEFORE UPDATE ON conversion_requests
   FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_tool_categories_updated_at BEFORE UPDATE ON tool_categories
+  FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
 CREATE TRIGGER update_tools_updated_at BEFORE UPDATE ON tools
   FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
@@ -151,5 +211,5 @@ INSERT INTO exams (exam_id, title, icon, description) VALUES
 ('jee-advanced', 'JEE Advanced', '🚀', 'Advanced Engineering Entrance Exam');
 
 -- Grant permissions (example for public schema)
-GRANT SELECT ON exams, documents, tools TO anon;
+GRANT SELECT ON exams, documents, tool_categories, tools TO anon;
 GRANT SELECT, INSERT ON conversion_requests TO authenticated;
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+)
+
+// AdminHandler handles administrative requests
+type AdminHandler struct {
+	store storage.Store
+	// docsMu serialises document edits in this process; edits from other
+	// instances are caught by UpdateExamDocuments
+	docsMu sync.Mutex
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(store storage.Store) *AdminHandler {
+	return &AdminHandler{store: store}
+}
+
+// ReloadCatalog re-reads exams and tools from disk
+// @Summary Reload exams and tools
+// @Description Re-read and validate exams.json and tools.json; the current data is kept if they are invalid
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
+// @Failure 501 {object} models.APIResponse
+// @Router /api/admin/reload [post]
+func (h *AdminHandler) ReloadCatalog(c *gin.Context) {
+	reloader, ok := h.store.(storage.Reloader)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, models.APIResponse{
+			Success: false,
+			Error:   "The configured storage backend does not support reloading",
+		})
+		return
+	}
+
+	if err := reloader.Reload(c.Request.Context()); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exams and tools reloaded",
+	})
+}
+
+// CreateExam adds a new exam
+// @Summary Create exam
+// @Description Create an exam with its document requirements
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param exam body models.Exam true "Exam"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/admin/exams [post]
+func (h *AdminHandler) CreateExam(c *gin.Context) {
+	var exam models.Exam
+	if err := c.ShouldBindJSON(&exam); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	created, err := h.store.CreateExam(c.Request.Context(), exam)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Exam created successfully",
+		Data:    created,
+	})
+}
+
+// UpdateExam replaces an exam, including its document list
+// @Summary Update exam
+// @Description Replace an exam and its document requirements
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param exam body models.Exam true "Exam"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/exams/:id [put]
+func (h *AdminHandler) UpdateExam(c *gin.Context) {
+	var exam models.Exam
+	if err := c.ShouldBindJSON(&exam); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+	examID := c.Param("id")
+	if exam.ID != "" && exam.ID != examID {
+		respondBadRequest(c, "Exam ID in body does not match the URL")
+		return
+	}
+	exam.ID = examID
+
+	updated, err := h.store.UpdateExam(c.Request.Context(), exam)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exam updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeleteExam removes an exam
+// @Summary Delete exam
+// @Description Delete an exam and its document requirements
+// @Tags admin
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/exams/:id [delete]
+func (h *AdminHandler) DeleteExam(c *gin.Context) {
+	if err := h.store.DeleteExam(c.Request.Context(), c.Param("id")); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exam deleted successfully",
+	})
+}
+
+// AddDocument appends a document requirement to an exam
+// @Summary Add exam document
+// @Description Add a document requirement to an exam
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param document body models.Document true "Document"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/exams/:id/documents [post]
+func (h *AdminHandler) AddDocument(c *gin.Context) {
+	var doc models.Document
+	if err := c.ShouldBindJSON(&doc); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	h.editDocuments(c, documentAdd, func(docs []models.Document) ([]models.Document, bool) {
+		if findDocumentIn(docs, doc.ID) >= 0 {
+			return nil, false
+		}
+		return append(docs, doc), true
+	})
+}
+
+// UpdateDocument replaces one document requirement of an exam
+// @Summary Update exam document
+// @Description Replace a document requirement of an exam
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param doc_id path string true "Document ID"
+// @Param document body models.Document true "Document"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/exams/:id/documents/:doc_id [put]
+func (h *AdminHandler) UpdateDocument(c *gin.Context) {
+	var doc models.Document
+	if err := c.ShouldBindJSON(&doc); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+	docID := c.Param("doc_id")
+	if doc.ID != "" && doc.ID != docID {
+		respondBadRequest(c, "Document ID in body does not match the URL")
+		return
+	}
+	doc.ID = docID
+
+	h.editDocuments(c, documentUpdate, func(docs []models.Document) ([]models.Document, bool) {
+		i := findDocumentIn(docs, docID)
+		if i < 0 {
+			return nil, false
+		}
+		docs[i] = doc
+		return docs, true
+	})
+}
+
+// DeleteDocument removes one document requirement from an exam
+// @Summary Delete exam document
+// @Description Remove a document requirement from an exam
+// @Tags admin
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param doc_id path string true "Document ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/exams/:id/documents/:doc_id [delete]
+func (h *AdminHandler) DeleteDocument(c *gin.Context) {
+	docID := c.Param("doc_id")
+	h.editDocuments(c, documentDelete, func(docs []models.Document) ([]models.Document, bool) {
+		i := findDocumentIn(docs, docID)
+		if i < 0 {
+			return nil, false
+		}
+		return append(docs[:i], docs[i+1:]...), true
+	})
+}
+
+// documentEdit is the kind of change editDocuments makes
+type documentEdit int
+
+const (
+	documentAdd documentEdit = iota
+	documentUpdate
+	documentDelete
+)
+
+// editAttempts is how many times editDocuments retries an edit that lost a
+// race with another one
+const editAttempts = 3
+
+// editDocuments loads the exam from the URL, applies edit to a copy of its
+// documents and saves them if they are still at the revision edit saw,
+// starting over from the saved exam otherwise, as when another instance
+// edited them meanwhile. edit reports false when the
+// document it targets is missing (or, when adding, already present).
+func (h *AdminHandler) editDocuments(c *gin.Context, mode documentEdit, edit func([]models.Document) ([]models.Document, bool)) {
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+
+	ctx := c.Request.Context()
+	var updated *models.Exam
+	for attempt := 1; ; attempt++ {
+		exam, err := h.store.GetExamByID(ctx, c.Param("id"))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		// Work on a copy: the store may share the slice with concurrent readers
+		docs := make([]models.Document, len(exam.Documents))
+		copy(docs, exam.Documents)
+		docs, ok := edit(docs)
+		if !ok {
+			if mode == documentAdd {
+				c.JSON(http.StatusConflict, models.APIResponse{
+					Success: false,
+					Error:   "Document already exists",
+				})
+				return
+			}
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Document not found",
+			})
+			return
+		}
+
+		updated, err = h.store.UpdateExamDocuments(ctx, exam.ID, exam.Revision, docs)
+		if errors.Is(err, storage.ErrStale) && attempt < editAttempts {
+			continue
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		break
+	}
+
+	status, message := http.StatusOK, "Document updated successfully"
+	switch mode {
+	case documentAdd:
+		status, message = http.StatusCreated, "Document added successfully"
+	case documentDelete:
+		message = "Document deleted successfully"
+	}
+	c.JSON(status, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    updated,
+	})
+}
+
+// findDocumentIn returns the index of the document with the given ID, or -1
+func findDocumentIn(docs []models.Document, documentID string) int {
+	for i := range docs {
+		if docs[i].ID == documentID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+)
+
+// newAdminTestServer serves the admin routes, without their token check,
+// over a MemoryStorage holding jee-main and one tool category
+func newAdminTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemoryStorage([]models.Exam{{
+		ID:    "jee-main",
+		Title: "JEE Main",
+		Documents: []models.Document{
+			{ID: "photo", Name: "Photo", Format: "JPG", MaxSize: 51200},
+		},
+	}}, []models.ToolCategory{{
+		ID:       "image",
+		Category: "Image Tools",
+		Tools: []models.Tool{
+			{ID: "png-to-jpg", Name: "PNG to JPG"},
+			{ID: "compress-image", Name: "Compress"},
+		},
+	}})
+	if err := store.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := NewAdminHandler(store)
+	router := gin.New()
+	router.POST("/api/admin/reload", admin.ReloadCatalog)
+	router.POST("/api/admin/exams", admin.CreateExam)
+	router.PUT("/api/admin/exams/:id", admin.UpdateExam)
+	router.DELETE("/api/admin/exams/:id", admin.DeleteExam)
+	router.POST("/api/admin/exams/:id/documents", admin.AddDocument)
+	router.PUT("/api/admin/exams/:id/documents/:doc_id", admin.UpdateDocument)
+	router.DELETE("/api/admin/exams/:id/documents/:doc_id", admin.DeleteDocument)
+	router.GET("/api/admin/tools", admin.GetAllTools)
+	router.PUT("/api/admin/tools/order", admin.ReorderToolCategories)
+	router.POST("/api/admin/tools/categories", admin.CreateToolCategory)
+	router.PUT("/api/admin/tools/categories/:id", admin.UpdateToolCategory)
+	router.DELETE("/api/admin/tools/categories/:id", admin.DeleteToolCategory)
+	router.PUT("/api/admin/tools/categories/:id/order", admin.ReorderTools)
+	router.POST("/api/admin/tools/categories/:id/tools", admin.CreateTool)
+	router.PUT("/api/admin/tools/:tool_id", admin.UpdateTool)
+	router.DELETE("/api/admin/tools/:tool_id", admin.DeleteTool)
+	return &testServer{router: router, store: store}
+}
+
+// adminStep is one admin request and the status it should get
+type adminStep struct {
+	method, path, body string
+	status             int
+}
+
+// run sends each step in turn
+func (s *testServer) run(t *testing.T, steps []adminStep) {
+	t.Helper()
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		req.Header.Set("Content-Type", "application/json")
+		w, resp := s.do(t, req)
+		if w.Code != step.status || resp.Success != (step.status < 300) {
+			t.Errorf("%s %s %s = %d %+v; want %d", step.method, step.path, step.body, w.Code, resp, step.status)
+		}
+	}
+}
+
+func TestAdminExams(t *testing.T) {
+	s := newAdminTestServer(t)
+	neet := `{"id":"neet","title":"NEET","documents":[{"id":"photo","name":"Photo","format":"JPG","max_size":1000}]}`
+	s.run(t, []adminStep{
+		{http.MethodPost, "/api/admin/exams", neet, http.StatusCreated},
+		{http.MethodPost, "/api/admin/exams", neet, http.StatusConflict},
+		{http.MethodPost, "/api/admin/exams", `{"id":"gate"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/exams", `{"id":"gate","title":"GATE","documents":[{"id":"sig","name":"Signature","format":"TIFF","max_size":10}]}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/exams", `not json`, http.StatusBadRequest},
+
+		{http.MethodPut, "/api/admin/exams/neet", `{"title":"NEET UG"}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/exams/neet", `{"id":"gate","title":"GATE"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/exams/gate", `{"title":"GATE"}`, http.StatusNotFound},
+
+		{http.MethodDelete, "/api/admin/exams/jee-main", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/exams/jee-main", "", http.StatusNotFound},
+	})
+
+	exams, err := s.store.GetExams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exams) != 1 || exams[0].ID != "neet" || exams[0].Title != "NEET UG" || len(exams[0].Documents) != 0 {
+		t.Errorf("exams = %+v; want just NEET UG, its documents replaced", exams)
+	}
+
+	// MemoryStorage has no files to reload from
+	s.run(t, []adminStep{{http.MethodPost, "/api/admin/reload", "", http.StatusNotImplemented}})
+}
+
+func TestAdminDocuments(t *testing.T) {
+	s := newAdminTestServer(t)
+	sig := `{"id":"signature","name":"Signature","format":"JPG","max_size":20000}`
+	s.run(t, []adminStep{
+		{http.MethodPost, "/api/admin/exams/jee-main/documents", sig, http.StatusCreated},
+		{http.MethodPost, "/api/admin/exams/jee-main/documents", sig, http.StatusConflict},
+		{http.MethodPost, "/api/admin/exams/jee-main/documents", `{"id":"thumb","name":"Thumb","format":"JPG"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/exams/neet/documents", sig, http.StatusNotFound},
+
+		{http.MethodPut, "/api/admin/exams/jee-main/documents/signature", `{"name":"Signature","format":"PNG","max_size":30000}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/exams/jee-main/documents/signature", `{"id":"photo","name":"Photo","format":"PNG","max_size":1}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/exams/jee-main/documents/thumb", `{"name":"Thumb","format":"PNG","max_size":1}`, http.StatusNotFound},
+
+		{http.MethodDelete, "/api/admin/exams/jee-main/documents/photo", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/exams/jee-main/documents/photo", "", http.StatusNotFound},
+		{http.MethodDelete, "/api/admin/exams/neet/documents/photo", "", http.StatusNotFound},
+	})
+
+	exam, err := s.store.GetExamByID(context.Background(), "jee-main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exam.Documents) != 1 || exam.Documents[0].Format != "PNG" || exam.Documents[0].MaxSize != 30000 {
+		t.Errorf("documents = %+v; want the updated signature only", exam.Documents)
+	}
+	// Each successful edit is a revision
+	if exam.Revision != 4 {
+		t.Errorf("revision %d; want 4", exam.Revision)
+	}
+}
+
+func TestAdminTools(t *testing.T) {
+	s := newAdminTestServer(t)
+	s.run(t, []adminStep{
+		{http.MethodPost, "/api/admin/tools/categories", `{"id":"pdf","category":"PDF Tools"}`, http.StatusCreated},
+		{http.MethodPost, "/api/admin/tools/categories", `{"id":"pdf","category":"PDF Tools"}`, http.StatusConflict},
+		{http.MethodPost, "/api/admin/tools/categories", `{"id":"docs"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/categories/pdf", `{"category":"PDF","icon":"file"}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/tools/categories/pdf", `{"id":"image","category":"PDF"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/categories/docs", `{"category":"Docs"}`, http.StatusNotFound},
+
+		{http.MethodPost, "/api/admin/tools/categories/pdf/tools", `{"id":"merge-pdf","name":"Merge PDF"}`, http.StatusCreated},
+		{http.MethodPost, "/api/admin/tools/categories/pdf/tools", `{"id":"png-to-jpg","name":"PNG to JPG"}`, http.StatusConflict},
+		{http.MethodPost, "/api/admin/tools/categories/pdf/tools", `{"id":"split-pdf"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/tools/categories/docs/tools", `{"id":"split-pdf","name":"Split PDF"}`, http.StatusNotFound},
+		{http.MethodPut, "/api/admin/tools/merge-pdf", `{"name":"Merge PDFs","disabled":true}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/tools/merge-pdf", `{"id":"split-pdf","name":"Split PDF"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/split-pdf", `{"name":"Split PDF"}`, http.StatusNotFound},
+
+		{http.MethodPut, "/api/admin/tools/order", `{"ids":["pdf","image"]}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/tools/order", `{"ids":["pdf"]}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/order", `{"ids":["pdf","docs"]}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/order", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/categories/image/order", `{"ids":["compress-image","png-to-jpg"]}`, http.StatusOK},
+		{http.MethodPut, "/api/admin/tools/categories/image/order", `{"ids":["compress-image","compress-image"]}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/tools/categories/docs/order", `{"ids":[]}`, http.StatusNotFound},
+
+		{http.MethodDelete, "/api/admin/tools/png-to-jpg", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/tools/png-to-jpg", "", http.StatusNotFound},
+		{http.MethodDelete, "/api/admin/tools/categories/docs", "", http.StatusNotFound},
+		{http.MethodGet, "/api/admin/tools", "", http.StatusOK},
+	})
+
+	tools, err := s.store.GetTools(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, cat := range tools {
+		ids := []string{cat.ID + ":" + cat.Category}
+		for _, tool := range cat.Tools {
+			ids = append(ids, tool.ID)
+		}
+		got = append(got, strings.Join(ids, ","))
+	}
+	want := "pdf:PDF,merge-pdf image:Image Tools,compress-image"
+	if strings.Join(got, " ") != want {
+		t.Errorf("tools = %s; want %s", strings.Join(got, " "), want)
+	}
+	if merge := tools[0].Tools[0]; merge.Name != "Merge PDFs" || !merge.Disabled {
+		t.Errorf("merge-pdf = %+v; want renamed and disabled", merge)
+	}
+
+	s.run(t, []adminStep{{http.MethodDelete, "/api/admin/tools/categories/pdf", "", http.StatusOK}})
+	if tools, _ := s.store.GetTools(context.Background()); len(tools) != 1 || tools[0].ID != "image" {
+		t.Errorf("tools = %+v; want the pdf category gone with its tools", tools)
+	}
+}
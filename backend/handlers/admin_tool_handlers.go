@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+)
+
+// GetAllTools returns the whole tool catalogue, including disabled entries
+// @Summary Get all tools (admin)
+// @Description Get every tool category and tool, including disabled ones
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.APIResponse
+// @Router /api/admin/tools [get]
+func (h *AdminHandler) GetAllTools(c *gin.Context) {
+	tools, err := h.store.GetTools(c.Request.Context())
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tools retrieved successfully",
+		Data:    tools,
+	})
+}
+
+// ReorderToolCategories sets the order of the tool categories
+// @Summary Reorder tool categories
+// @Description Set the category order; ids must list every category exactly once
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param order body models.ReorderRequest true "Category IDs in order"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Router /api/admin/tools/order [put]
+func (h *AdminHandler) ReorderToolCategories(c *gin.Context) {
+	var req models.ReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	if err := h.store.ReorderToolCategories(c.Request.Context(), req.IDs); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tool categories reordered successfully",
+	})
+}
+
+// CreateToolCategory adds a tool category
+// @Summary Create tool category
+// @Description Create a tool category, optionally with its tools, after the existing ones
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param category body models.ToolCategory true "Tool category"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/admin/tools/categories [post]
+func (h *AdminHandler) CreateToolCategory(c *gin.Context) {
+	var cat models.ToolCategory
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	created, err := h.store.CreateToolCategory(c.Request.Context(), cat)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Tool category created successfully",
+		Data:    created,
+	})
+}
+
+// UpdateToolCategory changes a tool category's name, icon or disabled flag
+// @Summary Update tool category
+// @Description Replace a category's name, icon and disabled flag; its tools are not changed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param category body models.ToolCategory true "Tool category"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/tools/categories/:id [put]
+func (h *AdminHandler) UpdateToolCategory(c *gin.Context) {
+	var cat models.ToolCategory
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+	categoryID := c.Param("id")
+	if cat.ID != "" && cat.ID != categoryID {
+		respondBadRequest(c, "Category ID in body does not match the URL")
+		return
+	}
+	cat.ID = categoryID
+
+	updated, err := h.store.UpdateToolCategory(c.Request.Context(), cat)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tool category updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeleteToolCategory removes a tool category
+// @Summary Delete tool category
+// @Description Delete a tool category and every tool in it
+// @Tags admin
+// @Produce json
+// @Param id path string true "Category ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/tools/categories/:id [delete]
+func (h *AdminHandler) DeleteToolCategory(c *gin.Context) {
+	if err := h.store.DeleteToolCategory(c.Request.Context(), c.Param("id")); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tool category deleted successfully",
+	})
+}
+
+// ReorderTools sets the order of the tools in a category
+// @Summary Reorder tools
+// @Description Set the tool order within a category; ids must list every tool in it exactly once
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param order body models.ReorderRequest true "Tool IDs in order"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/tools/categories/:id/order [put]
+func (h *AdminHandler) ReorderTools(c *gin.Context) {
+	var req models.ReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	if err := h.store.ReorderTools(c.Request.Context(), c.Param("id"), req.IDs); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tools reordered successfully",
+	})
+}
+
+// CreateTool adds a tool to a category
+// @Summary Create tool
+// @Description Add a tool at the end of a category
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param tool body models.Tool true "Tool"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/admin/tools/categories/:id/tools [post]
+func (h *AdminHandler) CreateTool(c *gin.Context) {
+	var tool models.Tool
+	if err := c.ShouldBindJSON(&tool); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+
+	created, err := h.store.CreateTool(c.Request.Context(), c.Param("id"), tool)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Tool created successfully",
+		Data:    created,
+	})
+}
+
+// UpdateTool replaces a tool
+// @Summary Update tool
+// @Description Replace a tool's name, description, logo and disabled flag
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param tool_id path string true "Tool ID"
+// @Param tool body models.Tool true "Tool"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/tools/:tool_id [put]
+func (h *AdminHandler) UpdateTool(c *gin.Context) {
+	var tool models.Tool
+	if err := c.ShouldBindJSON(&tool); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+	toolID := c.Param("tool_id")
+	if tool.ID != "" && tool.ID != toolID {
+		respondBadRequest(c, "Tool ID in body does not match the URL")
+		return
+	}
+	tool.ID = toolID
+
+	updated, err := h.store.UpdateTool(c.Request.Context(), tool)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tool updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeleteTool removes a tool
+// @Summary Delete tool
+// @Description Delete a tool from the catalogue
+// @Tags admin
+// @Produce json
+// @Param tool_id path string true "Tool ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/admin/tools/:tool_id [delete]
+func (h *AdminHandler) DeleteTool(c *gin.Context) {
+	if err := h.store.DeleteTool(c.Request.Context(), c.Param("tool_id")); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tool deleted successfully",
+	})
+}
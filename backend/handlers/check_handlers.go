@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/check"
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// CheckHandler checks files against exam document requirements
+type CheckHandler struct {
+	store       storage.Store
+	registry    *convert.Registry
+	checker     *check.Checker
+	spoolDir    string
+	maxFileSize int64
+}
+
+// NewCheckHandler creates a new check handler. Files are spooled to local
+// disk while they are checked and removed afterwards; fixes suggest tools
+// from registry.
+func NewCheckHandler(store storage.Store, registry *convert.Registry, cfg *config.Config) *CheckHandler {
+	return &CheckHandler{
+		store:       store,
+		registry:    registry,
+		checker:     check.New(registry),
+		spoolDir:    filepath.Join(cfg.UploadDirectory, "tmp"),
+		maxFileSize: cfg.MaxFileSize,
+	}
+}
+
+// CheckDocument reports whether a file meets a document's requirements
+// @Summary Check a file against a document
+// @Description Check the file in the multipart field "file" against the requirements of an exam document: its detected format, size and, for images, pixel size, aspect ratio and DPI, with each rule passed or failed and how to fix failures. PDFs report their page count. The file is not stored and no conversion is created.
+// @Tags exams
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param doc_id path string true "Document ID"
+// @Param file formData file true "File to check"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 413 {object} models.APIResponse
+// @Router /api/exams/:id/documents/:doc_id/check [post]
+func (h *CheckHandler) CheckDocument(c *gin.Context) {
+	ctx := c.Request.Context()
+	exam, err := h.store.GetExamByID(ctx, c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Exam not found")
+		return
+	}
+	doc := findDocument(exam, c.Param("doc_id"))
+	if doc == nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   "Document not found",
+		})
+		return
+	}
+
+	// Files over the document's limit are still checked, to report by how
+	// much they are over
+	limit := h.maxFileSize
+	if c.Request.ContentLength > limit+multipartOverhead {
+		respondTooLarge(c, limit)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	part, err := filePart(c.Request)
+	if err != nil {
+		if isMaxBytesError(err) {
+			respondTooLarge(c, limit)
+			return
+		}
+		respondBadRequest(c, err.Error())
+		return
+	}
+	defer part.Close()
+	name := utils.SanitizeFileName(filepath.Base(part.FileName()))
+
+	path, size, err := spoolUpload(part, h.spoolDir, limit)
+	if path != "" {
+		defer os.Remove(path)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errFileTooLarge) || isMaxBytesError(err):
+			respondTooLarge(c, limit)
+		case size == 0:
+			respondBadRequest(c, "Uploaded file is empty")
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to read upload: " + err.Error(),
+			})
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to read upload: " + err.Error(),
+		})
+		return
+	}
+	defer f.Close()
+
+	tools, err := availableToolIDs(ctx, h.store, h.registry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	report := h.checker.Check(*doc, check.File{Name: name, Data: f, Size: size}, tools)
+	report.ExamID = exam.ID
+	message := "File meets the document's requirements"
+	if !report.Compliant {
+		message = "File does not meet the document's requirements"
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    report,
+	})
+}
+
+// availableToolIDs lists the tools users may request, in catalogue order
+func availableToolIDs(ctx context.Context, store storage.Store, registry *convert.Registry) ([]string, error) {
+	categories, err := store.GetTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, cat := range availableTools(enabledTools(categories), registry) {
+		for _, tool := range cat.Tools {
+			ids = append(ids, tool.ID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// Uploads are stored by content: every conversion whose input has the same
+// SHA-256 points at one blob, and the store counts the conversions holding
+// it so it is deleted with the last one. Converted outputs are shared the
+// same way when a conversion reuses an earlier one's output.
+
+// inputKey is the blob key of an uploaded file with the given SHA-256
+func inputKey(hash string) string {
+	return "inputs/sha256/" + hash[:2] + "/" + hash
+}
+
+// hashFile returns the hex SHA-256 of the local file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reuseOutput completes conv with the output of an earlier conversion of the
+// same input by the same tool and options, if there is one whose output is
+// still stored. It reports whether conv now shares that output.
+func (h *ConversionHandler) reuseOutput(ctx context.Context, conv *models.ConversionRequest) (bool, error) {
+	if conv.ToolID == "" {
+		return false, nil
+	}
+	cached, err := h.store.FindCachedConversion(ctx, conv.InputHash, conv.ToolID, conv.Options)
+	if errors.Is(err, storage.ErrNotFound) || err == nil && cached.ID == conv.ID {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := blob.Acquire(ctx, h.blobs, h.store, cached.OutputPath, nil); err != nil {
+		if errors.Is(err, blob.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	conv.OutputPath = cached.OutputPath
+	conv.OutputSize = cached.OutputSize
+	conv.OutputSettings = cached.OutputSettings
+	conv.Status = utils.StatusCompleted
+	conv.ErrorMsg = ""
+	return true, nil
+}
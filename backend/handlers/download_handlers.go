@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/middleware"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/utils"
+)
+
+// userIDHeader names the user making a request. There are no user accounts
+// yet, so it is the same ID the conversion was requested with.
+const userIDHeader = "X-User-ID"
+
+// DownloadOutput serves the converted file of a completed conversion
+// @Summary Download conversion output
+// @Description Download the converted file. Supports Range, If-Range and If-None-Match so interrupted downloads can resume. The X-User-ID header must name the user who requested the conversion.
+// @Tags conversions
+// @Produce octet-stream
+// @Param id path string true "Conversion ID"
+// @Param X-User-ID header string true "User who requested the conversion"
+// @Param Range header string false "Byte range, e.g. bytes=1024-"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 416 {object} models.APIResponse
+// @Router /api/conversions/:id/download [get]
+func (h *ConversionHandler) DownloadOutput(c *gin.Context) {
+	conv, err := h.store.GetConversionByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Conversion not found")
+		return
+	}
+	if !requireOwner(c, conv) {
+		return
+	}
+	if conv.Status != utils.StatusCompleted {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error:   "Conversion is " + conv.Status + ", not completed",
+		})
+		return
+	}
+	h.serveOutput(c, conv)
+}
+
+// serveOutput streams a conversion's output blob with its type, download
+// name and an ETag, letting http.ServeContent handle range and conditional
+// requests
+func (h *ConversionHandler) serveOutput(c *gin.Context, conv *models.ConversionRequest) {
+	if conv.OutputPath == "" {
+		respondOutputMissing(c)
+		return
+	}
+	f, info, err := blob.Open(c.Request.Context(), h.blobs, conv.OutputPath)
+	if errors.Is(err, blob.ErrNotExist) || errors.Is(err, blob.ErrInvalidKey) {
+		respondOutputMissing(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to open output: " + err.Error(),
+		})
+		return
+	}
+	defer f.Close()
+
+	name := outputName(conv)
+	header := c.Writer.Header()
+	header.Set("Content-Type", outputContentType(f, info.Size, name))
+	header.Set("Content-Disposition", attachmentDisposition(name))
+	// The output of a conversion is written once, so its size and
+	// modification time identify its content
+	header.Set("ETag", fmt.Sprintf(`"%s-%x-%x"`, conv.ID, info.Size, info.ModTime.UnixNano()))
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime, f)
+}
+
+// outputName is the name a conversion's output is downloaded under. It
+// comes from the conversion's own upload rather than the output blob's key,
+// which belongs to another conversion when the output was reused.
+func outputName(conv *models.ConversionRequest) string {
+	return convert.OutputName(conv.FileName, strings.TrimPrefix(path.Ext(conv.OutputPath), "."))
+}
+
+// outputContentType identifies an output file by its content, falling back
+// to its extension
+func outputContentType(f io.ReaderAt, size int64, name string) string {
+	if ft, err := utils.DetectFileType(f, size); err == nil {
+		return ft.MIME
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// attachmentDisposition builds a Content-Disposition header for downloading
+// a file as name. Names that are not plain ASCII get an ASCII filename for
+// old clients next to the RFC 5987 encoded filename*.
+func attachmentDisposition(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	if ascii == name {
+		return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; %s`, ascii,
+		strings.TrimPrefix(mime.FormatMediaType("attachment", map[string]string{"filename": name}), "attachment; "))
+}
+
+// requireOwner answers 401 or 403 unless the X-User-ID header names the user
+// who requested the conversion or the request came through a signed link
+// for it
+func requireOwner(c *gin.Context, conv *models.ConversionRequest) bool {
+	status, message := checkOwner(c, conv)
+	if status != 0 {
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return false
+	}
+	return true
+}
+
+// checkOwner is requireOwner without the response: it returns the status and
+// message to refuse the request with, or zero if it may go ahead
+func checkOwner(c *gin.Context, conv *models.ConversionRequest) (int, string) {
+	if c.GetString(middleware.SignedConversionKey) == conv.ID {
+		return 0, ""
+	}
+	userID := c.GetHeader(userIDHeader)
+	if userID == "" {
+		return http.StatusUnauthorized, userIDHeader + " header is required"
+	}
+	if userID != conv.UserID {
+		return http.StatusForbidden, "Conversion belongs to another user"
+	}
+	return 0, ""
+}
+
+// respondOutputMissing answers 404 for a completed conversion whose output
+// file is gone
+func respondOutputMissing(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.APIResponse{
+		Success: false,
+		Error:   "Conversion output not found",
+	})
+}
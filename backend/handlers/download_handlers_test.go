@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/utils"
+)
+
+// newConversion requests a png-to-jpg conversion of the photo for userID
+func (s *testServer) newConversion(t *testing.T, userID string) string {
+	t.Helper()
+	w, resp := s.requestConversion(t, map[string]interface{}{
+		"user_id": userID, "exam_id": "jee-main", "document_id": "photo",
+		"file_name": "photo.png", "file_size": 1024, "tool_id": "png-to-jpg",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("request conversion = %d %+v", w.Code, resp)
+	}
+	var created models.ConversionResponse
+	decodeData(t, resp, &created)
+	return created.ID
+}
+
+// completeConversion stores output as a conversion's JPEG output and marks
+// it completed, as the worker would
+func (s *testServer) completeConversion(t *testing.T, id string, output []byte) *models.ConversionRequest {
+	t.Helper()
+	ctx := context.Background()
+	conv, err := s.store.GetConversionByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := convert.OutputKey(*conv, "jpg")
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(output), int64(len(output))); err != nil {
+		t.Fatal(err)
+	}
+	conv.Status = utils.StatusCompleted
+	conv.OutputPath = key
+	conv.OutputSize = int64(len(output))
+	saved, err := s.store.SaveConversion(ctx, *conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+func TestUploadRejections(t *testing.T) {
+	s := newTestServer(t)
+	id := s.newConversion(t, "alice")
+	tests := []struct {
+		name   string
+		id     string
+		user   string
+		file   string
+		data   []byte
+		status int
+	}{
+		{"unknown conversion", "unknown", "alice", "photo.png", testPNG(t), http.StatusNotFound},
+		{"no user", id, "", "photo.png", testPNG(t), http.StatusUnauthorized},
+		{"another user", id, "bob", "photo.png", testPNG(t), http.StatusForbidden},
+		{"disallowed extension", id, "alice", "photo.exe", testPNG(t), http.StatusBadRequest},
+		{"input the tool does not take", id, "alice", "photo.jpg", testPNG(t), http.StatusUnsupportedMediaType},
+		{"content not matching the extension", id, "alice", "photo.png", []byte("GIF89a not a png at all"), http.StatusUnsupportedMediaType},
+		{"empty file", id, "alice", "photo.png", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w, resp := s.upload(t, tt.id, tt.user, tt.file, tt.data); w.Code != tt.status || resp.Success {
+			t.Errorf("%s: %d %+v; want %d", tt.name, w.Code, resp, tt.status)
+		}
+	}
+	if conv, _ := s.store.GetConversionByID(context.Background(), id); conv.InputPath != "" || conv.Status != utils.StatusPending {
+		t.Errorf("rejected uploads changed the conversion: %+v", conv)
+	}
+	if len(s.jobs.ids) != 0 {
+		t.Errorf("rejected uploads enqueued %v", s.jobs.ids)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	s := newTestServer(t)
+	id := s.newConversion(t, "alice")
+	url := "/api/conversions/" + id + "/download"
+	output := append([]byte{0xff, 0xd8, 0xff, 0xe0}, "rest of the jpeg"...)
+
+	if w, _ := s.get(t, "/api/conversions/unknown/download", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("download of an unknown conversion = %d; want 404", w.Code)
+	}
+	conv := s.completeConversion(t, id, output)
+	if w, _ := s.get(t, url, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("download without %s = %d; want 401", userIDHeader, w.Code)
+	}
+	if w, _ := s.get(t, url, "bob"); w.Code != http.StatusForbidden {
+		t.Errorf("download by another user = %d; want 403", w.Code)
+	}
+
+	w, _ := s.get(t, url, "alice")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), output) || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("download = %d %q (%s); want the JPEG", w.Code, w.Body, w.Header().Get("Content-Type"))
+	}
+	etag := w.Header().Get("ETag")
+
+	// Interrupted downloads resume with a range, and cached ones revalidate
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set(userIDHeader, "alice")
+	req.Header.Set("Range", "bytes=4-")
+	if w, _ := s.do(t, req); w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), output[4:]) {
+		t.Errorf("ranged download = %d %q; want 206 with the rest", w.Code, w.Body)
+	}
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set(userIDHeader, "alice")
+	req.Header.Set("If-None-Match", etag)
+	if w, _ := s.do(t, req); w.Code != http.StatusNotModified {
+		t.Errorf("download with a matching ETag = %d; want 304", w.Code)
+	}
+
+	// The output file has gone
+	if err := s.blobs.Delete(context.Background(), conv.OutputPath); err != nil {
+		t.Fatal(err)
+	}
+	if w, resp := s.get(t, url, "alice"); w.Code != http.StatusNotFound || resp.Error != "Conversion output not found" {
+		t.Errorf("download of a missing output = %d %+v; want 404", w.Code, resp)
+	}
+}
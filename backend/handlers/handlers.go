@@ -1,20 +1,30 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/check"
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/convert"
 	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/scan"
+	"github.com/oneforall/backend/signedurl"
 	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
 )
 
 // ExamHandler handles exam-related requests
 type ExamHandler struct {
-	store *storage.JSONStorage
+	store storage.Store
 }
 
 // NewExamHandler creates a new exam handler
-func NewExamHandler(store *storage.JSONStorage) *ExamHandler {
+func NewExamHandler(store storage.Store) *ExamHandler {
 	return &ExamHandler{store: store}
 }
 
@@ -27,7 +37,7 @@ func NewExamHandler(store *storage.JSONStorage) *ExamHandler {
 // @Success 200 {object} models.APIResponse
 // @Router /api/exams [get]
 func (h *ExamHandler) GetAllExams(c *gin.Context) {
-	exams, err := h.store.GetExams()
+	exams, err := h.store.GetExams(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -56,12 +66,9 @@ func (h *ExamHandler) GetAllExams(c *gin.Context) {
 func (h *ExamHandler) GetExamByID(c *gin.Context) {
 	examID := c.Param("id")
 
-	exam, err := h.store.GetExamByID(examID)
+	exam, err := h.store.GetExamByID(c.Request.Context(), examID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Error:   "Exam not found",
-		})
+		respondLookupError(c, err, "Exam not found")
 		return
 	}
 
@@ -74,24 +81,26 @@ func (h *ExamHandler) GetExamByID(c *gin.Context) {
 
 // ToolHandler handles tool-related requests
 type ToolHandler struct {
-	store *storage.JSONStorage
+	store    storage.Store
+	registry *convert.Registry
 }
 
-// NewToolHandler creates a new tool handler
-func NewToolHandler(store *storage.JSONStorage) *ToolHandler {
-	return &ToolHandler{store: store}
+// NewToolHandler creates a new tool handler. Only tools with a converter in
+// registry are listed.
+func NewToolHandler(store storage.Store, registry *convert.Registry) *ToolHandler {
+	return &ToolHandler{store: store, registry: registry}
 }
 
 // GetAllTools returns all available tools
 // @Summary Get all tools
-// @Description Get a list of all file conversion tools
+// @Description Get a list of all enabled file conversion tools that have a converter
 // @Tags tools
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.APIResponse
 // @Router /api/tools [get]
 func (h *ToolHandler) GetAllTools(c *gin.Context) {
-	tools, err := h.store.GetTools()
+	tools, err := h.store.GetTools(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -103,23 +112,93 @@ func (h *ToolHandler) GetAllTools(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Tools retrieved successfully",
-		Data:    tools,
+		Data:    availableTools(enabledTools(tools), h.registry),
 	})
 }
 
+// enabledTools drops disabled categories and tools
+func enabledTools(categories []models.ToolCategory) []models.ToolCategory {
+	enabled := make([]models.ToolCategory, 0, len(categories))
+	for _, cat := range categories {
+		if cat.Disabled {
+			continue
+		}
+		tools := make([]models.Tool, 0, len(cat.Tools))
+		for _, tool := range cat.Tools {
+			if !tool.Disabled {
+				tools = append(tools, tool)
+			}
+		}
+		cat.Tools = tools
+		enabled = append(enabled, cat)
+	}
+	return enabled
+}
+
+// availableTools drops tools that have no converter, and categories left
+// without tools
+func availableTools(categories []models.ToolCategory, registry *convert.Registry) []models.ToolCategory {
+	available := make([]models.ToolCategory, 0, len(categories))
+	for _, cat := range categories {
+		tools := make([]models.Tool, 0, len(cat.Tools))
+		for _, tool := range cat.Tools {
+			if registry.Supports(tool.ID) {
+				tools = append(tools, tool)
+			}
+		}
+		if len(tools) == 0 {
+			continue
+		}
+		cat.Tools = tools
+		available = append(available, cat)
+	}
+	return available
+}
+
 // ConversionHandler handles file conversion requests
 type ConversionHandler struct {
-	store *storage.JSONStorage
+	store       storage.Store
+	blobs       blob.Store
+	spoolDir    string
+	maxFileSize int64
+	signer      *signedurl.Signer
+	linkExpiry  time.Duration
+	scanner     scan.Scanner
+	jobs        JobQueue
+	registry    *convert.Registry
+	checker     *check.Checker
 }
 
-// NewConversionHandler creates a new conversion handler
-func NewConversionHandler(store *storage.JSONStorage) *ConversionHandler {
-	return &ConversionHandler{store: store}
+// JobQueue takes conversions whose input is ready to be converted
+type JobQueue interface {
+	// Enqueue queues a pending conversion without blocking. It reports
+	// false if it could not; the conversion is then picked up later.
+	Enqueue(conversionID string) bool
+}
+
+// NewConversionHandler creates a new conversion handler. Uploads are
+// spooled to local disk for validation and scanning before they go to
+// blobs; scanner may be nil to skip malware scanning, and jobs may be nil
+// if conversions are processed elsewhere. Only tools with a converter in
+// registry can be requested.
+func NewConversionHandler(store storage.Store, blobs blob.Store, scanner scan.Scanner, jobs JobQueue, registry *convert.Registry, cfg *config.Config) *ConversionHandler {
+	return &ConversionHandler{
+		store:       store,
+		blobs:       blobs,
+		spoolDir:    filepath.Join(cfg.UploadDirectory, "tmp"),
+		maxFileSize: cfg.MaxFileSize,
+		signer:      signedURLSigner(cfg.URLSigningSecret),
+		linkExpiry:  cfg.SignedURLExpiry,
+		scanner:     scanner,
+		jobs:        jobs,
+		registry:    registry,
+		checker:     check.New(registry),
+	}
 }
 
 // RequestConversion creates a new file conversion request
 // @Summary Request file conversion
-// @Description Create a new file conversion request
+// @Description Create a new file conversion request. tool_id and options are optional and select the conversion to run; tool_id must be a tool listed by GET /api/tools that accepts the format of file_name; an input already converted the same way is completed as soon as it is uploaded.
 // @Tags conversions
 // @Accept json
 // @Produce json
@@ -129,11 +208,13 @@ func NewConversionHandler(store *storage.JSONStorage) *ConversionHandler {
 // @Router /api/conversions/request [post]
 func (h *ConversionHandler) RequestConversion(c *gin.Context) {
 	var req struct {
-		UserID     string `json:"user_id" binding:"required"`
-		ExamID     string `json:"exam_id" binding:"required"`
-		DocumentID string `json:"document_id" binding:"required"`
-		FileName   string `json:"file_name" binding:"required"`
-		FileSize   int64  `json:"file_size" binding:"required"`
+		UserID     string            `json:"user_id" binding:"required"`
+		ExamID     string            `json:"exam_id" binding:"required"`
+		DocumentID string            `json:"document_id" binding:"required"`
+		FileName   string            `json:"file_name" binding:"required"`
+		FileSize   int64             `json:"file_size" binding:"required"`
+		ToolID     string            `json:"tool_id"`
+		Options    map[string]string `json:"options"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -144,7 +225,70 @@ func (h *ConversionHandler) RequestConversion(c *gin.Context) {
 		return
 	}
 
-	conv, err := h.store.CreateConversion(req.UserID, req.ExamID, req.DocumentID, req.FileName, req.FileSize)
+	exam, err := h.store.GetExamByID(c.Request.Context(), req.ExamID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Unknown exam: " + req.ExamID,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	doc := findDocument(exam, req.DocumentID)
+	if doc == nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Unknown document for exam " + req.ExamID + ": " + req.DocumentID,
+		})
+		return
+	}
+
+	if req.ToolID != "" {
+		tools, err := h.store.GetTools(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if findEnabledTool(tools, req.ToolID) == nil {
+			respondBadRequest(c, "Unknown tool: "+req.ToolID)
+			return
+		}
+		reg, ok := h.registry.Lookup(req.ToolID)
+		if !ok {
+			respondBadRequest(c, "Tool not available: "+req.ToolID)
+			return
+		}
+		if err := reg.CheckInput(req.ToolID, convert.FormatOf(req.FileName)); err != nil {
+			respondBadRequest(c, err.Error())
+			return
+		}
+		options, err := reg.RequestOptions(req.Options, *doc)
+		if err != nil {
+			respondBadRequest(c, err.Error())
+			return
+		}
+		req.Options = options
+	}
+
+	conv, err := h.store.CreateConversion(c.Request.Context(), models.ConversionRequest{
+		UserID:       req.UserID,
+		ExamID:       req.ExamID,
+		DocumentID:   req.DocumentID,
+		ExamRevision: exam.Revision,
+		FileName:     req.FileName,
+		FileSize:     req.FileSize,
+		ToolID:       req.ToolID,
+		Options:      req.Options,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -178,27 +322,60 @@ func (h *ConversionHandler) RequestConversion(c *gin.Context) {
 func (h *ConversionHandler) GetConversionStatus(c *gin.Context) {
 	conversionID := c.Param("id")
 
-	conv, err := h.store.GetConversionByID(conversionID)
+	conv, err := h.store.GetConversionByID(c.Request.Context(), conversionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.APIResponse{
-			Success: false,
-			Error:   "Conversion not found",
-		})
+		respondLookupError(c, err, "Conversion not found")
 		return
 	}
 
+	resp := models.ConversionResponse{
+		ID:        conv.ID,
+		Status:    conv.Status,
+		InputFile: conv.FileName,
+		Progress:  conversionProgress(conv.Status),
+		Message:   conversionMessage(conv),
+	}
+	if conv.OutputPath != "" {
+		resp.OutputFile = outputName(conv)
+		resp.OutputSize = conv.OutputSize
+		resp.OutputSettings = conv.OutputSettings
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Conversion status retrieved",
-		Data: models.ConversionResponse{
-			ID:        conv.ID,
-			Status:    conv.Status,
-			InputFile: conv.FileName,
-			Message:   "Conversion in progress",
-		},
+		Data:    resp,
 	})
 }
 
+// conversionProgress is a coarse percentage for a conversion's status
+func conversionProgress(status string) int {
+	switch status {
+	case utils.StatusProcessing:
+		return 50
+	case utils.StatusCompleted, utils.StatusFailed, utils.StatusQuarantined:
+		return 100
+	default:
+		return 0
+	}
+}
+
+// conversionMessage describes where a conversion is up to
+func conversionMessage(conv *models.ConversionRequest) string {
+	switch {
+	case conv.ErrorMsg != "":
+		return conv.ErrorMsg
+	case conv.Status == utils.StatusPending && conv.InputPath == "":
+		return "Awaiting upload"
+	case conv.Status == utils.StatusPending:
+		return "Queued for conversion"
+	case conv.Status == utils.StatusCompleted:
+		return "Conversion completed"
+	default:
+		return "Conversion in progress"
+	}
+}
+
 // GetUserConversions retrieves all conversions for a user
 // @Summary Get user conversions
 // @Description Get all conversion requests for a specific user
@@ -211,7 +388,7 @@ func (h *ConversionHandler) GetConversionStatus(c *gin.Context) {
 func (h *ConversionHandler) GetUserConversions(c *gin.Context) {
 	userID := c.Param("user_id")
 
-	conversions, err := h.store.GetUserConversions(userID)
+	conversions, err := h.store.GetUserConversions(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -227,6 +404,72 @@ func (h *ConversionHandler) GetUserConversions(c *gin.Context) {
 	})
 }
 
+// findEnabledTool returns the enabled tool with the given ID, or nil
+func findEnabledTool(categories []models.ToolCategory, toolID string) *models.Tool {
+	for _, cat := range enabledTools(categories) {
+		for i := range cat.Tools {
+			if cat.Tools[i].ID == toolID {
+				return &cat.Tools[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findDocument returns the document with the given ID, or nil
+func findDocument(exam *models.Exam, documentID string) *models.Document {
+	for i := range exam.Documents {
+		if exam.Documents[i].ID == documentID {
+			return &exam.Documents[i]
+		}
+	}
+	return nil
+}
+
+// respondLookupError answers 404 for missing records and 500 for anything else
+func respondLookupError(c *gin.Context, err error, notFoundMsg string) {
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   notFoundMsg,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// respondStoreError maps storage errors from write operations to HTTP statuses
+func respondStoreError(c *gin.Context, err error) {
+	c.JSON(storeErrorStatus(err), models.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// storeErrorStatus is the HTTP status for a storage error
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrConflict), errors.Is(err, storage.ErrStale):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// respondBadRequest answers 400 with the given message
+func respondBadRequest(c *gin.Context, msg string) {
+	c.JSON(http.StatusBadRequest, models.APIResponse{
+		Success: false,
+		Error:   msg,
+	})
+}
+
 // HealthCheck endpoint
 func HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
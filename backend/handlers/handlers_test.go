@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/middleware"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/scan"
+	"github.com/oneforall/backend/signedurl"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// recordingQueue is a JobQueue that remembers what was enqueued
+type recordingQueue struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (q *recordingQueue) Enqueue(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ids = append(q.ids, id)
+	return true
+}
+
+// testSigningSecret signs the test server's links
+const testSigningSecret = "test-secret"
+
+// testServer holds a router serving the exam, tool and conversion routes
+// over a MemoryStorage
+type testServer struct {
+	router *gin.Engine
+	store  *storage.MemoryStorage
+	blobs  blob.Store
+	jobs   *recordingQueue
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newScanningTestServer(t, nil)
+}
+
+// newScanningTestServer is newTestServer with uploads scanned by scanner
+func newScanningTestServer(t *testing.T, scanner scan.Scanner) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	registry, err := convert.Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemoryStorage([]models.Exam{{
+		ID:    "jee-main",
+		Title: "JEE Main",
+		Documents: []models.Document{
+			{ID: "photo", Name: "Photo", Format: "JPG, PNG", MaxSize: 51200, Required: true},
+			{ID: "admit-card", Name: "Admit Card", Format: "PDF", MaxSize: 512000},
+		},
+	}}, []models.ToolCategory{{
+		ID:       "image",
+		Category: "Image Tools",
+		Tools: []models.Tool{
+			{ID: "png-to-jpg", Name: "PNG to JPG"},
+			{ID: "compress-image", Name: "Compress"},
+			{ID: "jpg-to-png", Name: "JPG to PNG", Disabled: true},
+			{ID: "heic-to-jpg", Name: "HEIC to JPG"},
+		},
+	}})
+	if err := store.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	blobs := blob.NewLocal(dir)
+	jobs := &recordingQueue{}
+	cfg := &config.Config{UploadDirectory: dir, MaxFileSize: 10 << 20, URLSigningSecret: testSigningSecret, SignedURLExpiry: time.Minute}
+	conv := NewConversionHandler(store, blobs, scanner, jobs, registry, cfg)
+	exams := NewExamHandler(store)
+	tools := NewToolHandler(store, registry)
+
+	router := gin.New()
+	router.GET("/api/exams", exams.GetAllExams)
+	router.GET("/api/exams/:id", exams.GetExamByID)
+	router.GET("/api/tools", tools.GetAllTools)
+	router.POST("/api/conversions/request", conv.RequestConversion)
+	router.GET("/api/conversions/:id", conv.GetConversionStatus)
+	router.POST("/api/conversions/:id/upload", conv.UploadFile)
+	router.GET("/api/conversions/:id/download", conv.DownloadOutput)
+	router.POST("/api/conversions/:id/links", conv.CreateSignedLink)
+	router.POST("/api/signed/conversions/:id/upload", middleware.SignedURL(testSigningSecret, signedurl.ScopeUpload), conv.UploadFile)
+	router.GET("/api/signed/conversions/:id/download", middleware.SignedURL(testSigningSecret, signedurl.ScopeDownload), conv.DownloadOutput)
+	router.GET("/api/conversions/user/:user_id", conv.GetUserConversions)
+	router.POST("/api/exams/:id/kits", conv.CreateKit)
+	router.GET("/api/kits/:id", conv.GetKit)
+	router.GET("/api/kits/:id/download", conv.DownloadKit)
+	return &testServer{router: router, store: store, blobs: blobs, jobs: jobs}
+}
+
+// do sends a request and decodes the APIResponse, with its Data left as
+// raw JSON for the caller to decode
+func (s *testServer) do(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	var resp apiResponse
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", req.Method, req.URL, err)
+		}
+	}
+	return w, resp
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+func (s *testServer) get(t *testing.T, url, userID string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if userID != "" {
+		req.Header.Set(userIDHeader, userID)
+	}
+	return s.do(t, req)
+}
+
+func (s *testServer) requestConversion(t *testing.T, body map[string]interface{}) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/conversions/request", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(t, req)
+}
+
+func (s *testServer) upload(t *testing.T, id, userID, name string, data []byte) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", name)
+	part.Write(data)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/conversions/"+id+"/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if userID != "" {
+		req.Header.Set(userIDHeader, userID)
+	}
+	return s.do(t, req)
+}
+
+// testPNG encodes a small opaque PNG
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 8), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeData(t *testing.T, resp apiResponse, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		t.Fatalf("decoding data %s: %v", resp.Data, err)
+	}
+}
+
+func TestExamHandlers(t *testing.T) {
+	s := newTestServer(t)
+
+	w, resp := s.get(t, "/api/exams", "")
+	if w.Code != http.StatusOK || !resp.Success {
+		t.Fatalf("GET /api/exams = %d %+v", w.Code, resp)
+	}
+	var exams []models.Exam
+	decodeData(t, resp, &exams)
+	if len(exams) != 1 || exams[0].ID != "jee-main" {
+		t.Errorf("exams = %+v; want jee-main", exams)
+	}
+
+	w, resp = s.get(t, "/api/exams/jee-main", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/exams/jee-main = %d %+v", w.Code, resp)
+	}
+	var exam models.Exam
+	decodeData(t, resp, &exam)
+	if len(exam.Documents) != 2 || exam.Revision != 1 {
+		t.Errorf("exam = %+v; want two documents at revision 1", exam)
+	}
+
+	if w, resp := s.get(t, "/api/exams/unknown", ""); w.Code != http.StatusNotFound || resp.Success {
+		t.Errorf("GET unknown exam = %d %+v; want 404", w.Code, resp)
+	}
+}
+
+func TestToolHandlers(t *testing.T) {
+	s := newTestServer(t)
+
+	w, resp := s.get(t, "/api/tools", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/tools = %d %+v", w.Code, resp)
+	}
+	var categories []models.ToolCategory
+	decodeData(t, resp, &categories)
+	var ids []string
+	for _, cat := range categories {
+		for _, tool := range cat.Tools {
+			ids = append(ids, tool.ID)
+		}
+	}
+	// The disabled tool and the one without a converter are left out
+	if got := strings.Join(ids, ","); got != "png-to-jpg,compress-image" {
+		t.Errorf("tools = %s; want png-to-jpg,compress-image", got)
+	}
+}
+
+func TestRequestConversionValidation(t *testing.T) {
+	s := newTestServer(t)
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"user_id": "alice", "exam_id": "jee-main", "document_id": "photo",
+			"file_name": "photo.png", "file_size": 1024, "tool_id": "png-to-jpg",
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(map[string]interface{})
+		error  string
+	}{
+		{"missing field", func(b map[string]interface{}) { delete(b, "user_id") }, "UserID"},
+		{"unknown exam", func(b map[string]interface{}) { b["exam_id"] = "neet" }, "Unknown exam"},
+		{"unknown document", func(b map[string]interface{}) { b["document_id"] = "thumb" }, "Unknown document"},
+		{"disabled tool", func(b map[string]interface{}) { b["tool_id"] = "jpg-to-png" }, "Unknown tool"},
+		{"tool without converter", func(b map[string]interface{}) { b["tool_id"] = "heic-to-jpg" }, "not available"},
+		{"wrong input format", func(b map[string]interface{}) { b["file_name"] = "photo.jpg" }, "does not accept jpg"},
+		{"invalid option", func(b map[string]interface{}) { b["options"] = map[string]string{"quality": "high"} }, "quality"},
+	}
+	for _, tt := range tests {
+		body := valid()
+		tt.change(body)
+		w, resp := s.requestConversion(t, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(resp.Error, tt.error) {
+			t.Errorf("%s: %d %q; want 400 mentioning %q", tt.name, w.Code, resp.Error, tt.error)
+		}
+	}
+
+	w, resp := s.requestConversion(t, valid())
+	if w.Code != http.StatusCreated || !resp.Success {
+		t.Fatalf("valid request = %d %+v; want 201", w.Code, resp)
+	}
+	var created models.ConversionResponse
+	decodeData(t, resp, &created)
+	if created.ID == "" || created.Status != utils.StatusPending {
+		t.Errorf("created = %+v; want a pending conversion", created)
+	}
+}
+
+func TestConversionLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	data := testPNG(t)
+
+	_, resp := s.requestConversion(t, map[string]interface{}{
+		"user_id": "alice", "exam_id": "jee-main", "document_id": "photo",
+		"file_name": "photo.png", "file_size": len(data), "tool_id": "png-to-jpg",
+	})
+	var created models.ConversionResponse
+	decodeData(t, resp, &created)
+	id := created.ID
+
+	if w, _ := s.upload(t, id, "", "photo.png", data); w.Code != http.StatusUnauthorized {
+		t.Errorf("upload without %s = %d; want 401", userIDHeader, w.Code)
+	}
+	if w, _ := s.upload(t, id, "bob", "photo.png", data); w.Code != http.StatusForbidden {
+		t.Errorf("upload by another user = %d; want 403", w.Code)
+	}
+	if w, _ := s.upload(t, id, "alice", "scan.pdf", []byte("%PDF-1.4\n")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("upload of a PDF to png-to-jpg = %d; want 415", w.Code)
+	}
+	if w, _ := s.get(t, "/api/conversions/"+id+"/download", "alice"); w.Code != http.StatusConflict {
+		t.Errorf("download before conversion = %d; want 409", w.Code)
+	}
+
+	w, resp := s.upload(t, id, "alice", "photo.png", data)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload = %d %+v; want 201", w.Code, resp)
+	}
+	var uploaded models.UploadResponse
+	decodeData(t, resp, &uploaded)
+	if uploaded.ContentType != "image/png" || uploaded.FileSize != int64(len(data)) || uploaded.InputHash == "" {
+		t.Errorf("upload = %+v; want the PNG's type, size and hash", uploaded)
+	}
+	if len(s.jobs.ids) != 1 || s.jobs.ids[0] != id {
+		t.Errorf("enqueued %v; want %s", s.jobs.ids, id)
+	}
+
+	// Complete the conversion as the worker would
+	ctx := context.Background()
+	conv, err := s.store.GetConversionByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := []byte("converted output")
+	key := convert.OutputKey(*conv, "jpg")
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(output), int64(len(output))); err != nil {
+		t.Fatal(err)
+	}
+	conv.Status = utils.StatusCompleted
+	conv.OutputPath = key
+	conv.OutputSize = int64(len(output))
+	if _, err := s.store.SaveConversion(ctx, *conv); err != nil {
+		t.Fatal(err)
+	}
+
+	w, resp = s.get(t, "/api/conversions/"+id, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d %+v", w.Code, resp)
+	}
+	var status models.ConversionResponse
+	decodeData(t, resp, &status)
+	if status.Status != utils.StatusCompleted || status.OutputFile != "photo.jpg" || status.Progress != 100 {
+		t.Errorf("status = %+v; want completed photo.jpg at 100%%", status)
+	}
+
+	if w, _ := s.upload(t, id, "alice", "photo.png", data); w.Code != http.StatusConflict {
+		t.Errorf("upload after completion = %d; want 409", w.Code)
+	}
+	w, _ = s.get(t, "/api/conversions/"+id+"/download", "alice")
+	if w.Code != http.StatusOK || w.Body.String() != string(output) {
+		t.Errorf("download = %d %q; want 200 with the output", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "photo.jpg") {
+		t.Errorf("Content-Disposition = %q; want photo.jpg", got)
+	}
+
+	w, resp = s.get(t, "/api/conversions/user/alice", "")
+	var mine []models.ConversionRequest
+	decodeData(t, resp, &mine)
+	if w.Code != http.StatusOK || len(mine) != 1 || mine[0].ID != id {
+		t.Errorf("user conversions = %d %+v; want just %s", w.Code, mine, id)
+	}
+	if w, _ := s.get(t, "/api/conversions/unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown conversion = %d; want 404", w.Code)
+	}
+}
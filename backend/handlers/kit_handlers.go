@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/check"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// kitManifestName is the name of the manifest in a kit's ZIP
+const kitManifestName = "manifest.json"
+
+// kitFile is a file uploaded for one document of a kit
+type kitFile struct {
+	doc  *models.Document
+	path string
+	size int64
+}
+
+// CreateKit prepares several documents of an exam at once
+// @Summary Create an exam kit
+// @Description Upload files for several documents of an exam in one multipart request, each file part named after its document ID. Each file is converted, compressed or kept as it is to meet its document's requirements, as one conversion per document; GET /api/kits/:id follows them and lists the required documents still missing. Files that no available tool can fix are recorded as failed with the fixes needed.
+// @Tags kits
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param X-User-ID header string true "User preparing the kit"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 413 {object} models.APIResponse
+// @Router /api/exams/:id/kits [post]
+func (h *ConversionHandler) CreateKit(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetHeader(userIDHeader)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   userIDHeader + " header is required",
+		})
+		return
+	}
+	exam, err := h.store.GetExamByID(ctx, c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Exam not found")
+		return
+	}
+
+	// Files are converted or compressed as needed, so each may be as large
+	// as the server allows rather than its document's limit
+	limit := int64(len(exam.Documents))*(h.maxFileSize+multipartOverhead) + multipartOverhead
+	if c.Request.ContentLength > limit {
+		respondTooLarge(c, h.maxFileSize)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	files, err := h.spoolKitFiles(c.Request, exam)
+	for _, f := range files {
+		defer os.Remove(f.path)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errFileTooLarge) || isMaxBytesError(err):
+			respondTooLarge(c, h.maxFileSize)
+		case errors.Is(err, errBadKit):
+			respondBadRequest(c, err.Error())
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to store upload: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	tools, err := availableToolIDs(ctx, h.store, h.registry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	kitID := uuid.New().String()
+	for _, f := range files {
+		if err := h.addKitDocument(ctx, kitID, userID, exam, f, tools); err != nil {
+			h.abandonKit(ctx, kitID, err)
+			respondStoreError(c, err)
+			return
+		}
+	}
+
+	kit, err := h.kitStatus(ctx, kitID)
+	if err != nil {
+		respondLookupError(c, err, "Kit not found")
+		return
+	}
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Exam kit created",
+		Data:    kit,
+	})
+}
+
+// errBadKit is returned for kit uploads that do not name documents properly
+var errBadKit = errors.New("invalid kit upload")
+
+// spoolKitFiles spools each file part of a kit upload to disk. Parts must be
+// named after distinct documents of exam. The files spooled are returned
+// even on error, for the caller to remove.
+func (h *ConversionHandler) spoolKitFiles(r *http.Request, exam *models.Exam) ([]kitFile, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: expected a multipart/form-data upload: %v", errBadKit, err)
+	}
+	var files []kitFile
+	seen := map[string]bool{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, err
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		doc := findDocument(exam, part.FormName())
+		if doc == nil {
+			part.Close()
+			return files, fmt.Errorf("%w: unknown document for exam %s: %q", errBadKit, exam.ID, part.FormName())
+		}
+		if seen[doc.ID] {
+			part.Close()
+			return files, fmt.Errorf("%w: more than one file for document %s", errBadKit, doc.ID)
+		}
+		seen[doc.ID] = true
+
+		path, size, err := spoolUpload(part, h.spoolDir, h.maxFileSize)
+		part.Close()
+		if path != "" {
+			files = append(files, kitFile{doc: doc, path: path, size: size})
+		}
+		if err != nil {
+			if size == 0 && !errors.Is(err, errFileTooLarge) && !isMaxBytesError(err) {
+				return files, fmt.Errorf("%w: file for document %s is empty", errBadKit, doc.ID)
+			}
+			return files, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files given; name each file part after a document ID", errBadKit)
+	}
+	return files, nil
+}
+
+// addKitDocument creates the conversion preparing one file of a kit. The
+// file is named after its document and queued with the tool that makes it
+// meet the document's requirements, or with no tool to be kept as it is.
+// A file that cannot be fixed is recorded as failed with the fixes needed.
+func (h *ConversionHandler) addKitDocument(ctx context.Context, kitID, userID string, exam *models.Exam, f kitFile, tools []string) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	plan, planErr := h.checker.Plan(*f.doc, check.File{Data: file, Size: f.size}, tools)
+	file.Close()
+
+	name := f.doc.ID
+	if plan.Report.Format != "" {
+		name += "." + plan.Report.Format
+	}
+	conv := models.ConversionRequest{
+		UserID:       userID,
+		ExamID:       exam.ID,
+		DocumentID:   f.doc.ID,
+		ExamRevision: exam.Revision,
+		FileName:     name,
+		FileSize:     f.size,
+		ToolID:       plan.ToolID,
+		KitID:        kitID,
+	}
+	if planErr == nil && plan.ToolID != "" {
+		reg, _ := h.registry.Lookup(plan.ToolID)
+		conv.Options, planErr = reg.RequestOptions(nil, *f.doc)
+	}
+	created, err := h.store.CreateConversion(ctx, conv)
+	if err != nil {
+		return err
+	}
+	if planErr != nil {
+		return h.failKitDocument(ctx, created, planErr)
+	}
+	if _, err := h.attachInput(ctx, created, f.path, name, f.size); err != nil {
+		if errors.Is(err, errInfected) {
+			// attachInput has quarantined the conversion
+			return nil
+		}
+		log.Printf("⚠ Failed to attach kit file for %s: %v", created.ID, err)
+		return h.failKitDocument(ctx, created, err)
+	}
+	return nil
+}
+
+// failKitDocument records why a kit document could not be prepared
+func (h *ConversionHandler) failKitDocument(ctx context.Context, conv *models.ConversionRequest, cause error) error {
+	conv.Status = utils.StatusFailed
+	conv.ErrorMsg = cause.Error()
+	_, err := h.store.SaveConversion(ctx, *conv)
+	return err
+}
+
+// abandonKit marks the conversions already created for a kit failed, when
+// the kit could not be created in full, so none is left pending or queued
+func (h *ConversionHandler) abandonKit(ctx context.Context, kitID string, cause error) {
+	// The client may have gone; record the failures regardless
+	ctx = context.WithoutCancel(ctx)
+	conversions, err := h.store.GetKitConversions(ctx, kitID)
+	if err != nil {
+		log.Printf("⚠ Failed to abandon kit %s: %v", kitID, err)
+		return
+	}
+	for _, conv := range conversions {
+		if conv.Status == utils.StatusFailed {
+			continue
+		}
+		if err := h.store.UpdateConversion(ctx, conv.ID, utils.StatusFailed, "Kit creation failed: "+cause.Error()); err != nil {
+			log.Printf("⚠ Failed to abandon kit conversion %s: %v", conv.ID, err)
+		}
+	}
+}
+
+// GetKit returns the progress of an exam kit
+// @Summary Get exam kit status
+// @Description Get the documents of an exam kit with the status of each conversion, and the required documents the kit has no file for. The kit is completed once every conversion has finished. The X-User-ID header must name the user who created the kit.
+// @Tags kits
+// @Produce json
+// @Param id path string true "Kit ID"
+// @Param X-User-ID header string true "User who created the kit"
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/kits/:id [get]
+func (h *ConversionHandler) GetKit(c *gin.Context) {
+	kit, err := h.kitStatus(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Kit not found")
+		return
+	}
+	if !requireKitOwner(c, kit) {
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Kit retrieved successfully",
+		Data:    kit,
+	})
+}
+
+// kitStatus builds a kit from its conversions and its exam's documents
+func (h *ConversionHandler) kitStatus(ctx context.Context, kitID string) (*models.Kit, error) {
+	conversions, err := h.store.GetKitConversions(ctx, kitID)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversions) == 0 {
+		return nil, fmt.Errorf("kit %s: %w", kitID, storage.ErrNotFound)
+	}
+	first := conversions[0]
+	kit := &models.Kit{
+		ID:        kitID,
+		UserID:    first.UserID,
+		ExamID:    first.ExamID,
+		Status:    utils.StatusCompleted,
+		Documents: []models.KitDocument{},
+		Missing:   []models.Document{},
+		CreatedAt: first.CreatedAt,
+	}
+	// The exam may have been deleted since; the kit is still shown
+	exam, err := h.store.GetExamByID(ctx, first.ExamID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	prepared := map[string]bool{}
+	for _, conv := range conversions {
+		doc := models.KitDocument{
+			DocumentID:   conv.DocumentID,
+			ConversionID: conv.ID,
+			ToolID:       conv.ToolID,
+			Status:       conv.Status,
+			Error:        conv.ErrorMsg,
+			OutputSize:   conv.OutputSize,
+		}
+		if exam != nil {
+			if d := findDocument(exam, conv.DocumentID); d != nil {
+				doc.DocumentName = d.Name
+			}
+		}
+		if conv.OutputPath != "" {
+			// A reused output keeps the name it was converted under, so
+			// name the file after its document and the output's format
+			doc.FileName = conv.DocumentID + path.Ext(conv.OutputPath)
+		}
+		switch conv.Status {
+		case utils.StatusPending, utils.StatusProcessing:
+			kit.Status = utils.StatusProcessing
+		case utils.StatusCompleted:
+			prepared[conv.DocumentID] = true
+		}
+		kit.Documents = append(kit.Documents, doc)
+	}
+
+	kit.Complete = true
+	if exam != nil {
+		for _, doc := range exam.Documents {
+			if !doc.Required {
+				continue
+			}
+			if !prepared[doc.ID] {
+				kit.Complete = false
+			}
+			if !hasKitDocument(kit, doc.ID) {
+				kit.Missing = append(kit.Missing, doc)
+			}
+		}
+	}
+	return kit, nil
+}
+
+// hasKitDocument reports whether the kit has a file for a document, prepared
+// or not
+func hasKitDocument(kit *models.Kit, documentID string) bool {
+	for _, doc := range kit.Documents {
+		if doc.DocumentID == documentID {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadKit serves an exam kit as a ZIP
+// @Summary Download an exam kit
+// @Description Download the prepared files of a finished exam kit as a ZIP, each named after its document, with a manifest.json giving the kit's status, the required documents still missing and each file checked against its document. The X-User-ID header must name the user who created the kit.
+// @Tags kits
+// @Produce application/zip
+// @Param id path string true "Kit ID"
+// @Param X-User-ID header string true "User who created the kit"
+// @Success 200 {file} file
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/kits/:id/download [get]
+func (h *ConversionHandler) DownloadKit(c *gin.Context) {
+	ctx := c.Request.Context()
+	kit, err := h.kitStatus(ctx, c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Kit not found")
+		return
+	}
+	if !requireKitOwner(c, kit) {
+		return
+	}
+	if kit.Status != utils.StatusCompleted {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error:   "Kit is still processing",
+		})
+		return
+	}
+
+	// Find and check the files before answering, so a missing one is an
+	// error rather than a truncated ZIP
+	keys, err := h.checkKitFiles(ctx, kit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to read kit files: " + err.Error(),
+		})
+		return
+	}
+	manifest, err := json.MarshalIndent(kit, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "application/zip")
+	header.Set("Content-Disposition", attachmentDisposition(kit.ExamID+"-kit.zip"))
+	header.Set("Cache-Control", "private, no-cache")
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	for i, doc := range kit.Documents {
+		if keys[i] == "" {
+			continue
+		}
+		if err := h.copyZipBlob(ctx, zw, doc.FileName, keys[i]); err != nil {
+			log.Printf("⚠ Failed to write kit %s: %v", kit.ID, err)
+			return
+		}
+	}
+	if err := writeZipFile(zw, kitManifestName, manifest); err != nil {
+		log.Printf("⚠ Failed to write kit %s: %v", kit.ID, err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("⚠ Failed to write kit %s: %v", kit.ID, err)
+	}
+}
+
+// requireKitOwner answers 401 or 403 and returns false unless the
+// X-User-ID header names the user who created kit
+func requireKitOwner(c *gin.Context, kit *models.Kit) bool {
+	userID := c.GetHeader(userIDHeader)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   userIDHeader + " header is required",
+		})
+		return false
+	}
+	if userID != kit.UserID {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Error:   "Kit belongs to another user",
+		})
+		return false
+	}
+	return true
+}
+
+// checkKitFiles finds the prepared file of each completed kit document and
+// checks it against its document. It returns the blob keys in the order of
+// kit.Documents, with "" for documents without a prepared file.
+func (h *ConversionHandler) checkKitFiles(ctx context.Context, kit *models.Kit) ([]string, error) {
+	exam, err := h.store.GetExamByID(ctx, kit.ExamID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	keys := make([]string, len(kit.Documents))
+	for i := range kit.Documents {
+		doc := &kit.Documents[i]
+		if doc.Status != utils.StatusCompleted {
+			continue
+		}
+		conv, err := h.store.GetConversionByID(ctx, doc.ConversionID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := h.blobs.Stat(ctx, conv.OutputPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", doc.DocumentID, err)
+		}
+		keys[i] = conv.OutputPath
+		if exam == nil {
+			continue
+		}
+		if d := findDocument(exam, doc.DocumentID); d != nil {
+			report, err := h.checkBlob(ctx, *d, doc.FileName, conv.OutputPath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", doc.DocumentID, err)
+			}
+			report.ExamID = exam.ID
+			doc.Check = &report
+		}
+	}
+	return keys, nil
+}
+
+// checkBlob checks the blob under key against a document, reading only the
+// parts of it the checks need
+func (h *ConversionHandler) checkBlob(ctx context.Context, doc models.Document, name, key string) (models.DocumentCheck, error) {
+	f, info, err := blob.Open(ctx, h.blobs, key)
+	if err != nil {
+		return models.DocumentCheck{}, err
+	}
+	defer f.Close()
+	return h.checker.Check(doc, check.File{Name: name, Data: f, Size: info.Size}, nil), nil
+}
+
+// copyZipBlob streams the blob under key into a ZIP as name
+func (h *ConversionHandler) copyZipBlob(ctx context.Context, zw *zip.Writer, name, key string) error {
+	r, err := h.blobs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// writeZipFile adds a file to a ZIP
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
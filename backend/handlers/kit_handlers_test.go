@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/scan"
+	"github.com/oneforall/backend/utils"
+)
+
+// markerScanner reports files containing marker as infected
+type markerScanner struct {
+	marker []byte
+}
+
+func (s markerScanner) Scan(ctx context.Context, r io.Reader) (scan.Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return scan.Result{}, err
+	}
+	if bytes.Contains(data, s.marker) {
+		return scan.Result{Infected: true, Signature: "Test-Signature"}, nil
+	}
+	return scan.Result{}, nil
+}
+
+// createKit uploads files, keyed by document ID, as a kit of jee-main
+func (s *testServer) createKit(t *testing.T, userID string, files map[string][]byte, names map[string]string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for docID, data := range files {
+		part, _ := mw.CreateFormFile(docID, names[docID])
+		part.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/exams/jee-main/kits", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if userID != "" {
+		req.Header.Set(userIDHeader, userID)
+	}
+	return s.do(t, req)
+}
+
+func TestKitQuarantinesInfectedDocuments(t *testing.T) {
+	s := newScanningTestServer(t, markerScanner{marker: []byte("EICAR")})
+	infected := append([]byte("%PDF-1.4\n"), []byte("EICAR test\n%%EOF\n")...)
+
+	w, resp := s.createKit(t, "alice",
+		map[string][]byte{"photo": testPNG(t), "admit-card": infected},
+		map[string]string{"photo": "photo.png", "admit-card": "admit.pdf"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create kit = %d %+v; want 201", w.Code, resp)
+	}
+	var kit models.Kit
+	decodeData(t, resp, &kit)
+
+	statuses := map[string]string{}
+	for _, doc := range kit.Documents {
+		statuses[doc.DocumentID] = doc.Status
+	}
+	if statuses["admit-card"] != utils.StatusQuarantined {
+		t.Errorf("infected document is %q; want quarantined", statuses["admit-card"])
+	}
+	if statuses["photo"] != utils.StatusPending {
+		t.Errorf("clean document is %q; want pending", statuses["photo"])
+	}
+
+	conversions, err := s.store.GetKitConversions(context.Background(), kit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, conv := range conversions {
+		if conv.DocumentID == "admit-card" && (conv.ScanVerdict != scan.VerdictInfected || conv.InputPath != "") {
+			t.Errorf("quarantined conversion = %+v; want an infected verdict and no stored input", conv)
+		}
+	}
+}
+
+func TestKitOwnership(t *testing.T) {
+	s := newTestServer(t)
+	w, resp := s.createKit(t, "alice", map[string][]byte{"photo": testPNG(t)}, map[string]string{"photo": "photo.png"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create kit = %d %+v; want 201", w.Code, resp)
+	}
+	var kit models.Kit
+	decodeData(t, resp, &kit)
+
+	for _, path := range []string{"/api/kits/" + kit.ID, "/api/kits/" + kit.ID + "/download"} {
+		if w, _ := s.get(t, path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without %s = %d; want 401", path, userIDHeader, w.Code)
+		}
+		if w, _ := s.get(t, path, "bob"); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s as another user = %d; want 403", path, w.Code)
+		}
+	}
+	if w, _ := s.get(t, "/api/kits/"+kit.ID, "alice"); w.Code != http.StatusOK {
+		t.Errorf("GET kit as its owner = %d; want 200", w.Code)
+	}
+	if w, _ := s.get(t, "/api/kits/"+kit.ID+"/download", "alice"); w.Code != http.StatusConflict {
+		t.Errorf("download of an unfinished kit = %d; want 409", w.Code)
+	}
+	if w, _ := s.get(t, "/api/kits/unknown", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("GET unknown kit = %d; want 404", w.Code)
+	}
+	if w, _ := s.createKit(t, "", map[string][]byte{"photo": testPNG(t)}, map[string]string{"photo": "photo.png"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("create kit without %s = %d; want 401", userIDHeader, w.Code)
+	}
+	if w, _ := s.createKit(t, "alice", map[string][]byte{"thumb": testPNG(t)}, map[string]string{"thumb": "t.png"}); w.Code != http.StatusBadRequest {
+		t.Errorf("create kit for an unknown document = %d; want 400", w.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/signedurl"
+	"github.com/oneforall/backend/utils"
+)
+
+// signedURLSigner returns the signer for secret, or nil if signed links are
+// disabled
+func signedURLSigner(secret string) *signedurl.Signer {
+	if secret == "" {
+		return nil
+	}
+	return signedurl.New(secret)
+}
+
+// CreateSignedLink issues a short-lived link to upload the input or download
+// the output of a conversion
+// @Summary Create signed link
+// @Description Create a link that uploads the input ("upload") or downloads the output ("download") of a conversion without the X-User-ID header. The link is valid until expires_at (SIGNED_URL_EXPIRY after creation).
+// @Tags conversions
+// @Accept json
+// @Produce json
+// @Param id path string true "Conversion ID"
+// @Param X-User-ID header string true "User who requested the conversion"
+// @Param request body map[string]interface{} true "Link scope: {\"scope\": \"download\"}"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 503 {object} models.APIResponse
+// @Router /api/conversions/:id/links [post]
+func (h *ConversionHandler) CreateSignedLink(c *gin.Context) {
+	if h.signer == nil {
+		c.JSON(http.StatusServiceUnavailable, models.APIResponse{
+			Success: false,
+			Error:   "Signed links are disabled; set URL_SIGNING_SECRET to enable them",
+		})
+		return
+	}
+
+	var req struct {
+		Scope signedurl.Scope `json:"scope" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBadRequest(c, err.Error())
+		return
+	}
+	if !signedurl.ValidScope(req.Scope) {
+		respondBadRequest(c, `Scope must be "upload" or "download"`)
+		return
+	}
+
+	conv, err := h.store.GetConversionByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Conversion not found")
+		return
+	}
+	if !requireOwner(c, conv) {
+		return
+	}
+	// Refuse links that could not be used anyway
+	want := utils.StatusPending
+	if req.Scope == signedurl.ScopeDownload {
+		want = utils.StatusCompleted
+	}
+	if conv.Status != want {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error:   "Conversion is " + conv.Status + ", not " + want,
+		})
+		return
+	}
+
+	expires := time.Now().Add(h.linkExpiry).Truncate(time.Second)
+	query := h.signer.Sign(req.Scope, conv.ID, expires)
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "Signed link created",
+		Data: models.SignedLinkResponse{
+			URL:       "/api/signed/conversions/" + conv.ID + "/" + string(req.Scope) + "?" + query.Encode(),
+			Scope:     string(req.Scope),
+			ExpiresAt: expires,
+		},
+	})
+}
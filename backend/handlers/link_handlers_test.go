@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/models"
+)
+
+// createLink asks for a signed link to a conversion
+func (s *testServer) createLink(t *testing.T, id, userID string, body string) (*httptest.ResponseRecorder, apiResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/conversions/"+id+"/links", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		req.Header.Set(userIDHeader, userID)
+	}
+	return s.do(t, req)
+}
+
+// link creates a signed link of scope for alice's conversion and returns
+// its URL
+func (s *testServer) link(t *testing.T, id, scope string) string {
+	t.Helper()
+	w, resp := s.createLink(t, id, "alice", `{"scope":"`+scope+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create %s link = %d %+v", scope, w.Code, resp)
+	}
+	var link models.SignedLinkResponse
+	decodeData(t, resp, &link)
+	return link.URL
+}
+
+func TestCreateSignedLink(t *testing.T) {
+	s := newTestServer(t)
+	id := s.newConversion(t, "alice")
+	tests := []struct {
+		name   string
+		id     string
+		user   string
+		body   string
+		status int
+	}{
+		{"no scope", id, "alice", `{}`, http.StatusBadRequest},
+		{"unknown scope", id, "alice", `{"scope":"delete"}`, http.StatusBadRequest},
+		{"unknown conversion", "unknown", "alice", `{"scope":"upload"}`, http.StatusNotFound},
+		{"no user", id, "", `{"scope":"upload"}`, http.StatusUnauthorized},
+		{"another user", id, "bob", `{"scope":"upload"}`, http.StatusForbidden},
+		{"download before completion", id, "alice", `{"scope":"download"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if w, resp := s.createLink(t, tt.id, tt.user, tt.body); w.Code != tt.status || resp.Success {
+			t.Errorf("%s: %d %+v; want %d", tt.name, w.Code, resp, tt.status)
+		}
+	}
+
+	w, resp := s.createLink(t, id, "alice", `{"scope":"upload"}`)
+	var link models.SignedLinkResponse
+	decodeData(t, resp, &link)
+	if w.Code != http.StatusCreated || link.Scope != "upload" || link.ExpiresAt.IsZero() ||
+		!strings.HasPrefix(link.URL, "/api/signed/conversions/"+id+"/upload?") {
+		t.Errorf("upload link = %d %+v", w.Code, link)
+	}
+
+	// Without a signing secret links are disabled
+	router := gin.New()
+	conv := NewConversionHandler(s.store, s.blobs, nil, s.jobs, nil, &config.Config{})
+	router.POST("/api/conversions/:id/links", conv.CreateSignedLink)
+	unsigned := &testServer{router: router}
+	if w, _ := unsigned.createLink(t, id, "alice", `{"scope":"upload"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("link without a secret = %d; want 503", w.Code)
+	}
+}
+
+func TestSignedLinks(t *testing.T) {
+	s := newTestServer(t)
+	id := s.newConversion(t, "alice")
+	uploadURL := s.link(t, id, "upload")
+
+	// A signed upload needs no X-User-ID header
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "photo.png")
+	part.Write(testPNG(t))
+	mw.Close()
+	signedUpload := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w, _ := s.do(t, req)
+		return w
+	}
+
+	// Links only work for their own scope and conversion, unaltered
+	other := s.newConversion(t, "alice")
+	tampered := strings.Replace(uploadURL, "signature=", "signature=0", 1)
+	for name, url := range map[string]string{
+		"tampered signature": tampered,
+		"another conversion": strings.Replace(uploadURL, id, other, 1),
+		"no signature":       "/api/signed/conversions/" + id + "/upload",
+	} {
+		if w := signedUpload(url); w.Code != http.StatusForbidden {
+			t.Errorf("%s: upload = %d; want 403", name, w.Code)
+		}
+	}
+	downloadPath := strings.Replace(uploadURL, "/upload?", "/download?", 1)
+	if w, _ := s.get(t, downloadPath, ""); w.Code != http.StatusForbidden {
+		t.Errorf("upload link used to download = %d; want 403", w.Code)
+	}
+
+	if w := signedUpload(uploadURL); w.Code != http.StatusCreated {
+		t.Fatalf("signed upload = %d %s; want 201", w.Code, w.Body)
+	}
+	if len(s.jobs.ids) != 1 || s.jobs.ids[0] != id {
+		t.Errorf("enqueued %v; want %s", s.jobs.ids, id)
+	}
+
+	output := []byte("converted output")
+	s.completeConversion(t, id, output)
+	if w := signedUpload(uploadURL); w.Code != http.StatusConflict {
+		t.Errorf("signed upload once completed = %d; want 409", w.Code)
+	}
+	if w, _ := s.createLink(t, id, "alice", `{"scope":"upload"}`); w.Code != http.StatusConflict {
+		t.Errorf("upload link once completed = %d; want 409", w.Code)
+	}
+	downloadURL := s.link(t, id, "download")
+	w, _ := s.get(t, downloadURL, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), output) {
+		t.Errorf("signed download = %d %q; want the output", w.Code, w.Body)
+	}
+
+	// A signed link stands in for the owner, whatever X-User-ID says
+	if w, _ := s.get(t, downloadURL, "bob"); w.Code != http.StatusOK {
+		t.Errorf("signed download with another user's header = %d; want 200", w.Code)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+)
+
+// GetExamRevisions lists every revision of an exam's document requirements
+// @Summary Get exam revisions
+// @Description List the revisions of an exam's document requirements, oldest first
+// @Tags exams
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/exams/:id/revisions [get]
+func (h *ExamHandler) GetExamRevisions(c *gin.Context) {
+	revisions, err := h.store.GetExamRevisions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Exam not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exam revisions retrieved successfully",
+		Data:    revisions,
+	})
+}
+
+// GetExamRevision returns one revision of an exam's document requirements
+// @Summary Get exam revision
+// @Description Get the document requirements of an exam as of a revision
+// @Tags exams
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/exams/:id/revisions/:revision [get]
+func (h *ExamHandler) GetExamRevision(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		respondBadRequest(c, "Revision must be a number")
+		return
+	}
+
+	revisions, err := h.store.GetExamRevisions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Exam not found")
+		return
+	}
+	rev := findRevision(revisions, number)
+	if rev == nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Revision %d not found", number),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exam revision retrieved successfully",
+		Data:    rev,
+	})
+}
+
+// DiffExamRevisions compares two revisions of an exam's document requirements
+// @Summary Diff exam revisions
+// @Description Show documents added, removed and changed between two revisions. to defaults to the latest revision and from to the one before it.
+// @Tags exams
+// @Produce json
+// @Param id path string true "Exam ID"
+// @Param from query int false "Older revision"
+// @Param to query int false "Newer revision"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/exams/:id/revisions/diff [get]
+func (h *ExamHandler) DiffExamRevisions(c *gin.Context) {
+	revisions, err := h.store.GetExamRevisions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Exam not found")
+		return
+	}
+
+	to := revisions[len(revisions)-1].Revision
+	if v := c.Query("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			respondBadRequest(c, "to must be a revision number")
+			return
+		}
+	}
+	from := to - 1
+	if v := c.Query("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			respondBadRequest(c, "from must be a revision number")
+			return
+		}
+	}
+
+	fromRev, toRev := findRevision(revisions, from), findRevision(revisions, to)
+	if fromRev == nil || toRev == nil {
+		missing := from
+		if toRev == nil {
+			missing = to
+		}
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Revision %d not found", missing),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Exam revision diff computed successfully",
+		Data:    storage.DiffRevisions(*fromRev, *toRev),
+	})
+}
+
+// findRevision returns the revision with the given number, or nil
+func findRevision(revisions []models.ExamRevision, number int) *models.ExamRevision {
+	for i := range revisions {
+		if revisions[i].Revision == number {
+			return &revisions[i]
+		}
+	}
+	return nil
+}
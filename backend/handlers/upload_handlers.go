@@ -0,0 +1,471 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/scan"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/tus"
+	"github.com/oneforall/backend/utils"
+)
+
+// multipartOverhead is allowed on top of the file size limit for the
+// multipart boundaries and part headers around the file
+const multipartOverhead = 1 << 20
+
+// errFileTooLarge is returned by saveUpload when the file exceeds its limit
+var errFileTooLarge = errors.New("file too large")
+
+// errScanFailed is returned when an upload could not be scanned for malware
+var errScanFailed = errors.New("malware scan failed")
+
+// errInfected is returned when the scanner found malware in an upload
+var errInfected = errors.New("malware detected")
+
+// UploadFile streams the file for a conversion request to disk
+// @Summary Upload conversion input
+// @Description Upload the file for a pending conversion request as the multipart field "file". It must fit both the server limit and the document's max_size, and its content must match its extension. The X-User-ID header must name the user who requested the conversion, unless the request comes through a signed upload link.
+// @Tags conversions
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Conversion ID"
+// @Param X-User-ID header string false "User who requested the conversion"
+// @Param file formData file true "File to convert"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 413 {object} models.APIResponse
+// @Failure 415 {object} models.APIResponse
+// @Router /api/conversions/:id/upload [post]
+func (h *ConversionHandler) UploadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	conv, err := h.store.GetConversionByID(ctx, c.Param("id"))
+	if err != nil {
+		respondLookupError(c, err, "Conversion not found")
+		return
+	}
+	if !requireOwner(c, conv) {
+		return
+	}
+	if conv.Status != utils.StatusPending {
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error:   "Conversion is already " + conv.Status,
+		})
+		return
+	}
+
+	limit, err := h.uploadLimit(ctx, conv)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	if c.Request.ContentLength > limit+multipartOverhead {
+		respondTooLarge(c, limit)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	part, err := filePart(c.Request)
+	if err != nil {
+		if isMaxBytesError(err) {
+			respondTooLarge(c, limit)
+			return
+		}
+		respondBadRequest(c, err.Error())
+		return
+	}
+	defer part.Close()
+
+	name := utils.SanitizeFileName(filepath.Base(part.FileName()))
+	if !utils.IsValidFileExtension(name) {
+		respondBadRequest(c, "File type not allowed: "+name)
+		return
+	}
+	if err := h.checkToolInput(conv, name); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	path, size, err := spoolUpload(part, h.spoolDir, limit)
+	if path != "" {
+		defer os.Remove(path)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errFileTooLarge) || isMaxBytesError(err):
+			respondTooLarge(c, limit)
+		case size == 0:
+			respondBadRequest(c, "Uploaded file is empty")
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to store upload: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	saved, err := h.attachInput(ctx, conv, path, name, size)
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	message := "File received, awaiting conversion"
+	if saved.Status == utils.StatusCompleted {
+		message = "Identical file already converted, output is ready"
+	}
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Message: "File uploaded successfully",
+		Data: models.UploadResponse{
+			ID:          saved.ID,
+			FileName:    saved.FileName,
+			FileSize:    saved.FileSize,
+			Path:        saved.InputPath,
+			ContentType: saved.ContentType,
+			InputHash:   saved.InputHash,
+			Status:      saved.Status,
+			Message:     message,
+		},
+	})
+}
+
+// PrepareResumableUpload checks a new tus upload before any data is
+// accepted. Its metadata must name a pending conversion of the user in
+// X-User-ID in "conversion_id" and the file in "filename", and its length
+// must fit the document's limit.
+func (h *ConversionHandler) PrepareResumableUpload(c *gin.Context, upload *tus.Upload) error {
+	ctx := c.Request.Context()
+	conv, err := h.resumableConversion(c, upload)
+	if err != nil {
+		return err
+	}
+	limit, err := h.uploadLimit(ctx, conv)
+	if err != nil {
+		return tus.NewError(storeErrorStatus(err), err.Error())
+	}
+	if upload.Length > limit {
+		return tus.NewError(http.StatusRequestEntityTooLarge, "File exceeds the maximum size of "+utils.FormatFileSize(limit))
+	}
+	if upload.Length == 0 {
+		return tus.NewError(http.StatusBadRequest, "Uploaded file is empty")
+	}
+
+	name := resumableFileName(upload)
+	if !utils.IsValidFileExtension(name) {
+		return tus.NewError(http.StatusBadRequest, "File type not allowed: "+name)
+	}
+	if err := h.checkToolInput(conv, name); err != nil {
+		return tus.NewError(http.StatusUnsupportedMediaType, err.Error())
+	}
+	return nil
+}
+
+// checkToolInput refuses a file named name that the conversion's tool does
+// not take, e.g. a PDF for png-to-jpg
+func (h *ConversionHandler) checkToolInput(conv *models.ConversionRequest, name string) error {
+	if conv.ToolID == "" {
+		return nil
+	}
+	reg, ok := h.registry.Lookup(conv.ToolID)
+	if !ok {
+		// The worker reports the missing converter
+		return nil
+	}
+	return reg.CheckInput(conv.ToolID, convert.FormatOf(name))
+}
+
+// CompleteResumableUpload stores a finished tus upload like a regular upload
+// and attaches it to its conversion request. The request carrying the last
+// chunk must come from the conversion's user too. On failure the data stays
+// with the tus upload so completion can be retried.
+func (h *ConversionHandler) CompleteResumableUpload(c *gin.Context, upload *tus.Upload, path string) error {
+	conv, err := h.resumableConversion(c, upload)
+	if err != nil {
+		return err
+	}
+	if _, err := h.attachInput(c.Request.Context(), conv, path, resumableFileName(upload), upload.Length); err != nil {
+		return tus.NewError(uploadErrorStatus(err), err.Error())
+	}
+	return nil
+}
+
+// resumableConversion loads the pending conversion a tus upload belongs to,
+// refusing requests from other users than the one who requested it
+func (h *ConversionHandler) resumableConversion(c *gin.Context, upload *tus.Upload) (*models.ConversionRequest, error) {
+	id := upload.Metadata["conversion_id"]
+	if id == "" {
+		return nil, tus.NewError(http.StatusBadRequest, `Upload-Metadata must include "conversion_id"`)
+	}
+	conv, err := h.store.GetConversionByID(c.Request.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, tus.NewError(http.StatusNotFound, "Conversion not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status, message := checkOwner(c, conv); status != 0 {
+		return nil, tus.NewError(status, message)
+	}
+	if conv.Status != utils.StatusPending {
+		return nil, tus.NewError(http.StatusConflict, "Conversion is already "+conv.Status)
+	}
+	return conv, nil
+}
+
+// resumableFileName is the sanitized file name from a tus upload's metadata.
+// Clients commonly send it as "filename" or "name".
+func resumableFileName(upload *tus.Upload) string {
+	name := upload.Metadata["filename"]
+	if name == "" {
+		name = upload.Metadata["name"]
+	}
+	return utils.SanitizeFileName(filepath.Base(name))
+}
+
+// attachInput checks that the content of the local file at path matches
+// name and scans it for malware, then attaches it to a conversion as its
+// input, stored by content so identical uploads share one blob. The input
+// it replaces, if any, is released. If an identical input was already
+// converted with the same tool and options, the conversion is completed
+// with that output straight away; otherwise it is queued for conversion.
+// An infected file is not stored and its conversion is quarantined instead.
+func (h *ConversionHandler) attachInput(ctx context.Context, conv *models.ConversionRequest, path, name string, size int64) (*models.ConversionRequest, error) {
+	fileType, err := utils.ValidateFile(name, path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.scanFile(ctx, path)
+	if err != nil {
+		log.Printf("⚠ Failed to scan upload for %s: %v", conv.ID, err)
+		return nil, fmt.Errorf("%w, try again later", errScanFailed)
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash upload: %w", err)
+	}
+
+	conv.FileName = name
+	conv.FileSize = size
+	conv.InputHash = hash
+	conv.ContentType = fileType.MIME
+	conv.ScanVerdict = ""
+	conv.ScanSignature = ""
+	if h.scanner != nil {
+		conv.ScanVerdict = result.Verdict()
+		conv.ScanSignature = result.Signature
+	}
+	if result.Infected {
+		return h.quarantine(ctx, conv)
+	}
+
+	key := inputKey(hash)
+	if err := blob.Acquire(ctx, h.blobs, h.store, key, func() error {
+		return putFile(ctx, h.blobs, key, path, size)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+
+	previous := conv.InputPath
+	conv.InputPath = key
+	reused, err := h.reuseOutput(ctx, conv)
+	if err != nil {
+		log.Printf("⚠ Failed to look up cached output for %s: %v", conv.ID, err)
+	}
+
+	saved, err := h.store.SaveConversion(ctx, *conv)
+	if err != nil {
+		blob.Release(ctx, h.blobs, h.store, key)
+		if reused {
+			blob.Release(ctx, h.blobs, h.store, conv.OutputPath)
+		}
+		return nil, err
+	}
+	blob.Release(ctx, h.blobs, h.store, previous)
+	if saved.Status == utils.StatusPending && h.jobs != nil && !h.jobs.Enqueue(saved.ID) {
+		log.Printf("Conversion queue full, %s will be picked up later", saved.ID)
+	}
+	return saved, nil
+}
+
+// scanFile runs the malware scanner, if one is configured, over the local
+// file at path
+func (h *ConversionHandler) scanFile(ctx context.Context, path string) (scan.Result, error) {
+	if h.scanner == nil {
+		return scan.Result{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return scan.Result{}, err
+	}
+	defer f.Close()
+	return h.scanner.Scan(ctx, f)
+}
+
+// quarantine records that the upload for conv is infected. The file is not
+// kept, and the input it would have replaced is released.
+func (h *ConversionHandler) quarantine(ctx context.Context, conv *models.ConversionRequest) (*models.ConversionRequest, error) {
+	previous := conv.InputPath
+	conv.InputPath = ""
+	conv.Status = utils.StatusQuarantined
+	conv.ErrorMsg = "Malware detected: " + conv.ScanSignature
+	if _, err := h.store.SaveConversion(ctx, *conv); err != nil {
+		return nil, err
+	}
+	blob.Release(ctx, h.blobs, h.store, previous)
+	log.Printf("⚠ Quarantined conversion %s: %s found in %s", conv.ID, conv.ScanSignature, conv.FileName)
+	return nil, fmt.Errorf("%w (%s), the file was rejected", errInfected, conv.ScanSignature)
+}
+
+// putFile copies the local file at path into blob storage under key
+func putFile(ctx context.Context, blobs blob.Store, key, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return blobs.Put(ctx, key, f, size)
+}
+
+// uploadErrorStatus is the HTTP status for an error attaching an upload
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrContentMismatch):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errInfected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errScanFailed):
+		return http.StatusServiceUnavailable
+	}
+	return storeErrorStatus(err)
+}
+
+// uploadLimit is the largest file accepted for a conversion: the server
+// limit or the document's max_size, whichever is smaller. A conversion that
+// compresses its output to a size limit may take larger files.
+func (h *ConversionHandler) uploadLimit(ctx context.Context, conv *models.ConversionRequest) (int64, error) {
+	doc, err := h.conversionDocument(ctx, conv)
+	if err != nil {
+		return 0, err
+	}
+	limit := h.maxFileSize
+	if conv.Options[convert.OptionMaxSize] != "" {
+		return limit, nil
+	}
+	if doc.MaxSize > 0 && doc.MaxSize < limit {
+		limit = doc.MaxSize
+	}
+	return limit, nil
+}
+
+// conversionDocument returns the document a conversion targets, as it was in
+// the exam revision the request was validated against
+func (h *ConversionHandler) conversionDocument(ctx context.Context, conv *models.ConversionRequest) (*models.Document, error) {
+	var docs []models.Document
+	if conv.ExamRevision > 0 {
+		revisions, err := h.store.GetExamRevisions(ctx, conv.ExamID)
+		if err != nil {
+			return nil, err
+		}
+		if rev := findRevision(revisions, conv.ExamRevision); rev != nil {
+			docs = rev.Documents
+		}
+	}
+	if docs == nil {
+		exam, err := h.store.GetExamByID(ctx, conv.ExamID)
+		if err != nil {
+			return nil, err
+		}
+		docs = exam.Documents
+	}
+
+	if i := findDocumentIn(docs, conv.DocumentID); i >= 0 {
+		return &docs[i], nil
+	}
+	return nil, fmt.Errorf("document %s of exam %s: %w", conv.DocumentID, conv.ExamID, storage.ErrNotFound)
+}
+
+// filePart returns the multipart part named "file", reading the request body
+// as a stream instead of buffering the whole form
+func filePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("expected a multipart/form-data upload: %w", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`missing multipart field "file"`)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// spoolUpload streams src into a temporary file in dir, so it can be
+// validated before it is stored. It returns the file's path, which the caller
+// removes, and the number of bytes written.
+func spoolUpload(src io.Reader, dir string, limit int64) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(tmp, io.LimitReader(src, limit+1))
+	if err == nil && size > limit {
+		err = errFileTooLarge
+	}
+	if err == nil && size == 0 {
+		err = errors.New("empty file")
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return tmp.Name(), size, err
+}
+
+// isMaxBytesError reports whether err came from http.MaxBytesReader
+func isMaxBytesError(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// respondTooLarge answers 413 naming the limit that applies
+func respondTooLarge(c *gin.Context, limit int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, models.APIResponse{
+		Success: false,
+		Error:   "File exceeds the maximum size of " + utils.FormatFileSize(limit),
+	})
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/oneforall/backend/blob"
 	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/convert"
+	"github.com/oneforall/backend/handlers"
 	"github.com/oneforall/backend/routes"
+	"github.com/oneforall/backend/scan"
 	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/worker"
 )
 
 func init() {
@@ -18,17 +31,80 @@ func init() {
 }
 
 func main() {
-	// Initialize storage (JSON for now)
-	store := storage.NewJSONStorage()
-	if err := store.Initialize(); err != nil {
+	// Get server configuration
+	cfg := config.NewConfig()
+
+	// Run a one-shot command such as `import` if one was given
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	// Initialize storage (JSON unless DATABASE_TYPE says otherwise)
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure storage: %v", err)
+	}
+	if err := store.Initialize(context.Background()); err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	defer store.Close()
 
-	// Get server configuration
-	cfg := config.NewConfig()
+	// Pick up edits to exams.json and tools.json without a restart
+	if reloader, ok := store.(storage.Reloader); ok {
+		watchCatalog(cfg, reloader)
+	}
+
+	// Uploaded inputs and converted outputs live in blob storage
+	blobs, err := blob.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure blob storage: %v", err)
+	}
+
+	// Uploads are scanned for malware when CLAMD_ADDRESS is set
+	scanner, err := scan.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure malware scanning: %v", err)
+	}
+	if scanner == nil {
+		log.Println("⚠ Malware scanning disabled; set CLAMD_ADDRESS to enable it")
+	}
+
+	// Converters behind the tools in tools.json; tools without one are
+	// hidden from GET /api/tools
+	registry, err := convert.Builtin()
+	if err != nil {
+		log.Fatalf("Failed to register converters: %v", err)
+	}
+	checkConverters(context.Background(), store, registry)
+
+	// Stop on SIGINT or SIGTERM, letting requests and conversions finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Pending conversions are processed in the background unless
+	// WORKER_COUNT is 0, e.g. when another instance runs the workers
+	var pool *worker.Pool
+	var jobs handlers.JobQueue
+	if cfg.WorkerCount > 0 {
+		pool = worker.New(store, blobs, convert.NewProcessor(registry, blobs, filepath.Join(cfg.UploadDirectory, "tmp")), worker.Config{
+			Workers:      cfg.WorkerCount,
+			QueueSize:    cfg.QueueSize,
+			PollInterval: cfg.WorkerPollInterval,
+			JobTimeout:   cfg.JobTimeout,
+		})
+		if err := pool.Start(ctx); err != nil {
+			log.Fatalf("Failed to start conversion workers: %v", err)
+		}
+		jobs = pool
+	} else {
+		log.Println("⚠ Conversion workers disabled; set WORKER_COUNT to enable them")
+	}
 
 	// Setup routes
-	router := routes.SetupRoutes(store)
+	router := routes.SetupRoutes(ctx, cfg, store, blobs, scanner, jobs, registry)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -36,8 +112,74 @@ func main() {
 		port = cfg.Port
 	}
 
-	log.Printf("🚀 Starting 1forall backend server on port %s\n", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("🚀 Starting 1forall backend server on port %s\n", port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	case <-ctx.Done():
+		stop()
+		log.Println("Shutting down, waiting for requests and conversions to finish")
+	}
+
+	// Requests and conversions share one SHUTDOWN_TIMEOUT; conversions
+	// still running after it are put back to pending for the next start
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠ Server shutdown: %v", err)
+	}
+	if pool != nil {
+		if err := pool.Shutdown(shutdownCtx); err != nil {
+			log.Printf("⚠ Worker shutdown: %v", err)
+		}
+	}
+	log.Println("✓ Server stopped")
+}
+
+// checkConverters warns about enabled tools in the catalogue that have no
+// converter, which are not offered, and converters for tools it does not list
+func checkConverters(ctx context.Context, store storage.Store, registry *convert.Registry) {
+	tools, err := store.GetTools(ctx)
+	if err != nil {
+		log.Printf("⚠ Failed to check converters: %v", err)
+		return
+	}
+	missing, unlisted := registry.Check(tools)
+	if len(missing) > 0 {
+		log.Printf("⚠ No converter for %d tools, hiding them: %s", len(missing), strings.Join(missing, ", "))
+	}
+	if len(unlisted) > 0 {
+		log.Printf("⚠ Converters for tools missing from tools.json: %s", strings.Join(unlisted, ", "))
+	}
+	log.Printf("✓ %d converters registered", len(registry.ToolIDs()))
+}
+
+// watchCatalog reloads the exam and tool catalogue on SIGHUP and, if the
+// backend supports it, whenever the files change on disk
+func watchCatalog(cfg *config.Config, reloader storage.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("SIGHUP received, reloading exams and tools")
+			if err := reloader.Reload(context.Background()); err != nil {
+				log.Printf("⚠ Keeping previous exams and tools: %v", err)
+			}
+		}
+	}()
+
+	watcher, ok := reloader.(interface {
+		Watch(ctx context.Context, interval time.Duration)
+	})
+	if ok && cfg.ReloadInterval > 0 {
+		go watcher.Watch(context.Background(), cfg.ReloadInterval)
 	}
 }
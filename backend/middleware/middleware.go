@@ -1,9 +1,15 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"errors"
 	"log"
+	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/signedurl"
 )
 
 // LoggerMiddleware logs HTTP requests
@@ -30,3 +36,62 @@ func RecoveryMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminAuth only lets requests through that carry "Authorization: Bearer
+// <token>". With an empty token the admin API is disabled entirely.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.APIResponse{
+				Success: false,
+				Error:   "Admin API is disabled; set ADMIN_TOKEN to enable it",
+			})
+			return
+		}
+
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Invalid or missing admin token",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// SignedConversionKey is the context key under which SignedURL stores the ID
+// of the conversion a request was authorised for
+const SignedConversionKey = "signedConversionID"
+
+// SignedURL only lets requests through whose expires and signature query
+// parameters were signed with secret for scope and the conversion in the
+// :id path parameter. With an empty secret signed links are disabled.
+func SignedURL(secret string, scope signedurl.Scope) gin.HandlerFunc {
+	signer := signedurl.New(secret)
+	return func(c *gin.Context) {
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.APIResponse{
+				Success: false,
+				Error:   "Signed links are disabled; set URL_SIGNING_SECRET to enable them",
+			})
+			return
+		}
+
+		conversionID := c.Param("id")
+		if err := signer.Verify(scope, conversionID, c.Request.URL.Query()); err != nil {
+			msg := "Invalid link signature"
+			if errors.Is(err, signedurl.ErrExpired) {
+				msg = "Link has expired"
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Error:   msg,
+			})
+			return
+		}
+		c.Set(SignedConversionKey, conversionID)
+		c.Next()
+	}
+}
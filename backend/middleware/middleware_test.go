@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"bearer token", "secret", "Bearer secret", http.StatusOK},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"token without scheme", "secret", "secret", http.StatusUnauthorized},
+		{"other scheme", "secret", "Basic secret", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"lowercase scheme", "secret", "bearer secret", http.StatusUnauthorized},
+		{"disabled", "", "Bearer ", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.GET("/admin", AdminAuth(tt.token), func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d; want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
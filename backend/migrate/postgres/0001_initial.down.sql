@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS user_conversions;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS tools;
+DROP TABLE IF EXISTS tool_categories;
+DROP TABLE IF EXISTS conversion_requests;
+DROP TABLE IF EXISTS documents;
+DROP TABLE IF EXISTS exams;
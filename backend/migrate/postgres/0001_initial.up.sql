@@ -0,0 +1,94 @@
+-- Initial schema. Mirrors database/schema.sql without the Supabase-specific
+-- grants, row level security and sample data. IF NOT EXISTS lets it adopt
+-- databases created before migrations existed.
+
+CREATE TABLE IF NOT EXISTS exams (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  exam_id VARCHAR(50) UNIQUE NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  icon VARCHAR(10),
+  description TEXT,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS documents (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  document_id VARCHAR(50) NOT NULL,
+  exam_id VARCHAR(50) NOT NULL REFERENCES exams(exam_id) ON DELETE CASCADE,
+  name VARCHAR(255) NOT NULL,
+  size VARCHAR(20),
+  format VARCHAR(100),
+  max_size BIGINT,
+  required BOOLEAN DEFAULT false,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (exam_id, document_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversion_requests (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  conversion_id VARCHAR(50) UNIQUE NOT NULL,
+  user_id VARCHAR(100) NOT NULL,
+  exam_id VARCHAR(50) REFERENCES exams(exam_id) ON DELETE SET NULL,
+  document_id VARCHAR(50),
+  file_name VARCHAR(255) NOT NULL,
+  file_size BIGINT NOT NULL,
+  input_path VARCHAR(500),
+  output_path VARCHAR(500),
+  status VARCHAR(50) DEFAULT 'pending',
+  error_msg TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT status_check CHECK (status IN ('pending', 'processing', 'completed', 'failed'))
+);
+
+CREATE TABLE IF NOT EXISTS tool_categories (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  category_id VARCHAR(50) UNIQUE NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  icon VARCHAR(10),
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tools (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  tool_id VARCHAR(50) UNIQUE NOT NULL,
+  category VARCHAR(50) NOT NULL REFERENCES tool_categories(category_id) ON DELETE CASCADE,
+  name VARCHAR(255) NOT NULL,
+  description TEXT,
+  logo VARCHAR(10),
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id VARCHAR(100) UNIQUE NOT NULL,
+  email VARCHAR(255) UNIQUE,
+  name VARCHAR(255),
+  avatar_url VARCHAR(500),
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_conversions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id VARCHAR(100) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  conversion_id VARCHAR(50) NOT NULL REFERENCES conversion_requests(conversion_id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_user_id ON conversion_requests(user_id);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_exam_id ON conversion_requests(exam_id);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_status ON conversion_requests(status);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_created_at ON conversion_requests(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_documents_exam_id ON documents(exam_id);
+CREATE INDEX IF NOT EXISTS idx_tools_category ON tools(category);
+CREATE INDEX IF NOT EXISTS idx_user_conversions_user_id ON user_conversions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_conversions_conversion_id ON user_conversions(conversion_id);
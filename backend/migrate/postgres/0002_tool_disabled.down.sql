@@ -0,0 +1,2 @@
+ALTER TABLE tools DROP COLUMN IF EXISTS disabled;
+ALTER TABLE tool_categories DROP COLUMN IF EXISTS disabled;
@@ -0,0 +1,5 @@
+-- Lets admins hide tools and categories from GET /api/tools without
+-- deleting them.
+
+ALTER TABLE tool_categories ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE tools ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
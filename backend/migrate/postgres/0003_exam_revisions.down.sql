@@ -0,0 +1,3 @@
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS exam_revision;
+DROP TABLE IF EXISTS exam_revisions;
+ALTER TABLE exams DROP COLUMN IF EXISTS revision;
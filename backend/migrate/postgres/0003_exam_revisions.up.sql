@@ -0,0 +1,16 @@
+-- Keeps every version of an exam's document requirements. exam_id is not a
+-- foreign key so the history outlives a deleted exam, and conversions keep
+-- pointing at the revision they were validated against.
+
+ALTER TABLE exams ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS exam_revisions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  exam_id VARCHAR(50) NOT NULL,
+  revision INTEGER NOT NULL,
+  documents JSONB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (exam_id, revision)
+);
+
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS exam_revision INTEGER;
@@ -0,0 +1 @@
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS content_type;
@@ -0,0 +1,4 @@
+-- The MIME type detected from an uploaded file's content, so later stages
+-- need not trust the file name.
+
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS content_type VARCHAR(255);
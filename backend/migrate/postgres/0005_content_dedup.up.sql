@@ -0,0 +1,15 @@
+-- Uploads are stored once per SHA-256 and shared between conversions.
+-- blob_refs counts the conversions holding each shared blob, so it is only
+-- deleted with the last one. tool_id and options identify a conversion so a
+-- repeated one can reuse an earlier output.
+
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS input_hash VARCHAR(64);
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS tool_id VARCHAR(50);
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS options TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_input_hash ON conversion_requests(input_hash, tool_id);
+
+CREATE TABLE IF NOT EXISTS blob_refs (
+  blob_key VARCHAR(500) PRIMARY KEY,
+  refs INTEGER NOT NULL CHECK (refs > 0)
+);
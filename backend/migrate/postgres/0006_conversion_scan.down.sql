@@ -0,0 +1,8 @@
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS scan_signature;
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS scan_verdict;
+
+-- Quarantined requests cannot be represented any more
+UPDATE conversion_requests SET status = 'failed' WHERE status = 'quarantined';
+ALTER TABLE conversion_requests DROP CONSTRAINT IF EXISTS status_check;
+ALTER TABLE conversion_requests ADD CONSTRAINT status_check
+  CHECK (status IN ('pending', 'processing', 'completed', 'failed'));
@@ -0,0 +1,9 @@
+-- Uploads are scanned for malware before they can be converted. Infected
+-- ones are quarantined, and the scanner's verdict is kept on the request.
+
+ALTER TABLE conversion_requests DROP CONSTRAINT IF EXISTS status_check;
+ALTER TABLE conversion_requests ADD CONSTRAINT status_check
+  CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'quarantined'));
+
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS scan_verdict VARCHAR(20);
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS scan_signature VARCHAR(255);
@@ -0,0 +1,2 @@
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS output_settings;
+ALTER TABLE conversion_requests DROP COLUMN IF EXISTS output_size;
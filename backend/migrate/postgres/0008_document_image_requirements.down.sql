@@ -0,0 +1,9 @@
+ALTER TABLE documents DROP COLUMN IF EXISTS fit;
+ALTER TABLE documents DROP COLUMN IF EXISTS height_cm;
+ALTER TABLE documents DROP COLUMN IF EXISTS width_cm;
+ALTER TABLE documents DROP COLUMN IF EXISTS dpi;
+ALTER TABLE documents DROP COLUMN IF EXISTS aspect_ratio;
+ALTER TABLE documents DROP COLUMN IF EXISTS max_height;
+ALTER TABLE documents DROP COLUMN IF EXISTS min_height;
+ALTER TABLE documents DROP COLUMN IF EXISTS max_width;
+ALTER TABLE documents DROP COLUMN IF EXISTS min_width;
@@ -0,0 +1,13 @@
+-- Optional image requirements of a document: pixel bounds, a width:height
+-- aspect ratio, a DPI and print size in centimetres, and whether images are
+-- cropped or padded to the required shape.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS min_width INTEGER;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS max_width INTEGER;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS min_height INTEGER;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS max_height INTEGER;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS aspect_ratio VARCHAR(20);
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS dpi INTEGER;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS width_cm DOUBLE PRECISION;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS height_cm DOUBLE PRECISION;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS fit VARCHAR(20);
@@ -0,0 +1,6 @@
+-- kit_id groups the conversions of an exam kit, which prepares several
+-- documents of one exam together and is downloaded as one ZIP.
+
+ALTER TABLE conversion_requests ADD COLUMN IF NOT EXISTS kit_id VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_kit_id ON conversion_requests(kit_id);
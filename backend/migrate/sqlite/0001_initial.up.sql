@@ -0,0 +1,93 @@
+-- Initial schema. Same layout as postgres/0001_initial.up.sql with SQLite
+-- types. IF NOT EXISTS lets it adopt databases created before migrations
+-- existed.
+
+CREATE TABLE IF NOT EXISTS exams (
+  id INTEGER PRIMARY KEY,
+  exam_id TEXT UNIQUE NOT NULL,
+  title TEXT NOT NULL,
+  icon TEXT,
+  description TEXT,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS documents (
+  id INTEGER PRIMARY KEY,
+  document_id TEXT NOT NULL,
+  exam_id TEXT NOT NULL REFERENCES exams(exam_id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  size TEXT,
+  format TEXT,
+  max_size INTEGER,
+  required BOOLEAN DEFAULT 0,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (exam_id, document_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversion_requests (
+  id INTEGER PRIMARY KEY,
+  conversion_id TEXT UNIQUE NOT NULL,
+  user_id TEXT NOT NULL,
+  exam_id TEXT REFERENCES exams(exam_id) ON DELETE SET NULL,
+  document_id TEXT,
+  file_name TEXT NOT NULL,
+  file_size INTEGER NOT NULL,
+  input_path TEXT,
+  output_path TEXT,
+  status TEXT DEFAULT 'pending',
+  error_msg TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT status_check CHECK (status IN ('pending', 'processing', 'completed', 'failed'))
+);
+
+CREATE TABLE IF NOT EXISTS tool_categories (
+  id INTEGER PRIMARY KEY,
+  category_id TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  icon TEXT,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tools (
+  id INTEGER PRIMARY KEY,
+  tool_id TEXT UNIQUE NOT NULL,
+  category TEXT NOT NULL REFERENCES tool_categories(category_id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  description TEXT,
+  logo TEXT,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY,
+  user_id TEXT UNIQUE NOT NULL,
+  email TEXT UNIQUE,
+  name TEXT,
+  avatar_url TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_conversions (
+  id INTEGER PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  conversion_id TEXT NOT NULL REFERENCES conversion_requests(conversion_id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_user_id ON conversion_requests(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_exam_id ON conversion_requests(exam_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_status ON conversion_requests(status);
+CREATE INDEX IF NOT EXISTS idx_documents_exam_id ON documents(exam_id);
+CREATE INDEX IF NOT EXISTS idx_tools_category ON tools(category);
+CREATE INDEX IF NOT EXISTS idx_user_conversions_user_id ON user_conversions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_conversions_conversion_id ON user_conversions(conversion_id);
@@ -0,0 +1,2 @@
+ALTER TABLE tools DROP COLUMN disabled;
+ALTER TABLE tool_categories DROP COLUMN disabled;
@@ -0,0 +1,5 @@
+-- Lets admins hide tools and categories from GET /api/tools without
+-- deleting them.
+
+ALTER TABLE tool_categories ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE tools ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE conversion_requests DROP COLUMN exam_revision;
+DROP TABLE IF EXISTS exam_revisions;
+ALTER TABLE exams DROP COLUMN revision;
@@ -0,0 +1,4 @@
+-- The MIME type detected from an uploaded file's content, so later stages
+-- need not trust the file name.
+
+ALTER TABLE conversion_requests ADD COLUMN content_type TEXT;
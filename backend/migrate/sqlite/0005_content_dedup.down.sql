@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS blob_refs;
+DROP INDEX IF EXISTS idx_conversion_requests_input_hash;
+ALTER TABLE conversion_requests DROP COLUMN options;
+ALTER TABLE conversion_requests DROP COLUMN tool_id;
+ALTER TABLE conversion_requests DROP COLUMN input_hash;
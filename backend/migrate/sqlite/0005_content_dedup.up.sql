@@ -0,0 +1,15 @@
+-- Uploads are stored once per SHA-256 and shared between conversions.
+-- blob_refs counts the conversions holding each shared blob, so it is only
+-- deleted with the last one. tool_id and options identify a conversion so a
+-- repeated one can reuse an earlier output.
+
+ALTER TABLE conversion_requests ADD COLUMN input_hash TEXT;
+ALTER TABLE conversion_requests ADD COLUMN tool_id TEXT;
+ALTER TABLE conversion_requests ADD COLUMN options TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_input_hash ON conversion_requests(input_hash, tool_id);
+
+CREATE TABLE IF NOT EXISTS blob_refs (
+  blob_key TEXT PRIMARY KEY,
+  refs INTEGER NOT NULL CHECK (refs > 0)
+);
@@ -0,0 +1,40 @@
+-- Quarantined requests cannot be represented any more and become failed
+
+CREATE TABLE conversion_requests_new (
+  id INTEGER PRIMARY KEY,
+  conversion_id TEXT UNIQUE NOT NULL,
+  user_id TEXT NOT NULL,
+  exam_id TEXT REFERENCES exams(exam_id) ON DELETE SET NULL,
+  document_id TEXT,
+  file_name TEXT NOT NULL,
+  file_size INTEGER NOT NULL,
+  input_path TEXT,
+  output_path TEXT,
+  status TEXT DEFAULT 'pending',
+  error_msg TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  exam_revision INTEGER,
+  content_type TEXT,
+  input_hash TEXT,
+  tool_id TEXT,
+  options TEXT,
+  CONSTRAINT status_check CHECK (status IN ('pending', 'processing', 'completed', 'failed'))
+);
+INSERT INTO conversion_requests_new (id, conversion_id, user_id, exam_id, document_id, file_name, file_size, input_path, output_path,
+  status, error_msg, created_at, updated_at, exam_revision, content_type, input_hash, tool_id, options)
+SELECT id, conversion_id, user_id, exam_id, document_id, file_name, file_size, input_path, output_path,
+  CASE status WHEN 'quarantined' THEN 'failed' ELSE status END, error_msg, created_at, updated_at, exam_revision, content_type, input_hash, tool_id, options
+FROM conversion_requests;
+
+-- Dropping the old table cascades to user_conversions, so keep its rows
+CREATE TEMP TABLE user_conversions_backup AS SELECT * FROM user_conversions;
+DROP TABLE conversion_requests;
+ALTER TABLE conversion_requests_new RENAME TO conversion_requests;
+INSERT INTO user_conversions SELECT * FROM user_conversions_backup;
+DROP TABLE user_conversions_backup;
+
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_user_id ON conversion_requests(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_exam_id ON conversion_requests(exam_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_status ON conversion_requests(status);
+CREATE INDEX IF NOT EXISTS idx_conversion_requests_input_hash ON conversion_requests(input_hash, tool_id);
@@ -0,0 +1,2 @@
+ALTER TABLE conversion_requests DROP COLUMN output_settings;
+ALTER TABLE conversion_requests DROP COLUMN output_size;
@@ -0,0 +1,5 @@
+-- output_size and output_settings record what a converter produced, e.g.
+-- the JPEG quality and scale chosen to fit a document's size limit.
+
+ALTER TABLE conversion_requests ADD COLUMN output_size INTEGER;
+ALTER TABLE conversion_requests ADD COLUMN output_settings TEXT;
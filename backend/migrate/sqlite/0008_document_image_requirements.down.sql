@@ -0,0 +1,9 @@
+ALTER TABLE documents DROP COLUMN fit;
+ALTER TABLE documents DROP COLUMN height_cm;
+ALTER TABLE documents DROP COLUMN width_cm;
+ALTER TABLE documents DROP COLUMN dpi;
+ALTER TABLE documents DROP COLUMN aspect_ratio;
+ALTER TABLE documents DROP COLUMN max_height;
+ALTER TABLE documents DROP COLUMN min_height;
+ALTER TABLE documents DROP COLUMN max_width;
+ALTER TABLE documents DROP COLUMN min_width;
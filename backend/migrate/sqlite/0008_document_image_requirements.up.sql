@@ -0,0 +1,13 @@
+-- Optional image requirements of a document: pixel bounds, a width:height
+-- aspect ratio, a DPI and print size in centimetres, and whether images are
+-- cropped or padded to the required shape.
+
+ALTER TABLE documents ADD COLUMN min_width INTEGER;
+ALTER TABLE documents ADD COLUMN max_width INTEGER;
+ALTER TABLE documents ADD COLUMN min_height INTEGER;
+ALTER TABLE documents ADD COLUMN max_height INTEGER;
+ALTER TABLE documents ADD COLUMN aspect_ratio TEXT;
+ALTER TABLE documents ADD COLUMN dpi INTEGER;
+ALTER TABLE documents ADD COLUMN width_cm REAL;
+ALTER TABLE documents ADD COLUMN height_cm REAL;
+ALTER TABLE documents ADD COLUMN fit TEXT;
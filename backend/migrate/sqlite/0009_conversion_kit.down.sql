@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_conversion_requests_kit_id;
+ALTER TABLE conversion_requests DROP COLUMN kit_id;
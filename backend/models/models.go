@@ -1,16 +1,52 @@
 package models
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // Exam represents an entrance exam
 type Exam struct {
-	ID          string      `json:"id"`
-	Title       string      `json:"title"`
-	Icon        string      `json:"icon"`
-	Description string      `json:"description"`
-	Documents   []Document  `json:"documents"`
-	CreatedAt   time.Time   `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Icon        string     `json:"icon"`
+	Description string     `json:"description"`
+	Documents   []Document `json:"documents"`
+	Revision    int        `json:"revision,omitempty"` // current revision of Documents, set by storage
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ExamRevision is a snapshot of an exam's document requirements. A new
+// revision is recorded every time the documents change.
+type ExamRevision struct {
+	ExamID    string     `json:"exam_id"`
+	Revision  int        `json:"revision"`
+	Documents []Document `json:"documents"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ExamRevisionDiff describes how the documents changed between two revisions
+type ExamRevisionDiff struct {
+	ExamID  string           `json:"exam_id"`
+	From    int              `json:"from"`
+	To      int              `json:"to"`
+	Added   []Document       `json:"added"`
+	Removed []Document       `json:"removed"`
+	Changed []DocumentChange `json:"changed"`
+}
+
+// DocumentChange lists the fields of one document that differ between revisions
+type DocumentChange struct {
+	DocumentID string        `json:"document_id"`
+	Fields     []FieldChange `json:"fields"`
+}
+
+// FieldChange is the old and new value of one field
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
 }
 
 // Document represents a required document for an exam
@@ -21,6 +57,42 @@ type Document struct {
 	Format   string `json:"format"`
 	MaxSize  int64  `json:"max_size"`
 	Required bool   `json:"required"`
+
+	// Optional image requirements. Pixel bounds are inclusive; AspectRatio
+	// is width:height such as "3:4"; WidthCM and HeightCM give a print size
+	// that, at DPI, fixes the exact pixel size. Fit says how an image is
+	// brought to the required shape: "crop" (the default) or "pad".
+	MinWidth    int     `json:"min_width,omitempty"`
+	MaxWidth    int     `json:"max_width,omitempty"`
+	MinHeight   int     `json:"min_height,omitempty"`
+	MaxHeight   int     `json:"max_height,omitempty"`
+	AspectRatio string  `json:"aspect_ratio,omitempty"`
+	DPI         int     `json:"dpi,omitempty"`
+	WidthCM     float64 `json:"width_cm,omitempty"`
+	HeightCM    float64 `json:"height_cm,omitempty"`
+	Fit         string  `json:"fit,omitempty"`
+}
+
+// Ways a document's image is brought to its required shape
+const (
+	FitCrop = "crop"
+	FitPad  = "pad"
+)
+
+// HasImageRequirements reports whether the document constrains image
+// dimensions, shape or DPI
+func (d Document) HasImageRequirements() bool {
+	return d.MinWidth > 0 || d.MaxWidth > 0 || d.MinHeight > 0 || d.MaxHeight > 0 ||
+		d.AspectRatio != "" || d.DPI > 0 || d.WidthCM > 0 || d.HeightCM > 0
+}
+
+// PixelSize is the exact pixel size fixed by the document's print size and
+// DPI, or zeros if it does not give both
+func (d Document) PixelSize() (width, height int) {
+	if d.WidthCM <= 0 || d.HeightCM <= 0 || d.DPI <= 0 {
+		return 0, 0
+	}
+	return int(math.Round(d.WidthCM / 2.54 * float64(d.DPI))), int(math.Round(d.HeightCM / 2.54 * float64(d.DPI)))
 }
 
 // ConversionRequest represents a file conversion request
@@ -33,10 +105,37 @@ type ConversionRequest struct {
 	FileSize   int64     `json:"file_size"`
 	InputPath  string    `json:"input_path"`
 	OutputPath string    `json:"output_path"`
-	Status     string    `json:"status"` // pending, processing, completed, failed
+	Status     string    `json:"status"` // pending, processing, completed, failed, quarantined
 	ErrorMsg   string    `json:"error_msg,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// ExamRevision is the revision of the exam's documents the request was
+	// validated against
+	ExamRevision int `json:"exam_revision,omitempty"`
+	// ContentType is the MIME type detected from the uploaded file's
+	// content, set once the upload has been validated
+	ContentType string `json:"content_type,omitempty"`
+	// InputHash is the hex SHA-256 of the uploaded file. Uploads with the
+	// same content share one stored blob.
+	InputHash string `json:"input_hash,omitempty"`
+	// ToolID and Options select the conversion to run. A completed
+	// conversion of the same input with the same tool and options is reused.
+	ToolID  string            `json:"tool_id,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	// ScanVerdict is the malware scanner's verdict on the upload, "clean"
+	// or "infected", and empty if it was not scanned. ScanSignature names
+	// the malware found.
+	ScanVerdict   string `json:"scan_verdict,omitempty"`
+	ScanSignature string `json:"scan_signature,omitempty"`
+	// OutputSize is the size of the converted file, and OutputSettings the
+	// settings the converter chose for it, e.g. the JPEG quality that
+	// brought it under a size limit
+	OutputSize     int64             `json:"output_size,omitempty"`
+	OutputSettings map[string]string `json:"output_settings,omitempty"`
+	// KitID groups the conversions of an exam kit, which prepares several
+	// documents of one exam together
+	KitID string `json:"kit_id,omitempty"`
 }
 
 // Tool represents a conversion tool
@@ -47,6 +146,7 @@ type Tool struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Logo        string `json:"logo"`
+	Disabled    bool   `json:"disabled,omitempty"`
 }
 
 // ToolCategory represents a category of tools
@@ -55,6 +155,7 @@ type ToolCategory struct {
 	Category string `json:"category"`
 	Icon     string `json:"icon"`
 	Tools    []Tool `json:"tools"`
+	Disabled bool   `json:"disabled,omitempty"`
 }
 
 // APIResponse is a generic API response wrapper
@@ -67,12 +168,22 @@ type APIResponse struct {
 
 // UploadResponse represents the response after file upload
 type UploadResponse struct {
-	ID        string `json:"id"`
-	FileName  string `json:"file_name"`
-	FileSize  int64  `json:"file_size"`
-	Path      string `json:"path"`
-	Status    string `json:"status"`
-	Message   string `json:"message"`
+	ID          string `json:"id"`
+	FileName    string `json:"file_name"`
+	FileSize    int64  `json:"file_size"`
+	Path        string `json:"path"`
+	ContentType string `json:"content_type,omitempty"`
+	InputHash   string `json:"input_hash,omitempty"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+}
+
+// SignedLinkResponse represents a short-lived link to a conversion's file.
+// URL is relative to the API host.
+type SignedLinkResponse struct {
+	URL       string    `json:"url"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // ConversionResponse represents the response for a conversion operation
@@ -83,6 +194,72 @@ type ConversionResponse struct {
 	OutputFile string `json:"output_file,omitempty"`
 	Progress   int    `json:"progress"`
 	Message    string `json:"message"`
+
+	// OutputSize and OutputSettings describe the converted file once the
+	// conversion has completed
+	OutputSize     int64             `json:"output_size,omitempty"`
+	OutputSettings map[string]string `json:"output_settings,omitempty"`
+}
+
+// DocumentCheck reports whether a file meets the requirements of an exam
+// document. Format is the format detected from the file's content; Width,
+// Height and DPI are given for images and Pages for PDFs, when they could be
+// read.
+type DocumentCheck struct {
+	ExamID     string      `json:"exam_id"`
+	DocumentID string      `json:"document_id"`
+	FileName   string      `json:"file_name"`
+	FileSize   int64       `json:"file_size"`
+	Format     string      `json:"format,omitempty"`
+	MIME       string      `json:"mime,omitempty"`
+	Width      int         `json:"width,omitempty"`
+	Height     int         `json:"height,omitempty"`
+	DPI        int         `json:"dpi,omitempty"`
+	Pages      int         `json:"pages,omitempty"`
+	Compliant  bool        `json:"compliant"`
+	Rules      []RuleCheck `json:"rules"`
+}
+
+// RuleCheck is the outcome of one document rule, with how to fix the file
+// when it fails
+type RuleCheck struct {
+	Rule     string `json:"rule"`
+	Passed   bool   `json:"passed"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// Kit is an exam kit: the conversions preparing several documents of one
+// exam together, downloaded as one ZIP once they have all finished. Status
+// is processing until then, and completed after.
+type Kit struct {
+	ID        string        `json:"id"`
+	UserID    string        `json:"user_id"`
+	ExamID    string        `json:"exam_id"`
+	Status    string        `json:"status"`
+	Documents []KitDocument `json:"documents"`
+	// Missing lists the required documents the kit has no file for
+	Missing []Document `json:"missing"`
+	// Complete is set once every required document has been prepared
+	Complete  bool      `json:"complete"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KitDocument is one document of a kit and the conversion preparing it.
+// FileName is its name in the ZIP, once prepared.
+type KitDocument struct {
+	DocumentID   string `json:"document_id"`
+	DocumentName string `json:"document_name"`
+	ConversionID string `json:"conversion_id"`
+	FileName     string `json:"file_name,omitempty"`
+	ToolID       string `json:"tool_id,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	OutputSize   int64  `json:"output_size,omitempty"`
+	// Check is the prepared file checked against the document, given in
+	// the ZIP's manifest
+	Check *DocumentCheck `json:"check,omitempty"`
 }
 
 // PaginationQuery represents pagination parameters
@@ -90,3 +267,8 @@ type PaginationQuery struct {
 	Page  int `form:"page" binding:"min=1"`
 	Limit int `form:"limit" binding:"min=1,max=100"`
 }
+
+// ReorderRequest lists IDs in their new order
+type ReorderRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
@@ -1,13 +1,25 @@
 package routes
 
 import (
+	"context"
+	"path/filepath"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/convert"
 	"github.com/oneforall/backend/handlers"
+	"github.com/oneforall/backend/middleware"
+	"github.com/oneforall/backend/scan"
+	"github.com/oneforall/backend/signedurl"
 	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/tus"
 )
 
-// SetupRoutes configures all API routes
-func SetupRoutes(store *storage.JSONStorage) *gin.Engine {
+// SetupRoutes configures all API routes. Background work the routes need,
+// such as expiring abandoned uploads, stops when ctx is done.
+func SetupRoutes(ctx context.Context, cfg *config.Config, store storage.Store, blobs blob.Store, scanner scan.Scanner, jobs handlers.JobQueue, registry *convert.Registry) *gin.Engine {
 	router := gin.Default()
 
 	// Add CORS middleware
@@ -21,26 +33,101 @@ func SetupRoutes(store *storage.JSONStorage) *gin.Engine {
 	{
 		// Exam routes
 		examHandler := handlers.NewExamHandler(store)
+		checkHandler := handlers.NewCheckHandler(store, registry, cfg)
 		exams := api.Group("/exams")
 		{
 			exams.GET("", examHandler.GetAllExams)
 			exams.GET("/:id", examHandler.GetExamByID)
+			exams.GET("/:id/revisions", examHandler.GetExamRevisions)
+			exams.GET("/:id/revisions/diff", examHandler.DiffExamRevisions)
+			exams.GET("/:id/revisions/:revision", examHandler.GetExamRevision)
+			exams.POST("/:id/documents/:doc_id/check", checkHandler.CheckDocument)
 		}
 
 		// Tools routes
-		toolHandler := handlers.NewToolHandler(store)
+		toolHandler := handlers.NewToolHandler(store, registry)
 		tools := api.Group("/tools")
 		{
 			tools.GET("", toolHandler.GetAllTools)
 		}
 
 		// Conversion routes
-		convHandler := handlers.NewConversionHandler(store)
+		convHandler := handlers.NewConversionHandler(store, blobs, scanner, jobs, registry, cfg)
 		conversions := api.Group("/conversions")
 		{
 			conversions.POST("/request", convHandler.RequestConversion)
 			conversions.GET("/:id", convHandler.GetConversionStatus)
+			conversions.POST("/:id/upload", convHandler.UploadFile)
+			conversions.GET("/:id/download", convHandler.DownloadOutput)
+			conversions.HEAD("/:id/download", convHandler.DownloadOutput)
 			conversions.GET("/user/:user_id", convHandler.GetUserConversions)
+			conversions.POST("/:id/links", convHandler.CreateSignedLink)
+		}
+
+		// Exam kit routes: one conversion per document of an exam, shared
+		// under a kit ID and downloaded together as a ZIP
+		api.POST("/exams/:id/kits", convHandler.CreateKit)
+		kits := api.Group("/kits")
+		{
+			kits.GET("/:id", convHandler.GetKit)
+			kits.GET("/:id/download", convHandler.DownloadKit)
+		}
+
+		// Signed link routes: the same file endpoints, authorised by the
+		// expires and signature query parameters instead of X-User-ID
+		signed := api.Group("/signed/conversions")
+		{
+			upload := middleware.SignedURL(cfg.URLSigningSecret, signedurl.ScopeUpload)
+			download := middleware.SignedURL(cfg.URLSigningSecret, signedurl.ScopeDownload)
+			signed.POST("/:id/upload", upload, convHandler.UploadFile)
+			signed.GET("/:id/download", download, convHandler.DownloadOutput)
+			signed.HEAD("/:id/download", download, convHandler.DownloadOutput)
+		}
+
+		// Resumable (tus) upload routes. Finished uploads are attached to
+		// the conversion named in their metadata; unfinished ones are
+		// removed UPLOAD_EXPIRY after their last chunk.
+		uploadHandler := tus.NewHandler(tus.Config{
+			Dir:        filepath.Join(cfg.UploadDirectory, "tus"),
+			BasePath:   "/api/uploads",
+			MaxSize:    cfg.MaxFileSize,
+			Expiry:     cfg.UploadExpiry,
+			PreCreate:  convHandler.PrepareResumableUpload,
+			OnComplete: convHandler.CompleteResumableUpload,
+		})
+		go uploadHandler.Watch(ctx, time.Minute)
+		uploads := api.Group("/uploads", uploadHandler.Protocol())
+		{
+			uploads.OPTIONS("", uploadHandler.Options)
+			uploads.POST("", uploadHandler.Create)
+			uploads.OPTIONS("/:id", uploadHandler.Options)
+			uploads.HEAD("/:id", uploadHandler.Head)
+			uploads.PATCH("/:id", uploadHandler.Patch)
+			uploads.DELETE("/:id", uploadHandler.Terminate)
+		}
+
+		// Admin routes
+		adminHandler := handlers.NewAdminHandler(store)
+		admin := api.Group("/admin", middleware.AdminAuth(cfg.AdminToken))
+		{
+			admin.POST("/reload", adminHandler.ReloadCatalog)
+
+			admin.POST("/exams", adminHandler.CreateExam)
+			admin.PUT("/exams/:id", adminHandler.UpdateExam)
+			admin.DELETE("/exams/:id", adminHandler.DeleteExam)
+			admin.POST("/exams/:id/documents", adminHandler.AddDocument)
+			admin.PUT("/exams/:id/documents/:doc_id", adminHandler.UpdateDocument)
+			admin.DELETE("/exams/:id/documents/:doc_id", adminHandler.DeleteDocument)
+
+			admin.GET("/tools", adminHandler.GetAllTools)
+			admin.PUT("/tools/order", adminHandler.ReorderToolCategories)
+			admin.POST("/tools/categories", adminHandler.CreateToolCategory)
+			admin.PUT("/tools/categories/:id", adminHandler.UpdateToolCategory)
+			admin.DELETE("/tools/categories/:id", adminHandler.DeleteToolCategory)
+			admin.PUT("/tools/categories/:id/order", adminHandler.ReorderTools)
+			admin.POST("/tools/categories/:id/tools", adminHandler.CreateTool)
+			admin.PUT("/tools/:tool_id", adminHandler.UpdateTool)
+			admin.DELETE("/tools/:tool_id", adminHandler.DeleteTool)
 		}
 	}
 
@@ -52,10 +139,13 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-User-ID, Range, If-Range, If-None-Match, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Defer-Length, Upload-Concat")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, HEAD, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Range, Accept-Ranges, ETag, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
 
-		if c.Request.Method == "OPTIONS" {
+		// Answer CORS preflights here; other OPTIONS requests (tus
+		// discovery) go to their routes
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
 			c.AbortWithStatus(204)
 			return
 		}
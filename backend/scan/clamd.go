@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is the largest piece of the file sent in one INSTREAM chunk
+const chunkSize = 64 << 10
+
+// Clamd scans files with a running clamd daemon using its INSTREAM
+// command, so clamd needs no access to the backend's files
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a scanner talking to clamd at address on network ("tcp"
+// or "unix"). timeout bounds each scan, including sending the file.
+func NewClamd(network, address string, timeout time.Duration) *Clamd {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Clamd{network: network, address: address, timeout: timeout}
+}
+
+// Scan streams r to clamd and parses its reply, which is "stream: OK" for
+// a clean file and "stream: <signature> FOUND" for an infected one
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := sendStream(conn, r); err != nil {
+		// clamd closes the connection early when the stream exceeds its
+		// StreamMaxLength; its reply says so
+		if reply, readErr := readReply(conn); readErr == nil && reply != "" {
+			return parseReply(reply)
+		}
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// sendStream sends the INSTREAM command followed by r as length-prefixed
+// chunks and the zero-length chunk that ends the stream
+func sendStream(conn net.Conn, r io.Reader) error {
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, werr := w.Write(size[:]); werr != nil {
+				return werr
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading file: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readReply reads clamd's NUL-terminated reply
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply turns a reply to INSTREAM into a result
+func parseReply(reply string) (Result, error) {
+	body := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case body == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(body, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(body, " FOUND")}, nil
+	default:
+		// e.g. "INSTREAM size limit exceeded. ERROR"
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
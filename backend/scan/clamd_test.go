@@ -0,0 +1,214 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM commands on a listener. It records the chunk
+// sizes and data of the last stream, and replies with reply once the
+// terminating zero-length chunk arrives. If limit is set, it replies with
+// clamd's size limit error and closes the connection, without reading the
+// rest, as soon as more than limit bytes have arrived.
+type fakeClamd struct {
+	t        *testing.T
+	listener net.Listener
+	reply    string
+	limit    int
+	done     chan struct{}
+
+	command string
+	chunks  []int
+	data    []byte
+}
+
+func newFakeClamd(t *testing.T, network, address, reply string) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{t: t, listener: listener, reply: reply, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	defer close(f.done)
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		f.t.Errorf("reading command: %v", err)
+		return
+	}
+	f.command = command
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			f.t.Errorf("reading chunk size: %v", err)
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 {
+			break
+		}
+		f.chunks = append(f.chunks, int(n))
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			f.t.Errorf("reading chunk: %v", err)
+			return
+		}
+		f.data = append(f.data, chunk...)
+		if f.limit > 0 && len(f.data) > f.limit {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+	}
+	io.WriteString(conn, f.reply)
+}
+
+// scan sends data to the fake and waits for it to finish with the stream
+func (f *fakeClamd) scan(data []byte) (Result, error) {
+	clamd := NewClamd(f.listener.Addr().Network(), f.listener.Addr().String(), 5*time.Second)
+	result, err := clamd.Scan(context.Background(), bytes.NewReader(data))
+	<-f.done
+	return result, err
+}
+
+func TestClamdChunkFraming(t *testing.T) {
+	f := newFakeClamd(t, "tcp", "127.0.0.1:0", "stream: OK\x00")
+	data := bytes.Repeat([]byte("0123456789"), (2*chunkSize+100)/10)
+
+	result, err := f.scan(data)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if result.Infected || result.Verdict() != VerdictClean {
+		t.Errorf("Scan = %+v; want clean", result)
+	}
+	if f.command != "zINSTREAM\x00" {
+		t.Errorf("command = %q; want zINSTREAM", f.command)
+	}
+	want := []int{chunkSize, chunkSize, len(data) - 2*chunkSize}
+	if len(f.chunks) != len(want) || f.chunks[0] != want[0] || f.chunks[1] != want[1] || f.chunks[2] != want[2] {
+		t.Errorf("chunks = %v; want %v", f.chunks, want)
+	}
+	if !bytes.Equal(f.data, data) {
+		t.Error("data received differs from the file sent")
+	}
+}
+
+func TestClamdEmptyFile(t *testing.T) {
+	f := newFakeClamd(t, "tcp", "127.0.0.1:0", "stream: OK\x00")
+	if _, err := f.scan(nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(f.chunks) != 0 {
+		t.Errorf("chunks = %v; want only the terminating chunk", f.chunks)
+	}
+}
+
+func TestClamdReplies(t *testing.T) {
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		err       string
+	}{
+		{reply: "stream: OK\x00"},
+		{reply: "stream: Eicar-Test-Signature FOUND\x00", infected: true, signature: "Eicar-Test-Signature"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND\n\x00", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{reply: "stream: Can't allocate memory ERROR\x00", err: "Can't allocate memory ERROR"},
+		// clamd closes the connection without a NUL after some errors
+		{reply: "stream: lstat() failed. ERROR", err: "lstat() failed. ERROR"},
+	}
+	for _, tt := range tests {
+		f := newFakeClamd(t, "tcp", "127.0.0.1:0", tt.reply)
+		result, err := f.scan([]byte("file"))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("reply %q: Scan = %+v, %v; want error %q", tt.reply, result, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("reply %q: Scan: %v", tt.reply, err)
+			continue
+		}
+		if result.Infected != tt.infected || result.Signature != tt.signature {
+			t.Errorf("reply %q: Scan = %+v; want infected %v, signature %q", tt.reply, result, tt.infected, tt.signature)
+		}
+	}
+}
+
+// TestClamdSizeLimit covers clamd closing the connection mid-stream when
+// the file exceeds its StreamMaxLength: sending fails, and the reply it
+// left explains why. A Unix socket keeps the reply readable after the
+// close, as clamd's is in practice, where a TCP reset could discard it.
+func TestClamdSizeLimit(t *testing.T) {
+	f := newFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"), "stream: OK\x00")
+	f.limit = chunkSize
+	data := make([]byte, 64*chunkSize)
+
+	_, err := f.scan(data)
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan = %v; want the size limit error", err)
+	}
+	if len(f.data) >= len(data) {
+		t.Error("fake read the whole stream; the early close was not exercised")
+	}
+}
+
+func TestClamdTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accept and read, but never reply
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	clamd := NewClamd("tcp", listener.Addr().String(), 100*time.Millisecond)
+	start := time.Now()
+	if _, err := clamd.Scan(context.Background(), strings.NewReader("file")); err == nil {
+		t.Fatal("Scan without a reply succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Scan took %s; want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	if _, err := NewClamd("tcp", address, time.Second).Scan(context.Background(), strings.NewReader("file")); err == nil {
+		t.Fatal("Scan with clamd down succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+// Package scan checks uploaded files for malware before they are converted
+// and shared onwards
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/oneforall/backend/config"
+)
+
+// Verdicts recorded on a conversion request
+const (
+	VerdictClean    = "clean"
+	VerdictInfected = "infected"
+)
+
+// Result is a scanner's verdict on one file
+type Result struct {
+	// Infected is set when the scanner found malware
+	Infected bool
+	// Signature names what was found, e.g. "Eicar-Test-Signature"
+	Signature string
+}
+
+// Verdict is the value stored for the result, VerdictClean or VerdictInfected
+func (r Result) Verdict() string {
+	if r.Infected {
+		return VerdictInfected
+	}
+	return VerdictClean
+}
+
+// Scanner is implemented by each malware scanner
+type Scanner interface {
+	// Scan reads r to the end and reports whether it is infected. An error
+	// means no verdict could be reached; the file must not be trusted.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// New creates the scanner configured by CLAMD_ADDRESS, or nil if scanning
+// is disabled
+func New(cfg *config.Config) (Scanner, error) {
+	if cfg.ClamdAddress == "" {
+		return nil, nil
+	}
+	network, address, err := parseAddress(cfg.ClamdAddress)
+	if err != nil {
+		return nil, err
+	}
+	return NewClamd(network, address, cfg.ClamdTimeout), nil
+}
+
+// parseAddress splits "tcp://host:port" or "unix:///path/to/clamd.sock"
+// into a network and address for net.Dial
+func parseAddress(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return "tcp", strings.TrimPrefix(addr, "tcp://"), nil
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://"), nil
+	default:
+		return "", "", fmt.Errorf("invalid CLAMD_ADDRESS %q: want tcp://host:port or unix:///path", addr)
+	}
+}
+
+// defaultTimeout bounds a whole scan when no timeout is configured
+const defaultTimeout = 2 * time.Minute
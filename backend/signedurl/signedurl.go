@@ -0,0 +1,92 @@
+// Package signedurl creates and checks short-lived links that grant access to
+// one conversion's upload or download without any other credentials. A link
+// carries its expiry and an HMAC-SHA256 over the scope, conversion ID and
+// expiry, keyed with a server-side secret.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Scope says what a signed link may be used for
+type Scope string
+
+const (
+	// ScopeUpload allows uploading the input of a conversion
+	ScopeUpload Scope = "upload"
+	// ScopeDownload allows downloading the output of a conversion
+	ScopeDownload Scope = "download"
+)
+
+// Query parameters carried by a signed link
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+// ErrExpired is returned for a correctly signed link past its expiry
+var ErrExpired = errors.New("link has expired")
+
+// ErrInvalid is returned for a link with a missing or wrong signature
+var ErrInvalid = errors.New("invalid link signature")
+
+// ValidScope reports whether s names a known scope
+func ValidScope(s Scope) bool {
+	return s == ScopeUpload || s == ScopeDownload
+}
+
+// Signer signs and verifies links with one secret
+type Signer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// New creates a signer for secret
+func New(secret string) *Signer {
+	return &Signer{secret: []byte(secret), now: time.Now}
+}
+
+// Sign returns the query parameters that make a link valid for scope on
+// conversionID until expires
+func (s *Signer) Sign(scope Scope, conversionID string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		ExpiresParam:   {exp},
+		SignatureParam: {s.signature(scope, conversionID, exp)},
+	}
+}
+
+// Verify checks the parameters of a link used for scope on conversionID
+func (s *Signer) Verify(scope Scope, conversionID string, query url.Values) error {
+	exp := query.Get(ExpiresParam)
+	given, err := base64.RawURLEncoding.DecodeString(query.Get(SignatureParam))
+	if exp == "" || err != nil {
+		return ErrInvalid
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(s.signature(scope, conversionID, exp))
+	if !hmac.Equal(given, want) {
+		return ErrInvalid
+	}
+	// Only trust the expiry once the signature shows it was not altered
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if s.now().Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// signature is the URL-safe HMAC of a link's scope, conversion and expiry
+func (s *Signer) signature(scope Scope, conversionID, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("1forall-link-v1\n" + string(scope) + "\n" + conversionID + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
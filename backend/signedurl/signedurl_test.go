@@ -0,0 +1,95 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := New("secret")
+	signer.now = func() time.Time { return now }
+	valid := signer.Sign(ScopeDownload, "conv-1", now.Add(time.Hour))
+
+	// with returns a copy of valid with key set to value, or removed if
+	// value is empty
+	with := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range valid {
+			q[k] = append([]string(nil), v...)
+		}
+		if value == "" {
+			q.Del(key)
+		} else {
+			q.Set(key, value)
+		}
+		return q
+	}
+	flipped := []byte(valid.Get(SignatureParam))
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		scope  Scope
+		id     string
+		query  url.Values
+		want   error
+	}{
+		{"valid", signer, ScopeDownload, "conv-1", valid, nil},
+		{"other scope", signer, ScopeUpload, "conv-1", valid, ErrInvalid},
+		{"other conversion", signer, ScopeDownload, "conv-2", valid, ErrInvalid},
+		{"other secret", New("other"), ScopeDownload, "conv-1", valid, ErrInvalid},
+		{"tampered signature", signer, ScopeDownload, "conv-1", with(SignatureParam, string(flipped)), ErrInvalid},
+		{"signature not base64", signer, ScopeDownload, "conv-1", with(SignatureParam, "!!!"), ErrInvalid},
+		{"truncated signature", signer, ScopeDownload, "conv-1", with(SignatureParam, valid.Get(SignatureParam)[:10]), ErrInvalid},
+		{"missing signature", signer, ScopeDownload, "conv-1", with(SignatureParam, ""), ErrInvalid},
+		{"missing expiry", signer, ScopeDownload, "conv-1", with(ExpiresParam, ""), ErrInvalid},
+		{"extended expiry", signer, ScopeDownload, "conv-1", with(ExpiresParam, strconv.FormatInt(now.Add(24*time.Hour).Unix(), 10)), ErrInvalid},
+		{"no parameters", signer, ScopeDownload, "conv-1", url.Values{}, ErrInvalid},
+	}
+	for _, tt := range tests {
+		err := tt.signer.Verify(tt.scope, tt.id, tt.query)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	issued := time.Unix(1700000000, 0)
+	signer := New("secret")
+	query := signer.Sign(ScopeUpload, "conv-1", issued.Add(time.Minute))
+
+	tests := []struct {
+		at   time.Time
+		want error
+	}{
+		{issued, nil},
+		{issued.Add(time.Minute - time.Second), nil},
+		// A link is expired from its expiry second on
+		{issued.Add(time.Minute), ErrExpired},
+		{issued.Add(time.Hour), ErrExpired},
+	}
+	for _, tt := range tests {
+		signer.now = func() time.Time { return tt.at }
+		if err := signer.Verify(ScopeUpload, "conv-1", query); err != tt.want {
+			t.Errorf("Verify %s after issue = %v; want %v", tt.at.Sub(issued), err, tt.want)
+		}
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for scope, want := range map[Scope]bool{ScopeUpload: true, ScopeDownload: true, "": false, "admin": false, "Download": false} {
+		if got := ValidScope(scope); got != want {
+			t.Errorf("ValidScope(%q) = %v; want %v", scope, got, want)
+		}
+	}
+}
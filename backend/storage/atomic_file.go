@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotCount is how many previous versions of a data file are kept
+// next to it as file.1 (newest) to file.N (oldest)
+const snapshotCount = 3
+
+// CorruptFileError reports a data file that exists but cannot be parsed
+type CorruptFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptFileError) Error() string {
+	return fmt.Sprintf("%s is corrupt: %v", e.Path, e.Err)
+}
+
+func (e *CorruptFileError) Unwrap() error {
+	return e.Err
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the
+// old or the new content in place, never a partial write. The previous
+// content is rotated into the numbered snapshots first.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+
+	if err := rotateSnapshots(path); err != nil {
+		return fmt.Errorf("failed to rotate snapshots: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateSnapshots shifts path.1..path.N-1 up by one and links the current
+// file as path.1. The current file stays in place until it is replaced.
+func rotateSnapshots(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	for i := snapshotCount - 1; i >= 1; i-- {
+		from := snapshotPath(path, i)
+		if err := os.Rename(from, snapshotPath(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	newest := snapshotPath(path, 1)
+	if err := os.Link(path, newest); err != nil {
+		// Hard links are not available everywhere; fall back to a copy
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(newest, data, 0644)
+	}
+	return nil
+}
+
+// snapshotPath returns the name of the n-th previous version of path
+func snapshotPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// syncDir flushes a directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms cannot fsync directories; the rename is still atomic
+	// there, only its durability is left to the OS, so the error is ignored
+	d.Sync()
+	return nil
+}
+
+// readJSONWithRecovery decodes path into v. If path is missing or corrupt it
+// falls back to the newest snapshot that decodes, moving a corrupt file aside
+// as path.corrupt-<timestamp> so it can be inspected. v is left untouched if
+// neither the file nor any snapshot exists.
+func readJSONWithRecovery(path string, v interface{}) error {
+	candidates := []string{path}
+	for i := 1; i <= snapshotCount; i++ {
+		candidates = append(candidates, snapshotPath(path, i))
+	}
+
+	var corrupt []error
+	for _, candidate := range candidates {
+		data, err := ioutil.ReadFile(candidate)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := decodeJSONFile(data, v); err != nil {
+			corrupt = append(corrupt, &CorruptFileError{Path: candidate, Err: err})
+			continue
+		}
+
+		if candidate != path {
+			for _, err := range corrupt {
+				log.Printf("⚠ %v", err)
+			}
+			log.Printf("⚠ Recovered %s from snapshot %s", path, candidate)
+			if err := quarantineCorrupt(path); err != nil {
+				return err
+			}
+			if err := writeFileAtomic(path, data, 0644); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+		}
+		return nil
+	}
+
+	if len(corrupt) > 0 {
+		return fmt.Errorf("no usable copy of %s: %w", path, errors.Join(corrupt...))
+	}
+	return nil
+}
+
+// encodeJSONFile formats v the way the hand-edited data files are laid out:
+// two-space indent, without escaping characters such as "<" in "< 2MB"
+func encodeJSONFile(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJSONFile rejects empty files, which json.Unmarshal reports vaguely
+func decodeJSONFile(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("file is empty")
+	}
+	return json.Unmarshal(data, v)
+}
+
+// quarantineCorrupt moves a corrupt data file out of the way, if present
+func quarantineCorrupt(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	aside := corruptPath(path)
+	if err := os.Rename(path, aside); err != nil {
+		return fmt.Errorf("failed to move corrupt %s aside: %w", path, err)
+	}
+	log.Printf("⚠ Moved corrupt %s to %s", path, aside)
+	return nil
+}
+
+// corruptPath returns the name a corrupt copy of path is kept under
+func corruptPath(path string) string {
+	return fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+}
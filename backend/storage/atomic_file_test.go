@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readFile returns the content of path, or "" if it is missing
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	for _, content := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		if err := writeFileAtomic(path, []byte(content), 0640); err != nil {
+			t.Fatalf("writeFileAtomic(%s): %v", content, err)
+		}
+	}
+
+	if got := readFile(t, path); got != "v5" {
+		t.Errorf("file = %q; want v5", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v; want 0640", info.Mode().Perm())
+	}
+
+	// The previous versions rotate through path.1 (newest) to path.N
+	for n, want := range []string{"v4", "v3", "v2"} {
+		if got := readFile(t, snapshotPath(path, n+1)); got != want {
+			t.Errorf("snapshot %d = %q; want %q", n+1, got, want)
+		}
+	}
+	if _, err := os.Stat(snapshotPath(path, snapshotCount+1)); !os.IsNotExist(err) {
+		t.Errorf("snapshot %d exists; want only %d kept", snapshotCount+1, snapshotCount)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomicFailureLeavesNoTempFile(t *testing.T) {
+	dir := t.TempDir()
+	// A non-empty directory where the file should go makes the write fail
+	path := filepath.Join(dir, "data.json")
+	if err := os.MkdirAll(filepath.Join(path, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new"), 0644); err == nil {
+		t.Fatal("write over a non-empty directory succeeded")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "data.json" {
+			t.Errorf("failed write left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestReadJSONWithRecovery(t *testing.T) {
+	type doc struct {
+		Version int `json:"version"`
+	}
+	tests := []struct {
+		name string
+		// files maps snapshot numbers to content, 0 being the file itself;
+		// missing entries are missing files
+		files       map[int]string
+		want        int
+		wantErr     bool
+		wantCorrupt bool
+	}{
+		{"primary", map[int]string{0: `{"version":3}`, 1: `{"version":2}`}, 3, false, false},
+		{"corrupt primary", map[int]string{0: `{"vers`, 1: `{"version":2}`}, 2, false, true},
+		{"empty primary", map[int]string{0: ``, 1: `{"version":2}`}, 2, false, true},
+		{"missing primary", map[int]string{1: `{"version":2}`}, 2, false, false},
+		{"corrupt newest snapshot", map[int]string{0: `x`, 1: `y`, 2: `{"version":1}`}, 1, false, true},
+		{"nothing usable", map[int]string{0: `x`, 1: `y`}, 0, true, false},
+		{"no files", map[int]string{}, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "data.json")
+			for n, content := range tt.files {
+				name := path
+				if n > 0 {
+					name = snapshotPath(path, n)
+				}
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got doc
+			err := readJSONWithRecovery(path, &got)
+			if tt.wantErr {
+				var corrupt *CorruptFileError
+				if !errors.As(err, &corrupt) {
+					t.Fatalf("readJSONWithRecovery = %v; want a CorruptFileError", err)
+				}
+				if got.Version != 0 {
+					t.Errorf("decoded %+v from corrupt files", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readJSONWithRecovery: %v", err)
+			}
+			if got.Version != tt.want {
+				t.Errorf("version = %d; want %d", got.Version, tt.want)
+			}
+
+			aside, _ := filepath.Glob(path + ".corrupt-*")
+			if tt.wantCorrupt {
+				if len(aside) != 1 {
+					t.Fatalf("corrupt copies = %v; want one", aside)
+				}
+				if readFile(t, aside[0]) != tt.files[0] {
+					t.Errorf("corrupt copy = %q; want the old primary %q", readFile(t, aside[0]), tt.files[0])
+				}
+			} else if len(aside) != 0 {
+				t.Errorf("corrupt copies = %v; want none", aside)
+			}
+
+			// A recovered file is restored in place from the snapshot
+			if _, ok := tt.files[0]; ok || tt.want != 0 {
+				var again doc
+				if err := decodeJSONFile([]byte(readFile(t, path)), &again); err != nil || again.Version != tt.want {
+					t.Errorf("file after recovery = %q; want version %d", readFile(t, path), tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/oneforall/backend/models"
+)
+
+// optionsKey encodes conversion options canonically, with sorted keys, so
+// equal options compare and store equal. No options encode as "".
+func optionsKey(options map[string]string) string {
+	if len(options) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(options) // maps are marshalled in key order
+	return string(data)
+}
+
+// parseOptionsKey decodes options stored by optionsKey
+func parseOptionsKey(key string) (map[string]string, error) {
+	if key == "" {
+		return nil, nil
+	}
+	var options map[string]string
+	if err := json.Unmarshal([]byte(key), &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// findCachedConversion picks the most recently updated completed conversion
+// with a reusable output among conversions, for the in-process stores
+func findCachedConversion(conversions []models.ConversionRequest, inputHash, toolID string, options map[string]string) (models.ConversionRequest, bool) {
+	opts := optionsKey(options)
+	var found models.ConversionRequest
+	ok := false
+	for _, conv := range conversions {
+		if conv.Status != "completed" || conv.OutputPath == "" ||
+			conv.InputHash != inputHash || conv.ToolID != toolID || optionsKey(conv.Options) != opts {
+			continue
+		}
+		if !ok || conv.UpdatedAt.After(found.UpdatedAt) {
+			found, ok = conv, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// The helpers below implement exam edits for the slice-backed stores. They
+// never modify the slice they are given, so readers still holding the old
+// one are unaffected.
+
+// withExamAdded returns exams plus a validated new exam with fresh timestamps
+func withExamAdded(exams []models.Exam, exam models.Exam, now time.Time) ([]models.Exam, *models.Exam, error) {
+	for _, existing := range exams {
+		if existing.ID == exam.ID {
+			return nil, nil, fmt.Errorf("exam %s: %w", exam.ID, ErrConflict)
+		}
+	}
+	if exam.Documents == nil {
+		exam.Documents = []models.Document{}
+	}
+	exam.CreatedAt = now
+	exam.UpdatedAt = now
+	if err := ValidateExam(exam); err != nil {
+		return nil, nil, err
+	}
+
+	updated := make([]models.Exam, 0, len(exams)+1)
+	updated = append(updated, exams...)
+	updated = append(updated, exam)
+	return updated, &exam, nil
+}
+
+// withExamReplaced returns exams with the exam of the same ID replaced,
+// keeping its CreatedAt and bumping UpdatedAt
+func withExamReplaced(exams []models.Exam, exam models.Exam, now time.Time) ([]models.Exam, *models.Exam, error) {
+	for i, existing := range exams {
+		if existing.ID != exam.ID {
+			continue
+		}
+		if exam.Documents == nil {
+			exam.Documents = []models.Document{}
+		}
+		exam.CreatedAt = existing.CreatedAt
+		exam.UpdatedAt = now
+		if err := ValidateExam(exam); err != nil {
+			return nil, nil, err
+		}
+
+		updated := make([]models.Exam, len(exams))
+		copy(updated, exams)
+		updated[i] = exam
+		return updated, &exam, nil
+	}
+	return nil, nil, fmt.Errorf("exam %s: %w", exam.ID, ErrNotFound)
+}
+
+// examWithDocuments returns the exam with the given ID with its documents
+// replaced by docs, or ErrStale if its documents are no longer at revision
+func examWithDocuments(exams []models.Exam, examID string, revision int, docs []models.Document) (models.Exam, error) {
+	exam := findExam(exams, examID)
+	if exam == nil {
+		return models.Exam{}, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+	}
+	if exam.Revision != revision {
+		return models.Exam{}, fmt.Errorf("exam %s is at revision %d, not %d: %w", examID, exam.Revision, revision, ErrStale)
+	}
+	updated := *exam
+	updated.Documents = docs
+	return updated, nil
+}
+
+// withExamRemoved returns exams without the exam with the given ID
+func withExamRemoved(exams []models.Exam, examID string) ([]models.Exam, error) {
+	for i, existing := range exams {
+		if existing.ID != examID {
+			continue
+		}
+		updated := make([]models.Exam, 0, len(exams)-1)
+		updated = append(updated, exams[:i]...)
+		updated = append(updated, exams[i+1:]...)
+		return updated, nil
+	}
+	return nil, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+}
+
+// The tool helpers work the same way on a deep copy of the categories, so
+// the tool slices of the old catalogue are never touched either.
+
+// copyTools returns a copy of categories that shares no slices with it
+func copyTools(categories []models.ToolCategory) []models.ToolCategory {
+	copied := make([]models.ToolCategory, len(categories))
+	for i, cat := range categories {
+		cat.Tools = append([]models.Tool{}, cat.Tools...)
+		copied[i] = cat
+	}
+	return copied
+}
+
+// findToolCategory returns the index of the category with the given ID, or -1
+func findToolCategory(categories []models.ToolCategory, categoryID string) int {
+	for i, cat := range categories {
+		if cat.ID == categoryID {
+			return i
+		}
+	}
+	return -1
+}
+
+// findTool returns the category and tool index of the tool with the given
+// ID, or -1, -1
+func findTool(categories []models.ToolCategory, toolID string) (int, int) {
+	for i, cat := range categories {
+		for j, tool := range cat.Tools {
+			if tool.ID == toolID {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// withToolCategoryAdded returns categories plus a new category at the end
+func withToolCategoryAdded(categories []models.ToolCategory, cat models.ToolCategory) ([]models.ToolCategory, *models.ToolCategory, error) {
+	if findToolCategory(categories, cat.ID) >= 0 {
+		return nil, nil, fmt.Errorf("tool category %s: %w", cat.ID, ErrConflict)
+	}
+	for _, tool := range cat.Tools {
+		if i, _ := findTool(categories, tool.ID); i >= 0 {
+			return nil, nil, fmt.Errorf("tool %s: %w", tool.ID, ErrConflict)
+		}
+	}
+	cat.Tools = append([]models.Tool{}, cat.Tools...)
+
+	updated := append(copyTools(categories), cat)
+	if err := ValidateTools(updated); err != nil {
+		return nil, nil, err
+	}
+	return updated, &cat, nil
+}
+
+// withToolCategoryReplaced returns categories with the name, icon and
+// disabled flag of one category replaced; its tools are kept
+func withToolCategoryReplaced(categories []models.ToolCategory, cat models.ToolCategory) ([]models.ToolCategory, *models.ToolCategory, error) {
+	i := findToolCategory(categories, cat.ID)
+	if i < 0 {
+		return nil, nil, fmt.Errorf("tool category %s: %w", cat.ID, ErrNotFound)
+	}
+	updated := copyTools(categories)
+	cat.Tools = updated[i].Tools
+	updated[i] = cat
+	if err := ValidateTools(updated); err != nil {
+		return nil, nil, err
+	}
+	return updated, &cat, nil
+}
+
+// withToolCategoryRemoved returns categories without the given category
+func withToolCategoryRemoved(categories []models.ToolCategory, categoryID string) ([]models.ToolCategory, error) {
+	i := findToolCategory(categories, categoryID)
+	if i < 0 {
+		return nil, fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	updated := copyTools(categories)
+	return append(updated[:i], updated[i+1:]...), nil
+}
+
+// withToolCategoriesReordered returns categories in the order given by ids,
+// which must name every category exactly once
+func withToolCategoriesReordered(categories []models.ToolCategory, ids []string) ([]models.ToolCategory, error) {
+	if err := checkPermutation("tool categories", len(categories), ids, func(id string) bool {
+		return findToolCategory(categories, id) >= 0
+	}); err != nil {
+		return nil, err
+	}
+	current := copyTools(categories)
+	updated := make([]models.ToolCategory, 0, len(categories))
+	for _, id := range ids {
+		updated = append(updated, current[findToolCategory(current, id)])
+	}
+	return updated, nil
+}
+
+// withToolAdded returns categories with tool appended to a category
+func withToolAdded(categories []models.ToolCategory, categoryID string, tool models.Tool) ([]models.ToolCategory, *models.Tool, error) {
+	i := findToolCategory(categories, categoryID)
+	if i < 0 {
+		return nil, nil, fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	if c, _ := findTool(categories, tool.ID); c >= 0 {
+		return nil, nil, fmt.Errorf("tool %s: %w", tool.ID, ErrConflict)
+	}
+	updated := copyTools(categories)
+	updated[i].Tools = append(updated[i].Tools, tool)
+	if err := ValidateTools(updated); err != nil {
+		return nil, nil, err
+	}
+	return updated, &tool, nil
+}
+
+// withToolReplaced returns categories with the tool of the same ID replaced
+func withToolReplaced(categories []models.ToolCategory, tool models.Tool) ([]models.ToolCategory, *models.Tool, error) {
+	i, j := findTool(categories, tool.ID)
+	if i < 0 {
+		return nil, nil, fmt.Errorf("tool %s: %w", tool.ID, ErrNotFound)
+	}
+	updated := copyTools(categories)
+	updated[i].Tools[j] = tool
+	if err := ValidateTools(updated); err != nil {
+		return nil, nil, err
+	}
+	return updated, &tool, nil
+}
+
+// withToolRemoved returns categories without the given tool
+func withToolRemoved(categories []models.ToolCategory, toolID string) ([]models.ToolCategory, error) {
+	i, j := findTool(categories, toolID)
+	if i < 0 {
+		return nil, fmt.Errorf("tool %s: %w", toolID, ErrNotFound)
+	}
+	updated := copyTools(categories)
+	updated[i].Tools = append(updated[i].Tools[:j], updated[i].Tools[j+1:]...)
+	return updated, nil
+}
+
+// withToolsReordered returns categories with the tools of one category in the
+// order given by ids, which must name every tool in it exactly once
+func withToolsReordered(categories []models.ToolCategory, categoryID string, ids []string) ([]models.ToolCategory, error) {
+	i := findToolCategory(categories, categoryID)
+	if i < 0 {
+		return nil, fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	updated := copyTools(categories)
+	tools := updated[i].Tools
+	byID := make(map[string]models.Tool, len(tools))
+	for _, tool := range tools {
+		byID[tool.ID] = tool
+	}
+	if err := checkPermutation("tools", len(tools), ids, func(id string) bool {
+		_, ok := byID[id]
+		return ok
+	}); err != nil {
+		return nil, err
+	}
+	reordered := make([]models.Tool, 0, len(tools))
+	for _, id := range ids {
+		reordered = append(reordered, byID[id])
+	}
+	updated[i].Tools = reordered
+	return updated, nil
+}
+
+// checkPermutation makes sure ids names each of the n existing items once
+func checkPermutation(what string, n int, ids []string, exists func(string) bool) error {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !exists(id) {
+			return fmt.Errorf("%w: unknown id %q in %s order", ErrInvalid, id, what)
+		}
+		if seen[id] {
+			return fmt.Errorf("%w: id %q listed twice in %s order", ErrInvalid, id, what)
+		}
+		seen[id] = true
+	}
+	if len(ids) != n {
+		return fmt.Errorf("%w: %s order must list all %d ids, got %d", ErrInvalid, what, n, len(ids))
+	}
+	return nil
+}
+
+// conversionsWithStatus returns the conversions with a status, oldest first
+func conversionsWithStatus(conversions []models.ConversionRequest, status string) []models.ConversionRequest {
+	var matched []models.ConversionRequest
+	for _, conv := range conversions {
+		if conv.Status == status {
+			matched = append(matched, conv)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+	})
+	return matched
+}
+
+// conversionsInKit returns the conversions of an exam kit, oldest first
+func conversionsInKit(conversions []models.ConversionRequest, kitID string) []models.ConversionRequest {
+	var matched []models.ConversionRequest
+	for _, conv := range conversions {
+		if conv.KitID == kitID {
+			matched = append(matched, conv)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+	})
+	return matched
+}
+
+// withFixedConversionFields copies the fields SaveConversion never changes
+// from the stored request onto conv
+func withFixedConversionFields(conv, stored models.ConversionRequest) models.ConversionRequest {
+	conv.UserID = stored.UserID
+	conv.ExamID = stored.ExamID
+	conv.DocumentID = stored.DocumentID
+	conv.ExamRevision = stored.ExamRevision
+	conv.ToolID = stored.ToolID
+	conv.Options = stored.Options
+	conv.KitID = stored.KitID
+	conv.CreatedAt = stored.CreatedAt
+	return conv
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// Importer is implemented by backends that can bulk load existing data.
+// Imports overwrite rows with the same IDs, so running one twice is harmless.
+type Importer interface {
+	ImportExams(ctx context.Context, exams []models.Exam) error
+	ImportExamRevisions(ctx context.Context, revisions []models.ExamRevision) error
+	ImportTools(ctx context.Context, categories []models.ToolCategory) error
+	ImportConversions(ctx context.Context, conversions []models.ConversionRequest) error
+	ImportBlobRefs(ctx context.Context, refs map[string]int) error
+}
+
+// ImportStats reports how many records ImportJSON copied
+type ImportStats struct {
+	Exams       int
+	Revisions   int
+	Tools       int
+	Conversions int
+	BlobRefs    int
+}
+
+// ImportJSON copies exams.json, exam_revisions.json, tools.json,
+// conversions.json and blob_refs.json from dataDir into dst. Missing files are skipped; unreadable ones abort the import.
+func ImportJSON(ctx context.Context, dst Importer, dataDir string) (ImportStats, error) {
+	var stats ImportStats
+	src := NewJSONStorage(dataDir)
+
+	if err := src.loadExams(); err != nil && !os.IsNotExist(err) {
+		return stats, fmt.Errorf("failed to read %s: %w", src.examsFile, err)
+	}
+	if err := src.loadRevisions(); err != nil {
+		return stats, fmt.Errorf("failed to read %s: %w", src.revisionsFile, err)
+	}
+	// Fill in the revision numbers (and any edits not yet recorded) the way
+	// the JSON backend would on startup, without writing to dataDir
+	addRevisions(src.revisions, pendingRevisions(src.revisions, src.exams, src.exams, time.Now()))
+	if err := src.loadTools(); err != nil && !os.IsNotExist(err) {
+		return stats, fmt.Errorf("failed to read %s: %w", src.toolsFile, err)
+	}
+	// Blob references first, as the conversion journal replays onto them
+	if err := src.loadBlobRefs(); err != nil {
+		return stats, fmt.Errorf("failed to read %s: %w", src.blobRefsFile, err)
+	}
+	if err := src.loadConversions(); err != nil {
+		return stats, fmt.Errorf("failed to read %s: %w", src.conversionsFile, err)
+	}
+
+	// Exams go first: conversions reference them
+	if err := dst.ImportExams(ctx, src.exams); err != nil {
+		return stats, err
+	}
+	stats.Exams = len(src.exams)
+
+	var revisions []models.ExamRevision
+	for _, revs := range src.revisions {
+		revisions = append(revisions, revs...)
+	}
+	if err := dst.ImportExamRevisions(ctx, revisions); err != nil {
+		return stats, err
+	}
+	stats.Revisions = len(revisions)
+
+	if err := dst.ImportTools(ctx, src.tools); err != nil {
+		return stats, err
+	}
+	for _, cat := range src.tools {
+		stats.Tools += len(cat.Tools)
+	}
+
+	if err := dst.ImportConversions(ctx, src.conversions); err != nil {
+		return stats, err
+	}
+	stats.Conversions = len(src.conversions)
+
+	if err := dst.ImportBlobRefs(ctx, src.blobRefs); err != nil {
+		return stats, err
+	}
+	stats.BlobRefs = len(src.blobRefs)
+
+	return stats, nil
+}
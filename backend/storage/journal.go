@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// journalCompactEvery is how many journal entries are written before the
+// journal is folded into a new conversions.json snapshot
+const journalCompactEvery = 1000
+
+// Journal operations
+const (
+	journalOpCreate  = "create"
+	journalOpUpdate  = "update"
+	journalOpBlobRef = "blob_ref"
+)
+
+// journalEntry is one line of conversions.journal. It carries the whole
+// conversion as it was after the change, or a blob's reference count after
+// it, so replaying an entry twice is harmless.
+type journalEntry struct {
+	Op         string                    `json:"op"`
+	At         time.Time                 `json:"at"`
+	Conversion *models.ConversionRequest `json:"conversion,omitempty"`
+	Blob       string                    `json:"blob,omitempty"`
+	Refs       int                       `json:"refs,omitempty"`
+}
+
+// valid reports whether an entry carries the change its operation needs
+func (e journalEntry) valid() bool {
+	if e.Op == journalOpBlobRef {
+		return e.Blob != ""
+	}
+	return e.Conversion != nil && e.Conversion.ID != ""
+}
+
+// replayJournal applies every complete entry in the journal at path and
+// returns how many it applied and the offset just past the last good one.
+// The first entry it cannot read ends the replay; openJournal deals with
+// whatever follows.
+func replayJournal(path string, apply func(journalEntry)) (int, int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	entries := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return entries, offset, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil || !entry.valid() {
+			log.Printf("⚠ Stopped replaying %s at an unreadable entry at offset %d", path, offset)
+			return entries, offset, nil
+		}
+		apply(entry)
+		offset += int64(len(line))
+		entries++
+	}
+}
+
+// openJournal opens the journal for appending, first removing anything past
+// goodOffset that replayJournal could not read. A torn final line, as left
+// by a crash mid-append, is cut off. Any other unreadable tail may hold
+// entries that were acknowledged, so it is moved aside to
+// conversions.journal.corrupt-<timestamp> for inspection rather than lost.
+func openJournal(path string, goodOffset int64) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := trimJournal(f, path, goodOffset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// trimJournal removes the journal's tail past goodOffset, saving it first
+// unless it is a torn final line
+func trimJournal(f *os.File, path string, goodOffset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size <= goodOffset {
+		return nil
+	}
+
+	// Every entry is written with its newline in one append, so a tail
+	// without one is a single torn entry
+	torn, err := isTornLine(io.NewSectionReader(f, goodOffset, size-goodOffset))
+	if err != nil {
+		return err
+	}
+	if torn {
+		log.Printf("⚠ Cutting off an incomplete entry at the end of %s (offset %d)", path, goodOffset)
+	} else {
+		aside, err := saveJournalTail(f, path, goodOffset, size)
+		if err != nil {
+			return fmt.Errorf("failed to move the unreadable end of %s aside: %w", path, err)
+		}
+		log.Printf("⚠ Moved the unreadable end of %s (%d bytes from offset %d) to %s", path, size-goodOffset, goodOffset, aside)
+	}
+
+	if err := f.Truncate(goodOffset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// isTornLine reports whether r holds no newline
+func isTornLine(r io.Reader) (bool, error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if bytes.IndexByte(buf[:n], '\n') >= 0 {
+			return false, nil
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// saveJournalTail durably copies the journal from offset to size into a new
+// corrupt file next to it and returns its name
+func saveJournalTail(f *os.File, path string, offset, size int64) (string, error) {
+	aside := corruptPath(path)
+	out, err := os.OpenFile(aside, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(f, offset, size-offset)); err != nil {
+		out.Close()
+		os.Remove(aside)
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(aside)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(aside)
+		return "", err
+	}
+	return aside, syncDir(filepath.Dir(path))
+}
+
+// applyJournalEntry replays a create, update or blob reference change onto
+// the in-memory state
+func (js *JSONStorage) applyJournalEntry(entry journalEntry) {
+	if entry.Op == journalOpBlobRef {
+		setBlobRefs(js.blobRefs, entry.Blob, entry.Refs)
+		return
+	}
+	conv := *entry.Conversion
+	if i, ok := js.conversionIndex[conv.ID]; ok {
+		js.conversions[i] = conv
+		return
+	}
+	js.conversionIndex[conv.ID] = len(js.conversions)
+	js.conversions = append(js.conversions, conv)
+}
+
+// setBlobRefs sets the reference count of a blob, forgetting it at zero
+func setBlobRefs(blobRefs map[string]int, key string, refs int) {
+	if refs <= 0 {
+		delete(blobRefs, key)
+		return
+	}
+	blobRefs[key] = refs
+}
+
+// appendJournal durably records a change to one conversion. The caller must
+// hold js.mu, and should apply the change in memory and then call
+// maybeCompactJournal once this succeeds.
+func (js *JSONStorage) appendJournal(op string, conv models.ConversionRequest) error {
+	return js.writeJournal(journalEntry{Op: op, At: time.Now(), Conversion: &conv})
+}
+
+// appendBlobRefs durably records the new reference count of a blob, like
+// appendJournal
+func (js *JSONStorage) appendBlobRefs(key string, refs int) error {
+	return js.writeJournal(journalEntry{Op: journalOpBlobRef, At: time.Now(), Blob: key, Refs: refs})
+}
+
+// writeJournal appends one entry to the journal. The caller must hold js.mu.
+func (js *JSONStorage) writeJournal(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := js.journal.Write(line); err != nil {
+		// Cut off any partial line so later entries stay replayable
+		js.journal.Truncate(js.journalOffset)
+		js.journal.Seek(js.journalOffset, io.SeekStart)
+		return err
+	}
+	if err := js.journal.Sync(); err != nil {
+		return err
+	}
+
+	js.journalEntries++
+	js.journalOffset += int64(len(line))
+	return nil
+}
+
+// maybeCompactJournal compacts the journal once it holds journalCompactEvery
+// entries. The caller must hold js.mu.
+func (js *JSONStorage) maybeCompactJournal() {
+	if js.journalEntries < journalCompactEvery {
+		return
+	}
+	if err := js.compactJournal(); err != nil {
+		// Every entry is still safely in the journal; compaction is retried
+		// on the next write
+		log.Printf("Failed to compact conversion journal: %v", err)
+	}
+}
+
+// compactJournal writes the current conversions and blob reference counts
+// as new snapshots and moves the journal into the history directory,
+// keeping every past transition. The caller must hold js.mu.
+func (js *JSONStorage) compactJournal() error {
+	if err := js.saveConversions(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := js.saveBlobRefs(); err != nil {
+		return fmt.Errorf("failed to write blob reference snapshot: %w", err)
+	}
+
+	// If we crash after the snapshot but before the journal moves, replaying
+	// the old journal over the new snapshot yields the same state
+	if err := os.MkdirAll(js.historyDir, 0755); err != nil {
+		return err
+	}
+	archived := filepath.Join(js.historyDir, fmt.Sprintf("conversions-%s.journal", time.Now().UTC().Format("20060102T150405.000000000Z")))
+	if err := os.Rename(js.journalFile, archived); err != nil {
+		return err
+	}
+	journal, err := openJournal(js.journalFile, 0)
+	if err != nil {
+		// Keep appending to the old journal rather than losing writes
+		os.Rename(archived, js.journalFile)
+		return err
+	}
+	js.journal.Close()
+	js.journal = journal
+	js.journalEntries = 0
+	js.journalOffset = 0
+	return syncDir(js.dataDir)
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oneforall/backend/models"
+)
+
+// openJSONStorage initialises a JSONStorage over dir
+func openJSONStorage(t *testing.T, dir string) *JSONStorage {
+	t.Helper()
+	js := NewJSONStorage(dir)
+	if err := js.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return js
+}
+
+// crash closes the journal without compacting it, as a crash would leave it
+func crash(js *JSONStorage) {
+	js.journal.Close()
+	js.journal = nil
+}
+
+// writeSomeChanges creates two conversions, updates one, and moves a blob
+// reference count to 1
+func writeSomeChanges(t *testing.T, js *JSONStorage) (first, second string) {
+	t.Helper()
+	ctx := context.Background()
+	a, err := js.CreateConversion(ctx, models.ConversionRequest{UserID: "alice", ExamID: "jee-main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := js.CreateConversion(ctx, models.ConversionRequest{UserID: "bob", ExamID: "jee-main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := js.UpdateConversion(ctx, a.ID, "failed", "broken"); err != nil {
+		t.Fatal(err)
+	}
+	for _, add := range []bool{true, true, false} {
+		if add {
+			_, err = js.AddBlobRef(ctx, "inputs/x")
+		} else {
+			_, err = js.ReleaseBlobRef(ctx, "inputs/x")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a.ID, b.ID
+}
+
+// checkChanges verifies the state writeSomeChanges left
+func checkChanges(t *testing.T, js *JSONStorage, first, second string) {
+	t.Helper()
+	ctx := context.Background()
+	a, err := js.GetConversionByID(ctx, first)
+	if err != nil {
+		t.Fatalf("first conversion: %v", err)
+	}
+	if a.Status != "failed" || a.ErrorMsg != "broken" || a.UserID != "alice" {
+		t.Errorf("first conversion = %+v; want the update applied", a)
+	}
+	b, err := js.GetConversionByID(ctx, second)
+	if err != nil {
+		t.Fatalf("second conversion: %v", err)
+	}
+	if b.Status != "pending" || b.UserID != "bob" {
+		t.Errorf("second conversion = %+v; want it as created", b)
+	}
+	if len(js.conversions) != 2 {
+		t.Errorf("%d conversions; want 2", len(js.conversions))
+	}
+	if js.blobRefs["inputs/x"] != 1 {
+		t.Errorf("blob references = %v; want inputs/x at 1", js.blobRefs)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	js := openJSONStorage(t, dir)
+	first, second := writeSomeChanges(t, js)
+	crash(js)
+
+	if _, err := os.Stat(filepath.Join(dir, "conversions.json")); !os.IsNotExist(err) {
+		t.Fatalf("snapshot written before compaction: %v", err)
+	}
+	js = openJSONStorage(t, dir)
+	defer js.Close()
+	checkChanges(t, js, first, second)
+	if js.journalEntries != 6 {
+		t.Errorf("replayed %d entries; want 6", js.journalEntries)
+	}
+
+	// Writes after the replay append to the same journal
+	if _, err := js.CreateConversion(context.Background(), models.ConversionRequest{UserID: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(js)
+	js = openJSONStorage(t, dir)
+	defer js.Close()
+	if len(js.conversions) != 3 || js.journalEntries != 7 {
+		t.Errorf("after a second replay: %d conversions, %d entries; want 3 and 7", len(js.conversions), js.journalEntries)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	js := openJSONStorage(t, dir)
+	first, second := writeSomeChanges(t, js)
+
+	// The write that reaches journalCompactEvery entries compacts
+	js.journalEntries = journalCompactEvery - 1
+	if _, err := js.AddBlobRef(context.Background(), "inputs/y"); err != nil {
+		t.Fatal(err)
+	}
+	if js.journalEntries != 0 || js.journalOffset != 0 {
+		t.Errorf("after compaction: %d entries at offset %d; want an empty journal", js.journalEntries, js.journalOffset)
+	}
+	if info, err := os.Stat(js.journalFile); err != nil || info.Size() != 0 {
+		t.Errorf("journal after compaction: %v, %v; want an empty file", info, err)
+	}
+	archived, err := filepath.Glob(filepath.Join(dir, "history", "conversions-*.journal"))
+	if err != nil || len(archived) != 1 {
+		t.Fatalf("archived journals = %v, %v; want one", archived, err)
+	}
+	crash(js)
+
+	// The snapshots alone hold the state
+	js = openJSONStorage(t, dir)
+	checkChanges(t, js, first, second)
+	if js.blobRefs["inputs/y"] != 1 || js.journalEntries != 0 {
+		t.Errorf("reopened with blob references %v and %d entries; want inputs/y at 1 and none", js.blobRefs, js.journalEntries)
+	}
+	crash(js)
+
+	// A crash between writing the snapshots and moving the journal leaves
+	// the old journal to replay over them, which changes nothing
+	data, err := os.ReadFile(archived[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(js.journalFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	js = openJSONStorage(t, dir)
+	checkChanges(t, js, first, second)
+	if js.blobRefs["inputs/y"] != 1 {
+		t.Errorf("blob references = %v; want inputs/y at 1", js.blobRefs)
+	}
+
+	// Close compacts what is left
+	if err := js.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(js.journalFile); err != nil || info.Size() != 0 {
+		t.Errorf("journal after Close: %v, %v; want an empty file", info, err)
+	}
+}
+
+func TestJournalDamagedTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+		// aside is whether the tail is kept in a corrupt file rather than
+		// cut off
+		aside bool
+	}{
+		{"torn final line", `{"op":"update","at":"2024-01-01T00:00:00Z","conver`, false},
+		{"garbled line", "\x00\x00\x00garbage\n", true},
+		{"garbled line before good entries", "garbage\n" + `{"op":"blob_ref","at":"2024-01-01T00:00:00Z","blob":"inputs/z","refs":1}` + "\n", true},
+		{"entry without its conversion", `{"op":"update","at":"2024-01-01T00:00:00Z"}` + "\n", true},
+		{"garbled line then a torn one", "garbage\n{\"op\":", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			js := openJSONStorage(t, dir)
+			first, second := writeSomeChanges(t, js)
+			good := js.journalOffset
+			crash(js)
+
+			f, err := os.OpenFile(filepath.Join(dir, "conversions.journal"), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			js = openJSONStorage(t, dir)
+			defer js.Close()
+			checkChanges(t, js, first, second)
+			if _, ok := js.blobRefs["inputs/z"]; ok {
+				t.Error("entry after the damage was replayed")
+			}
+			if info, err := os.Stat(js.journalFile); err != nil || info.Size() != good {
+				t.Errorf("journal = %v, %v; want it cut back to %d bytes", info, err, good)
+			}
+
+			aside, _ := filepath.Glob(filepath.Join(dir, "conversions.journal.corrupt-*"))
+			if !tt.aside {
+				if len(aside) != 0 {
+					t.Errorf("torn line moved aside to %v; want it dropped", aside)
+				}
+			} else if len(aside) != 1 {
+				t.Errorf("corrupt files = %v; want one", aside)
+			} else if data, err := os.ReadFile(aside[0]); err != nil || string(data) != tt.tail {
+				t.Errorf("corrupt file holds %q, %v; want the damaged tail %q", data, err, tt.tail)
+			}
+
+			// New entries land right after the good ones
+			if _, err := js.CreateConversion(context.Background(), models.ConversionRequest{UserID: "carol"}); err != nil {
+				t.Fatal(err)
+			}
+			crash(js)
+			js = openJSONStorage(t, dir)
+			defer js.Close()
+			if len(js.conversions) != 3 || js.journalEntries != 7 {
+				t.Errorf("after writing past the damage: %d conversions, %d entries; want 3 and 7", len(js.conversions), js.journalEntries)
+			}
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,33 +14,60 @@ import (
 	"github.com/oneforall/backend/models"
 )
 
-// JSONStorage handles JSON file-based storage
+// JSONStorage handles JSON file-based storage. Exams and tools are read from
+// their files; conversion changes are appended to conversions.journal and
+// periodically compacted into the conversions.json snapshot. Blob reference
+// count changes go through the same journal, compacted into blob_refs.json.
 type JSONStorage struct {
-	dataDir              string
-	examsFile            string
-	toolsFile            string
-	conversionsFile      string
-	mu                   sync.RWMutex
-	exams                []models.Exam
-	tools                []models.ToolCategory
-	conversions          []models.ConversionRequest
+	dataDir         string
+	examsFile       string
+	toolsFile       string
+	conversionsFile string
+	journalFile     string
+	revisionsFile   string
+	blobRefsFile    string
+	historyDir      string
+	mu              sync.RWMutex
+	exams           []models.Exam
+	tools           []models.ToolCategory
+	conversions     []models.ConversionRequest
+	conversionIndex map[string]int
+	revisions       map[string][]models.ExamRevision
+	blobRefs        map[string]int
+	journal         *os.File
+	journalEntries  int
+	journalOffset   int64
+	examsStamp      fileStamp
+	toolsStamp      fileStamp
 }
 
+var (
+	_ Store    = (*JSONStorage)(nil)
+	_ Reloader = (*JSONStorage)(nil)
+)
+
 // NewJSONStorage creates a new JSON storage instance
-func NewJSONStorage() *JSONStorage {
+func NewJSONStorage(dataDir string) *JSONStorage {
 	return &JSONStorage{
-		dataDir:         "./data",
-		examsFile:       "./data/exams.json",
-		toolsFile:       "./data/tools.json",
-		conversionsFile: "./data/conversions.json",
+		dataDir:         dataDir,
+		examsFile:       filepath.Join(dataDir, "exams.json"),
+		toolsFile:       filepath.Join(dataDir, "tools.json"),
+		conversionsFile: filepath.Join(dataDir, "conversions.json"),
+		journalFile:     filepath.Join(dataDir, "conversions.journal"),
+		revisionsFile:   filepath.Join(dataDir, "exam_revisions.json"),
+		blobRefsFile:    filepath.Join(dataDir, "blob_refs.json"),
+		historyDir:      filepath.Join(dataDir, "history"),
 		exams:           []models.Exam{},
 		tools:           []models.ToolCategory{},
 		conversions:     []models.ConversionRequest{},
+		conversionIndex: map[string]int{},
+		revisions:       map[string][]models.ExamRevision{},
+		blobRefs:        map[string]int{},
 	}
 }
 
 // Initialize sets up the storage directories and loads data
-func (js *JSONStorage) Initialize() error {
+func (js *JSONStorage) Initialize(ctx context.Context) error {
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll(js.dataDir, 0755); err != nil {
 		return fmt.Errorf("failed to create data directory: %w", err)
@@ -52,75 +79,186 @@ func (js *JSONStorage) Initialize() error {
 		return fmt.Errorf("failed to create uploads directory: %w", err)
 	}
 
-	// Load all data
+	// Load all data. Missing catalogue files leave the lists empty; corrupt
+	// ones stop startup so they are fixed instead of silently served empty.
 	if err := js.loadExams(); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load exams: %w", err)
+		}
 		log.Printf("Failed to load exams: %v", err)
-		// Initialize with empty exams if file doesn't exist
 		js.exams = []models.Exam{}
 	}
 
+	if err := js.loadRevisions(); err != nil {
+		return fmt.Errorf("failed to load exam revisions: %w", err)
+	}
+	// Catch up on edits made to exams.json while the server was stopped
+	if pending := pendingRevisions(js.revisions, js.exams, js.exams, time.Now()); len(pending) > 0 {
+		addRevisions(js.revisions, pending)
+		if err := js.saveRevisions(); err != nil {
+			return fmt.Errorf("failed to save exam revisions: %w", err)
+		}
+	}
+
 	if err := js.loadTools(); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load tools: %w", err)
+		}
 		log.Printf("Failed to load tools: %v", err)
 		js.tools = []models.ToolCategory{}
 	}
 
+	// Blob references first: the journal replayed over the conversions
+	// also holds their changes since the last snapshot
+	if err := js.loadBlobRefs(); err != nil {
+		return fmt.Errorf("failed to load blob references: %w", err)
+	}
 	if err := js.loadConversions(); err != nil {
-		log.Printf("Failed to load conversions: %v", err)
-		js.conversions = []models.ConversionRequest{}
+		return fmt.Errorf("failed to load conversions: %w", err)
+	}
+
+	journal, err := openJournal(js.journalFile, js.journalOffset)
+	if err != nil {
+		return fmt.Errorf("failed to open conversion journal: %w", err)
 	}
+	js.journal = journal
 
 	log.Println("✓ Storage initialized successfully")
 	return nil
 }
 
+// Close folds the journal into a fresh snapshot so the next start does not
+// have to replay it, then closes the journal
+func (js *JSONStorage) Close() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.journal == nil {
+		return nil
+	}
+	if js.journalEntries > 0 {
+		if err := js.compactJournal(); err != nil {
+			log.Printf("Failed to compact conversion journal: %v", err)
+		}
+	}
+	err := js.journal.Close()
+	js.journal = nil
+	return err
+}
+
 // loadExams loads exams from JSON file
 func (js *JSONStorage) loadExams() error {
-	data, err := ioutil.ReadFile(js.examsFile)
+	exams, stamp, err := readExamsFile(js.examsFile)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &js.exams)
+	js.exams = exams
+	js.examsStamp = stamp
+	return nil
+}
+
+// loadRevisions loads the exam revision history, falling back to the newest
+// good snapshot like conversions.json
+func (js *JSONStorage) loadRevisions() error {
+	if err := readJSONWithRecovery(js.revisionsFile, &js.revisions); err != nil {
+		return err
+	}
+	if js.revisions == nil {
+		js.revisions = map[string][]models.ExamRevision{}
+	}
+	return nil
+}
+
+// saveRevisions writes the exam revision history atomically. The caller must
+// hold js.mu (or be initialising).
+func (js *JSONStorage) saveRevisions() error {
+	data, err := encodeJSONFile(js.revisions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(js.revisionsFile, data, 0644)
+}
+
+// loadBlobRefs loads the blob reference counts, falling back to the newest
+// good snapshot like conversions.json
+func (js *JSONStorage) loadBlobRefs() error {
+	if err := readJSONWithRecovery(js.blobRefsFile, &js.blobRefs); err != nil {
+		return err
+	}
+	if js.blobRefs == nil {
+		js.blobRefs = map[string]int{}
+	}
+	return nil
+}
+
+// saveBlobRefs writes a snapshot of the blob reference counts atomically.
+// Individual changes go through appendBlobRefs instead. The caller must hold
+// js.mu.
+func (js *JSONStorage) saveBlobRefs() error {
+	data, err := encodeJSONFile(js.blobRefs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(js.blobRefsFile, data, 0644)
 }
 
 // loadTools loads tools from JSON file
 func (js *JSONStorage) loadTools() error {
-	data, err := ioutil.ReadFile(js.toolsFile)
+	tools, stamp, err := readToolsFile(js.toolsFile)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &js.tools)
+	js.tools = tools
+	js.toolsStamp = stamp
+	return nil
 }
 
-// loadConversions loads conversions from JSON file
+// loadConversions loads conversions from JSON file, falling back to the
+// newest good snapshot if the file is missing or corrupt, then replays the
+// journal on top of it
 func (js *JSONStorage) loadConversions() error {
-	data, err := ioutil.ReadFile(js.conversionsFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // File doesn't exist yet, that's fine
-		}
+	if err := readJSONWithRecovery(js.conversionsFile, &js.conversions); err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &js.conversions)
+	if js.conversions == nil {
+		js.conversions = []models.ConversionRequest{}
+	}
+	js.conversionIndex = make(map[string]int, len(js.conversions))
+	for i, conv := range js.conversions {
+		js.conversionIndex[conv.ID] = i
+	}
+
+	entries, offset, err := replayJournal(js.journalFile, js.applyJournalEntry)
+	if err != nil {
+		return fmt.Errorf("failed to replay %s: %w", js.journalFile, err)
+	}
+	if entries > 0 {
+		log.Printf("Replayed %d conversion journal entries", entries)
+	}
+	js.journalEntries = entries
+	js.journalOffset = offset
+	return nil
 }
 
-// saveConversions persists conversions to JSON file
+// saveConversions writes a full snapshot of conversions to JSON file atomically.
+// Individual changes go through appendJournal instead.
 func (js *JSONStorage) saveConversions() error {
 	data, err := json.MarshalIndent(js.conversions, "", "  ")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(js.conversionsFile, data, 0644)
+	return writeFileAtomic(js.conversionsFile, data, 0644)
 }
 
 // GetExams returns all exams
-func (js *JSONStorage) GetExams() ([]models.Exam, error) {
+func (js *JSONStorage) GetExams(ctx context.Context) ([]models.Exam, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 	return js.exams, nil
 }
 
 // GetExamByID returns a specific exam by ID
-func (js *JSONStorage) GetExamByID(examID string) (*models.Exam, error) {
+func (js *JSONStorage) GetExamByID(ctx context.Context, examID string) (*models.Exam, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 
@@ -129,77 +267,347 @@ func (js *JSONStorage) GetExamByID(examID string) (*models.Exam, error) {
 			return &exam, nil
 		}
 	}
-	return nil, fmt.Errorf("exam not found: %s", examID)
+	return nil, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+}
+
+// CreateExam adds a new exam and writes exams.json
+func (js *JSONStorage) CreateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	exams, created, err := withExamAdded(js.exams, exam, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveExams(exams); err != nil {
+		return nil, fmt.Errorf("failed to save exams: %w", err)
+	}
+	created.Revision = findExam(exams, created.ID).Revision
+	return created, nil
+}
+
+// UpdateExam replaces an existing exam and writes exams.json
+func (js *JSONStorage) UpdateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.updateExam(exam)
+}
+
+// UpdateExamDocuments replaces an exam's documents if they are still at
+// revision and writes exams.json
+func (js *JSONStorage) UpdateExamDocuments(ctx context.Context, examID string, revision int, docs []models.Document) (*models.Exam, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	exam, err := examWithDocuments(js.exams, examID, revision, docs)
+	if err != nil {
+		return nil, err
+	}
+	return js.updateExam(exam)
+}
+
+// updateExam replaces an existing exam and writes exams.json. The caller
+// must hold js.mu.
+func (js *JSONStorage) updateExam(exam models.Exam) (*models.Exam, error) {
+	exams, updated, err := withExamReplaced(js.exams, exam, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveExams(exams); err != nil {
+		return nil, fmt.Errorf("failed to save exams: %w", err)
+	}
+	updated.Revision = findExam(exams, updated.ID).Revision
+	return updated, nil
+}
+
+// DeleteExam removes an exam and writes exams.json
+func (js *JSONStorage) DeleteExam(ctx context.Context, examID string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	exams, err := withExamRemoved(js.exams, examID)
+	if err != nil {
+		return err
+	}
+	if err := js.saveExams(exams); err != nil {
+		return fmt.Errorf("failed to save exams: %w", err)
+	}
+	return nil
+}
+
+// saveExams writes exams.json atomically, makes exams the live list and
+// records a revision for every exam whose documents changed. The caller must
+// hold js.mu.
+func (js *JSONStorage) saveExams(exams []models.Exam) error {
+	pending := pendingRevisions(js.revisions, js.exams, exams, time.Now())
+
+	// Revisions live in exam_revisions.json; keep exams.json as it was
+	stored := make([]models.Exam, len(exams))
+	for i, exam := range exams {
+		exam.Revision = 0
+		stored[i] = exam
+	}
+	data, err := encodeJSONFile(stored)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(js.examsFile, data, 0644); err != nil {
+		return err
+	}
+	js.exams = exams
+	// Remember our own write so the file watcher does not reload it
+	if stamp, err := statStamp(js.examsFile); err == nil {
+		js.examsStamp = stamp
+	}
+
+	js.recordRevisions(pending)
+	return nil
+}
+
+// recordRevisions adds revisions to the history and writes it. The exams
+// are already saved by then, so a failed write is only logged: the next
+// start notices the missing revision and records it again. The caller must
+// hold js.mu.
+func (js *JSONStorage) recordRevisions(revs []models.ExamRevision) {
+	if len(revs) == 0 {
+		return
+	}
+	addRevisions(js.revisions, revs)
+	if err := js.saveRevisions(); err != nil {
+		log.Printf("Failed to save exam revisions: %v", err)
+	}
+}
+
+// GetExamRevisions returns the revisions of an exam's documents
+func (js *JSONStorage) GetExamRevisions(ctx context.Context, examID string) ([]models.ExamRevision, error) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+	return examRevisions(js.revisions[examID], findExam(js.exams, examID), examID)
 }
 
 // GetTools returns all tools
-func (js *JSONStorage) GetTools() ([]models.ToolCategory, error) {
+func (js *JSONStorage) GetTools(ctx context.Context) ([]models.ToolCategory, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 	return js.tools, nil
 }
 
-// CreateConversion creates a new conversion request
-func (js *JSONStorage) CreateConversion(userID, examID, documentID, fileName string, fileSize int64) (*models.ConversionRequest, error) {
+// CreateToolCategory adds a tool category at the end
+func (js *JSONStorage) CreateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
 	js.mu.Lock()
 	defer js.mu.Unlock()
 
-	conv := models.ConversionRequest{
-		ID:         uuid.New().String(),
-		UserID:     userID,
-		ExamID:     examID,
-		DocumentID: documentID,
-		FileName:   fileName,
-		FileSize:   fileSize,
-		Status:     "pending",
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	tools, created, err := withToolCategoryAdded(js.tools, cat)
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return nil, fmt.Errorf("failed to save tools: %w", err)
 	}
+	return created, nil
+}
 
-	js.conversions = append(js.conversions, conv)
+// UpdateToolCategory changes a tool category, keeping its tools
+func (js *JSONStorage) UpdateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, updated, err := withToolCategoryReplaced(js.tools, cat)
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return nil, fmt.Errorf("failed to save tools: %w", err)
+	}
+	return updated, nil
+}
 
-	if err := js.saveConversions(); err != nil {
+// DeleteToolCategory removes a tool category and its tools
+func (js *JSONStorage) DeleteToolCategory(ctx context.Context, categoryID string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, err := withToolCategoryRemoved(js.tools, categoryID)
+	if err != nil {
+		return err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return fmt.Errorf("failed to save tools: %w", err)
+	}
+	return nil
+}
+
+// ReorderToolCategories sets the order of the tool categories
+func (js *JSONStorage) ReorderToolCategories(ctx context.Context, ids []string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, err := withToolCategoriesReordered(js.tools, ids)
+	if err != nil {
+		return err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return fmt.Errorf("failed to save tools: %w", err)
+	}
+	return nil
+}
+
+// CreateTool adds a tool at the end of a category
+func (js *JSONStorage) CreateTool(ctx context.Context, categoryID string, tool models.Tool) (*models.Tool, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, created, err := withToolAdded(js.tools, categoryID, tool)
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return nil, fmt.Errorf("failed to save tools: %w", err)
+	}
+	return created, nil
+}
+
+// UpdateTool replaces a tool
+func (js *JSONStorage) UpdateTool(ctx context.Context, tool models.Tool) (*models.Tool, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, updated, err := withToolReplaced(js.tools, tool)
+	if err != nil {
+		return nil, err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return nil, fmt.Errorf("failed to save tools: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteTool removes a tool
+func (js *JSONStorage) DeleteTool(ctx context.Context, toolID string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, err := withToolRemoved(js.tools, toolID)
+	if err != nil {
+		return err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return fmt.Errorf("failed to save tools: %w", err)
+	}
+	return nil
+}
+
+// ReorderTools sets the order of the tools in a category
+func (js *JSONStorage) ReorderTools(ctx context.Context, categoryID string, ids []string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tools, err := withToolsReordered(js.tools, categoryID, ids)
+	if err != nil {
+		return err
+	}
+	if err := js.saveTools(tools); err != nil {
+		return fmt.Errorf("failed to save tools: %w", err)
+	}
+	return nil
+}
+
+// saveTools writes tools.json atomically and makes tools the live catalogue.
+// The caller must hold js.mu.
+func (js *JSONStorage) saveTools(tools []models.ToolCategory) error {
+	data, err := encodeJSONFile(tools)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(js.toolsFile, data, 0644); err != nil {
+		return err
+	}
+	js.tools = tools
+	// Remember our own write so the file watcher does not reload it
+	if stamp, err := statStamp(js.toolsFile); err == nil {
+		js.toolsStamp = stamp
+	}
+	return nil
+}
+
+// CreateConversion creates a new conversion request
+func (js *JSONStorage) CreateConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	conv.ID = uuid.New().String()
+	conv.Status = "pending"
+	conv.CreatedAt = time.Now()
+	conv.UpdatedAt = conv.CreatedAt
+
+	if err := js.appendJournal(journalOpCreate, conv); err != nil {
 		return nil, fmt.Errorf("failed to save conversion: %w", err)
 	}
+	js.conversionIndex[conv.ID] = len(js.conversions)
+	js.conversions = append(js.conversions, conv)
+	js.maybeCompactJournal()
 
 	return &conv, nil
 }
 
 // GetConversionByID retrieves a conversion request by ID
-func (js *JSONStorage) GetConversionByID(conversionID string) (*models.ConversionRequest, error) {
+func (js *JSONStorage) GetConversionByID(ctx context.Context, conversionID string) (*models.ConversionRequest, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 
-	for _, conv := range js.conversions {
-		if conv.ID == conversionID {
-			return &conv, nil
-		}
+	i, ok := js.conversionIndex[conversionID]
+	if !ok {
+		return nil, fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
 	}
-	return nil, fmt.Errorf("conversion not found: %s", conversionID)
+	conv := js.conversions[i]
+	return &conv, nil
 }
 
 // UpdateConversion updates a conversion request
-func (js *JSONStorage) UpdateConversion(conversionID, status, errorMsg string) error {
+func (js *JSONStorage) UpdateConversion(ctx context.Context, conversionID, status, errorMsg string) error {
 	js.mu.Lock()
 	defer js.mu.Unlock()
 
-	for i, conv := range js.conversions {
-		if conv.ID == conversionID {
-			js.conversions[i].Status = status
-			js.conversions[i].ErrorMsg = errorMsg
-			js.conversions[i].UpdatedAt = time.Now()
-
-			if err := js.saveConversions(); err != nil {
-				return fmt.Errorf("failed to save conversion: %w", err)
-			}
-			return nil
-		}
+	i, ok := js.conversionIndex[conversionID]
+	if !ok {
+		return fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
+	}
+
+	conv := js.conversions[i]
+	conv.Status = status
+	conv.ErrorMsg = errorMsg
+	conv.UpdatedAt = time.Now()
+
+	if err := js.appendJournal(journalOpUpdate, conv); err != nil {
+		return fmt.Errorf("failed to save conversion: %w", err)
 	}
-	return fmt.Errorf("conversion not found: %s", conversionID)
+	js.conversions[i] = conv
+	js.maybeCompactJournal()
+	return nil
+}
+
+// SaveConversion replaces an existing conversion request
+func (js *JSONStorage) SaveConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	i, ok := js.conversionIndex[conv.ID]
+	if !ok {
+		return nil, fmt.Errorf("conversion %s: %w", conv.ID, ErrNotFound)
+	}
+	conv = withFixedConversionFields(conv, js.conversions[i])
+	conv.UpdatedAt = time.Now()
+
+	if err := js.appendJournal(journalOpUpdate, conv); err != nil {
+		return nil, fmt.Errorf("failed to save conversion: %w", err)
+	}
+	js.conversions[i] = conv
+	js.maybeCompactJournal()
+	return &conv, nil
 }
 
 // GetUserConversions retrieves all conversions for a specific user
-func (js *JSONStorage) GetUserConversions(userID string) ([]models.ConversionRequest, error) {
+func (js *JSONStorage) GetUserConversions(ctx context.Context, userID string) ([]models.ConversionRequest, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 
@@ -213,7 +621,7 @@ func (js *JSONStorage) GetUserConversions(userID string) ([]models.ConversionReq
 }
 
 // GetConversionsByExam retrieves all conversions for a specific exam
-func (js *JSONStorage) GetConversionsByExam(examID string) ([]models.ConversionRequest, error) {
+func (js *JSONStorage) GetConversionsByExam(ctx context.Context, examID string) ([]models.ConversionRequest, error) {
 	js.mu.RLock()
 	defer js.mu.RUnlock()
 
@@ -225,3 +633,62 @@ func (js *JSONStorage) GetConversionsByExam(examID string) ([]models.ConversionR
 	}
 	return examConversions, nil
 }
+
+// GetKitConversions retrieves the conversions of an exam kit, oldest first
+func (js *JSONStorage) GetKitConversions(ctx context.Context, kitID string) ([]models.ConversionRequest, error) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	return conversionsInKit(js.conversions, kitID), nil
+}
+
+// ListConversionsByStatus retrieves all conversions with a status, oldest first
+func (js *JSONStorage) ListConversionsByStatus(ctx context.Context, status string) ([]models.ConversionRequest, error) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	return conversionsWithStatus(js.conversions, status), nil
+}
+
+// FindCachedConversion finds a completed conversion whose output can be reused
+func (js *JSONStorage) FindCachedConversion(ctx context.Context, inputHash, toolID string, options map[string]string) (*models.ConversionRequest, error) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	conv, ok := findCachedConversion(js.conversions, inputHash, toolID, options)
+	if !ok {
+		return nil, fmt.Errorf("cached conversion: %w", ErrNotFound)
+	}
+	return &conv, nil
+}
+
+// AddBlobRef counts one more reference to a blob
+func (js *JSONStorage) AddBlobRef(ctx context.Context, key string) (int, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	refs := js.blobRefs[key] + 1
+	if err := js.appendBlobRefs(key, refs); err != nil {
+		return 0, fmt.Errorf("failed to save blob references: %w", err)
+	}
+	js.blobRefs[key] = refs
+	js.maybeCompactJournal()
+	return refs, nil
+}
+
+// ReleaseBlobRef drops a reference to a blob
+func (js *JSONStorage) ReleaseBlobRef(ctx context.Context, key string) (int, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	refs, ok := js.blobRefs[key]
+	if !ok {
+		return 0, nil
+	}
+	if err := js.appendBlobRefs(key, refs-1); err != nil {
+		return refs, fmt.Errorf("failed to save blob references: %w", err)
+	}
+	setBlobRefs(js.blobRefs, key, refs-1)
+	js.maybeCompactJournal()
+	return refs - 1, nil
+}
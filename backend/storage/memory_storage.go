@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneforall/backend/models"
+)
+
+// MemoryStorage keeps everything in memory and never touches disk.
+// It is meant for handler tests and local experiments.
+type MemoryStorage struct {
+	mu          sync.RWMutex
+	exams       []models.Exam
+	tools       []models.ToolCategory
+	conversions []models.ConversionRequest
+	revisions   map[string][]models.ExamRevision
+	blobRefs    map[string]int
+}
+
+var _ Store = (*MemoryStorage)(nil)
+
+// NewMemoryStorage creates an in-memory storage seeded with the given exams and tools
+func NewMemoryStorage(exams []models.Exam, tools []models.ToolCategory) *MemoryStorage {
+	if exams == nil {
+		exams = []models.Exam{}
+	}
+	if tools == nil {
+		tools = []models.ToolCategory{}
+	}
+	exams = append([]models.Exam{}, exams...)
+	revisions := map[string][]models.ExamRevision{}
+	pendingRevisions(revisions, exams, exams, time.Now())
+	return &MemoryStorage{
+		exams:       exams,
+		tools:       tools,
+		conversions: []models.ConversionRequest{},
+		revisions:   revisions,
+		blobRefs:    map[string]int{},
+	}
+}
+
+// Initialize is a no-op for in-memory storage
+func (ms *MemoryStorage) Initialize(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op for in-memory storage
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// GetExams returns all exams
+func (ms *MemoryStorage) GetExams(ctx context.Context) ([]models.Exam, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.exams, nil
+}
+
+// GetExamByID returns a specific exam by ID
+func (ms *MemoryStorage) GetExamByID(ctx context.Context, examID string) (*models.Exam, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, exam := range ms.exams {
+		if exam.ID == examID {
+			return &exam, nil
+		}
+	}
+	return nil, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+}
+
+// CreateExam adds a new exam
+func (ms *MemoryStorage) CreateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	exams, created, err := withExamAdded(ms.exams, exam, now)
+	if err != nil {
+		return nil, err
+	}
+	addRevisions(ms.revisions, pendingRevisions(ms.revisions, ms.exams, exams, now))
+	ms.exams = exams
+	created.Revision = findExam(exams, created.ID).Revision
+	return created, nil
+}
+
+// UpdateExam replaces an existing exam
+func (ms *MemoryStorage) UpdateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.updateExam(exam)
+}
+
+// UpdateExamDocuments replaces an exam's documents if they are still at
+// revision
+func (ms *MemoryStorage) UpdateExamDocuments(ctx context.Context, examID string, revision int, docs []models.Document) (*models.Exam, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	exam, err := examWithDocuments(ms.exams, examID, revision, docs)
+	if err != nil {
+		return nil, err
+	}
+	return ms.updateExam(exam)
+}
+
+// updateExam replaces an existing exam. The caller must hold ms.mu.
+func (ms *MemoryStorage) updateExam(exam models.Exam) (*models.Exam, error) {
+	now := time.Now()
+	exams, updated, err := withExamReplaced(ms.exams, exam, now)
+	if err != nil {
+		return nil, err
+	}
+	addRevisions(ms.revisions, pendingRevisions(ms.revisions, ms.exams, exams, now))
+	ms.exams = exams
+	updated.Revision = findExam(exams, updated.ID).Revision
+	return updated, nil
+}
+
+// DeleteExam removes an exam
+func (ms *MemoryStorage) DeleteExam(ctx context.Context, examID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	exams, err := withExamRemoved(ms.exams, examID)
+	if err != nil {
+		return err
+	}
+	ms.exams = exams
+	return nil
+}
+
+// GetExamRevisions returns the revisions of an exam's documents
+func (ms *MemoryStorage) GetExamRevisions(ctx context.Context, examID string) ([]models.ExamRevision, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return examRevisions(ms.revisions[examID], findExam(ms.exams, examID), examID)
+}
+
+// GetTools returns all tools
+func (ms *MemoryStorage) GetTools(ctx context.Context) ([]models.ToolCategory, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.tools, nil
+}
+
+// CreateToolCategory adds a tool category at the end
+func (ms *MemoryStorage) CreateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, created, err := withToolCategoryAdded(ms.tools, cat)
+	if err != nil {
+		return nil, err
+	}
+	ms.tools = tools
+	return created, nil
+}
+
+// UpdateToolCategory changes a tool category, keeping its tools
+func (ms *MemoryStorage) UpdateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, updated, err := withToolCategoryReplaced(ms.tools, cat)
+	if err != nil {
+		return nil, err
+	}
+	ms.tools = tools
+	return updated, nil
+}
+
+// DeleteToolCategory removes a tool category and its tools
+func (ms *MemoryStorage) DeleteToolCategory(ctx context.Context, categoryID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, err := withToolCategoryRemoved(ms.tools, categoryID)
+	if err != nil {
+		return err
+	}
+	ms.tools = tools
+	return nil
+}
+
+// ReorderToolCategories sets the order of the tool categories
+func (ms *MemoryStorage) ReorderToolCategories(ctx context.Context, ids []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, err := withToolCategoriesReordered(ms.tools, ids)
+	if err != nil {
+		return err
+	}
+	ms.tools = tools
+	return nil
+}
+
+// CreateTool adds a tool at the end of a category
+func (ms *MemoryStorage) CreateTool(ctx context.Context, categoryID string, tool models.Tool) (*models.Tool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, created, err := withToolAdded(ms.tools, categoryID, tool)
+	if err != nil {
+		return nil, err
+	}
+	ms.tools = tools
+	return created, nil
+}
+
+// UpdateTool replaces a tool
+func (ms *MemoryStorage) UpdateTool(ctx context.Context, tool models.Tool) (*models.Tool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, updated, err := withToolReplaced(ms.tools, tool)
+	if err != nil {
+		return nil, err
+	}
+	ms.tools = tools
+	return updated, nil
+}
+
+// DeleteTool removes a tool
+func (ms *MemoryStorage) DeleteTool(ctx context.Context, toolID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, err := withToolRemoved(ms.tools, toolID)
+	if err != nil {
+		return err
+	}
+	ms.tools = tools
+	return nil
+}
+
+// ReorderTools sets the order of the tools in a category
+func (ms *MemoryStorage) ReorderTools(ctx context.Context, categoryID string, ids []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tools, err := withToolsReordered(ms.tools, categoryID, ids)
+	if err != nil {
+		return err
+	}
+	ms.tools = tools
+	return nil
+}
+
+// CreateConversion creates a new conversion request
+func (ms *MemoryStorage) CreateConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	conv.ID = uuid.New().String()
+	conv.Status = "pending"
+	conv.CreatedAt = now
+	conv.UpdatedAt = now
+	ms.conversions = append(ms.conversions, conv)
+	return &conv, nil
+}
+
+// GetConversionByID retrieves a conversion request by ID
+func (ms *MemoryStorage) GetConversionByID(ctx context.Context, conversionID string) (*models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, conv := range ms.conversions {
+		if conv.ID == conversionID {
+			return &conv, nil
+		}
+	}
+	return nil, fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
+}
+
+// UpdateConversion updates a conversion request
+func (ms *MemoryStorage) UpdateConversion(ctx context.Context, conversionID, status, errorMsg string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.conversions {
+		if ms.conversions[i].ID == conversionID {
+			ms.conversions[i].Status = status
+			ms.conversions[i].ErrorMsg = errorMsg
+			ms.conversions[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
+}
+
+// SaveConversion replaces an existing conversion request
+func (ms *MemoryStorage) SaveConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.conversions {
+		if ms.conversions[i].ID == conv.ID {
+			conv = withFixedConversionFields(conv, ms.conversions[i])
+			conv.UpdatedAt = time.Now()
+			ms.conversions[i] = conv
+			return &conv, nil
+		}
+	}
+	return nil, fmt.Errorf("conversion %s: %w", conv.ID, ErrNotFound)
+}
+
+// GetUserConversions retrieves all conversions for a specific user
+func (ms *MemoryStorage) GetUserConversions(ctx context.Context, userID string) ([]models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var userConversions []models.ConversionRequest
+	for _, conv := range ms.conversions {
+		if conv.UserID == userID {
+			userConversions = append(userConversions, conv)
+		}
+	}
+	return userConversions, nil
+}
+
+// GetConversionsByExam retrieves all conversions for a specific exam
+func (ms *MemoryStorage) GetConversionsByExam(ctx context.Context, examID string) ([]models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var examConversions []models.ConversionRequest
+	for _, conv := range ms.conversions {
+		if conv.ExamID == examID {
+			examConversions = append(examConversions, conv)
+		}
+	}
+	return examConversions, nil
+}
+
+// GetKitConversions retrieves the conversions of an exam kit, oldest first
+func (ms *MemoryStorage) GetKitConversions(ctx context.Context, kitID string) ([]models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return conversionsInKit(ms.conversions, kitID), nil
+}
+
+// ListConversionsByStatus retrieves all conversions with a status, oldest first
+func (ms *MemoryStorage) ListConversionsByStatus(ctx context.Context, status string) ([]models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return conversionsWithStatus(ms.conversions, status), nil
+}
+
+// FindCachedConversion finds a completed conversion whose output can be reused
+func (ms *MemoryStorage) FindCachedConversion(ctx context.Context, inputHash, toolID string, options map[string]string) (*models.ConversionRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	conv, ok := findCachedConversion(ms.conversions, inputHash, toolID, options)
+	if !ok {
+		return nil, fmt.Errorf("cached conversion: %w", ErrNotFound)
+	}
+	return &conv, nil
+}
+
+// AddBlobRef counts one more reference to a blob
+func (ms *MemoryStorage) AddBlobRef(ctx context.Context, key string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.blobRefs[key]++
+	return ms.blobRefs[key], nil
+}
+
+// ReleaseBlobRef drops a reference to a blob
+func (ms *MemoryStorage) ReleaseBlobRef(ctx context.Context, key string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	n := ms.blobRefs[key] - 1
+	if n <= 0 {
+		delete(ms.blobRefs, key)
+		return 0, nil
+	}
+	ms.blobRefs[key] = n
+	return n, nil
+}
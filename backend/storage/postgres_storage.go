@@ -0,0 +1,25 @@
+package storage
+
+import (
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/oneforall/backend/migrate"
+)
+
+// PostgresStorage stores exams, tools and conversions in PostgreSQL using
+// the tables described in database/schema.sql
+type PostgresStorage struct {
+	sqlStorage
+}
+
+var _ Migratable = (*PostgresStorage)(nil)
+
+// NewPostgresStorage creates a new PostgreSQL storage for the given connection URL
+func NewPostgresStorage(dsn string, autoMigrate bool) *PostgresStorage {
+	return &PostgresStorage{sqlStorage{
+		name:        "PostgreSQL",
+		driverName:  "pgx",
+		dsn:         dsn,
+		dialect:     migrate.Postgres,
+		autoMigrate: autoMigrate,
+	}}
+}
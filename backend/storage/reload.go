@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// fileStamp identifies a version of a file by modification time and size
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// equal reports whether two stamps name the same version of a file.
+// Modification times are compared with Equal, as == also compares their
+// monotonic readings and locations.
+func (s fileStamp) equal(other fileStamp) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+// statStamp returns the current stamp of path
+func statStamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// readExamsFile reads and validates an exams file
+func readExamsFile(path string) ([]models.Exam, fileStamp, error) {
+	stamp, err := statStamp(path)
+	if err != nil {
+		return nil, stamp, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, stamp, err
+	}
+	var exams []models.Exam
+	if err := decodeJSONFile(data, &exams); err != nil {
+		return nil, stamp, &CorruptFileError{Path: path, Err: err}
+	}
+	if exams == nil {
+		exams = []models.Exam{}
+	}
+	if err := ValidateExams(exams); err != nil {
+		return nil, stamp, fmt.Errorf("%s: %w", path, err)
+	}
+	return exams, stamp, nil
+}
+
+// readToolsFile reads and validates a tools file
+func readToolsFile(path string) ([]models.ToolCategory, fileStamp, error) {
+	stamp, err := statStamp(path)
+	if err != nil {
+		return nil, stamp, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, stamp, err
+	}
+	var tools []models.ToolCategory
+	if err := decodeJSONFile(data, &tools); err != nil {
+		return nil, stamp, &CorruptFileError{Path: path, Err: err}
+	}
+	if tools == nil {
+		tools = []models.ToolCategory{}
+	}
+	if err := ValidateTools(tools); err != nil {
+		return nil, stamp, fmt.Errorf("%s: %w", path, err)
+	}
+	return tools, stamp, nil
+}
+
+// Reload re-reads exams.json and tools.json. Both files are parsed and
+// validated before either is swapped in, so a bad edit leaves the data
+// being served unchanged. The files are read under the lock admin edits
+// write them under, so a reload never swaps in content older than an edit.
+func (js *JSONStorage) Reload(ctx context.Context) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	exams, examsStamp, err := readExamsFile(js.examsFile)
+	if err != nil {
+		return err
+	}
+	tools, toolsStamp, err := readToolsFile(js.toolsFile)
+	if err != nil {
+		return err
+	}
+
+	js.recordRevisions(pendingRevisions(js.revisions, js.exams, exams, time.Now()))
+	js.exams = exams
+	js.examsStamp = examsStamp
+	js.tools = tools
+	js.toolsStamp = toolsStamp
+
+	log.Printf("✓ Reloaded %d exams and %d tool categories", len(exams), len(tools))
+	return nil
+}
+
+// Watch polls exams.json and tools.json every interval and reloads them when
+// either changes on disk, until ctx is cancelled. Failed reloads are logged
+// and retried only after the file changes again.
+func (js *JSONStorage) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// failed remembers the file versions of the last failed reload so they
+	// are not retried until one of the files changes again
+	var failed []fileStamp
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		examsStamp, err := statStamp(js.examsFile)
+		if err != nil {
+			continue
+		}
+		toolsStamp, err := statStamp(js.toolsFile)
+		if err != nil {
+			continue
+		}
+		current := []fileStamp{examsStamp, toolsStamp}
+
+		js.mu.RLock()
+		loaded := []fileStamp{js.examsStamp, js.toolsStamp}
+		js.mu.RUnlock()
+		if sameStamps(current, loaded) || sameStamps(current, failed) {
+			continue
+		}
+
+		if err := js.Reload(ctx); err != nil {
+			log.Printf("⚠ Keeping previous exams and tools: %v", err)
+			failed = current
+		}
+	}
+}
+
+// sameStamps reports whether two lists of stamps are equal
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// examsJSON is an exams file with one exam titled title
+func examsJSON(title string) string {
+	return fmt.Sprintf(`[{"id": "neet", "title": %q, "documents": [{"id": "photo", "name": "Photo", "format": "JPG", "max_size": 1024}]}]`, title)
+}
+
+const toolsJSON = `[{"id": "image", "category": "Image", "tools": [{"id": "png-to-jpg", "name": "PNG to JPG"}]}]`
+
+// newReloadStorage opens a JSONStorage over exams and tools files written
+// into a new directory
+func newReloadStorage(t *testing.T) *JSONStorage {
+	t.Helper()
+	dir := t.TempDir()
+	writeDataFile(t, filepath.Join(dir, "exams.json"), examsJSON("NEET"))
+	writeDataFile(t, filepath.Join(dir, "tools.json"), toolsJSON)
+	js := openJSONStorage(t, dir)
+	t.Cleanup(func() { js.Close() })
+	return js
+}
+
+func writeDataFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// examTitle returns the title of the neet exam being served
+func examTitle(t *testing.T, js *JSONStorage) string {
+	t.Helper()
+	exam, err := js.GetExamByID(context.Background(), "neet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exam.Title
+}
+
+func TestReload(t *testing.T) {
+	js := newReloadStorage(t)
+	writeDataFile(t, js.examsFile, examsJSON("NEET UG"))
+	if err := js.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := examTitle(t, js); got != "NEET UG" {
+		t.Errorf("title = %q; want the reloaded NEET UG", got)
+	}
+	revisions, err := js.GetExamRevisions(context.Background(), "neet")
+	if err != nil || len(revisions) == 0 {
+		t.Errorf("revisions = %v, %v; want the reloaded edit recorded", revisions, err)
+	}
+}
+
+func TestReloadRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		exams string
+		tools string
+	}{
+		{"malformed exams", `[{"id": "neet",`, toolsJSON},
+		{"empty exams", ``, toolsJSON},
+		{"exam without a title", `[{"id": "neet"}]`, toolsJSON},
+		{"duplicate exams", `[{"id": "neet", "title": "A"}, {"id": "neet", "title": "B"}]`, toolsJSON},
+		// Good exams are not swapped in alone when the tools are bad
+		{"bad tools", examsJSON("NEET UG"), `[{"id": "image"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := newReloadStorage(t)
+			stamp := js.examsStamp
+			writeDataFile(t, js.examsFile, tt.exams)
+			writeDataFile(t, js.toolsFile, tt.tools)
+
+			if err := js.Reload(context.Background()); err == nil {
+				t.Fatal("Reload of an invalid file succeeded")
+			}
+			if got := examTitle(t, js); got != "NEET" {
+				t.Errorf("title = %q; want the previous NEET kept", got)
+			}
+			tools, err := js.GetTools(context.Background())
+			if err != nil || len(tools) != 1 || tools[0].Category != "Image" {
+				t.Errorf("tools = %+v, %v; want the previous ones kept", tools, err)
+			}
+			if !js.examsStamp.equal(stamp) {
+				t.Error("stamp of the rejected file was recorded as loaded")
+			}
+		})
+	}
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// replaceFile swaps content with modification time mtime in for path in
+// one step, so a poll never sees the content with another time
+func replaceFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	tmp := path + ".new"
+	writeDataFile(t, tmp, content)
+	if err := os.Chtimes(tmp, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	js := newReloadStorage(t)
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	replaceFile(t, js.examsFile, examsJSON("NEET"), past)
+	if err := js.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		js.Watch(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	reloadsTo := func(title string) func() bool {
+		return func() bool {
+			exam, err := js.GetExamByID(context.Background(), "neet")
+			return err == nil && exam.Title == title
+		}
+	}
+
+	// A new size with the same modification time is a change
+	replaceFile(t, js.examsFile, examsJSON("NEET UG"), past)
+	waitFor(t, "a reload after a size change", reloadsTo("NEET UG"))
+
+	// So is a new modification time with the same size
+	replaceFile(t, js.examsFile, examsJSON("NEET PG"), past.Add(time.Minute))
+	waitFor(t, "a reload after a modification time change", reloadsTo("NEET PG"))
+
+	// A bad edit keeps what is being served
+	replaceFile(t, js.examsFile, `[{"id": "neet"`, past.Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if got := examTitle(t, js); got != "NEET PG" {
+		t.Errorf("title after a bad edit = %q; want NEET PG kept", got)
+	}
+
+	// Fixing it is picked up
+	replaceFile(t, js.examsFile, examsJSON("NEET SS"), past.Add(3*time.Minute))
+	waitFor(t, "a reload after the fix", reloadsTo("NEET SS"))
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// Exams that predate revision history have no recorded revisions. They are
+// treated as revision 1 of their current documents, and that baseline is
+// only written down once the documents first change, so loading an existing
+// exams.json or database records nothing.
+
+// revisionsFor works out which revisions to record when exam is saved and
+// sets exam.Revision. prev is the exam as it was before the save, or nil if
+// it is new; latest is its newest recorded revision, or nil if it has none.
+func revisionsFor(exam *models.Exam, prev *models.Exam, latest *models.ExamRevision, now time.Time) []models.ExamRevision {
+	next := func(n int) models.ExamRevision {
+		return models.ExamRevision{ExamID: exam.ID, Revision: n, Documents: exam.Documents, CreatedAt: now}
+	}
+
+	switch {
+	case latest != nil:
+		if sameDocuments(latest.Documents, exam.Documents) {
+			exam.Revision = latest.Revision
+			return nil
+		}
+		exam.Revision = latest.Revision + 1
+		return []models.ExamRevision{next(exam.Revision)}
+
+	case prev == nil:
+		exam.Revision = 1
+		return []models.ExamRevision{next(1)}
+
+	case sameDocuments(prev.Documents, exam.Documents):
+		exam.Revision = 1
+		return nil
+
+	default:
+		baseline := models.ExamRevision{ExamID: exam.ID, Revision: 1, Documents: prev.Documents, CreatedAt: prev.UpdatedAt}
+		exam.Revision = 2
+		return []models.ExamRevision{baseline, next(2)}
+	}
+}
+
+// pendingRevisions runs revisionsFor over every exam in next, comparing it
+// with the exam of the same ID in prev and the history recorded so far
+func pendingRevisions(history map[string][]models.ExamRevision, prev, next []models.Exam, now time.Time) []models.ExamRevision {
+	previous := make(map[string]*models.Exam, len(prev))
+	for i := range prev {
+		previous[prev[i].ID] = &prev[i]
+	}
+
+	var pending []models.ExamRevision
+	for i := range next {
+		exam := &next[i]
+		pending = append(pending, revisionsFor(exam, previous[exam.ID], latestRevision(history[exam.ID]), now)...)
+	}
+	return pending
+}
+
+// addRevisions appends revs to the history of their exams
+func addRevisions(history map[string][]models.ExamRevision, revs []models.ExamRevision) {
+	for _, rev := range revs {
+		history[rev.ExamID] = append(history[rev.ExamID], rev)
+	}
+}
+
+// findExam returns the exam with the given ID in exams, or nil
+func findExam(exams []models.Exam, examID string) *models.Exam {
+	for i := range exams {
+		if exams[i].ID == examID {
+			return &exams[i]
+		}
+	}
+	return nil
+}
+
+// latestRevision returns the last of revs, or nil
+func latestRevision(revs []models.ExamRevision) *models.ExamRevision {
+	if len(revs) == 0 {
+		return nil
+	}
+	return &revs[len(revs)-1]
+}
+
+// baselineRevision is the implicit first revision of an exam that has never
+// had its documents changed
+func baselineRevision(exam *models.Exam) models.ExamRevision {
+	return models.ExamRevision{ExamID: exam.ID, Revision: 1, Documents: exam.Documents, CreatedAt: exam.CreatedAt}
+}
+
+// examRevisions returns the recorded revisions of an exam, or its baseline
+// if it has none. exam may be nil if the exam has since been deleted.
+func examRevisions(recorded []models.ExamRevision, exam *models.Exam, examID string) ([]models.ExamRevision, error) {
+	if len(recorded) > 0 {
+		return append([]models.ExamRevision{}, recorded...), nil
+	}
+	if exam == nil {
+		return nil, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+	}
+	return []models.ExamRevision{baselineRevision(exam)}, nil
+}
+
+// sameDocuments reports whether two document lists are identical, treating
+// nil and empty as equal
+func sameDocuments(a, b []models.Document) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// DiffRevisions compares the documents of two revisions of the same exam
+func DiffRevisions(from, to models.ExamRevision) models.ExamRevisionDiff {
+	diff := models.ExamRevisionDiff{
+		ExamID:  to.ExamID,
+		From:    from.Revision,
+		To:      to.Revision,
+		Added:   []models.Document{},
+		Removed: []models.Document{},
+		Changed: []models.DocumentChange{},
+	}
+
+	oldDocs := make(map[string]models.Document, len(from.Documents))
+	for _, doc := range from.Documents {
+		oldDocs[doc.ID] = doc
+	}
+	newDocs := make(map[string]bool, len(to.Documents))
+	for _, doc := range to.Documents {
+		newDocs[doc.ID] = true
+	}
+
+	// Positions are compared among the documents present in both revisions,
+	// so adding or removing one does not report every later one as moved
+	oldRank := map[string]int{}
+	for _, doc := range from.Documents {
+		if newDocs[doc.ID] {
+			oldRank[doc.ID] = len(oldRank)
+		}
+	}
+
+	for _, doc := range from.Documents {
+		if !newDocs[doc.ID] {
+			diff.Removed = append(diff.Removed, doc)
+		}
+	}
+	rank := 0
+	for _, doc := range to.Documents {
+		old, ok := oldDocs[doc.ID]
+		if !ok {
+			diff.Added = append(diff.Added, doc)
+			continue
+		}
+		fields := documentFieldChanges(old, doc)
+		if oldRank[doc.ID] != rank {
+			fields = append(fields, models.FieldChange{Field: "position", From: oldRank[doc.ID], To: rank})
+		}
+		rank++
+		if len(fields) > 0 {
+			diff.Changed = append(diff.Changed, models.DocumentChange{DocumentID: doc.ID, Fields: fields})
+		}
+	}
+	return diff
+}
+
+// documentFieldChanges lists the fields that differ between two versions of
+// a document
+func documentFieldChanges(a, b models.Document) []models.FieldChange {
+	var fields []models.FieldChange
+	add := func(name string, from, to interface{}) {
+		if from != to {
+			fields = append(fields, models.FieldChange{Field: name, From: from, To: to})
+		}
+	}
+	add("name", a.Name, b.Name)
+	add("size", a.Size, b.Size)
+	add("format", a.Format, b.Format)
+	add("max_size", a.MaxSize, b.MaxSize)
+	add("required", a.Required, b.Required)
+	add("min_width", a.MinWidth, b.MinWidth)
+	add("max_width", a.MaxWidth, b.MaxWidth)
+	add("min_height", a.MinHeight, b.MinHeight)
+	add("max_height", a.MaxHeight, b.MaxHeight)
+	add("aspect_ratio", a.AspectRatio, b.AspectRatio)
+	add("dpi", a.DPI, b.DPI)
+	add("width_cm", a.WidthCM, b.WidthCM)
+	add("height_cm", a.HeightCM, b.HeightCM)
+	add("fit", a.Fit, b.Fit)
+	return fields
+}
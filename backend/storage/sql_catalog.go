@@ -0,0 +1,521 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oneforall/backend/models"
+)
+
+// CreateExam inserts a new exam and its documents after the existing ones
+func (ss *sqlStorage) CreateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	now := time.Now().UTC()
+	exam.CreatedAt = now
+	exam.UpdatedAt = now
+	if exam.Documents == nil {
+		exam.Documents = []models.Document{}
+	}
+	if err := ValidateExam(exam); err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A deleted exam of the same ID leaves its history behind; carry on from it
+	latest, err := latestRevisionTx(ctx, tx, exam.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exam revisions: %w", err)
+	}
+	revisions := revisionsFor(&exam, nil, latest, now)
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO exams (exam_id, title, icon, description, revision, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, (SELECT COALESCE(MAX(sort_order), -1) + 1 FROM exams), $6, $6)
+		ON CONFLICT (exam_id) DO NOTHING`,
+		exam.ID, exam.Title, exam.Icon, exam.Description, exam.Revision, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("exam %s: %w", exam.ID, ErrConflict)
+	}
+	if err := replaceDocumentsTx(ctx, tx, exam.ID, exam.Documents, now); err != nil {
+		return nil, fmt.Errorf("failed to save documents: %w", err)
+	}
+	if err := insertRevisionsTx(ctx, tx, revisions); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	return &exam, nil
+}
+
+// UpdateExam replaces an exam's fields and documents, keeping its position
+// and CreatedAt
+func (ss *sqlStorage) UpdateExam(ctx context.Context, exam models.Exam) (*models.Exam, error) {
+	return ss.updateExam(ctx, exam, 0)
+}
+
+// UpdateExamDocuments replaces an exam's documents if they are still at
+// revision, keeping its other fields
+func (ss *sqlStorage) UpdateExamDocuments(ctx context.Context, examID string, revision int, docs []models.Document) (*models.Exam, error) {
+	if revision <= 0 {
+		return nil, fmt.Errorf("exam %s has no revision %d: %w", examID, revision, ErrStale)
+	}
+	return ss.updateExam(ctx, models.Exam{ID: examID, Documents: docs}, revision)
+}
+
+// updateExam replaces an exam, or with a revision only its documents if
+// they are still at that revision. A concurrent writer saving a revision
+// first is reported as ErrStale.
+func (ss *sqlStorage) updateExam(ctx context.Context, exam models.Exam, revision int) (*models.Exam, error) {
+	if exam.Documents == nil {
+		exam.Documents = []models.Document{}
+	}
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	defer tx.Rollback()
+
+	prev := models.Exam{ID: exam.ID}
+	err = tx.QueryRowContext(ctx, `SELECT title, icon, description, created_at, updated_at FROM exams WHERE exam_id = $1`, exam.ID).
+		Scan(&prev.Title, &prev.Icon, &prev.Description, &prev.CreatedAt, &prev.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("exam %s: %w", exam.ID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exam: %w", err)
+	}
+	if prev.Documents, err = queryDocuments(ctx, tx, exam.ID); err != nil {
+		return nil, err
+	}
+	latest, err := latestRevisionTx(ctx, tx, exam.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exam revisions: %w", err)
+	}
+	if revision > 0 {
+		current := 1
+		if latest != nil {
+			current = latest.Revision
+		}
+		if current != revision {
+			return nil, fmt.Errorf("exam %s is at revision %d, not %d: %w", exam.ID, current, revision, ErrStale)
+		}
+		exam.Title, exam.Icon, exam.Description = prev.Title, prev.Icon, prev.Description
+	}
+	if err := ValidateExam(exam); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	revisions := revisionsFor(&exam, &prev, latest, now)
+	exam.CreatedAt = prev.CreatedAt
+	exam.UpdatedAt = now
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE exams
+		SET title = $2, icon = $3, description = $4, revision = $5, updated_at = $6
+		WHERE exam_id = $1`,
+		exam.ID, exam.Title, exam.Icon, exam.Description, exam.Revision, now); err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	if err := replaceDocumentsTx(ctx, tx, exam.ID, exam.Documents, now); err != nil {
+		return nil, fmt.Errorf("failed to save documents: %w", err)
+	}
+	if err := insertRevisionsTx(ctx, tx, revisions); err != nil {
+		if revision > 0 && errors.Is(err, ErrConflict) {
+			return nil, fmt.Errorf("exam %s: %w", exam.ID, ErrStale)
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save exam: %w", err)
+	}
+	return &exam, nil
+}
+
+// DeleteExam removes an exam; its documents go with it through ON DELETE CASCADE
+func (ss *sqlStorage) DeleteExam(ctx context.Context, examID string) error {
+	res, err := ss.db.ExecContext(ctx, `DELETE FROM exams WHERE exam_id = $1`, examID)
+	if err != nil {
+		return fmt.Errorf("failed to delete exam: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetExamRevisions returns the recorded revisions of an exam, or the
+// implicit first one if its documents never changed
+func (ss *sqlStorage) GetExamRevisions(ctx context.Context, examID string) ([]models.ExamRevision, error) {
+	rows, err := ss.db.QueryContext(ctx, `
+		SELECT revision, documents, created_at
+		FROM exam_revisions
+		WHERE exam_id = $1
+		ORDER BY revision`, examID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exam revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var recorded []models.ExamRevision
+	for rows.Next() {
+		rev, err := scanRevision(rows, examID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan exam revision: %w", err)
+		}
+		recorded = append(recorded, *rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query exam revisions: %w", err)
+	}
+	if len(recorded) > 0 {
+		return recorded, nil
+	}
+
+	exam, err := ss.GetExamByID(ctx, examID)
+	if err != nil {
+		return nil, err
+	}
+	return examRevisions(nil, exam, examID)
+}
+
+// latestRevisionTx returns the newest recorded revision of an exam, or nil
+func latestRevisionTx(ctx context.Context, tx *sql.Tx, examID string) (*models.ExamRevision, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT revision, documents, created_at
+		FROM exam_revisions
+		WHERE exam_id = $1
+		ORDER BY revision DESC
+		LIMIT 1`, examID)
+	rev, err := scanRevision(row, examID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rev, err
+}
+
+// scanRevision reads a revision selected as revision, documents, created_at
+func scanRevision(row rowScanner, examID string) (*models.ExamRevision, error) {
+	rev := models.ExamRevision{ExamID: examID}
+	var documents []byte
+	if err := row.Scan(&rev.Revision, &documents, &rev.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(documents, &rev.Documents); err != nil {
+		return nil, fmt.Errorf("revision %d of %s: %w", rev.Revision, examID, err)
+	}
+	return &rev, nil
+}
+
+// insertRevisionsTx records new exam revisions
+func insertRevisionsTx(ctx context.Context, tx *sql.Tx, revisions []models.ExamRevision) error {
+	for _, rev := range revisions {
+		if err := insertRevisionTx(ctx, tx, rev, rev.CreatedAt.UTC(), false); err != nil {
+			return fmt.Errorf("failed to save revision %d of %s: %w", rev.Revision, rev.ExamID, err)
+		}
+	}
+	return nil
+}
+
+// insertRevisionTx inserts one revision. With replace set an existing
+// revision of the same number is overwritten, as imports need; otherwise it
+// is a conflict, which means another writer got there first.
+func insertRevisionTx(ctx context.Context, tx *sql.Tx, rev models.ExamRevision, createdAt time.Time, replace bool) error {
+	docs := rev.Documents
+	if docs == nil {
+		docs = []models.Document{}
+	}
+	documents, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+	onConflict := `ON CONFLICT (exam_id, revision) DO NOTHING`
+	if replace {
+		onConflict = `ON CONFLICT (exam_id, revision) DO UPDATE SET documents = excluded.documents, created_at = excluded.created_at`
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO exam_revisions (exam_id, revision, documents, created_at)
+		VALUES ($1, $2, $3, $4) `+onConflict,
+		rev.ExamID, rev.Revision, string(documents), createdAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 && !replace {
+		return fmt.Errorf("exam %s revision %d: %w", rev.ExamID, rev.Revision, ErrConflict)
+	}
+	return nil
+}
+
+// replaceDocumentsTx replaces the documents of an exam, keeping their order
+func replaceDocumentsTx(ctx context.Context, tx *sql.Tx, examID string, docs []models.Document, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE exam_id = $1`, examID); err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO documents (document_id, exam_id, name, size, format, max_size, required,
+				min_width, max_width, min_height, max_height, aspect_ratio, dpi, width_cm, height_cm, fit,
+				sort_order, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $18)`,
+			doc.ID, examID, doc.Name, doc.Size, doc.Format, doc.MaxSize, doc.Required,
+			nullableInt(doc.MinWidth), nullableInt(doc.MaxWidth), nullableInt(doc.MinHeight), nullableInt(doc.MaxHeight),
+			nullableString(doc.AspectRatio), nullableInt(doc.DPI), nullableFloat(doc.WidthCM), nullableFloat(doc.HeightCM),
+			nullableString(doc.Fit), i, now); err != nil {
+			return fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// CreateToolCategory inserts a tool category and its tools after the
+// existing categories
+func (ss *sqlStorage) CreateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
+	if cat.Tools == nil {
+		cat.Tools = []models.Tool{}
+	}
+	if err := ValidateTools([]models.ToolCategory{cat}); err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool category: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO tool_categories (category_id, name, icon, disabled, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(MAX(sort_order), -1) + 1 FROM tool_categories), $5, $5)
+		ON CONFLICT (category_id) DO NOTHING`,
+		cat.ID, cat.Category, cat.Icon, cat.Disabled, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool category: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("tool category %s: %w", cat.ID, ErrConflict)
+	}
+	for i, tool := range cat.Tools {
+		if err := insertToolTx(ctx, tx, cat.ID, tool, i, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save tool category: %w", err)
+	}
+	return &cat, nil
+}
+
+// UpdateToolCategory changes a category's name, icon and disabled flag
+func (ss *sqlStorage) UpdateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error) {
+	cat.Tools = nil
+	if err := ValidateToolCategory(cat); err != nil {
+		return nil, err
+	}
+
+	res, err := ss.db.ExecContext(ctx, `
+		UPDATE tool_categories
+		SET name = $2, icon = $3, disabled = $4, updated_at = $5
+		WHERE category_id = $1`,
+		cat.ID, cat.Category, cat.Icon, cat.Disabled, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool category: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("tool category %s: %w", cat.ID, ErrNotFound)
+	}
+
+	categories, err := ss.GetTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if i := findToolCategory(categories, cat.ID); i >= 0 {
+		return &categories[i], nil
+	}
+	return nil, fmt.Errorf("tool category %s: %w", cat.ID, ErrNotFound)
+}
+
+// DeleteToolCategory removes a category; its tools go with it through
+// ON DELETE CASCADE
+func (ss *sqlStorage) DeleteToolCategory(ctx context.Context, categoryID string) error {
+	res, err := ss.db.ExecContext(ctx, `DELETE FROM tool_categories WHERE category_id = $1`, categoryID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tool category: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	return nil
+}
+
+// ReorderToolCategories rewrites the sort order of every category
+func (ss *sqlStorage) ReorderToolCategories(ctx context.Context, ids []string) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reorder tool categories: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := queryIDsTx(ctx, tx, `SELECT category_id FROM tool_categories`)
+	if err != nil {
+		return fmt.Errorf("failed to reorder tool categories: %w", err)
+	}
+	if err := checkPermutation("tool categories", len(existing), ids, func(id string) bool { return existing[id] }); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE tool_categories SET sort_order = $2 WHERE category_id = $1`, id, i); err != nil {
+			return fmt.Errorf("failed to reorder tool categories: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to reorder tool categories: %w", err)
+	}
+	return nil
+}
+
+// CreateTool inserts a tool after the existing tools of its category
+func (ss *sqlStorage) CreateTool(ctx context.Context, categoryID string, tool models.Tool) (*models.Tool, error) {
+	if err := ValidateTool(tool); err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool: %w", err)
+	}
+	defer tx.Rollback()
+
+	var next int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT MAX(sort_order) FROM tools WHERE category = $1), -1) + 1
+		FROM tool_categories WHERE category_id = $1`, categoryID).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool: %w", err)
+	}
+	if err := insertToolTx(ctx, tx, categoryID, tool, next, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save tool: %w", err)
+	}
+	return &tool, nil
+}
+
+// UpdateTool changes a tool's fields, keeping its category and position
+func (ss *sqlStorage) UpdateTool(ctx context.Context, tool models.Tool) (*models.Tool, error) {
+	if err := ValidateTool(tool); err != nil {
+		return nil, err
+	}
+
+	res, err := ss.db.ExecContext(ctx, `
+		UPDATE tools
+		SET name = $2, description = $3, logo = $4, disabled = $5, updated_at = $6
+		WHERE tool_id = $1`,
+		tool.ID, tool.Name, tool.Description, tool.Logo, tool.Disabled, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tool: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("tool %s: %w", tool.ID, ErrNotFound)
+	}
+	return &tool, nil
+}
+
+// DeleteTool removes a tool
+func (ss *sqlStorage) DeleteTool(ctx context.Context, toolID string) error {
+	res, err := ss.db.ExecContext(ctx, `DELETE FROM tools WHERE tool_id = $1`, toolID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tool: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("tool %s: %w", toolID, ErrNotFound)
+	}
+	return nil
+}
+
+// ReorderTools rewrites the sort order of the tools in a category
+func (ss *sqlStorage) ReorderTools(ctx context.Context, categoryID string, ids []string) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reorder tools: %w", err)
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM tool_categories WHERE category_id = $1`, categoryID).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("tool category %s: %w", categoryID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reorder tools: %w", err)
+	}
+
+	existing, err := queryIDsTx(ctx, tx, `SELECT tool_id FROM tools WHERE category = $1`, categoryID)
+	if err != nil {
+		return fmt.Errorf("failed to reorder tools: %w", err)
+	}
+	if err := checkPermutation("tools", len(existing), ids, func(id string) bool { return existing[id] }); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE tools SET sort_order = $2 WHERE tool_id = $1`, id, i); err != nil {
+			return fmt.Errorf("failed to reorder tools: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to reorder tools: %w", err)
+	}
+	return nil
+}
+
+// insertToolTx inserts one tool, reporting an existing tool ID as a conflict
+func insertToolTx(ctx context.Context, tx *sql.Tx, categoryID string, tool models.Tool, sortOrder int, now time.Time) error {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO tools (tool_id, category, name, description, logo, disabled, sort_order, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (tool_id) DO NOTHING`,
+		tool.ID, categoryID, tool.Name, tool.Description, tool.Logo, tool.Disabled, sortOrder, now)
+	if err != nil {
+		return fmt.Errorf("failed to save tool %s: %w", tool.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("tool %s: %w", tool.ID, ErrConflict)
+	}
+	return nil
+}
+
+// queryIDsTx returns the set of IDs selected by query
+func queryIDsTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
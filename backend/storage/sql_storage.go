@@ -0,0 +1,719 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneforall/backend/migrate"
+	"github.com/oneforall/backend/models"
+)
+
+// sqlStorage implements Store on top of database/sql. The queries only use
+// $N placeholders and ON CONFLICT clauses, which both PostgreSQL and SQLite
+// understand, so PostgresStorage and SQLiteStorage share it.
+type sqlStorage struct {
+	name       string
+	driverName string
+	dsn        string
+	dialect    string
+	// maxOpenConns limits the connection pool when non-zero
+	maxOpenConns int
+	// autoMigrate applies pending migrations in Initialize; when false,
+	// Initialize refuses to start on an outdated schema
+	autoMigrate bool
+	db          *sql.DB
+}
+
+var _ Importer = (*sqlStorage)(nil)
+
+// Initialize connects to the database and brings the schema up to date
+func (ss *sqlStorage) Initialize(ctx context.Context) error {
+	migrator, err := ss.Migrator(ctx)
+	if err != nil {
+		return err
+	}
+
+	if ss.autoMigrate {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+	} else {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check schema version: %w", err)
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d pending migrations; run `migrate up` first", pending)
+		}
+	}
+
+	log.Printf("✓ %s storage initialized successfully", ss.name)
+	return nil
+}
+
+// Migrator connects to the database if needed and returns a migrator for
+// its schema, without applying anything
+func (ss *sqlStorage) Migrator(ctx context.Context) (*migrate.Migrator, error) {
+	if ss.db == nil {
+		db, err := sql.Open(ss.driverName, ss.dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		if ss.maxOpenConns > 0 {
+			db.SetMaxOpenConns(ss.maxOpenConns)
+		}
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		ss.db = db
+	}
+	return migrate.New(ss.db, ss.dialect)
+}
+
+// Close releases the database connection pool
+func (ss *sqlStorage) Close() error {
+	if ss.db == nil {
+		return nil
+	}
+	return ss.db.Close()
+}
+
+// GetExams returns all exams with their documents
+func (ss *sqlStorage) GetExams(ctx context.Context) ([]models.Exam, error) {
+	rows, err := ss.db.QueryContext(ctx, `
+		SELECT exam_id, title, COALESCE(icon, ''), COALESCE(description, ''), revision, created_at, updated_at
+		FROM exams
+		ORDER BY sort_order, exam_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exams: %w", err)
+	}
+	defer rows.Close()
+
+	exams := []models.Exam{}
+	index := map[string]int{}
+	for rows.Next() {
+		var exam models.Exam
+		if err := rows.Scan(&exam.ID, &exam.Title, &exam.Icon, &exam.Description, &exam.Revision, &exam.CreatedAt, &exam.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan exam: %w", err)
+		}
+		exam.Documents = []models.Document{}
+		index[exam.ID] = len(exams)
+		exams = append(exams, exam)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query exams: %w", err)
+	}
+
+	docs, err := ss.db.QueryContext(ctx, `
+		SELECT exam_id, `+documentColumns+`
+		FROM documents
+		ORDER BY exam_id, sort_order, document_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer docs.Close()
+
+	for docs.Next() {
+		var examID string
+		var doc models.Document
+		if err := docs.Scan(append([]interface{}{&examID}, documentFields(&doc)...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		if i, ok := index[examID]; ok {
+			exams[i].Documents = append(exams[i].Documents, doc)
+		}
+	}
+	if err := docs.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	return exams, nil
+}
+
+// GetExamByID returns a specific exam by ID
+func (ss *sqlStorage) GetExamByID(ctx context.Context, examID string) (*models.Exam, error) {
+	exam := models.Exam{ID: examID, Documents: []models.Document{}}
+	err := ss.db.QueryRowContext(ctx, `
+		SELECT title, COALESCE(icon, ''), COALESCE(description, ''), revision, created_at, updated_at
+		FROM exams
+		WHERE exam_id = $1`, examID).
+		Scan(&exam.Title, &exam.Icon, &exam.Description, &exam.Revision, &exam.CreatedAt, &exam.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("exam %s: %w", examID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exam: %w", err)
+	}
+
+	exam.Documents, err = queryDocuments(ctx, ss.db, examID)
+	if err != nil {
+		return nil, err
+	}
+	return &exam, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// documentColumns lists the document columns read into documentFields
+const documentColumns = `document_id, name, COALESCE(size, ''), COALESCE(format, ''), COALESCE(max_size, 0), COALESCE(required, false),
+		COALESCE(min_width, 0), COALESCE(max_width, 0), COALESCE(min_height, 0), COALESCE(max_height, 0),
+		COALESCE(aspect_ratio, ''), COALESCE(dpi, 0), COALESCE(width_cm, 0), COALESCE(height_cm, 0), COALESCE(fit, '')`
+
+// documentFields returns the scan destinations for documentColumns
+func documentFields(doc *models.Document) []interface{} {
+	return []interface{}{&doc.ID, &doc.Name, &doc.Size, &doc.Format, &doc.MaxSize, &doc.Required,
+		&doc.MinWidth, &doc.MaxWidth, &doc.MinHeight, &doc.MaxHeight,
+		&doc.AspectRatio, &doc.DPI, &doc.WidthCM, &doc.HeightCM, &doc.Fit}
+}
+
+// queryDocuments returns the documents of one exam in order
+func queryDocuments(ctx context.Context, q queryer, examID string) ([]models.Document, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE exam_id = $1
+		ORDER BY sort_order, document_id`, examID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	docs := []models.Document{}
+	for rows.Next() {
+		var doc models.Document
+		if err := rows.Scan(documentFields(&doc)...); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	return docs, nil
+}
+
+// GetTools returns all tool categories with their tools
+func (ss *sqlStorage) GetTools(ctx context.Context) ([]models.ToolCategory, error) {
+	rows, err := ss.db.QueryContext(ctx, `
+		SELECT category_id, name, COALESCE(icon, ''), disabled
+		FROM tool_categories
+		ORDER BY sort_order, category_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tool categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.ToolCategory{}
+	index := map[string]int{}
+	for rows.Next() {
+		var cat models.ToolCategory
+		if err := rows.Scan(&cat.ID, &cat.Category, &cat.Icon, &cat.Disabled); err != nil {
+			return nil, fmt.Errorf("failed to scan tool category: %w", err)
+		}
+		cat.Tools = []models.Tool{}
+		index[cat.ID] = len(categories)
+		categories = append(categories, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query tool categories: %w", err)
+	}
+
+	tools, err := ss.db.QueryContext(ctx, `
+		SELECT tool_id, category, name, COALESCE(description, ''), COALESCE(logo, ''), disabled
+		FROM tools
+		ORDER BY category, sort_order, tool_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tools: %w", err)
+	}
+	defer tools.Close()
+
+	for tools.Next() {
+		var categoryID string
+		var tool models.Tool
+		if err := tools.Scan(&tool.ID, &categoryID, &tool.Name, &tool.Description, &tool.Logo, &tool.Disabled); err != nil {
+			return nil, fmt.Errorf("failed to scan tool: %w", err)
+		}
+		if i, ok := index[categoryID]; ok {
+			categories[i].Tools = append(categories[i].Tools, tool)
+		}
+	}
+	if err := tools.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query tools: %w", err)
+	}
+	return categories, nil
+}
+
+// CreateConversion creates a new conversion request and records it in the
+// user's conversion history
+func (ss *sqlStorage) CreateConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	now := time.Now().UTC()
+	conv.ID = uuid.New().String()
+	conv.Status = "pending"
+	conv.CreatedAt = now
+	conv.UpdatedAt = now
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversion: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO conversion_requests
+			(conversion_id, user_id, exam_id, document_id, exam_revision, file_name, file_size,
+			 tool_id, options, kit_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		conv.ID, conv.UserID, conv.ExamID, conv.DocumentID, nullableInt(conv.ExamRevision), conv.FileName, conv.FileSize,
+		nullableString(conv.ToolID), nullableString(optionsKey(conv.Options)), nullableString(conv.KitID),
+		conv.Status, conv.CreatedAt, conv.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to save conversion: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO users (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING`, conv.UserID); err != nil {
+		return nil, fmt.Errorf("failed to save user: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_conversions (user_id, conversion_id, created_at)
+		VALUES ($1, $2, $3)`, conv.UserID, conv.ID, now); err != nil {
+		return nil, fmt.Errorf("failed to save user conversion: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save conversion: %w", err)
+	}
+	return &conv, nil
+}
+
+// GetConversionByID retrieves a conversion request by ID
+func (ss *sqlStorage) GetConversionByID(ctx context.Context, conversionID string) (*models.ConversionRequest, error) {
+	row := ss.db.QueryRowContext(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE conversion_id = $1`, conversionID)
+	conv, err := scanConversion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversion: %w", err)
+	}
+	return conv, nil
+}
+
+// UpdateConversion updates a conversion request
+func (ss *sqlStorage) UpdateConversion(ctx context.Context, conversionID, status, errorMsg string) error {
+	res, err := ss.db.ExecContext(ctx, `
+		UPDATE conversion_requests
+		SET status = $2, error_msg = $3, updated_at = $4
+		WHERE conversion_id = $1`, conversionID, status, errorMsg, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save conversion: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("conversion %s: %w", conversionID, ErrNotFound)
+	}
+	return nil
+}
+
+// SaveConversion replaces the mutable fields of an existing conversion request
+func (ss *sqlStorage) SaveConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error) {
+	conv.UpdatedAt = time.Now().UTC()
+	var options string
+	err := ss.db.QueryRowContext(ctx, `
+		UPDATE conversion_requests
+		SET file_name = $2, file_size = $3, input_path = $4, output_path = $5,
+			status = $6, error_msg = $7, content_type = $8, input_hash = $9,
+			scan_verdict = $10, scan_signature = $11, output_size = $12, output_settings = $13,
+			updated_at = $14
+		WHERE conversion_id = $1
+		RETURNING user_id, COALESCE(exam_id, ''), COALESCE(document_id, ''), COALESCE(exam_revision, 0),
+			COALESCE(tool_id, ''), COALESCE(options, ''), COALESCE(kit_id, ''), created_at`,
+		conv.ID, conv.FileName, conv.FileSize, conv.InputPath, conv.OutputPath,
+		conv.Status, conv.ErrorMsg, conv.ContentType, nullableString(conv.InputHash),
+		nullableString(conv.ScanVerdict), nullableString(conv.ScanSignature),
+		nullableInt64(conv.OutputSize), nullableString(optionsKey(conv.OutputSettings)), conv.UpdatedAt).
+		Scan(&conv.UserID, &conv.ExamID, &conv.DocumentID, &conv.ExamRevision, &conv.ToolID, &options, &conv.KitID, &conv.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("conversion %s: %w", conv.ID, ErrNotFound)
+	}
+	if err == nil {
+		conv.Options, err = parseOptionsKey(options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversion: %w", err)
+	}
+	return &conv, nil
+}
+
+// ListConversionsByStatus retrieves all conversions with a status, oldest first
+func (ss *sqlStorage) ListConversionsByStatus(ctx context.Context, status string) ([]models.ConversionRequest, error) {
+	return ss.queryConversions(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE status = $1
+		ORDER BY created_at`, status)
+}
+
+// FindCachedConversion finds a completed conversion whose output can be reused
+func (ss *sqlStorage) FindCachedConversion(ctx context.Context, inputHash, toolID string, options map[string]string) (*models.ConversionRequest, error) {
+	row := ss.db.QueryRowContext(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE input_hash = $1 AND COALESCE(tool_id, '') = $2 AND COALESCE(options, '') = $3
+			AND status = 'completed' AND COALESCE(output_path, '') <> ''
+		ORDER BY updated_at DESC
+		LIMIT 1`, inputHash, toolID, optionsKey(options))
+	conv, err := scanConversion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("cached conversion: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversion: %w", err)
+	}
+	return conv, nil
+}
+
+// AddBlobRef counts one more reference to a blob
+func (ss *sqlStorage) AddBlobRef(ctx context.Context, key string) (int, error) {
+	var refs int
+	err := ss.db.QueryRowContext(ctx, `
+		INSERT INTO blob_refs (blob_key, refs) VALUES ($1, 1)
+		ON CONFLICT (blob_key) DO UPDATE SET refs = blob_refs.refs + 1
+		RETURNING refs`, key).Scan(&refs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save blob reference: %w", err)
+	}
+	return refs, nil
+}
+
+// ReleaseBlobRef drops a reference to a blob, removing its row with the last one
+func (ss *sqlStorage) ReleaseBlobRef(ctx context.Context, key string) (int, error) {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release blob reference: %w", err)
+	}
+	defer tx.Rollback()
+
+	var refs int
+	err = tx.QueryRowContext(ctx, `SELECT refs FROM blob_refs WHERE blob_key = $1`, key).Scan(&refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to release blob reference: %w", err)
+	}
+	if refs <= 1 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM blob_refs WHERE blob_key = $1`, key)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE blob_refs SET refs = refs - 1 WHERE blob_key = $1`, key)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to release blob reference: %w", err)
+	}
+	return refs - 1, nil
+}
+
+// GetUserConversions retrieves all conversions for a specific user
+func (ss *sqlStorage) GetUserConversions(ctx context.Context, userID string) ([]models.ConversionRequest, error) {
+	return ss.queryConversions(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE user_id = $1
+		ORDER BY created_at`, userID)
+}
+
+// GetConversionsByExam retrieves all conversions for a specific exam
+func (ss *sqlStorage) GetConversionsByExam(ctx context.Context, examID string) ([]models.ConversionRequest, error) {
+	return ss.queryConversions(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE exam_id = $1
+		ORDER BY created_at`, examID)
+}
+
+// GetKitConversions retrieves the conversions of an exam kit
+func (ss *sqlStorage) GetKitConversions(ctx context.Context, kitID string) ([]models.ConversionRequest, error) {
+	return ss.queryConversions(ctx, `
+		SELECT `+conversionColumns+`
+		FROM conversion_requests
+		WHERE kit_id = $1
+		ORDER BY created_at`, kitID)
+}
+
+// conversionColumns lists the columns read by scanConversion, in order
+const conversionColumns = `conversion_id, user_id, COALESCE(exam_id, ''), COALESCE(document_id, ''),
+		file_name, file_size, COALESCE(input_path, ''), COALESCE(output_path, ''),
+		COALESCE(status, 'pending'), COALESCE(error_msg, ''), COALESCE(exam_revision, 0), COALESCE(content_type, ''),
+		COALESCE(input_hash, ''), COALESCE(tool_id, ''), COALESCE(options, ''),
+		COALESCE(scan_verdict, ''), COALESCE(scan_signature, ''),
+		COALESCE(output_size, 0), COALESCE(output_settings, ''), COALESCE(kit_id, ''), created_at, updated_at`
+
+// queryConversions runs a conversion query and collects the results
+func (ss *sqlStorage) queryConversions(ctx context.Context, query string, args ...interface{}) ([]models.ConversionRequest, error) {
+	rows, err := ss.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversions: %w", err)
+	}
+	defer rows.Close()
+
+	var conversions []models.ConversionRequest
+	for rows.Next() {
+		conv, err := scanConversion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversion: %w", err)
+		}
+		conversions = append(conversions, *conv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query conversions: %w", err)
+	}
+	return conversions, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanConversion reads a conversion selected with conversionColumns
+func scanConversion(row rowScanner) (*models.ConversionRequest, error) {
+	var conv models.ConversionRequest
+	var options, settings string
+	err := row.Scan(&conv.ID, &conv.UserID, &conv.ExamID, &conv.DocumentID,
+		&conv.FileName, &conv.FileSize, &conv.InputPath, &conv.OutputPath,
+		&conv.Status, &conv.ErrorMsg, &conv.ExamRevision, &conv.ContentType,
+		&conv.InputHash, &conv.ToolID, &options, &conv.ScanVerdict, &conv.ScanSignature,
+		&conv.OutputSize, &settings, &conv.KitID, &conv.CreatedAt, &conv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Options, err = parseOptionsKey(options); err != nil {
+		return nil, fmt.Errorf("conversion %s has invalid options: %w", conv.ID, err)
+	}
+	if conv.OutputSettings, err = parseOptionsKey(settings); err != nil {
+		return nil, fmt.Errorf("conversion %s has invalid output settings: %w", conv.ID, err)
+	}
+	return &conv, nil
+}
+
+// ImportExams inserts or replaces the given exams and their documents
+func (ss *sqlStorage) ImportExams(ctx context.Context, exams []models.Exam) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import exams: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, exam := range exams {
+		createdAt, updatedAt := importTimes(exam.CreatedAt, exam.UpdatedAt)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO exams (exam_id, title, icon, description, revision, sort_order, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (exam_id) DO UPDATE SET
+				title = excluded.title, icon = excluded.icon, description = excluded.description,
+				revision = excluded.revision, sort_order = excluded.sort_order, updated_at = excluded.updated_at`,
+			exam.ID, exam.Title, exam.Icon, exam.Description, max(exam.Revision, 1), i, createdAt, updatedAt); err != nil {
+			return fmt.Errorf("failed to import exam %s: %w", exam.ID, err)
+		}
+		if err := replaceDocumentsTx(ctx, tx, exam.ID, exam.Documents, updatedAt); err != nil {
+			return fmt.Errorf("failed to import documents for %s: %w", exam.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to import exams: %w", err)
+	}
+	return nil
+}
+
+// ImportTools inserts or replaces the given tool categories and their tools
+func (ss *sqlStorage) ImportTools(ctx context.Context, categories []models.ToolCategory) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import tools: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for i, cat := range categories {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO tool_categories (category_id, name, icon, disabled, sort_order, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)
+			ON CONFLICT (category_id) DO UPDATE SET
+				name = excluded.name, icon = excluded.icon, disabled = excluded.disabled,
+				sort_order = excluded.sort_order, updated_at = excluded.updated_at`,
+			cat.ID, cat.Category, cat.Icon, cat.Disabled, i, now); err != nil {
+			return fmt.Errorf("failed to import tool category %s: %w", cat.ID, err)
+		}
+		for j, tool := range cat.Tools {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO tools (tool_id, category, name, description, logo, disabled, sort_order, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+				ON CONFLICT (tool_id) DO UPDATE SET
+					category = excluded.category, name = excluded.name, description = excluded.description,
+					logo = excluded.logo, disabled = excluded.disabled, sort_order = excluded.sort_order,
+					updated_at = excluded.updated_at`,
+				tool.ID, cat.ID, tool.Name, tool.Description, tool.Logo, tool.Disabled, j, now); err != nil {
+				return fmt.Errorf("failed to import tool %s: %w", tool.ID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to import tools: %w", err)
+	}
+	return nil
+}
+
+// ImportConversions inserts or replaces the given conversion requests
+func (ss *sqlStorage) ImportConversions(ctx context.Context, conversions []models.ConversionRequest) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import conversions: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, conv := range conversions {
+		createdAt, updatedAt := importTimes(conv.CreatedAt, conv.UpdatedAt)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO conversion_requests
+				(conversion_id, user_id, exam_id, document_id, exam_revision, file_name, file_size,
+				 input_path, output_path, status, error_msg, content_type, input_hash, tool_id, options,
+				 scan_verdict, scan_signature, output_size, output_settings, kit_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+			ON CONFLICT (conversion_id) DO UPDATE SET
+				user_id = excluded.user_id, exam_id = excluded.exam_id, document_id = excluded.document_id,
+				exam_revision = excluded.exam_revision, file_name = excluded.file_name, file_size = excluded.file_size,
+				input_path = excluded.input_path, output_path = excluded.output_path,
+				status = excluded.status, error_msg = excluded.error_msg, content_type = excluded.content_type,
+				input_hash = excluded.input_hash, tool_id = excluded.tool_id, options = excluded.options,
+				scan_verdict = excluded.scan_verdict, scan_signature = excluded.scan_signature,
+				output_size = excluded.output_size, output_settings = excluded.output_settings,
+				kit_id = excluded.kit_id, updated_at = excluded.updated_at`,
+			conv.ID, conv.UserID, conv.ExamID, conv.DocumentID, nullableInt(conv.ExamRevision), conv.FileName, conv.FileSize,
+			conv.InputPath, conv.OutputPath, conv.Status, conv.ErrorMsg, conv.ContentType,
+			nullableString(conv.InputHash), nullableString(conv.ToolID), nullableString(optionsKey(conv.Options)),
+			nullableString(conv.ScanVerdict), nullableString(conv.ScanSignature),
+			nullableInt64(conv.OutputSize), nullableString(optionsKey(conv.OutputSettings)), nullableString(conv.KitID),
+			createdAt, updatedAt); err != nil {
+			return fmt.Errorf("failed to import conversion %s: %w", conv.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO users (user_id) VALUES ($1)
+			ON CONFLICT (user_id) DO NOTHING`, conv.UserID); err != nil {
+			return fmt.Errorf("failed to import user %s: %w", conv.UserID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO user_conversions (user_id, conversion_id, created_at)
+			SELECT user_id, conversion_id, created_at FROM conversion_requests
+			WHERE conversion_id = $1
+				AND NOT EXISTS (SELECT 1 FROM user_conversions WHERE conversion_id = $1)`,
+			conv.ID); err != nil {
+			return fmt.Errorf("failed to import user conversion %s: %w", conv.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to import conversions: %w", err)
+	}
+	return nil
+}
+
+// ImportBlobRefs inserts or replaces the given blob reference counts
+func (ss *sqlStorage) ImportBlobRefs(ctx context.Context, refs map[string]int) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import blob references: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, n := range refs {
+		if n <= 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO blob_refs (blob_key, refs) VALUES ($1, $2)
+			ON CONFLICT (blob_key) DO UPDATE SET refs = excluded.refs`, key, n); err != nil {
+			return fmt.Errorf("failed to import blob reference %s: %w", key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to import blob references: %w", err)
+	}
+	return nil
+}
+
+// ImportExamRevisions inserts or replaces the given exam revisions
+func (ss *sqlStorage) ImportExamRevisions(ctx context.Context, revisions []models.ExamRevision) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import exam revisions: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, rev := range revisions {
+		createdAt, _ := importTimes(rev.CreatedAt, rev.CreatedAt)
+		if err := insertRevisionTx(ctx, tx, rev, createdAt, true); err != nil {
+			return fmt.Errorf("failed to import revision %d of %s: %w", rev.Revision, rev.ExamID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to import exam revisions: %w", err)
+	}
+	return nil
+}
+
+// nullableInt stores zero as NULL, for optional integer columns
+func nullableInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// nullableInt64 stores zero as NULL, for optional sizes
+func nullableInt64(n int64) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// nullableFloat stores zero as NULL, for optional measurements
+func nullableFloat(f float64) interface{} {
+	if f == 0 {
+		return nil
+	}
+	return f
+}
+
+// nullableString stores an empty string as NULL, for optional text columns
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// importTimes fills in missing timestamps so imported rows sort sensibly
+func importTimes(createdAt, updatedAt time.Time) (time.Time, time.Time) {
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+	return createdAt.UTC(), updatedAt.UTC()
+}
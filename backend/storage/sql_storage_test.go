@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/oneforall/backend/models"
+)
+
+// TestSQLiteStorage runs the SQL storage suite against a fresh SQLite file
+func TestSQLiteStorage(t *testing.T) {
+	store := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"), true)
+	testSQLStorage(t, &store.sqlStorage)
+}
+
+// TestPostgresStorage runs the SQL storage suite against the PostgreSQL
+// database named by POSTGRES_TEST_DSN. The suite only touches rows it
+// creates, under IDs unique to the run, so the database may be shared.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	store := NewPostgresStorage(dsn, true)
+	testSQLStorage(t, &store.sqlStorage)
+}
+
+func testSQLStorage(t *testing.T, store *sqlStorage) {
+	ctx := context.Background()
+	if err := store.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// Suffix every ID so runs against a shared database do not collide
+	run := uuid.New().String()[:8]
+	t.Run("Exams", func(t *testing.T) { testSQLExams(t, store, run) })
+	t.Run("Tools", func(t *testing.T) { testSQLTools(t, store, run) })
+	t.Run("Conversions", func(t *testing.T) { testSQLConversions(t, store, run) })
+	t.Run("BlobRefs", func(t *testing.T) { testSQLBlobRefs(t, store, run) })
+}
+
+// testDocument returns a valid document with the given ID
+func testDocument(id string) models.Document {
+	return models.Document{ID: id, Name: "Document " + id, Size: "50 KB", Format: "JPG", MaxSize: 51200, Required: true}
+}
+
+func testSQLExams(t *testing.T, store *sqlStorage, run string) {
+	ctx := context.Background()
+	examID := "exam-" + run
+	t.Cleanup(func() { store.DeleteExam(ctx, examID) })
+
+	created, err := store.CreateExam(ctx, models.Exam{
+		ID:        examID,
+		Title:     "Test Exam",
+		Documents: []models.Document{testDocument("photo")},
+	})
+	if err != nil {
+		t.Fatalf("CreateExam: %v", err)
+	}
+	if created.CreatedAt.IsZero() {
+		t.Error("CreateExam did not set CreatedAt")
+	}
+	if _, err := store.CreateExam(ctx, models.Exam{ID: examID, Title: "Again"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateExam with a taken ID: got %v, want ErrConflict", err)
+	}
+	if _, err := store.CreateExam(ctx, models.Exam{ID: "untitled-" + run}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("CreateExam without a title: got %v, want ErrInvalid", err)
+	}
+
+	exam, err := store.GetExamByID(ctx, examID)
+	if err != nil {
+		t.Fatalf("GetExamByID: %v", err)
+	}
+	if exam.Revision != 1 || len(exam.Documents) != 1 || exam.Documents[0].ID != "photo" {
+		t.Fatalf("GetExamByID = revision %d, documents %+v; want revision 1 with photo", exam.Revision, exam.Documents)
+	}
+
+	docs := append(exam.Documents, testDocument("signature"))
+	updated, err := store.UpdateExamDocuments(ctx, examID, exam.Revision, docs)
+	if err != nil {
+		t.Fatalf("UpdateExamDocuments: %v", err)
+	}
+	if updated.Revision != 2 || updated.Title != "Test Exam" || len(updated.Documents) != 2 {
+		t.Errorf("UpdateExamDocuments = %+v; want revision 2 keeping the title", updated)
+	}
+	if _, err := store.UpdateExamDocuments(ctx, examID, exam.Revision, exam.Documents); !errors.Is(err, ErrStale) {
+		t.Errorf("UpdateExamDocuments from an old revision: got %v, want ErrStale", err)
+	}
+
+	renamed := *updated
+	renamed.Title = "Renamed Exam"
+	if _, err := store.UpdateExam(ctx, renamed); err != nil {
+		t.Fatalf("UpdateExam: %v", err)
+	}
+	exam, err = store.GetExamByID(ctx, examID)
+	if err != nil {
+		t.Fatalf("GetExamByID: %v", err)
+	}
+	if exam.Title != "Renamed Exam" || exam.Revision != 2 {
+		t.Errorf("after UpdateExam: title %q, revision %d; want the new title at revision 2", exam.Title, exam.Revision)
+	}
+
+	revisions, err := store.GetExamRevisions(ctx, examID)
+	if err != nil {
+		t.Fatalf("GetExamRevisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 1 || len(revisions[1].Documents) != 2 {
+		t.Errorf("GetExamRevisions = %+v; want revisions 1 and 2", revisions)
+	}
+
+	if err := store.DeleteExam(ctx, examID); err != nil {
+		t.Fatalf("DeleteExam: %v", err)
+	}
+	if _, err := store.GetExamByID(ctx, examID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetExamByID after DeleteExam: got %v, want ErrNotFound", err)
+	}
+	if revisions, err := store.GetExamRevisions(ctx, examID); err != nil || len(revisions) != 2 {
+		t.Errorf("GetExamRevisions after DeleteExam = %d revisions, %v; want both kept", len(revisions), err)
+	}
+}
+
+func testSQLTools(t *testing.T, store *sqlStorage, run string) {
+	ctx := context.Background()
+	catID := "cat-" + run
+	t.Cleanup(func() { store.DeleteToolCategory(ctx, catID) })
+
+	if _, err := store.CreateToolCategory(ctx, models.ToolCategory{
+		ID:       catID,
+		Category: "Test Tools",
+		Tools:    []models.Tool{{ID: "a-" + run, Name: "A"}},
+	}); err != nil {
+		t.Fatalf("CreateToolCategory: %v", err)
+	}
+	if _, err := store.CreateTool(ctx, catID, models.Tool{ID: "b-" + run, Name: "B"}); err != nil {
+		t.Fatalf("CreateTool: %v", err)
+	}
+	if err := store.ReorderTools(ctx, catID, []string{"b-" + run, "a-" + run}); err != nil {
+		t.Fatalf("ReorderTools: %v", err)
+	}
+	if _, err := store.UpdateTool(ctx, models.Tool{ID: "a-" + run, Name: "A2", Disabled: true}); err != nil {
+		t.Fatalf("UpdateTool: %v", err)
+	}
+
+	cat := findTestCategory(t, store, catID)
+	if len(cat.Tools) != 2 || cat.Tools[0].ID != "b-"+run || cat.Tools[1].Name != "A2" || !cat.Tools[1].Disabled {
+		t.Fatalf("tools = %+v; want B then a disabled A2", cat.Tools)
+	}
+
+	if err := store.DeleteTool(ctx, "b-"+run); err != nil {
+		t.Fatalf("DeleteTool: %v", err)
+	}
+	if err := store.DeleteTool(ctx, "b-"+run); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteTool twice: got %v, want ErrNotFound", err)
+	}
+	if cat := findTestCategory(t, store, catID); len(cat.Tools) != 1 {
+		t.Errorf("tools after DeleteTool = %+v; want only A2", cat.Tools)
+	}
+	if err := store.ReorderTools(ctx, catID, []string{"missing"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("ReorderTools with an unknown ID: got %v, want ErrInvalid", err)
+	}
+
+	if err := store.DeleteToolCategory(ctx, catID); err != nil {
+		t.Fatalf("DeleteToolCategory: %v", err)
+	}
+	categories, err := store.GetTools(ctx)
+	if err != nil {
+		t.Fatalf("GetTools: %v", err)
+	}
+	for _, cat := range categories {
+		if cat.ID == catID {
+			t.Errorf("category %s still listed after DeleteToolCategory", catID)
+		}
+	}
+}
+
+// findTestCategory returns the tool category with an ID, failing the test
+// if it is not listed
+func findTestCategory(t *testing.T, store *sqlStorage, id string) models.ToolCategory {
+	t.Helper()
+	categories, err := store.GetTools(context.Background())
+	if err != nil {
+		t.Fatalf("GetTools: %v", err)
+	}
+	for _, cat := range categories {
+		if cat.ID == id {
+			return cat
+		}
+	}
+	t.Fatalf("category %s not listed", id)
+	return models.ToolCategory{}
+}
+
+func testSQLConversions(t *testing.T, store *sqlStorage, run string) {
+	ctx := context.Background()
+	userID := "user-" + run
+	kitID := "kit-" + run
+	examID := "conversions-" + run
+	options := map[string]string{"quality": "80"}
+
+	// Conversions reference their exam
+	if _, err := store.CreateExam(ctx, models.Exam{ID: examID, Title: "Test Exam", Documents: []models.Document{testDocument("photo")}}); err != nil {
+		t.Fatalf("CreateExam: %v", err)
+	}
+	t.Cleanup(func() { store.DeleteExam(ctx, examID) })
+
+	created, err := store.CreateConversion(ctx, models.ConversionRequest{
+		UserID:     userID,
+		ExamID:     examID,
+		DocumentID: "photo",
+		FileName:   "photo.png",
+		FileSize:   1024,
+		ToolID:     "png-to-jpg",
+		Options:    options,
+		KitID:      kitID,
+	})
+	if err != nil {
+		t.Fatalf("CreateConversion: %v", err)
+	}
+	if created.ID == "" || created.Status != "pending" {
+		t.Fatalf("CreateConversion = %+v; want an ID and pending status", created)
+	}
+
+	conv, err := store.GetConversionByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetConversionByID: %v", err)
+	}
+	if conv.UserID != userID || conv.KitID != kitID || !reflect.DeepEqual(conv.Options, options) {
+		t.Errorf("GetConversionByID = %+v; want the created fields", conv)
+	}
+	if _, err := store.GetConversionByID(ctx, uuid.New().String()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetConversionByID for an unknown ID: got %v, want ErrNotFound", err)
+	}
+
+	if err := store.UpdateConversion(ctx, created.ID, "processing", ""); err != nil {
+		t.Fatalf("UpdateConversion: %v", err)
+	}
+	if err := store.UpdateConversion(ctx, uuid.New().String(), "processing", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateConversion for an unknown ID: got %v, want ErrNotFound", err)
+	}
+
+	hash := "hash-" + run
+	conv.Status = "completed"
+	conv.InputHash = hash
+	conv.InputPath = "inputs/" + hash
+	conv.OutputPath = "outputs/" + conv.ID + "/photo.jpg"
+	conv.OutputSize = 512
+	conv.OutputSettings = map[string]string{"quality": "80"}
+	// Who made the request never changes
+	conv.UserID = "someone-else"
+	saved, err := store.SaveConversion(ctx, *conv)
+	if err != nil {
+		t.Fatalf("SaveConversion: %v", err)
+	}
+	if saved.UserID != userID || saved.OutputSize != 512 || saved.OutputSettings["quality"] != "80" {
+		t.Errorf("SaveConversion = %+v; want the output fields saved and the user kept", saved)
+	}
+
+	cached, err := store.FindCachedConversion(ctx, hash, "png-to-jpg", options)
+	if err != nil {
+		t.Fatalf("FindCachedConversion: %v", err)
+	}
+	if cached.ID != conv.ID {
+		t.Errorf("FindCachedConversion = %s; want %s", cached.ID, conv.ID)
+	}
+	if _, err := store.FindCachedConversion(ctx, hash, "png-to-jpg", map[string]string{"quality": "60"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindCachedConversion with other options: got %v, want ErrNotFound", err)
+	}
+
+	second, err := store.CreateConversion(ctx, models.ConversionRequest{UserID: userID, ExamID: examID, FileName: "scan.pdf", KitID: kitID})
+	if err != nil {
+		t.Fatalf("CreateConversion: %v", err)
+	}
+	kit, err := store.GetKitConversions(ctx, kitID)
+	if err != nil {
+		t.Fatalf("GetKitConversions: %v", err)
+	}
+	if len(kit) != 2 || kit[0].ID != conv.ID || kit[1].ID != second.ID {
+		t.Errorf("GetKitConversions = %d conversions; want both, oldest first", len(kit))
+	}
+	mine, err := store.GetUserConversions(ctx, userID)
+	if err != nil || len(mine) != 2 {
+		t.Errorf("GetUserConversions = %d conversions, %v; want 2", len(mine), err)
+	}
+
+	pending, err := store.ListConversionsByStatus(ctx, "pending")
+	if err != nil {
+		t.Fatalf("ListConversionsByStatus: %v", err)
+	}
+	var found bool
+	for _, c := range pending {
+		if c.ID == conv.ID {
+			t.Errorf("ListConversionsByStatus(pending) lists completed conversion %s", c.ID)
+		}
+		found = found || c.ID == second.ID
+	}
+	if !found {
+		t.Errorf("ListConversionsByStatus(pending) does not list %s", second.ID)
+	}
+}
+
+func testSQLBlobRefs(t *testing.T, store *sqlStorage, run string) {
+	ctx := context.Background()
+	key := "inputs/blob-" + run
+
+	for want := 1; want <= 2; want++ {
+		if refs, err := store.AddBlobRef(ctx, key); err != nil || refs != want {
+			t.Fatalf("AddBlobRef = %d, %v; want %d", refs, err, want)
+		}
+	}
+	for want := 1; want >= 0; want-- {
+		if refs, err := store.ReleaseBlobRef(ctx, key); err != nil || refs != want {
+			t.Fatalf("ReleaseBlobRef = %d, %v; want %d", refs, err, want)
+		}
+	}
+	if refs, err := store.ReleaseBlobRef(ctx, key); err != nil || refs != 0 {
+		t.Errorf("ReleaseBlobRef of an unreferenced blob = %d, %v; want 0", refs, err)
+	}
+}
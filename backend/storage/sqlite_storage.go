@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/oneforall/backend/migrate"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStorage stores exams, tools and conversions in a single SQLite
+// database file. It uses the pure-Go modernc.org/sqlite driver, so no cgo
+// toolchain is needed.
+type SQLiteStorage struct {
+	sqlStorage
+}
+
+var _ Migratable = (*SQLiteStorage)(nil)
+
+// NewSQLiteStorage creates a new SQLite storage backed by the file at path
+func NewSQLiteStorage(path string, autoMigrate bool) *SQLiteStorage {
+	return &SQLiteStorage{sqlStorage{
+		name:        "SQLite",
+		driverName:  "sqlite",
+		dsn:         sqliteDSN(path),
+		dialect:     migrate.SQLite,
+		autoMigrate: autoMigrate,
+		// SQLite allows a single writer; one connection avoids SQLITE_BUSY
+		// when two transactions try to upgrade to a write lock at once.
+		maxOpenConns: 1,
+	}}
+}
+
+// sqliteDSN builds a connection string enabling foreign keys, WAL mode and a
+// busy timeout for the database file at path
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	return fmt.Sprintf("file:%s?%s", path, params.Encode())
+}
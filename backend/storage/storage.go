@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/oneforall/backend/config"
+	"github.com/oneforall/backend/migrate"
+	"github.com/oneforall/backend/models"
+)
+
+// ErrNotFound is returned when a requested exam or conversion does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalid is returned when exam or tool data fails validation
+var ErrInvalid = errors.New("invalid")
+
+// ErrConflict is returned when creating a record whose ID is already taken
+var ErrConflict = errors.New("already exists")
+
+// ErrStale is returned when a record changed between being read and being
+// written back
+var ErrStale = errors.New("changed since it was read")
+
+// Store is the persistence layer used by the handlers. JSONStorage,
+// MemoryStorage, PostgresStorage and SQLiteStorage implement it.
+type Store interface {
+	// Initialize prepares the backend and loads any existing data
+	Initialize(ctx context.Context) error
+	// Close releases any resources held by the backend
+	Close() error
+
+	// GetExams returns all exams
+	GetExams(ctx context.Context) ([]models.Exam, error)
+	// GetExamByID returns a specific exam or ErrNotFound
+	GetExamByID(ctx context.Context, examID string) (*models.Exam, error)
+	// CreateExam validates and adds a new exam, setting its timestamps.
+	// It returns ErrConflict if the ID is taken and ErrInvalid on bad data.
+	CreateExam(ctx context.Context, exam models.Exam) (*models.Exam, error)
+	// UpdateExam validates and replaces an existing exam, keeping CreatedAt
+	UpdateExam(ctx context.Context, exam models.Exam) (*models.Exam, error)
+	// UpdateExamDocuments replaces the documents of an exam whose documents
+	// are still at revision, keeping its other fields. It returns ErrStale if
+	// they have changed since, so edits read from an older revision are
+	// never saved over newer ones.
+	UpdateExamDocuments(ctx context.Context, examID string, revision int, docs []models.Document) (*models.Exam, error)
+	// DeleteExam removes an exam and its documents
+	DeleteExam(ctx context.Context, examID string) error
+	// GetExamRevisions returns the revisions of an exam's documents, oldest
+	// first. Revisions outlive the exam, so a deleted exam's are still listed.
+	GetExamRevisions(ctx context.Context, examID string) ([]models.ExamRevision, error)
+
+	// GetTools returns all tool categories with their tools, including
+	// disabled ones
+	GetTools(ctx context.Context) ([]models.ToolCategory, error)
+	// CreateToolCategory adds a category, with any tools it lists, at the end
+	CreateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error)
+	// UpdateToolCategory changes a category's name, icon and disabled flag;
+	// its tools are left alone
+	UpdateToolCategory(ctx context.Context, cat models.ToolCategory) (*models.ToolCategory, error)
+	// DeleteToolCategory removes a category and all of its tools
+	DeleteToolCategory(ctx context.Context, categoryID string) error
+	// ReorderToolCategories sets the category order; ids must list every
+	// category exactly once
+	ReorderToolCategories(ctx context.Context, ids []string) error
+	// CreateTool adds a tool at the end of a category
+	CreateTool(ctx context.Context, categoryID string, tool models.Tool) (*models.Tool, error)
+	// UpdateTool changes a tool's fields, keeping its category and position
+	UpdateTool(ctx context.Context, tool models.Tool) (*models.Tool, error)
+	// DeleteTool removes a tool
+	DeleteTool(ctx context.Context, toolID string) error
+	// ReorderTools sets the order of the tools in a category; ids must list
+	// every tool in it exactly once
+	ReorderTools(ctx context.Context, categoryID string, ids []string) error
+
+	// CreateConversion stores conv as a new pending conversion request,
+	// assigning its ID, status and timestamps
+	CreateConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error)
+	// GetConversionByID returns a conversion request or ErrNotFound
+	GetConversionByID(ctx context.Context, conversionID string) (*models.ConversionRequest, error)
+	// UpdateConversion sets the status and error message of a conversion request
+	UpdateConversion(ctx context.Context, conversionID, status, errorMsg string) error
+	// SaveConversion stores the file, path, status and error fields of conv
+	// on the existing request with its ID. Who made the request and for
+	// which document never change, and UpdatedAt is set by the store.
+	SaveConversion(ctx context.Context, conv models.ConversionRequest) (*models.ConversionRequest, error)
+	// GetUserConversions returns all conversion requests made by a user
+	GetUserConversions(ctx context.Context, userID string) ([]models.ConversionRequest, error)
+	// GetConversionsByExam returns all conversion requests for an exam
+	GetConversionsByExam(ctx context.Context, examID string) ([]models.ConversionRequest, error)
+	// GetKitConversions returns the conversion requests of an exam kit,
+	// oldest first
+	GetKitConversions(ctx context.Context, kitID string) ([]models.ConversionRequest, error)
+	// ListConversionsByStatus returns all conversion requests with a status,
+	// oldest first
+	ListConversionsByStatus(ctx context.Context, status string) ([]models.ConversionRequest, error)
+	// FindCachedConversion returns the most recently updated completed
+	// conversion of an input with the given hash by the same tool and
+	// options, or ErrNotFound
+	FindCachedConversion(ctx context.Context, inputHash, toolID string, options map[string]string) (*models.ConversionRequest, error)
+
+	// AddBlobRef records one more conversion holding the blob stored under
+	// key and returns the new count
+	AddBlobRef(ctx context.Context, key string) (int, error)
+	// ReleaseBlobRef drops one reference to a blob and returns how many
+	// remain. A blob with no recorded references has none left, so its
+	// caller may delete it.
+	ReleaseBlobRef(ctx context.Context, key string) (int, error)
+}
+
+// Migratable is implemented by database backends with a versioned schema
+type Migratable interface {
+	Store
+	// Migrator connects to the database without changing its schema
+	Migrator(ctx context.Context) (*migrate.Migrator, error)
+}
+
+// Reloader is implemented by backends that read exams and tools from files
+// and can pick up edits to them without a restart
+type Reloader interface {
+	// Reload re-reads and validates the catalogue, keeping the current data
+	// if the new files are invalid
+	Reload(ctx context.Context) error
+}
+
+// New returns the storage backend selected by cfg.DatabaseType.
+// The returned store still has to be initialized.
+func New(cfg *config.Config) (Store, error) {
+	switch cfg.DatabaseType {
+	case "", "json":
+		return NewJSONStorage(cfg.DataDirectory), nil
+	case "postgres", "postgresql":
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for database type %q", cfg.DatabaseType)
+		}
+		return NewPostgresStorage(cfg.DatabaseURL, cfg.AutoMigrate), nil
+	case "sqlite":
+		path := cfg.DatabaseURL
+		if path == "" {
+			path = filepath.Join(cfg.DataDirectory, "1forall.db")
+		}
+		return NewSQLiteStorage(path, cfg.AutoMigrate), nil
+	default:
+		return nil, fmt.Errorf("unknown database type %q", cfg.DatabaseType)
+	}
+}
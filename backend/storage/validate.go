@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/utils"
+)
+
+// ValidateExams checks a full exam list: every exam must be valid and exam
+// IDs must be unique
+func ValidateExams(exams []models.Exam) error {
+	seen := map[string]bool{}
+	for _, exam := range exams {
+		if err := ValidateExam(exam); err != nil {
+			return err
+		}
+		if seen[exam.ID] {
+			return fmt.Errorf("%w: duplicate exam id %q", ErrInvalid, exam.ID)
+		}
+		seen[exam.ID] = true
+	}
+	return nil
+}
+
+// ValidateExam checks one exam and its document requirements
+func ValidateExam(exam models.Exam) error {
+	if strings.TrimSpace(exam.ID) == "" {
+		return fmt.Errorf("%w: exam id is required", ErrInvalid)
+	}
+	if strings.TrimSpace(exam.Title) == "" {
+		return fmt.Errorf("%w: exam %q: title is required", ErrInvalid, exam.ID)
+	}
+
+	seen := map[string]bool{}
+	for _, doc := range exam.Documents {
+		if strings.TrimSpace(doc.ID) == "" {
+			return fmt.Errorf("%w: exam %q: document id is required", ErrInvalid, exam.ID)
+		}
+		if seen[doc.ID] {
+			return fmt.Errorf("%w: exam %q: duplicate document id %q", ErrInvalid, exam.ID, doc.ID)
+		}
+		seen[doc.ID] = true
+
+		if strings.TrimSpace(doc.Name) == "" {
+			return fmt.Errorf("%w: exam %q: document %q: name is required", ErrInvalid, exam.ID, doc.ID)
+		}
+		if doc.MaxSize <= 0 {
+			return fmt.Errorf("%w: exam %q: document %q: max_size must be positive", ErrInvalid, exam.ID, doc.ID)
+		}
+		extensions, unknown := utils.FormatExtensions(doc.Format)
+		if len(unknown) > 0 {
+			return fmt.Errorf("%w: exam %q: document %q: unknown format %s", ErrInvalid, exam.ID, doc.ID, strings.Join(unknown, ", "))
+		}
+		if len(extensions) == 0 {
+			return fmt.Errorf("%w: exam %q: document %q: format is required", ErrInvalid, exam.ID, doc.ID)
+		}
+		if err := validateImageRequirements(doc); err != nil {
+			return fmt.Errorf("%w: exam %q: document %q: %v", ErrInvalid, exam.ID, doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// validateImageRequirements checks a document's optional image requirements
+// for values that are negative, contradict each other or cannot be parsed
+func validateImageRequirements(doc models.Document) error {
+	if doc.MinWidth < 0 || doc.MaxWidth < 0 || doc.MinHeight < 0 || doc.MaxHeight < 0 || doc.DPI < 0 ||
+		doc.WidthCM < 0 || doc.HeightCM < 0 {
+		return fmt.Errorf("image requirements must not be negative")
+	}
+	if doc.DPI > utils.MaxDPI {
+		return fmt.Errorf("dpi must be at most %d", utils.MaxDPI)
+	}
+	if doc.MaxWidth > 0 && doc.MinWidth > doc.MaxWidth {
+		return fmt.Errorf("min_width is larger than max_width")
+	}
+	if doc.MaxHeight > 0 && doc.MinHeight > doc.MaxHeight {
+		return fmt.Errorf("min_height is larger than max_height")
+	}
+	if doc.AspectRatio != "" {
+		if _, err := utils.ParseAspectRatio(doc.AspectRatio); err != nil {
+			return err
+		}
+	}
+	if (doc.WidthCM > 0) != (doc.HeightCM > 0) {
+		return fmt.Errorf("width_cm and height_cm must be given together")
+	}
+	if doc.WidthCM > 0 {
+		if doc.DPI == 0 {
+			return fmt.Errorf("width_cm and height_cm need a dpi")
+		}
+		w, h := doc.PixelSize()
+		if w < doc.MinWidth || h < doc.MinHeight || doc.MaxWidth > 0 && w > doc.MaxWidth || doc.MaxHeight > 0 && h > doc.MaxHeight {
+			return fmt.Errorf("%.4gx%.4gcm at %d dpi is %dx%d pixels, outside the pixel bounds", doc.WidthCM, doc.HeightCM, doc.DPI, w, h)
+		}
+	}
+	switch doc.Fit {
+	case "", models.FitCrop, models.FitPad:
+	default:
+		return fmt.Errorf("fit must be %q or %q", models.FitCrop, models.FitPad)
+	}
+	return nil
+}
+
+// ValidateTools checks the tool catalogue: category IDs and tool IDs must be
+// present and unique, and everything needs a name
+func ValidateTools(categories []models.ToolCategory) error {
+	seenCategories := map[string]bool{}
+	seenTools := map[string]bool{}
+	for _, cat := range categories {
+		if err := ValidateToolCategory(cat); err != nil {
+			return err
+		}
+		if seenCategories[cat.ID] {
+			return fmt.Errorf("%w: duplicate tool category id %q", ErrInvalid, cat.ID)
+		}
+		seenCategories[cat.ID] = true
+
+		for _, tool := range cat.Tools {
+			if seenTools[tool.ID] {
+				return fmt.Errorf("%w: duplicate tool id %q", ErrInvalid, tool.ID)
+			}
+			seenTools[tool.ID] = true
+		}
+	}
+	return nil
+}
+
+// ValidateToolCategory checks one category and the tools in it
+func ValidateToolCategory(cat models.ToolCategory) error {
+	if strings.TrimSpace(cat.ID) == "" {
+		return fmt.Errorf("%w: tool category id is required", ErrInvalid)
+	}
+	if strings.TrimSpace(cat.Category) == "" {
+		return fmt.Errorf("%w: tool category %q: category name is required", ErrInvalid, cat.ID)
+	}
+	for _, tool := range cat.Tools {
+		if err := ValidateTool(tool); err != nil {
+			return fmt.Errorf("tool category %q: %w", cat.ID, err)
+		}
+	}
+	return nil
+}
+
+// ValidateTool checks a single tool
+func ValidateTool(tool models.Tool) error {
+	if strings.TrimSpace(tool.ID) == "" {
+		return fmt.Errorf("%w: tool id is required", ErrInvalid)
+	}
+	if strings.TrimSpace(tool.Name) == "" {
+		return fmt.Errorf("%w: tool %q: name is required", ErrInvalid, tool.ID)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package tus
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseMetadata decodes an Upload-Metadata header: comma-separated pairs of
+// a key and an optional base64-encoded value
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", strings.TrimSpace(pair))
+		}
+		key := fields[0]
+		if _, dup := metadata[key]; dup {
+			return nil, fmt.Errorf("duplicate Upload-Metadata key %q", key)
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("Upload-Metadata value of %q is not base64", key)
+			}
+			value = string(decoded)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// FormatMetadata encodes metadata as an Upload-Metadata header
+func FormatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key
+		if metadata[key] != "" {
+			pairs[i] += " " + base64.StdEncoding.EncodeToString([]byte(metadata[key]))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,205 @@
+package tus
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errNotFound = errors.New("upload not found")
+	errTooLong  = errors.New("chunk extends past Upload-Length")
+)
+
+// diskStore keeps each upload as two files in dir: <id> with the bytes
+// received so far and <id>.info with its JSON state. The info file is only
+// rewritten after the data it describes has been synced, so after a crash
+// Offset never claims more than is on disk.
+type diskStore struct {
+	dir string
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func newDiskStore(dir string) *diskStore {
+	return &diskStore{dir: dir, locked: make(map[string]bool)}
+}
+
+// lock marks an upload as busy so concurrent PATCH or DELETE requests are
+// refused instead of interleaving writes. It returns false if it already is.
+func (s *diskStore) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return false
+	}
+	s.locked[id] = true
+	return true
+}
+
+func (s *diskStore) unlock(id string) {
+	s.mu.Lock()
+	delete(s.locked, id)
+	s.mu.Unlock()
+}
+
+func (s *diskStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *diskStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// create writes the empty data file and initial state of a new upload
+func (s *diskStore) create(upload *Upload) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.save(upload)
+}
+
+// get loads the state of an upload
+func (s *diskStore) get(id string) (*Upload, error) {
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// save atomically replaces the state of an upload
+func (s *diskStore) save(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+upload.ID+".info-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.infoPath(upload.ID))
+}
+
+// write appends r to the upload's data at upload.Offset and returns how many
+// bytes were stored. Anything past upload.Length is refused with errTooLong.
+// Bytes left over from a write that was never recorded are overwritten.
+func (s *diskStore) write(upload *Upload, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(s.dataPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(upload.Offset); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return 0, err
+	}
+
+	remaining := upload.Length - upload.Offset
+	n, err := io.Copy(f, io.LimitReader(r, remaining))
+	if err == nil && n == remaining {
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			err = errTooLong
+		}
+	}
+	if syncErr := f.Sync(); syncErr != nil {
+		f.Close()
+		return 0, syncErr
+	}
+	if closeErr := f.Close(); closeErr != nil {
+		return 0, closeErr
+	}
+	return n, err
+}
+
+// complete records that an upload has been handed off and drops its data
+// file if the completion hook left it behind
+func (s *diskStore) complete(upload *Upload) error {
+	if err := os.Remove(s.dataPath(upload.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.save(upload)
+}
+
+// remove deletes an upload's data and state
+func (s *diskStore) remove(id string) error {
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.infoPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// expire removes every upload whose expiry time has passed, skipping those
+// being written, and returns how many were removed
+func (s *diskStore) expire(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || strings.HasPrefix(id, ".") {
+			continue
+		}
+		if !s.lock(id) {
+			continue
+		}
+		upload, err := s.get(id)
+		if err == nil && upload.expired(now) {
+			err = s.remove(id)
+			if err == nil {
+				removed++
+			}
+		}
+		s.unlock(id)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,412 @@
+// Package tus implements the server side of the tus 1.0 resumable upload
+// protocol (https://tus.io/protocols/resumable-upload) with the creation,
+// creation-with-upload, termination and expiration extensions. Uploads are
+// kept on local disk until they complete, are terminated or expire.
+package tus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneforall/backend/models"
+)
+
+// Version is the only protocol version the handler speaks
+const Version = "1.0.0"
+
+// Extensions lists the protocol extensions the handler supports
+const Extensions = "creation,creation-with-upload,termination,expiration"
+
+// offsetContentType is the content type of every PATCH body
+const offsetContentType = "application/offset+octet-stream"
+
+// Config configures a Handler
+type Config struct {
+	// Dir holds the partial uploads and their state
+	Dir string
+	// BasePath is the URL path the handler is mounted at, used to build the
+	// Location of new uploads
+	BasePath string
+	// MaxSize is the largest Upload-Length accepted
+	MaxSize int64
+	// Expiry is how long an upload is kept after its last PATCH; zero keeps
+	// uploads forever
+	Expiry time.Duration
+
+	// PreCreate may inspect and reject an upload before it is created. c is
+	// the creating request, e.g. to authorise it from its headers.
+	PreCreate func(c *gin.Context, upload *Upload) error
+	// OnComplete is called once all bytes are received, with the request
+	// carrying the last chunk. path is the file holding the data; the hook
+	// is expected to move it elsewhere. If it fails, the upload stays
+	// complete on disk and the next PATCH retries.
+	OnComplete func(c *gin.Context, upload *Upload, path string) error
+}
+
+// Upload is the state of one resumable upload
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Completed bool              `json:"completed,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Error is an error a hook returns to answer with a specific HTTP status
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// NewError returns an error that is answered with the given status
+func NewError(status int, message string) error {
+	return &Error{Status: status, Message: message}
+}
+
+// Handler serves the tus endpoints
+type Handler struct {
+	cfg   Config
+	store *diskStore
+}
+
+// NewHandler creates a tus handler storing uploads under cfg.Dir
+func NewHandler(cfg Config) *Handler {
+	return &Handler{cfg: cfg, store: newDiskStore(cfg.Dir)}
+}
+
+// Protocol sets Tus-Resumable on every response and rejects requests for
+// other protocol versions. OPTIONS requests need not name a version.
+func (h *Handler) Protocol() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", Version)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != Version {
+			c.Header("Tus-Version", Version)
+			respondError(c, http.StatusPreconditionFailed, "Unsupported tus version; use "+Version)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Options describes the server's tus capabilities
+// @Summary Resumable upload capabilities
+// @Description tus discovery: supported versions, extensions and the maximum upload size
+// @Tags uploads
+// @Success 204
+// @Router /api/uploads [options]
+func (h *Handler) Options(c *gin.Context) {
+	c.Header("Tus-Version", Version)
+	c.Header("Tus-Extension", Extensions)
+	if h.cfg.MaxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Create starts a new upload, optionally with its first chunk
+// @Summary Create resumable upload
+// @Description tus creation: Upload-Length is required, Upload-Metadata is passed to the upload hooks. A body of type application/offset+octet-stream is stored as the first chunk.
+// @Tags uploads
+// @Param Upload-Length header int true "Total size in bytes"
+// @Param Upload-Metadata header string false "Comma-separated key and base64 value pairs"
+// @Success 201
+// @Failure 400 {object} models.APIResponse
+// @Failure 413 {object} models.APIResponse
+// @Router /api/uploads [post]
+func (h *Handler) Create(c *gin.Context) {
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		respondError(c, http.StatusBadRequest, "Upload-Defer-Length is not supported")
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		respondError(c, http.StatusBadRequest, "Upload-Length must be a non-negative integer")
+		return
+	}
+	if h.cfg.MaxSize > 0 && length > h.cfg.MaxSize {
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload-Length exceeds the maximum of %d bytes", h.cfg.MaxSize))
+		return
+	}
+	metadata, err := ParseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	upload := &Upload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: h.expiresAt(now),
+	}
+	if h.cfg.PreCreate != nil {
+		if err := h.cfg.PreCreate(c, upload); err != nil {
+			respondHookError(c, err)
+			return
+		}
+	}
+
+	if err := h.store.create(upload); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to create upload: "+err.Error())
+		return
+	}
+	c.Header("Location", strings.TrimSuffix(h.cfg.BasePath, "/")+"/"+upload.ID)
+
+	if c.ContentType() == offsetContentType || length == 0 {
+		h.store.lock(upload.ID)
+		defer h.store.unlock(upload.ID)
+		if !h.receive(c, upload) {
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	setExpires(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// Head reports how much of an upload has been received
+// @Summary Resumable upload offset
+// @Description tus HEAD: returns Upload-Offset and Upload-Length so an interrupted upload can resume
+// @Tags uploads
+// @Param id path string true "Upload ID"
+// @Success 200
+// @Failure 404 {object} models.APIResponse
+// @Failure 410 {object} models.APIResponse
+// @Router /api/uploads/:id [head]
+func (h *Handler) Head(c *gin.Context) {
+	upload, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		c.Header("Upload-Metadata", FormatMetadata(upload.Metadata))
+	}
+	setExpires(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// Patch appends a chunk to an upload
+// @Summary Append to resumable upload
+// @Description tus PATCH: Upload-Offset must equal the bytes received so far. The upload is attached once its last byte arrives.
+// @Tags uploads
+// @Accept application/offset+octet-stream
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header int true "Offset of this chunk"
+// @Success 204
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 410 {object} models.APIResponse
+// @Failure 415 {object} models.APIResponse
+// @Failure 423 {object} models.APIResponse
+// @Router /api/uploads/:id [patch]
+func (h *Handler) Patch(c *gin.Context) {
+	if c.ContentType() != offsetContentType {
+		respondError(c, http.StatusUnsupportedMediaType, "Content-Type must be "+offsetContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	if !h.store.lock(c.Param("id")) {
+		respondError(c, http.StatusLocked, "Upload is being written by another request")
+		return
+	}
+	defer h.store.unlock(c.Param("id"))
+
+	upload, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if offset != upload.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		respondError(c, http.StatusConflict, fmt.Sprintf("Upload-Offset is %d but %d bytes have been received", offset, upload.Offset))
+		return
+	}
+	if c.Request.ContentLength > upload.Length-upload.Offset {
+		respondError(c, http.StatusRequestEntityTooLarge, "Chunk extends past Upload-Length")
+		return
+	}
+
+	if !h.receive(c, upload) {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	setExpires(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate discards an upload
+// @Summary Terminate resumable upload
+// @Description tus termination: deletes the upload and the data received so far
+// @Tags uploads
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Failure 404 {object} models.APIResponse
+// @Failure 423 {object} models.APIResponse
+// @Router /api/uploads/:id [delete]
+func (h *Handler) Terminate(c *gin.Context) {
+	id := c.Param("id")
+	if !h.store.lock(id) {
+		respondError(c, http.StatusLocked, "Upload is being written by another request")
+		return
+	}
+	defer h.store.unlock(id)
+
+	if _, ok := h.lookup(c); !ok {
+		return
+	}
+	if err := h.store.remove(id); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to delete upload: "+err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Watch removes expired uploads every interval until ctx is cancelled
+func (h *Handler) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := h.store.expire(time.Now())
+		if err != nil {
+			log.Printf("⚠ Failed to expire uploads: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired uploads", removed)
+		}
+	}
+}
+
+// lookup loads the upload named in the URL, answering 404 or 410 if it is
+// unknown or expired
+func (h *Handler) lookup(c *gin.Context) (*Upload, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		respondError(c, http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+	upload, err := h.store.get(id)
+	if errors.Is(err, errNotFound) {
+		respondError(c, http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if upload.expired(time.Now()) {
+		respondError(c, http.StatusGone, "Upload has expired")
+		return nil, false
+	}
+	return upload, true
+}
+
+// receive appends the request body to the upload and runs the completion
+// hook once it is whole. It answers the request itself and returns false if
+// anything fails; bytes received before a failure are kept.
+func (h *Handler) receive(c *gin.Context, upload *Upload) bool {
+	n, err := h.store.write(upload, c.Request.Body)
+	upload.Offset += n
+	if n > 0 {
+		upload.ExpiresAt = h.expiresAt(time.Now().UTC())
+	}
+	if saveErr := h.store.save(upload); saveErr != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to record upload progress: "+saveErr.Error())
+		return false
+	}
+	if errors.Is(err, errTooLong) {
+		respondError(c, http.StatusRequestEntityTooLarge, "Chunk extends past Upload-Length")
+		return false
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Upload interrupted: "+err.Error())
+		return false
+	}
+
+	if upload.Offset < upload.Length || upload.Completed {
+		return true
+	}
+	if h.cfg.OnComplete != nil {
+		if err := h.cfg.OnComplete(c, upload, h.store.dataPath(upload.ID)); err != nil {
+			respondHookError(c, err)
+			return false
+		}
+	}
+	upload.Completed = true
+	if err := h.store.complete(upload); err != nil {
+		log.Printf("⚠ Failed to record completion of upload %s: %v", upload.ID, err)
+	}
+	return true
+}
+
+// expiresAt is the expiry time of an upload last written at now
+func (h *Handler) expiresAt(now time.Time) time.Time {
+	if h.cfg.Expiry <= 0 {
+		return time.Time{}
+	}
+	return now.Add(h.cfg.Expiry)
+}
+
+// expired reports whether an upload has passed its expiry time
+func (u *Upload) expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// setExpires sets Upload-Expires for uploads that are still incomplete
+func setExpires(c *gin.Context, upload *Upload) {
+	if !upload.Completed && !upload.ExpiresAt.IsZero() {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// respondHookError answers with the status carried by a hook's *Error, or
+// 500 for any other error
+func respondHookError(c *gin.Context, err error) {
+	var tusErr *Error
+	if errors.As(err, &tusErr) {
+		respondError(c, tusErr.Status, tusErr.Message)
+		return
+	}
+	respondError(c, http.StatusInternalServerError, err.Error())
+}
+
+// respondError answers with an APIResponse error body. HEAD responses carry
+// only the status.
+func respondError(c *gin.Context, status int, msg string) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(status)
+		return
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   msg,
+	})
+}
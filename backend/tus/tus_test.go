@@ -0,0 +1,345 @@
+package tus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// completed is an upload handed to the OnComplete hook, with its data
+type completed struct {
+	upload *Upload
+	data   string
+}
+
+type testServer struct {
+	router    *gin.Engine
+	handler   *Handler
+	completed []completed
+}
+
+// newTestServer mounts a handler as the routes do, refusing uploads named
+// "secret.pdf" before they are created
+func newTestServer(t *testing.T, expiry time.Duration) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s := &testServer{}
+	s.handler = NewHandler(Config{
+		Dir:      t.TempDir(),
+		BasePath: "/api/uploads",
+		MaxSize:  100,
+		Expiry:   expiry,
+		PreCreate: func(c *gin.Context, upload *Upload) error {
+			if upload.Metadata["filename"] == "secret.pdf" {
+				return NewError(http.StatusForbidden, "not yours")
+			}
+			return nil
+		},
+		OnComplete: func(c *gin.Context, upload *Upload, path string) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			s.completed = append(s.completed, completed{upload: upload, data: string(data)})
+			return os.Remove(path)
+		},
+	})
+	s.router = gin.New()
+	uploads := s.router.Group("/api/uploads", s.handler.Protocol())
+	uploads.OPTIONS("", s.handler.Options)
+	uploads.POST("", s.handler.Create)
+	uploads.HEAD("/:id", s.handler.Head)
+	uploads.PATCH("/:id", s.handler.Patch)
+	uploads.DELETE("/:id", s.handler.Terminate)
+	return s
+}
+
+// do sends a tus request with the given headers and body
+func (s *testServer) do(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		if v == "" {
+			req.Header.Del(k)
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// create starts an upload of length bytes and returns its URL
+func (s *testServer) create(t *testing.T, length string) string {
+	t.Helper()
+	w := s.do(http.MethodPost, "/api/uploads", map[string]string{"Upload-Length": length}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+// patch sends a chunk at offset
+func (s *testServer) patch(url, offset, chunk string) *httptest.ResponseRecorder {
+	return s.do(http.MethodPatch, url, map[string]string{
+		"Upload-Offset": offset,
+		"Content-Type":  offsetContentType,
+	}, chunk)
+}
+
+func TestOptions(t *testing.T) {
+	s := newTestServer(t, 0)
+	w := s.do(http.MethodOptions, "/api/uploads", map[string]string{"Tus-Resumable": ""}, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("OPTIONS: %d", w.Code)
+	}
+	for header, want := range map[string]string{
+		"Tus-Resumable": Version,
+		"Tus-Version":   Version,
+		"Tus-Extension": Extensions,
+		"Tus-Max-Size":  "100",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: %q; want %q", header, got, want)
+		}
+	}
+
+	w = s.do(http.MethodPost, "/api/uploads", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "5"}, "")
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("Tus-Version") != Version {
+		t.Errorf("POST with another version: %d, Tus-Version %q; want 412", w.Code, w.Header().Get("Tus-Version"))
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s := newTestServer(t, time.Hour)
+	metadata := FormatMetadata(map[string]string{"filename": "photo.png", "conversion_id": "abc"})
+	w := s.do(http.MethodPost, "/api/uploads", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": metadata,
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	url := w.Header().Get("Location")
+	if !strings.HasPrefix(url, "/api/uploads/") || w.Header().Get("Upload-Expires") == "" {
+		t.Errorf("POST: Location %q, Upload-Expires %q", url, w.Header().Get("Upload-Expires"))
+	}
+
+	w = s.do(http.MethodHead, url, nil, "")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "0" || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("HEAD: %d, headers %v", w.Code, w.Header())
+	}
+	got, err := ParseMetadata(w.Header().Get("Upload-Metadata"))
+	if err != nil || got["filename"] != "photo.png" || got["conversion_id"] != "abc" || len(got) != 2 {
+		t.Errorf("HEAD: metadata %v, %v", got, err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"no length", nil, http.StatusBadRequest},
+		{"negative length", map[string]string{"Upload-Length": "-1"}, http.StatusBadRequest},
+		{"deferred length", map[string]string{"Upload-Defer-Length": "1"}, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": "101"}, http.StatusRequestEntityTooLarge},
+		{"bad metadata", map[string]string{"Upload-Length": "5", "Upload-Metadata": "filename ???"}, http.StatusBadRequest},
+		{"refused by the hook", map[string]string{"Upload-Length": "5", "Upload-Metadata": FormatMetadata(map[string]string{"filename": "secret.pdf"})}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := s.do(http.MethodPost, "/api/uploads", tt.headers, "")
+		if w.Code != tt.want {
+			t.Errorf("%s: %d %s; want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+		if w.Header().Get("Location") != "" {
+			t.Errorf("%s: created %s", tt.name, w.Header().Get("Location"))
+		}
+	}
+	entries, _ := os.ReadDir(s.handler.cfg.Dir)
+	if len(entries) != 2 {
+		t.Errorf("%d files in the upload directory; want the one upload's 2", len(entries))
+	}
+}
+
+func TestCreateWithUpload(t *testing.T) {
+	s := newTestServer(t, 0)
+	w := s.do(http.MethodPost, "/api/uploads", map[string]string{
+		"Upload-Length": "11",
+		"Content-Type":  offsetContentType,
+	}, "hello")
+	if w.Code != http.StatusCreated || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("POST with a chunk: %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w.Header().Get("Upload-Expires") != "" {
+		t.Error("upload without an expiry has Upload-Expires")
+	}
+	if w := s.patch(w.Header().Get("Location"), "5", " world"); w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+	if len(s.completed) != 1 || s.completed[0].data != "hello world" {
+		t.Errorf("completed %+v; want hello world", s.completed)
+	}
+
+	// An empty upload is complete as soon as it is created
+	s.create(t, "0")
+	if len(s.completed) != 2 || s.completed[1].data != "" {
+		t.Errorf("completed %+v; want the empty upload", s.completed)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	s := newTestServer(t, time.Hour)
+	url := s.create(t, "10")
+
+	w := s.patch(url, "0", "0123")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("PATCH: %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// A chunk sent again, or from further on, is refused with the offset to
+	// resume from
+	for _, offset := range []string{"0", "6"} {
+		w = s.patch(url, offset, "xxxx")
+		if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "4" {
+			t.Errorf("PATCH at %s: %d, Upload-Offset %q; want 409 at 4", offset, w.Code, w.Header().Get("Upload-Offset"))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		body    string
+		want    int
+	}{
+		{"wrong content type", url, map[string]string{"Upload-Offset": "4", "Content-Type": "application/octet-stream"}, "4567", http.StatusUnsupportedMediaType},
+		{"no offset", url, map[string]string{"Content-Type": offsetContentType}, "4567", http.StatusBadRequest},
+		{"past the length", url, map[string]string{"Upload-Offset": "4", "Content-Type": offsetContentType}, "456789ab", http.StatusRequestEntityTooLarge},
+		{"unknown upload", "/api/uploads/0b6c6ddc-4f1c-4b8f-9d3a-0d1e2f3a4b5c", map[string]string{"Upload-Offset": "0", "Content-Type": offsetContentType}, "x", http.StatusNotFound},
+		{"not an upload ID", "/api/uploads/..", map[string]string{"Upload-Offset": "0", "Content-Type": offsetContentType}, "x", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := s.do(http.MethodPatch, tt.url, tt.headers, tt.body); w.Code != tt.want {
+			t.Errorf("%s: %d %s; want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+
+	// An interrupted upload resumes from the offset HEAD reports
+	w = s.do(http.MethodHead, url, nil, "")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "4" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("HEAD: %d, headers %v", w.Code, w.Header())
+	}
+	if len(s.completed) != 0 {
+		t.Fatal("incomplete upload handed off")
+	}
+	if w := s.patch(url, w.Header().Get("Upload-Offset"), "456789"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("resumed PATCH: %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if len(s.completed) != 1 || s.completed[0].data != "0123456789" {
+		t.Errorf("completed %+v; want 0123456789", s.completed)
+	}
+	if w := s.do(http.MethodHead, url, nil, "0"); w.Header().Get("Upload-Expires") != "" {
+		t.Error("completed upload has Upload-Expires")
+	}
+
+	// The upload is being written by another request
+	id := strings.TrimPrefix(url, "/api/uploads/")
+	s.handler.store.lock(id)
+	if w := s.patch(url, "10", ""); w.Code != http.StatusLocked {
+		t.Errorf("PATCH of a locked upload: %d; want 423", w.Code)
+	}
+	s.handler.store.unlock(id)
+}
+
+func TestTerminate(t *testing.T) {
+	s := newTestServer(t, 0)
+	url := s.create(t, "10")
+	s.patch(url, "0", "0123")
+
+	if w := s.do(http.MethodDelete, url, nil, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d %s", w.Code, w.Body)
+	}
+	if entries, _ := os.ReadDir(s.handler.cfg.Dir); len(entries) != 0 {
+		t.Errorf("%d files left after DELETE", len(entries))
+	}
+	if w := s.do(http.MethodHead, url, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE: %d; want 404", w.Code)
+	}
+	if w := s.patch(url, "4", "4567"); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH after DELETE: %d; want 404", w.Code)
+	}
+	if w := s.do(http.MethodDelete, url, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: %d; want 404", w.Code)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := newTestServer(t, time.Hour)
+	stale := s.create(t, "10")
+	fresh := s.create(t, "10")
+	s.patch(stale, "0", "0123")
+
+	// Backdate the written upload's expiry
+	upload, err := s.handler.store.get(strings.TrimPrefix(stale, "/api/uploads/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := s.handler.store.save(upload); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []*httptest.ResponseRecorder{
+		s.do(http.MethodHead, stale, nil, ""),
+		s.patch(stale, "4", "4567"),
+	} {
+		if w.Code != http.StatusGone {
+			t.Errorf("expired upload: %d; want 410", w.Code)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.handler.Watch(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.do(http.MethodHead, stale, nil, "").Code != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatal("expired upload was not removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if w := s.do(http.MethodHead, fresh, nil, ""); w.Code != http.StatusOK {
+		t.Errorf("unexpired upload: %d; want it kept", w.Code)
+	}
+	if _, err := os.Stat(s.handler.store.dataPath(strings.TrimPrefix(stale, "/api/uploads/"))); !os.IsNotExist(err) {
+		t.Errorf("expired upload's data left behind: %v", err)
+	}
+
+	// Uploads without an expiry are kept, and busy ones are skipped
+	forever := newTestServer(t, 0)
+	forever.create(t, "10")
+	if n, err := forever.handler.store.expire(time.Now().Add(24 * time.Hour)); n != 0 || err != nil {
+		t.Errorf("expire removed %d uploads without an expiry, %v", n, err)
+	}
+	id := strings.TrimPrefix(fresh, "/api/uploads/")
+	s.handler.store.lock(id)
+	if n, err := s.handler.store.expire(time.Now().Add(2 * time.Hour)); n != 0 || err != nil {
+		t.Errorf("expire removed %d busy uploads, %v", n, err)
+	}
+	s.handler.store.unlock(id)
+	if n, err := s.handler.store.expire(time.Now().Add(2 * time.Hour)); n != 1 || err != nil {
+		t.Errorf("expire removed %d uploads, %v; want 1", n, err)
+	}
+}
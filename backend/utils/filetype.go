@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// FileType is a file format recognised from the content of a file
+type FileType struct {
+	Extension string `json:"extension"` // canonical extension, e.g. ".jpg"
+	MIME      string `json:"mime"`
+}
+
+// ErrContentMismatch is returned when a file's content is not the format
+// its extension claims, including content that is no known format at all
+var ErrContentMismatch = errors.New("file content does not match its extension")
+
+// ErrUnknownContent is returned by DetectFileType for content it does not
+// recognise
+var ErrUnknownContent = errors.New("file content is not a recognised format")
+
+// Formats recognised by DetectFileType
+var (
+	TypePDF  = FileType{".pdf", "application/pdf"}
+	TypeJPEG = FileType{".jpg", "image/jpeg"}
+	TypePNG  = FileType{".png", "image/png"}
+	TypeGIF  = FileType{".gif", "image/gif"}
+	TypeWEBP = FileType{".webp", "image/webp"}
+	TypeHEIC = FileType{".heic", "image/heic"}
+	TypeDOCX = FileType{".docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"}
+	TypeXLSX = FileType{".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}
+	TypePPTX = FileType{".pptx", "application/vnd.openxmlformats-officedocument.presentationml.presentation"}
+	TypeDOC  = FileType{".doc", "application/msword"}
+	TypeXLS  = FileType{".xls", "application/vnd.ms-excel"}
+	TypePPT  = FileType{".ppt", "application/vnd.ms-powerpoint"}
+	TypeMP3  = FileType{".mp3", "audio/mpeg"}
+	TypeWAV  = FileType{".wav", "audio/wav"}
+	TypeAAC  = FileType{".aac", "audio/aac"}
+	TypeM4A  = FileType{".m4a", "audio/mp4"}
+	TypeMP4  = FileType{".mp4", "video/mp4"}
+	TypeMOV  = FileType{".mov", "video/quicktime"}
+	TypeAVI  = FileType{".avi", "video/x-msvideo"}
+	TypeWEBM = FileType{".webm", "video/webm"}
+	TypeMKV  = FileType{".mkv", "video/x-matroska"}
+)
+
+// sniffLen is how much of the start of a file is examined
+const sniffLen = 4096
+
+// DetectFileType identifies the format of the size bytes readable from r by
+// their content alone
+func DetectFileType(r io.ReaderAt, size int64) (FileType, error) {
+	candidates := detectFileTypes(r, size)
+	if len(candidates) == 0 {
+		return FileType{}, ErrUnknownContent
+	}
+	return candidates[0], nil
+}
+
+// ValidateFileContent checks that the content readable from r is the format
+// named by the extension of filename and returns the detected type. A
+// mismatch, or content of no known format, is reported as
+// ErrContentMismatch.
+func ValidateFileContent(filename string, r io.ReaderAt, size int64) (FileType, error) {
+	ext := canonicalExtension(GetFileExtension(filename))
+	candidates := detectFileTypes(r, size)
+	for _, ft := range candidates {
+		if ft.Extension == ext {
+			return ft, nil
+		}
+	}
+	if len(candidates) == 0 {
+		return FileType{}, fmt.Errorf("%w: %s is not a recognised file type", ErrContentMismatch, filename)
+	}
+	return candidates[0], fmt.Errorf("%w: %s contains %s", ErrContentMismatch, filename, candidates[0].MIME)
+}
+
+// ValidateFile runs ValidateFileContent on the file at path, checking it
+// against the extension of filename
+func ValidateFile(filename, path string) (FileType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileType{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return FileType{}, err
+	}
+	return ValidateFileContent(filename, f, info.Size())
+}
+
+// canonicalExtension maps alternative spellings of an extension to the one
+// used in FileType
+func canonicalExtension(ext string) string {
+	if ext == ".jpeg" {
+		return ".jpg"
+	}
+	return ext
+}
+
+// detectFileTypes returns the formats the content may be, most likely
+// first. Some containers legitimately hold several formats: an ISO media
+// file may be .mp4, .m4a or .mov, and a legacy Office file whose streams
+// cannot be read may be any of .doc, .xls and .ppt.
+func detectFileTypes(r io.ReaderAt, size int64) []FileType {
+	head := readAt(r, 0, sniffLen)
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return []FileType{TypeJPEG}
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return []FileType{TypePNG}
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return []FileType{TypeGIF}
+	case bytes.HasPrefix(head, []byte("RIFF")) && len(head) >= 12:
+		switch string(head[8:12]) {
+		case "WEBP":
+			return []FileType{TypeWEBP}
+		case "WAVE":
+			return []FileType{TypeWAV}
+		case "AVI ":
+			return []FileType{TypeAVI}
+		}
+		return nil
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return detectMatroska(head)
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return detectISOMedia(head)
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return detectOOXML(r, size)
+	case bytes.HasPrefix(head, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}):
+		return detectOLE(r, head)
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return detectID3Audio(r, head)
+	case bytes.HasPrefix(head, []byte("ADIF")):
+		return []FileType{TypeAAC}
+	}
+
+	// PDF readers accept the header anywhere in the first kilobyte
+	if i := bytes.Index(head, []byte("%PDF-")); i >= 0 && i < 1024 {
+		return []FileType{TypePDF}
+	}
+	return detectAudioFrame(head)
+}
+
+// detectMatroska tells WebM from other Matroska files by the EBML DocType.
+// WebM is a subset of Matroska, so it may also be named .mkv.
+func detectMatroska(head []byte) []FileType {
+	switch {
+	case bytes.Contains(head, []byte("webm")):
+		return []FileType{TypeWEBM, TypeMKV}
+	case bytes.Contains(head, []byte("matroska")):
+		return []FileType{TypeMKV}
+	}
+	return nil
+}
+
+// detectISOMedia classifies an ISO base media file (MP4, QuickTime, HEIF)
+// by the brands in its ftyp box
+func detectISOMedia(head []byte) []FileType {
+	boxSize := int(binary.BigEndian.Uint32(head[0:4]))
+	if boxSize < 16 || boxSize > len(head) {
+		boxSize = min(len(head), 64)
+	}
+	brands := map[string]bool{string(head[8:12]): true}
+	for i := 16; i+4 <= boxSize; i += 4 {
+		brands[string(head[i:i+4])] = true
+	}
+	major := string(head[8:12])
+
+	for _, b := range []string{"heic", "heix", "heim", "heis", "hevc", "hevx"} {
+		if brands[b] {
+			return []FileType{TypeHEIC}
+		}
+	}
+	if (brands["mif1"] || brands["msf1"]) && !brands["avif"] && !brands["avis"] {
+		return []FileType{TypeHEIC}
+	}
+	if brands["avif"] || brands["avis"] {
+		return nil
+	}
+
+	// MP4, M4A and QuickTime share one container; which extension a file
+	// carries says more about its tracks than the brands do
+	switch {
+	case major == "qt  ":
+		return []FileType{TypeMOV, TypeMP4, TypeM4A}
+	case major == "M4A " || major == "M4B " || major == "M4P " || brands["M4A "]:
+		return []FileType{TypeM4A, TypeMP4, TypeMOV}
+	}
+	return []FileType{TypeMP4, TypeM4A, TypeMOV}
+}
+
+// detectOOXML tells Word, Excel and PowerPoint files apart by the folders
+// in the zip container. Other zip files are not a supported format.
+func detectOOXML(r io.ReaderAt, size int64) []FileType {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil
+	}
+	for _, f := range archive.File {
+		switch {
+		case strings.HasPrefix(f.Name, "word/"):
+			return []FileType{TypeDOCX}
+		case strings.HasPrefix(f.Name, "xl/"):
+			return []FileType{TypeXLSX}
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return []FileType{TypePPTX}
+		}
+	}
+	return nil
+}
+
+// maxOLEDirectorySectors bounds how much of a compound file's directory is
+// read looking for the stream that identifies it
+const maxOLEDirectorySectors = 64
+
+// detectOLE identifies a legacy Office document (OLE compound file) by the
+// name of its main stream. Other compound files, such as MSI installers, are
+// not a supported format. If the directory cannot be read at all, the file
+// may be any of the three.
+func detectOLE(r io.ReaderAt, head []byte) []FileType {
+	unknown := []FileType{TypeDOC, TypeXLS, TypePPT}
+	if len(head) < 512 {
+		return unknown
+	}
+	shift := binary.LittleEndian.Uint16(head[0x1E:])
+	if shift != 9 && shift != 12 {
+		return unknown
+	}
+	sectorSize := int64(1) << shift
+	sectorOffset := func(sector uint32) int64 { return (int64(sector) + 1) * sectorSize }
+
+	// The first 109 sectors of the FAT are listed in the header, which is
+	// enough to follow the directory chain of any normal document
+	fatSectors := int(binary.LittleEndian.Uint32(head[0x2C:]))
+	nextSector := func(sector uint32) uint32 {
+		perSector := uint32(sectorSize / 4)
+		index := int(sector / perSector)
+		if index >= fatSectors || index >= 109 {
+			return 0xFFFFFFFE
+		}
+		fatSector := binary.LittleEndian.Uint32(head[0x4C+4*index:])
+		entry := readAt(r, sectorOffset(fatSector)+int64(sector%perSector)*4, 4)
+		if len(entry) < 4 {
+			return 0xFFFFFFFE
+		}
+		return binary.LittleEndian.Uint32(entry)
+	}
+
+	sector := binary.LittleEndian.Uint32(head[0x30:])
+	readDirectory := false
+	for n := 0; n < maxOLEDirectorySectors && sector < 0xFFFFFFFA; n++ {
+		dir := readAt(r, sectorOffset(sector), int(sectorSize))
+		readDirectory = readDirectory || len(dir) > 0
+		for i := 0; i+128 <= len(dir); i += 128 {
+			switch oleEntryName(dir[i : i+128]) {
+			case "WordDocument":
+				return []FileType{TypeDOC}
+			case "Workbook", "Book":
+				return []FileType{TypeXLS}
+			case "PowerPoint Document":
+				return []FileType{TypePPT}
+			}
+		}
+		sector = nextSector(sector)
+	}
+	if readDirectory {
+		return nil
+	}
+	return unknown
+}
+
+// oleEntryName decodes the UTF-16 name of a compound file directory entry
+func oleEntryName(entry []byte) string {
+	length := int(binary.LittleEndian.Uint16(entry[64:]))
+	if length < 2 || length > 64 {
+		return ""
+	}
+	units := make([]uint16, length/2-1) // without the terminating NUL
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(entry[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// detectID3Audio looks past an ID3v2 tag at the first audio frame. Tagged
+// files whose first frame is not recognised are taken to be MP3, the format
+// ID3 was made for.
+func detectID3Audio(r io.ReaderAt, head []byte) []FileType {
+	if len(head) < 10 {
+		return nil
+	}
+	// The tag size is stored as four 7-bit bytes and excludes the header
+	// and the optional footer
+	tagSize := int64(head[6])<<21 | int64(head[7])<<14 | int64(head[8])<<7 | int64(head[9])
+	offset := 10 + tagSize
+	if head[5]&0x10 != 0 {
+		offset += 10
+	}
+	if types := detectAudioFrame(readAt(r, offset, 4)); types != nil {
+		return types
+	}
+	return []FileType{TypeMP3}
+}
+
+// detectAudioFrame recognises an MPEG audio frame header (MP3) or an ADTS
+// header (AAC) at the start of b
+func detectAudioFrame(b []byte) []FileType {
+	if len(b) < 4 || b[0] != 0xFF {
+		return nil
+	}
+	switch {
+	case b[1]&0xF6 == 0xF0:
+		// 12 sync bits, then the layer bits that ADTS always leaves at 0
+		return []FileType{TypeAAC}
+	case b[1]&0xE0 == 0xE0 && (b[1]&0x06 == 0x02 || b[1]&0x06 == 0x04) && b[2]&0xF0 != 0xF0 && b[2]&0x0C != 0x0C:
+		// 11 sync bits, layer III or II, and a valid bitrate and sample rate
+		return []FileType{TypeMP3}
+	}
+	return nil
+}
+
+// readAt reads up to n bytes at off, returning however many were available
+func readAt(r io.ReaderAt, off int64, n int) []byte {
+	buf := make([]byte, n)
+	read, _ := r.ReadAt(buf, off)
+	return buf[:read]
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// oleFile builds a minimal compound file with 512-byte sectors: the FAT in
+// sector 0 and a one-sector directory in sector 1 holding the root entry
+// and streams with the given names
+func oleFile(streams ...string) []byte {
+	const sectorSize = 512
+	file := make([]byte, 3*sectorSize)
+	header := file[:sectorSize]
+	copy(header, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1})
+	binary.LittleEndian.PutUint16(header[0x1A:], 3)      // major version
+	binary.LittleEndian.PutUint16(header[0x1C:], 0xFFFE) // byte order
+	binary.LittleEndian.PutUint16(header[0x1E:], 9)      // sector shift
+	binary.LittleEndian.PutUint32(header[0x2C:], 1)      // FAT sectors
+	binary.LittleEndian.PutUint32(header[0x30:], 1)      // first directory sector
+	for i := 0; i < 109; i++ {
+		binary.LittleEndian.PutUint32(header[0x4C+4*i:], 0xFFFFFFFF)
+	}
+	binary.LittleEndian.PutUint32(header[0x4C:], 0) // the FAT is sector 0
+
+	fat := file[sectorSize : 2*sectorSize]
+	for i := 0; i < sectorSize/4; i++ {
+		binary.LittleEndian.PutUint32(fat[4*i:], 0xFFFFFFFF)
+	}
+	binary.LittleEndian.PutUint32(fat[0:], 0xFFFFFFFD) // FAT sector
+	binary.LittleEndian.PutUint32(fat[4:], 0xFFFFFFFE) // end of the directory chain
+
+	dir := file[2*sectorSize:]
+	for i, name := range append([]string{"Root Entry"}, streams...) {
+		entry := dir[128*i : 128*(i+1)]
+		units := utf16.Encode([]rune(name))
+		for j, u := range units {
+			binary.LittleEndian.PutUint16(entry[2*j:], u)
+		}
+		binary.LittleEndian.PutUint16(entry[64:], uint16(2*len(units)+2))
+	}
+	return file
+}
+
+// isoMedia builds an ftyp box with a major brand and compatible brands
+func isoMedia(major string, compatible ...string) []byte {
+	box := make([]byte, 16, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(box, uint32(16+4*len(compatible)))
+	copy(box[4:], "ftyp"+major)
+	for _, b := range compatible {
+		box = append(box, b...)
+	}
+	return append(box, make([]byte, 32)...) // the next box
+}
+
+// id3 builds an ID3v2.3 tag of tagSize bytes, with a footer if flagged,
+// followed by frame
+func id3(tagSize int, footer bool, frame []byte) []byte {
+	b := []byte("ID3\x03\x00\x00")
+	if footer {
+		b[5] = 0x10
+	}
+	b = append(b, byte(tagSize>>21&0x7F), byte(tagSize>>14&0x7F), byte(tagSize>>7&0x7F), byte(tagSize&0x7F))
+	b = append(b, make([]byte, tagSize)...)
+	if footer {
+		b = append(b, "3DI\x03\x00\x10\x00\x00\x00\x00"...)
+	}
+	return append(b, frame...)
+}
+
+// zipFile builds a zip archive holding empty files with the given names
+func zipFile(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := w.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var (
+	mp3Frame  = []byte{0xFF, 0xFB, 0x90, 0x64}
+	adtsFrame = []byte{0xFF, 0xF1, 0x50, 0x80}
+)
+
+func TestDetectFileType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    []FileType
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F'}, []FileType{TypeJPEG}},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), []FileType{TypePNG}},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), []FileType{TypeGIF}},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), []FileType{TypeWEBP}},
+		{"wav", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), []FileType{TypeWAV}},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), []FileType{TypeAVI}},
+		{"other riff", []byte("RIFF\x00\x00\x00\x00CDDAfmt "), nil},
+		{"pdf", []byte("%PDF-1.7\n"), []FileType{TypePDF}},
+		{"pdf after junk", append(bytes.Repeat([]byte{' '}, 1000), "%PDF-1.4\n"...), []FileType{TypePDF}},
+		{"pdf header too late", append(bytes.Repeat([]byte{' '}, 1100), "%PDF-1.4\n"...), nil},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm"), []FileType{TypeWEBM, TypeMKV}},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\xa3\x42\x82\x88matroska"), []FileType{TypeMKV}},
+
+		// ISO base media brands
+		{"mp4 isom", isoMedia("isom", "isom", "iso2", "avc1", "mp41"), []FileType{TypeMP4, TypeM4A, TypeMOV}},
+		{"m4a", isoMedia("M4A ", "M4A ", "mp42", "isom"), []FileType{TypeM4A, TypeMP4, TypeMOV}},
+		{"m4a compatible brand", isoMedia("mp42", "M4A ", "mp42"), []FileType{TypeM4A, TypeMP4, TypeMOV}},
+		{"quicktime", isoMedia("qt  ", "qt  "), []FileType{TypeMOV, TypeMP4, TypeM4A}},
+		{"heic", isoMedia("heic", "mif1", "heic"), []FileType{TypeHEIC}},
+		{"heif mif1", isoMedia("mif1", "mif1", "miaf"), []FileType{TypeHEIC}},
+		{"heic compatible brand", isoMedia("mif1", "heix"), []FileType{TypeHEIC}},
+		{"avif", isoMedia("avif", "mif1", "miaf", "avif"), nil},
+
+		// Office
+		{"doc", oleFile("WordDocument", "1Table"), []FileType{TypeDOC}},
+		{"xls", oleFile("Workbook"), []FileType{TypeXLS}},
+		{"xls 5.0", oleFile("Book"), []FileType{TypeXLS}},
+		{"ppt", oleFile("Current User", "PowerPoint Document"), []FileType{TypePPT}},
+		{"msi", oleFile("䡀䅆䖧"), nil},
+		{"docx", nil, []FileType{TypeDOCX}},
+		{"xlsx", nil, []FileType{TypeXLSX}},
+		{"pptx", nil, []FileType{TypePPTX}},
+		{"plain zip", nil, nil},
+
+		// Audio
+		{"mp3 frame", append(mp3Frame, 0, 0), []FileType{TypeMP3}},
+		{"adts frame", append(adtsFrame, 0, 0), []FileType{TypeAAC}},
+		{"adif", []byte("ADIF\x00\x00"), []FileType{TypeAAC}},
+		{"id3 then mp3", id3(20, false, mp3Frame), []FileType{TypeMP3}},
+		{"id3 then adts", id3(20, false, adtsFrame), []FileType{TypeAAC}},
+		{"id3 with footer then adts", id3(20, true, adtsFrame), []FileType{TypeAAC}},
+		{"id3 then unknown frame", id3(20, false, []byte("junk")), []FileType{TypeMP3}},
+		{"bad mpeg bitrate", []byte{0xFF, 0xFB, 0xF0, 0x64}, nil},
+
+		{"exe", append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 56)...), nil},
+		{"text", []byte("hello, world\n"), nil},
+		{"empty", nil, nil},
+	}
+	zips := map[string][]string{
+		"docx":      {"[Content_Types].xml", "_rels/.rels", "word/document.xml"},
+		"xlsx":      {"[Content_Types].xml", "xl/workbook.xml"},
+		"pptx":      {"[Content_Types].xml", "ppt/presentation.xml"},
+		"plain zip": {"readme.txt", "photo.jpg"},
+	}
+	for _, tt := range tests {
+		content := tt.content
+		if names, ok := zips[tt.name]; ok {
+			content = zipFile(t, names...)
+		}
+		got := detectFileTypes(bytes.NewReader(content), int64(len(content)))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: detected %v; want %v", tt.name, got, tt.want)
+		}
+
+		ft, err := DetectFileType(bytes.NewReader(content), int64(len(content)))
+		if tt.want == nil {
+			if !errors.Is(err, ErrUnknownContent) {
+				t.Errorf("%s: DetectFileType = %v, %v; want ErrUnknownContent", tt.name, ft, err)
+			}
+		} else if err != nil || ft != tt.want[0] {
+			t.Errorf("%s: DetectFileType = %v, %v; want %v", tt.name, ft, err, tt.want[0])
+		}
+	}
+}
+
+func TestValidateFileContent(t *testing.T) {
+	docx := zipFile(t, "[Content_Types].xml", "word/document.xml")
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		want     FileType
+		mismatch bool
+	}{
+		{"pdf", "admit.pdf", []byte("%PDF-1.4\n"), TypePDF, false},
+		{"jpeg spelling", "photo.JPEG", []byte{0xFF, 0xD8, 0xFF, 0xE1}, TypeJPEG, false},
+		{"docx", "form.docx", docx, TypeDOCX, false},
+		{"m4a in an mp4 container", "voice.m4a", isoMedia("isom", "isom", "mp41"), TypeM4A, false},
+		{"webm named mkv", "clip.mkv", []byte("\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm"), TypeMKV, false},
+		{"unreadable ole as xls", "sheet.xls", oleFile()[:512], TypeXLS, false},
+
+		// Mismatches
+		{"renamed exe", "admit.pdf", append([]byte("MZ\x90\x00"), make([]byte, 60)...), FileType{}, true},
+		{"png named jpg", "photo.jpg", []byte("\x89PNG\r\n\x1a\n"), TypePNG, true},
+		{"plain zip named docx", "form.docx", zipFile(t, "readme.txt"), FileType{}, true},
+		{"xlsx named docx", "form.docx", zipFile(t, "xl/workbook.xml"), TypeXLSX, true},
+		{"doc named xls", "sheet.xls", oleFile("WordDocument"), TypeDOC, true},
+		{"heic named jpg", "photo.jpg", isoMedia("heic", "mif1"), TypeHEIC, true},
+
+		// Truncated headers
+		{"truncated png", "photo.png", []byte("\x89PN"), FileType{}, true},
+		{"truncated jpeg", "photo.jpg", []byte{0xFF, 0xD8}, FileType{}, true},
+		{"truncated riff", "photo.webp", []byte("RIFF\x00\x00\x00\x00WE"), FileType{}, true},
+		{"truncated ftyp", "clip.mp4", []byte("\x00\x00\x00\x18ftyp"), FileType{}, true},
+		{"truncated zip", "form.docx", docx[:40], FileType{}, true},
+		{"truncated id3", "song.mp3", []byte("ID3\x03\x00"), FileType{}, true},
+		{"truncated pdf", "admit.pdf", []byte("%PD"), FileType{}, true},
+		{"empty", "admit.pdf", nil, FileType{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ValidateFileContent(tt.filename, bytes.NewReader(tt.content), int64(len(tt.content)))
+		if tt.mismatch != errors.Is(err, ErrContentMismatch) {
+			t.Errorf("%s: ValidateFileContent error = %v; want mismatch %v", tt.name, err, tt.mismatch)
+		}
+		if got != tt.want {
+			t.Errorf("%s: ValidateFileContent type = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"mime/multipart"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -20,6 +22,7 @@ var AllowedExtensions = map[string]bool{
 	".png":   true,
 	".gif":   true,
 	".webp":  true,
+	".heic":  true,
 	".mp3":   true,
 	".wav":   true,
 	".aac":   true,
@@ -48,11 +51,15 @@ func GetFileExtension(filename string) string {
 	return strings.ToLower(filepath.Ext(filename))
 }
 
-// ValidateFileHeader checks the file MIME type using header bytes
-func ValidateFileHeader(file *multipart.FileHeader) (bool, string) {
-	// This would typically read file header bytes to validate
-	// For now, we'll just validate based on extension
-	return IsValidFileExtension(file.Filename), GetFileExtension(file.Filename)
+// ValidateFileHeader checks that an uploaded file's content matches its
+// extension and returns the detected type. See ValidateFileContent.
+func ValidateFileHeader(file *multipart.FileHeader) (FileType, error) {
+	f, err := file.Open()
+	if err != nil {
+		return FileType{}, err
+	}
+	defer f.Close()
+	return ValidateFileContent(file.Filename, f, file.Size)
 }
 
 // SanitizeFileName removes potentially dangerous characters from filename
@@ -72,19 +79,84 @@ func SanitizeFileName(filename string) string {
 
 // ConversionStatusConstants
 const (
-	StatusPending    = "pending"
-	StatusProcessing = "processing"
-	StatusCompleted  = "completed"
-	StatusFailed     = "failed"
+	StatusPending     = "pending"
+	StatusProcessing  = "processing"
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusQuarantined = "quarantined" // the upload was found infected
 )
 
 // IsValidStatus checks if the status is valid
 func IsValidStatus(status string) bool {
 	validStatuses := map[string]bool{
-		StatusPending:    true,
-		StatusProcessing: true,
-		StatusCompleted:  true,
-		StatusFailed:     true,
+		StatusPending:     true,
+		StatusProcessing:  true,
+		StatusCompleted:   true,
+		StatusFailed:      true,
+		StatusQuarantined: true,
 	}
 	return validStatuses[status]
 }
+
+// FormatExtensions parses a document format list such as "JPG, PNG" into
+// the file extensions it allows. JPG and JPEG are treated as the same format.
+// Unknown formats are returned in unknown.
+func FormatExtensions(format string) (extensions []string, unknown []string) {
+	seen := map[string]bool{}
+	for _, name := range strings.Split(format, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		ext := "." + name
+		if !AllowedExtensions[ext] {
+			unknown = append(unknown, strings.ToUpper(name))
+			continue
+		}
+		exts := []string{ext}
+		if ext == ".jpg" || ext == ".jpeg" {
+			exts = []string{".jpg", ".jpeg"}
+		}
+		for _, e := range exts {
+			if !seen[e] {
+				seen[e] = true
+				extensions = append(extensions, e)
+			}
+		}
+	}
+	return extensions, unknown
+}
+
+// MaxDPI is the highest DPI image files can record
+const MaxDPI = 65535
+
+// ParseAspectRatio parses a width:height ratio such as "3:4" or "1.5:1"
+// into width divided by height
+func ParseAspectRatio(ratio string) (float64, error) {
+	w, h, ok := strings.Cut(ratio, ":")
+	if !ok {
+		return 0, fmt.Errorf("aspect ratio %q is not width:height", ratio)
+	}
+	width, err1 := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	height, err2 := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, fmt.Errorf("aspect ratio %q is not width:height", ratio)
+	}
+	return width / height, nil
+}
+
+// FormatFileSize renders a byte count the way document sizes are written,
+// e.g. 512000 as "500KB" and 2097152 as "2MB"
+func FormatFileSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value == float64(int64(value)) {
+		return fmt.Sprintf("%d%s", int64(value), units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
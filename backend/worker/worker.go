@@ -0,0 +1,323 @@
+// Package worker runs conversions in the background. A fixed number of
+// workers take conversion IDs from a bounded queue and move each request
+// from pending through processing to completed or failed.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// Output describes a converted file stored in blob storage
+type Output struct {
+	Key  string
+	Size int64
+	// Settings are recorded on the conversion as its output settings
+	Settings map[string]string
+}
+
+// Processor performs one conversion. It stores the converted file in blob
+// storage and describes it. It must give up when ctx is cancelled.
+type Processor interface {
+	Process(ctx context.Context, conv models.ConversionRequest) (Output, error)
+}
+
+// ProcessorFunc adapts a function to Processor
+type ProcessorFunc func(ctx context.Context, conv models.ConversionRequest) (Output, error)
+
+// Process calls f
+func (f ProcessorFunc) Process(ctx context.Context, conv models.ConversionRequest) (Output, error) {
+	return f(ctx, conv)
+}
+
+// Config sizes a pool
+type Config struct {
+	// Workers is how many conversions run at once
+	Workers int
+	// QueueSize is how many conversions may wait for a worker. Conversions
+	// that do not fit stay pending until the next poll.
+	QueueSize int
+	// PollInterval is how often pending conversions are looked up, to pick
+	// up those that did not fit in the queue
+	PollInterval time.Duration
+	// JobTimeout bounds a single conversion
+	JobTimeout time.Duration
+}
+
+// Pool runs conversions with a bounded number of workers. Only one backend
+// instance sharing a database should run a pool: conversions are claimed
+// with UpdateConversion, which does not guard against a second claimant.
+type Pool struct {
+	store storage.Store
+	blobs blob.Store
+	proc  Processor
+	cfg   Config
+
+	queue chan string
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	// ctx is cancelled to abort running conversions when a shutdown runs
+	// out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	queued  map[string]bool // queued or running
+	stopped bool
+}
+
+// New creates a pool; Start begins processing. blobs is where proc stores
+// outputs, which are deleted again if they cannot be recorded.
+func New(store storage.Store, blobs blob.Store, proc Processor, cfg Config) *Pool {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		store:  store,
+		blobs:  blobs,
+		proc:   proc,
+		cfg:    cfg,
+		queue:  make(chan string, cfg.QueueSize),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		queued: map[string]bool{},
+	}
+}
+
+// Start puts conversions left processing by a previous run back to pending,
+// then starts the workers and the poller, which queues pending conversions
+// straight away
+func (p *Pool) Start(ctx context.Context) error {
+	interrupted, err := p.store.ListConversionsByStatus(ctx, utils.StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to list interrupted conversions: %w", err)
+	}
+	for _, conv := range interrupted {
+		if err := p.store.UpdateConversion(ctx, conv.ID, utils.StatusPending, ""); err != nil {
+			return fmt.Errorf("failed to re-queue conversion %s: %w", conv.ID, err)
+		}
+	}
+	if len(interrupted) > 0 {
+		log.Printf("Re-queued %d interrupted conversions", len(interrupted))
+	}
+
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	p.wg.Add(1)
+	go p.poll()
+	log.Printf("✓ Started %d conversion workers", p.cfg.Workers)
+	return nil
+}
+
+// Enqueue queues a conversion without blocking. It reports false if the
+// queue is full or the pool is shutting down; the conversion then stays
+// pending for the next poll or start.
+func (p *Pool) Enqueue(conversionID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return false
+	}
+	if p.queued[conversionID] {
+		return true
+	}
+	select {
+	case p.queue <- conversionID:
+		p.queued[conversionID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops taking conversions from the queue and waits for the
+// running ones to finish. If ctx ends first they are cancelled and put back
+// to pending, and Shutdown returns once they have stopped.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return fmt.Errorf("conversions cancelled: %w", ctx.Err())
+	}
+}
+
+// work runs queued conversions until the pool stops
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		// Check stop first so a busy queue cannot delay shutdown
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		select {
+		case <-p.stop:
+			return
+		case id := <-p.queue:
+			p.run(id)
+			p.mu.Lock()
+			delete(p.queued, id)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// poll queues pending conversions that have their input, now and then
+// every PollInterval
+func (p *Pool) poll() {
+	defer p.wg.Done()
+	var tick <-chan time.Time
+	if p.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(p.cfg.PollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		p.queuePending()
+		select {
+		case <-p.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+// queuePending queues pending conversions, oldest first, until the queue is
+// full
+func (p *Pool) queuePending() {
+	pending, err := p.store.ListConversionsByStatus(p.ctx, utils.StatusPending)
+	if err != nil {
+		log.Printf("⚠ Failed to list pending conversions: %v", err)
+		return
+	}
+	for _, conv := range pending {
+		if ready(conv) && !p.Enqueue(conv.ID) {
+			return
+		}
+	}
+}
+
+// ready reports whether a pending conversion has a clean input to convert
+func ready(conv models.ConversionRequest) bool {
+	return conv.Status == utils.StatusPending && conv.InputPath != ""
+}
+
+// run performs one conversion and records its outcome
+func (p *Pool) run(id string) {
+	conv, err := p.store.GetConversionByID(p.ctx, id)
+	if err != nil {
+		log.Printf("⚠ Failed to load conversion %s: %v", id, err)
+		return
+	}
+	if !ready(*conv) {
+		return
+	}
+	if err := p.store.UpdateConversion(p.ctx, id, utils.StatusProcessing, ""); err != nil {
+		log.Printf("⚠ Failed to start conversion %s: %v", id, err)
+		return
+	}
+
+	ctx := p.ctx
+	if p.cfg.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.JobTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	output, err := p.proc.Process(ctx, *conv)
+
+	// Record the outcome even if a shutdown has cancelled p.ctx
+	record := context.Background()
+	switch {
+	case err != nil && p.ctx.Err() != nil:
+		// Shutdown: leave it for the next start
+		err = p.store.UpdateConversion(record, id, utils.StatusPending, "")
+	case err != nil:
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("conversion took longer than %s", p.cfg.JobTimeout)
+		}
+		log.Printf("⚠ Conversion %s failed after %s: %v", id, time.Since(start).Round(time.Millisecond), err)
+		err = p.store.UpdateConversion(record, id, utils.StatusFailed, err.Error())
+	default:
+		err = p.complete(record, id, conv.InputPath, output)
+		if err == nil {
+			log.Printf("✓ Conversion %s completed in %s", id, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if err != nil {
+		log.Printf("⚠ Failed to record outcome of conversion %s: %v", id, err)
+	}
+}
+
+// complete records the output of a conversion. The conversion is reloaded
+// first, and the output is only recorded if it is still processing the
+// input that was converted; otherwise, or if recording fails, the output is
+// deleted. The conversion holds a reference to the output blob, like its
+// input.
+func (p *Pool) complete(ctx context.Context, id, inputPath string, output Output) error {
+	conv, err := p.store.GetConversionByID(ctx, id)
+	if err != nil {
+		p.discard(ctx, output)
+		return err
+	}
+	if conv.Status != utils.StatusProcessing || conv.InputPath != inputPath {
+		p.discard(ctx, output)
+		log.Printf("Conversion %s changed while it was processed, discarding its output", id)
+		return nil
+	}
+
+	if err := blob.Acquire(ctx, p.blobs, p.store, output.Key, nil); err != nil {
+		p.discard(ctx, output)
+		return err
+	}
+	conv.OutputPath = output.Key
+	conv.OutputSize = output.Size
+	conv.OutputSettings = output.Settings
+	conv.Status = utils.StatusCompleted
+	conv.ErrorMsg = ""
+	if _, err := p.store.SaveConversion(ctx, *conv); err != nil {
+		blob.Release(ctx, p.blobs, p.store, output.Key)
+		return err
+	}
+	return nil
+}
+
+// discard deletes an output that was not recorded
+func (p *Pool) discard(ctx context.Context, output Output) {
+	blob.Discard(ctx, p.blobs, p.store, output.Key)
+}
@@ -0,0 +1,302 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oneforall/backend/blob"
+	"github.com/oneforall/backend/models"
+	"github.com/oneforall/backend/storage"
+	"github.com/oneforall/backend/utils"
+)
+
+// fakeProcessor stores a small output for each conversion, under a key of
+// its own per run, after calling before if it is set. A conversion whose
+// file name is "fail" fails.
+type fakeProcessor struct {
+	blobs  blob.Store
+	runs   atomic.Int32
+	before func(ctx context.Context, conv models.ConversionRequest) error
+}
+
+func (f *fakeProcessor) Process(ctx context.Context, conv models.ConversionRequest) (Output, error) {
+	run := f.runs.Add(1)
+	if f.before != nil {
+		if err := f.before(ctx, conv); err != nil {
+			return Output{}, err
+		}
+	}
+	if conv.FileName == "fail" {
+		return Output{}, errors.New("unsupported input")
+	}
+	data := "converted " + conv.InputPath
+	key := fmt.Sprintf("outputs/%s-%d", conv.ID, run)
+	if err := f.blobs.Put(ctx, key, strings.NewReader(data), int64(len(data))); err != nil {
+		return Output{}, err
+	}
+	return Output{Key: key, Size: int64(len(data)), Settings: map[string]string{"quality": "90"}}, nil
+}
+
+func newTestPool(t *testing.T, cfg Config) (*Pool, *storage.MemoryStorage, *fakeProcessor) {
+	t.Helper()
+	store := storage.NewMemoryStorage(nil, nil)
+	if err := store.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	blobs := blob.NewLocal(t.TempDir())
+	proc := &fakeProcessor{blobs: blobs}
+	return New(store, blobs, proc, cfg), store, proc
+}
+
+// createConversion stores a pending conversion of the input at inputPath,
+// or of no input yet if it is empty
+func createConversion(t *testing.T, store storage.Store, fileName, inputPath string) string {
+	t.Helper()
+	conv, err := store.CreateConversion(context.Background(), models.ConversionRequest{
+		UserID: "user", ExamID: "exam", DocumentID: "photo", FileName: fileName, InputPath: inputPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conv.ID
+}
+
+func getConversion(t *testing.T, store storage.Store, id string) *models.ConversionRequest {
+	t.Helper()
+	conv, err := store.GetConversionByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conv
+}
+
+// waitStatus waits for a conversion to reach status
+func waitStatus(t *testing.T, store storage.Store, id, status string) *models.ConversionRequest {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conv := getConversion(t, store, id)
+		if conv.Status == status {
+			return conv
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("conversion %s is %s; want %s", id, conv.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func shutdown(t *testing.T, p *Pool) {
+	t.Helper()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func blobExists(store blob.Store, key string) bool {
+	_, err := store.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestPoolRunsConversions(t *testing.T) {
+	p, store, proc := newTestPool(t, Config{Workers: 2, QueueSize: 4})
+	ok := createConversion(t, store, "photo.png", "inputs/a")
+	failing := createConversion(t, store, "fail", "inputs/b")
+	waiting := createConversion(t, store, "photo.png", "")
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, p)
+
+	conv := waitStatus(t, store, ok, utils.StatusCompleted)
+	if conv.OutputPath == "" || conv.OutputSize == 0 || conv.OutputSettings["quality"] != "90" || conv.ErrorMsg != "" {
+		t.Errorf("completed conversion %+v; want its output recorded", conv)
+	}
+	if n, _ := store.AddBlobRef(context.Background(), conv.OutputPath); n != 2 {
+		t.Errorf("output has %d references after adding one; want 2", n)
+	}
+	if conv := waitStatus(t, store, failing, utils.StatusFailed); conv.ErrorMsg != "unsupported input" {
+		t.Errorf("failed conversion has error %q", conv.ErrorMsg)
+	}
+
+	// A conversion without its input is left pending until it is queued
+	// again once the input is there
+	if conv := getConversion(t, store, waiting); conv.Status != utils.StatusPending {
+		t.Errorf("conversion without input is %s", conv.Status)
+	}
+	conv = getConversion(t, store, waiting)
+	conv.InputPath = "inputs/c"
+	if _, err := store.SaveConversion(context.Background(), *conv); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Enqueue(waiting) {
+		t.Fatal("Enqueue refused a conversion")
+	}
+	waitStatus(t, store, waiting, utils.StatusCompleted)
+	if runs := proc.runs.Load(); runs != 3 {
+		t.Errorf("%d runs; want 3", runs)
+	}
+}
+
+func TestPoolJobTimeout(t *testing.T) {
+	p, store, proc := newTestPool(t, Config{JobTimeout: 20 * time.Millisecond})
+	proc.before = func(ctx context.Context, conv models.ConversionRequest) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	id := createConversion(t, store, "photo.png", "inputs/a")
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, p)
+
+	if conv := waitStatus(t, store, id, utils.StatusFailed); !strings.Contains(conv.ErrorMsg, "took longer than 20ms") {
+		t.Errorf("timed out conversion has error %q", conv.ErrorMsg)
+	}
+}
+
+// TestPoolStartRequeues checks that Start puts conversions a previous run
+// left processing back to pending, and runs them with those already
+// pending
+func TestPoolStartRequeues(t *testing.T) {
+	p, store, _ := newTestPool(t, Config{Workers: 1, QueueSize: 1, PollInterval: 10 * time.Millisecond})
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, createConversion(t, store, "photo.png", fmt.Sprintf("inputs/%d", i)))
+	}
+	for _, id := range ids[:2] {
+		if err := store.UpdateConversion(ctx, id, utils.StatusProcessing, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, p)
+
+	// With a queue of one, the poller queues the rest as it frees up
+	for _, id := range ids {
+		waitStatus(t, store, id, utils.StatusCompleted)
+	}
+}
+
+// TestPoolShutdownDrains checks that Shutdown lets running conversions
+// finish and stops taking queued ones, which stay pending
+func TestPoolShutdownDrains(t *testing.T) {
+	p, store, proc := newTestPool(t, Config{Workers: 1, QueueSize: 2})
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	proc.before = func(ctx context.Context, conv models.ConversionRequest) error {
+		started <- conv.ID
+		<-release
+		return nil
+	}
+	first := createConversion(t, store, "photo.png", "inputs/a")
+	second := createConversion(t, store, "photo.png", "inputs/b")
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	running := <-started
+
+	done := make(chan error, 1)
+	go func() { done <- p.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned with a conversion running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if p.Enqueue(first) {
+		t.Error("Enqueue accepted a conversion while shutting down")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if conv := getConversion(t, store, running); conv.Status != utils.StatusCompleted {
+		t.Errorf("running conversion is %s after Shutdown; want completed", conv.Status)
+	}
+	other := first
+	if running == first {
+		other = second
+	}
+	if conv := getConversion(t, store, other); conv.Status != utils.StatusPending {
+		t.Errorf("queued conversion is %s after Shutdown; want pending", conv.Status)
+	}
+	if runs := proc.runs.Load(); runs != 1 {
+		t.Errorf("%d runs; want only the running conversion", runs)
+	}
+}
+
+// TestPoolShutdownCancels checks that conversions still running when a
+// shutdown runs out of time are cancelled and put back to pending
+func TestPoolShutdownCancels(t *testing.T) {
+	p, store, proc := newTestPool(t, Config{})
+	started := make(chan struct{})
+	proc.before = func(ctx context.Context, conv models.ConversionRequest) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	id := createConversion(t, store, "photo.png", "inputs/a")
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v; want the deadline exceeded", err)
+	}
+	if conv := getConversion(t, store, id); conv.Status != utils.StatusPending || conv.ErrorMsg != "" {
+		t.Errorf("cancelled conversion is %s (%q); want pending", conv.Status, conv.ErrorMsg)
+	}
+}
+
+// TestPoolDiscardsStaleOutput checks that an output is deleted rather than
+// recorded when the conversion's input is replaced while it is processed,
+// and that the new input is converted by a later poll
+func TestPoolDiscardsStaleOutput(t *testing.T) {
+	p, store, proc := newTestPool(t, Config{PollInterval: 10 * time.Millisecond})
+	outputs := make(chan string, 2)
+	proc.before = func(ctx context.Context, conv models.ConversionRequest) error {
+		outputs <- fmt.Sprintf("outputs/%s-%d", conv.ID, proc.runs.Load())
+		if conv.InputPath != "inputs/old" {
+			return nil
+		}
+		// A new upload replaces the input meanwhile
+		conv.InputPath = "inputs/new"
+		conv.Status = utils.StatusPending
+		_, err := store.SaveConversion(ctx, conv)
+		return err
+	}
+	id := createConversion(t, store, "photo.png", "inputs/old")
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, p)
+
+	stale := <-outputs
+	deadline := time.Now().Add(5 * time.Second)
+	for blobExists(p.blobs, stale) {
+		if time.Now().After(deadline) {
+			t.Fatal("stale output was not deleted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if conv := getConversion(t, store, id); conv.OutputPath != "" || conv.InputPath != "inputs/new" {
+		t.Errorf("conversion %+v; want the new input without an output", conv)
+	}
+
+	conv := waitStatus(t, store, id, utils.StatusCompleted)
+	if want := <-outputs; conv.OutputPath != want || !blobExists(p.blobs, conv.OutputPath) {
+		t.Errorf("output %s recorded; want %s", conv.OutputPath, want)
+	}
+}